@@ -2,37 +2,98 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
+	"os"
 
-	"github.com/cortexproject/cortex/tools/thanosconvert"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
+
+	"github.com/cortexproject/cortex/pkg/util/runutil"
+	"github.com/cortexproject/cortex/tools/thanosconvert"
 )
 
 func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run converts the Thanos block metadata read from the input to Cortex, and writes the
+// converted metadata, or the changes required in dry-run mode, as JSON to the output.
+func run(args []string, stdin io.Reader, stdout io.Writer) (err error) {
+	var (
+		tenant string
+		input  string
+		output string
+		dryRun bool
+	)
+
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	fs.StringVar(&tenant, "tenant", "", "Tenant the block belongs to")
+	fs.StringVar(&input, "input", "", "Path to the meta.json of the block to convert, or - to read it from stdin")
+	fs.StringVar(&output, "output", "-", "Path to write the converted meta.json to, or - to write it to stdout")
+	fs.BoolVar(&dryRun, "dry-run", false, "Don't convert the metadata; only report the changes required")
+	if err = fs.Parse(args); err != nil {
+		return err
+	}
+
+	if tenant == "" {
+		return fmt.Errorf("the -tenant flag is required")
+	}
+	if input == "" {
+		return fmt.Errorf("the -input flag is required")
+	}
+
+	meta, err := readMeta(input, stdin)
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = stdout
+	if output != "-" {
+		f, err := os.Create(output)
+		if err != nil {
+			return fmt.Errorf("failed to create output file %s: %v", output, err)
+		}
+		// A failed close may leave the output file truncated.
+		defer runutil.CloseWithErrCapture(&err, f, "failed to close output file "+output)
+		out = f
+	}
+
+	if dryRun {
+		diff, err := thanosconvert.ConvertMetadataDryRun(meta, tenant)
+		if err != nil {
+			return err
+		}
+
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "\t")
+		return enc.Encode(diff)
+	}
+
+	converted, _, err := thanosconvert.ConvertMetadata(meta, tenant)
+	if err != nil {
+		return err
+	}
+	return converted.Write(out)
+}
+
+func readMeta(input string, stdin io.Reader) (metadata.Meta, error) {
+	r := io.NopCloser(stdin)
+	if input != "-" {
+		f, err := os.Open(input)
+		if err != nil {
+			return metadata.Meta{}, fmt.Errorf("failed to open input file %s: %v", input, err)
+		}
+		r = f
+	}
 
-	str := `{
-		"ulid": "01GARRGDJNMRDYCAYCW2HSP4ZG",
-		"minTime": 1660807063253,
-		"maxTime": 1660832062254,
-		"stats": {
-			"numSamples": 25000,
-			"numSeries": 1,
-			"numChunks": 212
-		},
-		"compaction": {
-			"level": 1,
-			"sources": [
-				"01GARRGDJNMRDYCAYCW2HSP4ZG"
-			]
-		},
-		"version": 1
-	}`
-	meta := metadata.Meta{}
-	json.Unmarshal([]byte(str), &meta)
-
-	ms, err := thanosconvert.ConvertMetadata(meta, "test")
-	fmt.Println(ms, err)
-
-	bs, _ := json.Marshal(meta)
-	fmt.Println(string(bs))
+	// metadata.Read closes the reader.
+	meta, err := metadata.Read(r)
+	if err != nil {
+		return metadata.Meta{}, fmt.Errorf("failed to read block metadata: %v", err)
+	}
+	return *meta, nil
 }
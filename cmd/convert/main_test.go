@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+
+	cortex_tsdb "github.com/cortexproject/cortex/pkg/storage/tsdb"
+)
+
+const thanosMeta = `{
+	"ulid": "01GARRGDJNMRDYCAYCW2HSP4ZG",
+	"minTime": 1660807063253,
+	"maxTime": 1660832062254,
+	"stats": {
+		"numSamples": 25000,
+		"numSeries": 1,
+		"numChunks": 212
+	},
+	"compaction": {
+		"level": 1,
+		"sources": [
+			"01GARRGDJNMRDYCAYCW2HSP4ZG"
+		]
+	},
+	"version": 1,
+	"thanos": {
+		"labels": {
+			"cluster": "foo"
+		},
+		"source": "sidecar"
+	}
+}`
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "meta.json")
+	require.NoError(t, os.WriteFile(input, []byte(thanosMeta), 0644))
+
+	t.Run("should convert the input file to the output file", func(t *testing.T) {
+		output := filepath.Join(dir, "converted.json")
+		require.NoError(t, run([]string{"-tenant", "user-1", "-input", input, "-output", output}, nil, nil))
+
+		f, err := os.Open(output)
+		require.NoError(t, err)
+		meta, err := metadata.Read(f)
+		require.NoError(t, err)
+
+		assert.Equal(t, "01GARRGDJNMRDYCAYCW2HSP4ZG", meta.ULID.String())
+		assert.Equal(t, map[string]string{cortex_tsdb.TenantIDExternalLabel: "user-1", "cluster": "foo"}, meta.Thanos.Labels)
+	})
+
+	t.Run("should convert stdin to stdout", func(t *testing.T) {
+		stdout := &bytes.Buffer{}
+		require.NoError(t, run([]string{"-tenant", "user-1", "-input", "-"}, strings.NewReader(thanosMeta), stdout))
+		assert.Contains(t, stdout.String(), `"__org_id__": "user-1"`)
+	})
+
+	t.Run("should only report the changes in dry-run mode", func(t *testing.T) {
+		stdout := &bytes.Buffer{}
+		require.NoError(t, run([]string{"-tenant", "user-1", "-input", input, "-dry-run"}, nil, stdout))
+		assert.JSONEq(t, `{
+			"added_labels": {"__org_id__": "user-1"},
+			"preserved_labels": {"cluster": "foo"},
+			"tenant_label_injected": true
+		}`, stdout.String())
+	})
+
+	t.Run("should fail without a tenant", func(t *testing.T) {
+		assert.Error(t, run([]string{"-input", input}, nil, nil))
+	})
+
+	t.Run("should fail without an input", func(t *testing.T) {
+		assert.Error(t, run([]string{"-tenant", "user-1"}, nil, nil))
+	})
+
+	t.Run("should fail if the input file doesn't exist", func(t *testing.T) {
+		assert.Error(t, run([]string{"-tenant", "user-1", "-input", filepath.Join(dir, "missing.json")}, nil, nil))
+	})
+
+	t.Run("should fail on invalid metadata", func(t *testing.T) {
+		invalid := strings.Replace(thanosMeta, `"version": 1`, `"version": 2`, 1)
+		assert.Error(t, run([]string{"-tenant", "user-1", "-input", "-"}, strings.NewReader(invalid), &bytes.Buffer{}))
+
+		noSources := strings.Replace(thanosMeta, `"01GARRGDJNMRDYCAYCW2HSP4ZG"
+		]`, `]`, 1)
+		require.NotEqual(t, thanosMeta, noSources)
+		assert.Error(t, run([]string{"-tenant", "user-1", "-input", "-"}, strings.NewReader(noSources), &bytes.Buffer{}))
+	})
+}
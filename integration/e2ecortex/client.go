@@ -7,9 +7,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"net"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gogo/protobuf/proto"
@@ -18,8 +22,23 @@ import (
 	promapi "github.com/prometheus/client_golang/api"
 	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/rulefmt"
 	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/weaveworks/common/user"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
 	yaml "gopkg.in/yaml.v3"
+
+	"github.com/cortexproject/cortex/integration/e2e"
+	"github.com/cortexproject/cortex/pkg/ingester"
+	ingester_client "github.com/cortexproject/cortex/pkg/ingester/client"
+	"github.com/cortexproject/cortex/pkg/querier"
+	"github.com/cortexproject/cortex/pkg/util/backoff"
 )
 
 var ErrNotFound = errors.New("not found")
@@ -32,9 +51,14 @@ type Client struct {
 	rulerAddress        string
 	distributorAddress  string
 	timeout             time.Duration
+	alertmanagerTimeout time.Duration
 	httpClient          *http.Client
 	querierClient       promv1.API
 	orgID               string
+
+	// Backoff of the retries of the idempotent requests failed with a 5xx status code,
+	// nil if disabled.
+	retryBackoff *backoff.Config
 }
 
 // NewClient makes a new Cortex client
@@ -45,31 +69,30 @@ func NewClient(
 	rulerAddress string,
 	orgID string,
 ) (*Client, error) {
-	// Create querier API client
-	querierAPIClient, err := promapi.NewClient(promapi.Config{
-		Address:      "http://" + querierAddress + "/api/prom",
-		RoundTripper: &addOrgIDRoundTripper{orgID: orgID, next: http.DefaultTransport},
-	})
-	if err != nil {
-		return nil, err
-	}
-
 	c := &Client{
 		distributorAddress:  distributorAddress,
 		querierAddress:      querierAddress,
 		alertmanagerAddress: alertmanagerAddress,
 		rulerAddress:        rulerAddress,
 		timeout:             5 * time.Second,
-		httpClient:          &http.Client{},
-		querierClient:       promv1.NewAPI(querierAPIClient),
 		orgID:               orgID,
 	}
 
+	retryTransport := &retryRoundTripper{getBackoff: c.getRetryBackoff, next: http.DefaultTransport}
+	c.httpClient = &http.Client{Transport: retryTransport}
+
+	// Create querier API client
+	querierAPIClient, err := promapi.NewClient(promapi.Config{
+		Address:      "http://" + querierAddress + "/api/prom",
+		RoundTripper: &addOrgIDRoundTripper{orgID: orgID, next: retryTransport},
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.querierClient = promv1.NewAPI(querierAPIClient)
+
 	if alertmanagerAddress != "" {
-		alertmanagerAPIClient, err := promapi.NewClient(promapi.Config{
-			Address:      "http://" + alertmanagerAddress,
-			RoundTripper: &addOrgIDRoundTripper{orgID: orgID, next: http.DefaultTransport},
-		})
+		alertmanagerAPIClient, err := newComponentAPIClient(alertmanagerAddress, orgID, c.getAlertmanagerTimeout)
 		if err != nil {
 			return nil, err
 		}
@@ -79,7 +102,48 @@ func NewClient(
 	return c, nil
 }
 
-// Push the input timeseries to the remote endpoint
+// newComponentAPIClient returns an API client for the Cortex component listening on the
+// given address, whose requests are cancelled once the timeout returned by getTimeout expires.
+func newComponentAPIClient(address, orgID string, getTimeout func() time.Duration) (promapi.Client, error) {
+	return promapi.NewClient(promapi.Config{
+		Address: "http://" + address,
+		RoundTripper: &timeoutRoundTripper{
+			getTimeout: getTimeout,
+			next:       &addOrgIDRoundTripper{orgID: orgID, next: http.DefaultTransport},
+		},
+	})
+}
+
+// SetTimeout overrides the default timeout of the client requests. The requests to the
+// Alertmanager honor it too, unless overridden by SetAlertmanagerTimeout.
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.timeout = timeout
+}
+
+// SetRetryBackoff enables the retries of the idempotent requests, like the queries, failed
+// with a 5xx status code, backing off between them. The requests are not retried by default.
+func (c *Client) SetRetryBackoff(cfg backoff.Config) {
+	c.retryBackoff = &cfg
+}
+
+func (c *Client) getRetryBackoff() *backoff.Config {
+	return c.retryBackoff
+}
+
+// SetAlertmanagerTimeout overrides the client timeout for the requests to the Alertmanager.
+func (c *Client) SetAlertmanagerTimeout(timeout time.Duration) {
+	c.alertmanagerTimeout = timeout
+}
+
+func (c *Client) getAlertmanagerTimeout() time.Duration {
+	if c.alertmanagerTimeout > 0 {
+		return c.alertmanagerTimeout
+	}
+	return c.timeout
+}
+
+// Push the input timeseries to the remote endpoint. The exemplars of the input timeseries, if
+// any, are pushed along with their samples.
 func (c *Client) Push(timeseries []prompb.TimeSeries) (*http.Response, error) {
 	// Create write request
 	data, err := proto.Marshal(&prompb.WriteRequest{Timeseries: timeseries})
@@ -87,6 +151,16 @@ func (c *Client) Push(timeseries []prompb.TimeSeries) (*http.Response, error) {
 		return nil, err
 	}
 
+	return c.push(data, "application/x-protobuf", "0.1.0")
+}
+
+// PushV2 pushes the input timeseries to the remote endpoint with the Remote-Write 2.0
+// protocol. The exemplars of the input timeseries, if any, are pushed along with their samples.
+func (c *Client) PushV2(timeseries []prompb.TimeSeries) (*http.Response, error) {
+	return c.push(marshalRemoteWrite2(timeseries), "application/x-protobuf;proto=io.prometheus.write.v2.Request", "2.0.0")
+}
+
+func (c *Client) push(data []byte, contentType, version string) (*http.Response, error) {
 	// Create HTTP request
 	compressed := snappy.Encode(nil, data)
 	req, err := http.NewRequest("POST", fmt.Sprintf("http://%s/api/prom/push", c.distributorAddress), bytes.NewReader(compressed))
@@ -95,8 +169,8 @@ func (c *Client) Push(timeseries []prompb.TimeSeries) (*http.Response, error) {
 	}
 
 	req.Header.Add("Content-Encoding", "snappy")
-	req.Header.Set("Content-Type", "application/x-protobuf")
-	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", version)
 	req.Header.Set("X-Scope-OrgID", c.orgID)
 
 	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
@@ -112,15 +186,150 @@ func (c *Client) Push(timeseries []prompb.TimeSeries) (*http.Response, error) {
 	return res, nil
 }
 
+// PushWithClockSkew pushes a single sample for the series with the given labels, whose
+// timestamp is set to the client current time shifted by skew. A positive skew pushes a
+// sample in the future, while a negative skew pushes a sample in the past.
+func (c *Client) PushWithClockSkew(labels []prompb.Label, value float64, skew time.Duration) (*http.Response, error) {
+	ts := time.Now().Add(skew).UnixNano() / int64(time.Millisecond)
+
+	return c.Push([]prompb.TimeSeries{{
+		Labels:  labels,
+		Samples: []prompb.Sample{{Value: value, Timestamp: ts}},
+	}})
+}
+
+// PushBurst pushes the input timeseries count times, as fast as possible, and returns the
+// number of batches accepted along with the index of the first batch rejected by the ingestion
+// rate limiter with 429. The returned index is -1 if no batch has been rate limited. Since the
+// rate limiter allows up to the burst size at once, tests can use it to assert the burst size.
+func (c *Client) PushBurst(series []prompb.TimeSeries, count int) (acceptedBatches int, firstLimitedAt int, err error) {
+	firstLimitedAt = -1
+
+	for i := 0; i < count; i++ {
+		res, err := c.Push(series)
+		if err != nil {
+			return acceptedBatches, firstLimitedAt, err
+		}
+
+		switch {
+		case res.StatusCode/100 == 2:
+			acceptedBatches++
+		case res.StatusCode == http.StatusTooManyRequests:
+			if firstLimitedAt < 0 {
+				firstLimitedAt = i
+			}
+		default:
+			return acceptedBatches, firstLimitedAt, fmt.Errorf("pushing batch %d failed with status %d", i, res.StatusCode)
+		}
+	}
+
+	return acceptedBatches, firstLimitedAt, nil
+}
+
 // Query runs an instant query.
 func (c *Client) Query(query string, ts time.Time) (model.Value, error) {
-	value, _, err := c.querierClient.Query(context.Background(), query, ts)
+	return c.QueryCtx(context.Background(), query, ts)
+}
+
+// QueryCtx runs an instant query, aborting it once the input context is done or the
+// client timeout expires, whichever comes first.
+func (c *Client) QueryCtx(ctx context.Context, query string, ts time.Time) (model.Value, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	value, _, err := c.querierClient.Query(withIdempotentRequest(ctx), query, ts)
 	return value, err
 }
 
+// QueryExemplars runs a query for the exemplars in the given time range.
+func (c *Client) QueryExemplars(query string, start, end time.Time) ([]promv1.ExemplarQueryResult, error) {
+	return c.querierClient.QueryExemplars(withIdempotentRequest(context.Background()), query, start, end)
+}
+
+// LatencyStats holds the latency distribution of a set of queries.
+type LatencyStats struct {
+	// Number of queries run, and how many of them failed. The latencies are
+	// computed on the successful queries only.
+	Iterations int
+	Errors     int
+
+	Min  time.Duration
+	Max  time.Duration
+	Mean time.Duration
+	P50  time.Duration
+	P95  time.Duration
+	P99  time.Duration
+}
+
+// QueryLatencyProfile runs the instant query at the given time iterations times, sequentially,
+// and returns the latency percentiles of the successful runs. Each query is subject to the
+// client timeout. If any query fails, the stats are returned along with an error reporting
+// the number of failures and the first one.
+func (c *Client) QueryLatencyProfile(query string, ts time.Time, iterations int) (LatencyStats, error) {
+	stats := LatencyStats{Iterations: iterations}
+	durations := make([]time.Duration, 0, iterations)
+
+	var firstErr error
+	for i := 0; i < iterations; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+		start := time.Now()
+		_, _, err := c.querierClient.Query(ctx, query, ts)
+		elapsed := time.Since(start)
+		cancel()
+
+		if err != nil {
+			stats.Errors++
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		durations = append(durations, elapsed)
+	}
+
+	if len(durations) > 0 {
+		sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+
+		var total time.Duration
+		for _, d := range durations {
+			total += d
+		}
+
+		stats.Min = durations[0]
+		stats.Max = durations[len(durations)-1]
+		stats.Mean = total / time.Duration(len(durations))
+		stats.P50 = latencyPercentile(durations, 0.50)
+		stats.P95 = latencyPercentile(durations, 0.95)
+		stats.P99 = latencyPercentile(durations, 0.99)
+	}
+
+	if firstErr != nil {
+		return stats, fmt.Errorf("%d of %d queries failed, first error: %w", stats.Errors, iterations, firstErr)
+	}
+	return stats, nil
+}
+
+// latencyPercentile returns the nearest-rank percentile of the sorted durations.
+func latencyPercentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
 // Query runs a query range.
 func (c *Client) QueryRange(query string, start, end time.Time, step time.Duration) (model.Value, error) {
-	value, _, err := c.querierClient.QueryRange(context.Background(), query, promv1.Range{
+	return c.QueryRangeCtx(context.Background(), query, start, end, step)
+}
+
+// QueryRangeCtx runs a query range, aborting it once the input context is done or the
+// client timeout expires, whichever comes first.
+func (c *Client) QueryRangeCtx(ctx context.Context, query string, start, end time.Time, step time.Duration) (model.Value, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	value, _, err := c.querierClient.QueryRange(withIdempotentRequest(ctx), query, promv1.Range{
 		Start: start,
 		End:   end,
 		Step:  step,
@@ -149,6 +358,28 @@ func (c *Client) QueryRaw(query string) (*http.Response, []byte, error) {
 	return c.query(addr)
 }
 
+// ExplainQuery returns the execution plan of the instant query at the given time,
+// using the analyze parameter of the querier query API.
+func (c *Client) ExplainQuery(query string, ts time.Time) (*querier.QueryPlan, error) {
+	addr := fmt.Sprintf("http://%s/api/prom/api/v1/query?analyze=true&query=%s&time=%s", c.querierAddress, url.QueryEscape(query), FormatTime(ts))
+
+	res, body, err := c.query(addr)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("explaining query failed with status %d and content %v", res.StatusCode, string(body))
+	}
+
+	var result struct {
+		Data *querier.QueryPlan `json:"data"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+	return result.Data, nil
+}
+
 func (c *Client) query(addr string) (*http.Response, []byte, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
 	defer cancel()
@@ -175,105 +406,977 @@ func (c *Client) query(addr string) (*http.Response, []byte, error) {
 
 // Series finds series by label matchers.
 func (c *Client) Series(matches []string, start, end time.Time) ([]model.LabelSet, error) {
-	result, _, err := c.querierClient.Series(context.Background(), matches, start, end)
+	return c.SeriesCtx(context.Background(), matches, start, end)
+}
+
+// SeriesCtx finds series by label matchers, with the same cancellation semantics of QueryCtx.
+func (c *Client) SeriesCtx(ctx context.Context, matches []string, start, end time.Time) ([]model.LabelSet, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	result, _, err := c.querierClient.Series(ctx, matches, start, end)
 	return result, err
 }
 
 // LabelValues gets label values
 func (c *Client) LabelValues(label string, start, end time.Time, matches []string) (model.LabelValues, error) {
-	result, _, err := c.querierClient.LabelValues(context.Background(), label, matches, start, end)
+	return c.LabelValuesCtx(context.Background(), label, start, end, matches)
+}
+
+// LabelValuesCtx gets label values, with the same cancellation semantics of QueryCtx.
+func (c *Client) LabelValuesCtx(ctx context.Context, label string, start, end time.Time, matches []string) (model.LabelValues, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	result, _, err := c.querierClient.LabelValues(ctx, label, matches, start, end)
 	return result, err
 }
 
 // LabelNames gets label names
 func (c *Client) LabelNames(start, end time.Time) ([]string, error) {
-	result, _, err := c.querierClient.LabelNames(context.Background(), nil, start, end)
+	return c.LabelNamesCtx(context.Background(), start, end)
+}
+
+// LabelNamesCtx gets label names, with the same cancellation semantics of QueryCtx.
+func (c *Client) LabelNamesCtx(ctx context.Context, start, end time.Time) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	result, _, err := c.querierClient.LabelNames(ctx, nil, start, end)
 	return result, err
 }
 
-type addOrgIDRoundTripper struct {
-	orgID string
-	next  http.RoundTripper
+// DeleteSeries requests the deletion of the series matching any of the input matchers
+// in the given time range, through the querier series API.
+func (c *Client) DeleteSeries(matches []string, start, end time.Time) error {
+	params := url.Values{}
+	for _, m := range matches {
+		params.Add("match[]", m)
+	}
+	params.Set("start", FormatTime(start))
+	params.Set("end", FormatTime(end))
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, fmt.Sprintf("http://%s/api/prom/api/v1/series?%s", c.querierAddress, params.Encode()), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Scope-OrgID", c.orgID)
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("deleting series failed with status %d and content %v", res.StatusCode, string(body))
+	}
+
+	return nil
 }
 
-func (r *addOrgIDRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
-	req.Header.Set("X-Scope-OrgID", r.orgID)
+// WaitForRecordingRule polls an instant query for expectedMetric until it returns at least
+// one sample or the timeout expires. A non-empty result implies the ruler has evaluated
+// the given rule group and written back the output of the recording rule.
+func (c *Client) WaitForRecordingRule(namespace, group, ruleName string, expectedMetric string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
 
-	return r.next.RoundTrip(req)
+	var (
+		value model.Value
+		err   error
+	)
+
+	for time.Now().Before(deadline) {
+		value, err = c.Query(expectedMetric, time.Now())
+		if err == nil {
+			if vector, ok := value.(model.Vector); ok && len(vector) > 0 {
+				return nil
+			}
+		}
+
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	return fmt.Errorf("recording rule %s in group %s (namespace %s) did not produce %s within %s. Last error: %v. Last value: %v", ruleName, group, namespace, expectedMetric, timeout, err, value)
 }
 
-// ServerStatus represents a Alertmanager status response
-// TODO: Upgrade to Alertmanager v0.20.0+ and utilize vendored structs
-type ServerStatus struct {
-	Data struct {
-		ConfigYaml string `json:"configYAML"`
-	} `json:"data"`
+// WaitReady polls the querier readiness probe until it reports ready or the timeout expires.
+// On timeout, the returned error reports the failing checks listed by the last response.
+func (c *Client) WaitReady(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	var lastErr error
+	for time.Now().Before(deadline) {
+		res, body, err := c.DoWithoutOrgID("GET", "http://"+c.querierAddress+"/ready", nil)
+		switch {
+		case err != nil:
+			lastErr = err
+		case res.StatusCode == http.StatusOK:
+			return nil
+		default:
+			lastErr = fmt.Errorf("status code %d, failing checks: %s", res.StatusCode, strings.TrimSpace(string(body)))
+		}
+
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	return fmt.Errorf("not ready within %s. Last error: %v", timeout, lastErr)
 }
 
-// userConfig is used to communicate a users alertmanager configs
-type userConfig struct {
-	TemplateFiles      map[string]string `yaml:"template_files"`
-	AlertmanagerConfig string            `yaml:"alertmanager_config"`
+// DoWithoutOrgID sends a request with the X-Scope-OrgID header deliberately omitted,
+// using a bare transport, and returns the response along with its body.
+func (c *Client) DoWithoutOrgID(method, addr string, body io.Reader) (*http.Response, []byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, addr, body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client := &http.Client{Transport: http.DefaultTransport}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return res, resBody, nil
 }
 
-// GetAlertmanagerStatusPage gets the status page of alertmanager.
-func (c *Client) GetAlertmanagerStatusPage(ctx context.Context) ([]byte, error) {
-	return c.getRawPage(ctx, "http://"+c.alertmanagerAddress+"/multitenant_alertmanager/status")
+// AssertRequiresAuth issues a request to the given path of the querier without the
+// X-Scope-OrgID header and returns an error unless the server rejects it with 401.
+func (c *Client) AssertRequiresAuth(method, path string) error {
+	res, body, err := c.DoWithoutOrgID(method, "http://"+c.querierAddress+path, nil)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusUnauthorized {
+		return fmt.Errorf("unauthenticated request %s %s returned status %d instead of %d and content %v", method, path, res.StatusCode, http.StatusUnauthorized, string(body))
+	}
+	return nil
 }
 
-func (c *Client) getRawPage(ctx context.Context, url string) ([]byte, error) {
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+// PushThenGap pushes preSamples samples for the series with the given labels, spaced by step,
+// with the last one pushed gap before now. If gap is greater than the query lookback delta,
+// an instant query for the series at now returns no value, like the series went stale.
+func (c *Client) PushThenGap(labels []prompb.Label, preSamples int, step time.Duration, gap time.Duration) error {
+	last := time.Now().Add(-gap)
+
+	samples := make([]prompb.Sample, 0, preSamples)
+	for i := preSamples - 1; i >= 0; i-- {
+		ts := last.Add(-time.Duration(i) * step)
+		samples = append(samples, prompb.Sample{Value: float64(preSamples - i), Timestamp: ts.UnixNano() / int64(time.Millisecond)})
+	}
+
+	res, err := c.Push([]prompb.TimeSeries{{Labels: labels, Samples: samples}})
+	if err != nil {
+		return err
+	}
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("pushing samples failed with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// GetMetricsWithFormat fetches the /metrics endpoint of the querier sending the given
+// Accept header and returns the exposed content along with the Content-Type chosen by
+// the server.
+func (c *Client) GetMetricsWithFormat(accept string) (string, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+c.querierAddress+"/metrics", nil)
+	if err != nil {
+		return "", "", err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", "", err
+	}
+	if res.StatusCode/100 != 2 {
+		return "", "", fmt.Errorf("fetching metrics failed with status %d and content %v", res.StatusCode, string(body))
+	}
+	return string(body), res.Header.Get("Content-Type"), nil
+}
+
+// GetIngesterStats fetches the in-memory series and WAL statistics from the ingester
+// listening on the given HTTP address.
+func (c *Client) GetIngesterStats(address string) (*ingester.IngesterStats, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+address+"/ingester/stats", nil)
 	if err != nil {
 		return nil, err
 	}
-	resp, err := c.httpClient.Do(req.WithContext(ctx))
+
+	res, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+	defer res.Body.Close()
 
-	content, err := io.ReadAll(resp.Body)
+	body, err := io.ReadAll(res.Body)
 	if err != nil {
 		return nil, err
 	}
-	if resp.StatusCode/100 != 2 {
-		return nil, fmt.Errorf("fetching page failed with status %d and content %v", resp.StatusCode, string(content))
+	if res.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("fetching ingester stats failed with status %d and content %v", res.StatusCode, string(body))
 	}
-	return content, nil
+
+	stats := &ingester.IngesterStats{}
+	if err := json.Unmarshal(body, stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
 }
 
-// GetAlertmanagerConfig gets the status of an alertmanager instance
-func (c *Client) GetAlertmanagerConfig(ctx context.Context) (*alertConfig.Config, error) {
-	u := c.alertmanagerClient.URL("/api/prom/api/v1/status", nil)
+// CheckGRPCHealth dials the given gRPC address and calls the standard gRPC health
+// service for the given service name (empty to check the server as a whole),
+// returning the reported serving status.
+func (c *Client) CheckGRPCHealth(address, service string) (grpc_health_v1.HealthCheckResponse_ServingStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
 
-	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	conn, err := grpc.DialContext(ctx, address, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	if err != nil {
-		return nil, fmt.Errorf("error creating request: %v", err)
+		return grpc_health_v1.HealthCheckResponse_UNKNOWN, err
 	}
+	defer conn.Close()
 
-	resp, body, err := c.alertmanagerClient.Do(ctx, req)
+	res, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: service})
+	if err != nil {
+		return grpc_health_v1.HealthCheckResponse_UNKNOWN, err
+	}
+	return res.Status, nil
+}
+
+// IngesterQueryStream dials the gRPC address of an ingester and issues a QueryStream
+// request on behalf of the client tenant, bypassing the distributor and querier. The
+// streamed responses are merged into a single one.
+func (c *Client) IngesterQueryStream(address string, from, to time.Time, matchers []*labels.Matcher) (*ingester_client.QueryStreamResponse, error) {
+	req, err := ingester_client.ToQueryRequest(model.TimeFromUnixNano(from.UnixNano()), model.TimeFromUnixNano(to.UnixNano()), matchers)
 	if err != nil {
 		return nil, err
 	}
 
-	if resp.StatusCode == http.StatusNotFound {
-		return nil, ErrNotFound
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	ctx, err = user.InjectIntoGRPCRequest(user.InjectOrgID(ctx, c.orgID))
+	if err != nil {
+		return nil, err
 	}
 
-	if resp.StatusCode/100 != 2 {
-		return nil, fmt.Errorf("getting config failed with status %d and error %v", resp.StatusCode, string(body))
+	conn, err := grpc.DialContext(ctx, address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
 	}
+	defer conn.Close()
 
-	var ss *ServerStatus
-	err = json.Unmarshal(body, &ss)
+	stream, err := ingester_client.NewIngesterClient(conn).QueryStream(ctx, req)
 	if err != nil {
 		return nil, err
 	}
 
-	cfg := &alertConfig.Config{}
-	err = yaml.Unmarshal([]byte(ss.Data.ConfigYaml), cfg)
+	res := &ingester_client.QueryStreamResponse{}
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
 
-	return cfg, err
+		res.Chunkseries = append(res.Chunkseries, resp.Chunkseries...)
+		res.Timeseries = append(res.Timeseries, resp.Timeseries...)
+	}
+	return res, nil
+}
+
+// RemoteRead runs a query selecting the series matching the input matchers in the
+// [start, end] time range through the querier remote read API.
+func (c *Client) RemoteRead(matchers []*labels.Matcher, start, end time.Time) (*prompb.ReadResponse, error) {
+	startMs, endMs := e2e.TimeToMilliseconds(start), e2e.TimeToMilliseconds(end)
+	q, err := remote.ToQuery(startMs, endMs, matchers, &storage.SelectHints{Start: startMs, End: endMs})
+	if err != nil {
+		return nil, err
+	}
+
+	return c.remoteRead(q)
+}
+
+// ExportSeries writes all the samples of the series matching each of the input selectors
+// in the [start, end] time range to w, in a newline-delimited format where each line is
+// "<labels> <timestamp ms> <value>". Each selector is read through a separate remote read
+// request and written out before the next one is issued, so the whole dataset is never
+// held in memory at once.
+func (c *Client) ExportSeries(matchers []string, start, end time.Time, w io.Writer) error {
+	for _, m := range matchers {
+		selector, err := parser.ParseMetricSelector(m)
+		if err != nil {
+			return err
+		}
+
+		startMs, endMs := e2e.TimeToMilliseconds(start), e2e.TimeToMilliseconds(end)
+		q, err := remote.ToQuery(startMs, endMs, selector, &storage.SelectHints{Start: startMs, End: endMs})
+		if err != nil {
+			return err
+		}
+
+		resp, err := c.remoteRead(q)
+		if err != nil {
+			return err
+		}
+
+		for _, result := range resp.Results {
+			for _, ts := range result.Timeseries {
+				lbls := make(labels.Labels, 0, len(ts.Labels))
+				for _, l := range ts.Labels {
+					lbls = append(lbls, labels.Label{Name: l.Name, Value: l.Value})
+				}
+				sort.Sort(lbls)
+
+				for _, sample := range ts.Samples {
+					if _, err := fmt.Fprintf(w, "%s %d %s\n", lbls.String(), sample.Timestamp, strconv.FormatFloat(sample.Value, 'g', -1, 64)); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// remoteRead runs the input query through the querier remote read API.
+func (c *Client) remoteRead(q *prompb.Query) (*prompb.ReadResponse, error) {
+	data, err := proto.Marshal(&prompb.ReadRequest{Queries: []*prompb.Query{q}})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("http://%s/api/prom/api/v1/read", c.querierAddress), bytes.NewReader(snappy.Encode(nil, data)))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Read-Version", "0.1.0")
+	req.Header.Set("X-Scope-OrgID", c.orgID)
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	compressed, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("remote read failed with status %d and content %v", res.StatusCode, string(compressed))
+	}
+
+	uncompressed, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &prompb.ReadResponse{}
+	if err := proto.Unmarshal(uncompressed, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ForgetIngester removes the given ingester from the ring through the ring admin page
+// exposed by the distributor.
+func (c *Client) ForgetIngester(instanceID string) error {
+	form := url.Values{"forget": {instanceID}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("http://%s/ingester/ring", c.distributorAddress), strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// Do not follow the redirect returned by the ring page.
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusFound && res.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("forgetting ingester %s failed with status %d and content %v", instanceID, res.StatusCode, string(body))
+	}
+	return nil
+}
+
+// ReregisterIngester forces the given ingester to heartbeat the ring, re-registering
+// itself with its current tokens if it has been forgotten. The ingester address is
+// looked up in the ring exposed by the distributor.
+func (c *Client) ReregisterIngester(instanceID string) error {
+	ring, err := c.GetRingStatus(c.distributorAddress, "/ingester/ring")
+	if err != nil {
+		return err
+	}
+
+	address := ""
+	for _, instance := range ring.Instances {
+		if instance.ID == instanceID {
+			address = instance.Address
+			break
+		}
+	}
+	if address == "" {
+		return fmt.Errorf("ingester %s not found in the ring", instanceID)
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("http://%s/ingester/ring/reregister", net.JoinHostPort(host, strconv.Itoa(httpPort))), nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("re-registering ingester %s failed with status %d and content %v", instanceID, res.StatusCode, string(body))
+	}
+	return nil
+}
+
+// RingStatus is the status of a ring, as exposed by the ring pages.
+type RingStatus struct {
+	Instances []RingInstance `json:"shards"`
+}
+
+// RingInstance is the status of an instance registered in a ring.
+type RingInstance struct {
+	ID      string   `json:"id"`
+	State   string   `json:"state"`
+	Address string   `json:"address"`
+	Zone    string   `json:"zone"`
+	Tokens  []uint32 `json:"tokens"`
+}
+
+// GetRingStatus gets the status of the ring exposed by the page at the given endpoint, like
+// /ingester/ring or /store-gateway/ring, of the component listening on the given HTTP address.
+func (c *Client) GetRingStatus(address, endpoint string) (*RingStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("http://%s%s", address, endpoint), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(res.Body)
+		return nil, fmt.Errorf("getting ring status from %s failed with status %d and content %v", endpoint, res.StatusCode, string(body))
+	}
+
+	status := &RingStatus{}
+	if err := json.NewDecoder(res.Body).Decode(status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// FlushIngester triggers the flush of the in-memory series of the ingester listening on
+// the given HTTP address to the storage.
+func (c *Client) FlushIngester(address string) error {
+	return c.postIngesterAction(address, "/ingester/flush", "flushing")
+}
+
+// TriggerIngesterShutdown triggers the shutdown of the ingester listening on the given
+// HTTP address, flushing its series to the storage before stopping.
+func (c *Client) TriggerIngesterShutdown(address string) error {
+	return c.postIngesterAction(address, "/ingester/shutdown", "shutting down")
+}
+
+func (c *Client) postIngesterAction(address, path, action string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("http://%s%s", address, path), nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("%s ingester %s failed with status %d and content %v", action, address, res.StatusCode, string(body))
+	}
+	return nil
+}
+
+// TenantDeletionStatus is the status of the deletion of a tenant, as exposed by the purger.
+type TenantDeletionStatus struct {
+	TenantID      string `json:"tenant_id"`
+	BlocksDeleted bool   `json:"blocks_deleted"`
+}
+
+// DeleteTenant requests the deletion of all the data of the client tenant to the purger,
+// which is expected to run within the process listening on the querier address.
+func (c *Client) DeleteTenant() (*http.Response, error) {
+	return c.purgerRequest(http.MethodPost, "/purger/delete_tenant")
+}
+
+// DeleteTenantStatus gets the status of the deletion of the client tenant from the purger.
+func (c *Client) DeleteTenantStatus() (TenantDeletionStatus, error) {
+	res, err := c.purgerRequest(http.MethodGet, "/purger/delete_tenant_status")
+	if err != nil {
+		return TenantDeletionStatus{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(res.Body)
+		return TenantDeletionStatus{}, fmt.Errorf("getting tenant deletion status failed with status %d and content %v", res.StatusCode, string(body))
+	}
+
+	status := TenantDeletionStatus{}
+	if err := json.NewDecoder(res.Body).Decode(&status); err != nil {
+		return TenantDeletionStatus{}, err
+	}
+	return status, nil
+}
+
+// purgerRequest sends a request to the purger. The returned response body must be closed.
+func (c *Client) purgerRequest(method, path string) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+
+	req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("http://%s%s", c.querierAddress, path), nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set("X-Scope-OrgID", c.orgID)
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	res.Body = &cancelOnCloseBody{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
+
+// SetRuleGroup configures the input rule group in the given namespace of the ruler.
+func (c *Client) SetRuleGroup(ruleGroup rulefmt.RuleGroup, namespace string) error {
+	data, err := yaml.Marshal(ruleGroup)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.rulerRequest(http.MethodPost, "/api/v1/rules/"+url.PathEscape(namespace), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	return checkRulerResponse(res, "setting rule group")
+}
+
+// GetRuleGroup gets the given rule group from the ruler. The response is returned as is, so
+// that the caller can check the status code, and its body must be closed by the caller.
+func (c *Client) GetRuleGroup(namespace, groupName string) (*http.Response, error) {
+	return c.rulerRequest(http.MethodGet, "/api/v1/rules/"+url.PathEscape(namespace)+"/"+url.PathEscape(groupName), nil)
+}
+
+// GetRuleGroups gets all the rule groups configured in the ruler, by namespace. It returns
+// ErrNotFound if the tenant has no rule groups.
+func (c *Client) GetRuleGroups() (map[string][]rulefmt.RuleGroup, error) {
+	res, err := c.rulerRequest(http.MethodGet, "/api/v1/rules", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if err := checkRulerResponse(res, "getting rule groups"); err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	ruleGroups := map[string][]rulefmt.RuleGroup{}
+	if err := yaml.Unmarshal(data, ruleGroups); err != nil {
+		return nil, err
+	}
+	return ruleGroups, nil
+}
+
+// DeleteRuleGroup deletes the given rule group from the ruler.
+func (c *Client) DeleteRuleGroup(namespace, groupName string) error {
+	res, err := c.rulerRequest(http.MethodDelete, "/api/v1/rules/"+url.PathEscape(namespace)+"/"+url.PathEscape(groupName), nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	return checkRulerResponse(res, "deleting rule group")
+}
+
+// DeleteRuleNamespace deletes all the rule groups in the given namespace from the ruler.
+func (c *Client) DeleteRuleNamespace(namespace string) error {
+	res, err := c.rulerRequest(http.MethodDelete, "/api/v1/rules/"+url.PathEscape(namespace), nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	return checkRulerResponse(res, "deleting rule namespace")
+}
+
+// GetPrometheusRules gets the rule groups, and the state of their rules, through the
+// Prometheus-compatible rules API of the ruler.
+func (c *Client) GetPrometheusRules() ([]promv1.RuleGroup, error) {
+	rulerAPIClient, err := newComponentAPIClient(c.rulerAddress+"/api/prom", c.orgID, func() time.Duration { return c.timeout })
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := promv1.NewAPI(rulerAPIClient).Rules(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return result.Groups, nil
+}
+
+// rulerRequest sends a request to the ruler, whose context is released once the response
+// body is closed.
+func (c *Client) rulerRequest(method, path string, body io.Reader) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+
+	req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("http://%s%s", c.rulerAddress, path), body)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	req.Header.Set("X-Scope-OrgID", c.orgID)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/yaml")
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	res.Body = &cancelOnCloseBody{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
+
+// checkRulerResponse returns ErrNotFound if the ruler responded with a 404, or an error
+// describing the failed operation if it responded with any other non-2xx status code.
+func checkRulerResponse(res *http.Response, operation string) error {
+	if res.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if res.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("%s failed with status %d and content %v", operation, res.StatusCode, string(body))
+	}
+	return nil
+}
+
+type addOrgIDRoundTripper struct {
+	orgID string
+	next  http.RoundTripper
+}
+
+func (r *addOrgIDRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.Header.Set("X-Scope-OrgID", r.orgID)
+
+	return r.next.RoundTrip(req)
+}
+
+// timeoutRoundTripper cancels the requests not completed, including reading the response
+// body, within the timeout.
+type timeoutRoundTripper struct {
+	getTimeout func() time.Duration
+	next       http.RoundTripper
+}
+
+func (r *timeoutRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), r.getTimeout())
+
+	res, err := r.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	res.Body = &cancelOnCloseBody{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
+
+type idempotentRequestContextKey int
+
+const idempotentRequestKey idempotentRequestContextKey = 0
+
+// withIdempotentRequest marks the requests issued with the returned context as idempotent,
+// so that they can be retried even if they're not GET requests, like the queries which
+// the Prometheus API client sends as POST requests.
+func withIdempotentRequest(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentRequestKey, true)
+}
+
+// retryRoundTripper retries the idempotent requests failed with a 5xx status code, if
+// a backoff is configured.
+type retryRoundTripper struct {
+	getBackoff func() *backoff.Config
+	next       http.RoundTripper
+}
+
+func (r *retryRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	cfg := r.getBackoff()
+	idempotent, _ := req.Context().Value(idempotentRequestKey).(bool)
+	if cfg == nil || (req.Method != http.MethodGet && !idempotent) {
+		return r.next.RoundTrip(req)
+	}
+
+	retries := backoff.New(req.Context(), *cfg)
+	for {
+		res, err := r.next.RoundTrip(req)
+		if err != nil || res.StatusCode/100 != 5 || !retries.Ongoing() {
+			return res, err
+		}
+
+		// The request body can only be sent again if it can be rewound.
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return res, nil
+			}
+
+			body, err := req.GetBody()
+			if err != nil {
+				return res, nil
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		_, _ = io.Copy(io.Discard, res.Body)
+		_ = res.Body.Close()
+
+		retries.Wait()
+	}
+}
+
+// cancelOnCloseBody releases the request context once the response body is closed.
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnCloseBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// ServerStatus represents a Alertmanager status response
+// TODO: Upgrade to Alertmanager v0.20.0+ and utilize vendored structs
+type ServerStatus struct {
+	Data struct {
+		ConfigYaml string `json:"configYAML"`
+	} `json:"data"`
+}
+
+// userConfig is used to communicate a users alertmanager configs
+type userConfig struct {
+	TemplateFiles      map[string]string `yaml:"template_files"`
+	AlertmanagerConfig string            `yaml:"alertmanager_config"`
+}
+
+// GetAlertmanagerStatusPage gets the status page of alertmanager.
+func (c *Client) GetAlertmanagerStatusPage(ctx context.Context) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.getAlertmanagerTimeout())
+	defer cancel()
+
+	return c.getRawPage(ctx, "http://"+c.alertmanagerAddress+"/multitenant_alertmanager/status")
+}
+
+func (c *Client) getRawPage(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("fetching page failed with status %d and content %v", resp.StatusCode, string(content))
+	}
+	return content, nil
+}
+
+// GetAlertmanagerConfig gets the status of an alertmanager instance
+func (c *Client) GetAlertmanagerConfig(ctx context.Context) (*alertConfig.Config, error) {
+	u := c.alertmanagerClient.URL("/api/prom/api/v1/status", nil)
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+
+	resp, body, err := c.alertmanagerClient.Do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("getting config failed with status %d and error %v", resp.StatusCode, string(body))
+	}
+
+	var ss *ServerStatus
+	err = json.Unmarshal(body, &ss)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &alertConfig.Config{}
+	err = yaml.Unmarshal([]byte(ss.Data.ConfigYaml), cfg)
+
+	return cfg, err
+}
+
+// SetAlertmanagerConfig uploads the Alertmanager config and templates of the tenant.
+func (c *Client) SetAlertmanagerConfig(ctx context.Context, amConfig string, templates map[string]string) error {
+	u := c.alertmanagerClient.URL("/api/v1/alerts", nil)
+
+	data, err := yaml.Marshal(&userConfig{
+		AlertmanagerConfig: amConfig,
+		TemplateFiles:      templates,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+
+	resp, body, err := c.alertmanagerClient.Do(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("setting config failed with status %d and error %v", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
+
+// DeleteAlertmanagerConfig deletes the Alertmanager config and templates of the tenant.
+func (c *Client) DeleteAlertmanagerConfig(ctx context.Context) error {
+	u := c.alertmanagerClient.URL("/api/v1/alerts", nil)
+
+	req, err := http.NewRequest(http.MethodDelete, u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+
+	resp, body, err := c.alertmanagerClient.Do(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("deleting config failed with status %d and error %v", resp.StatusCode, string(body))
+	}
+
+	return nil
 }
 
 func (c *Client) PostRequest(url string, body io.Reader) (*http.Response, error) {
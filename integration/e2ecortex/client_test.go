@@ -0,0 +1,813 @@
+package e2ecortex
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+	yaml "gopkg.in/yaml.v3"
+
+	"github.com/cortexproject/cortex/integration/e2e"
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/util/backoff"
+	"github.com/cortexproject/cortex/pkg/util/push"
+)
+
+func TestClient_RemoteRead(t *testing.T) {
+	start := time.Unix(1000, 0)
+	end := time.Unix(2000, 0)
+
+	expected := &prompb.ReadResponse{
+		Results: []*prompb.QueryResult{{
+			Timeseries: []*prompb.TimeSeries{{
+				Labels:  []prompb.Label{{Name: labels.MetricName, Value: "series_1"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1000000}, {Value: 2, Timestamp: 2000000}},
+			}},
+		}},
+	}
+
+	var received *prompb.ReadRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/api/prom/api/v1/read", r.URL.Path)
+		assert.Equal(t, "user-1", r.Header.Get("X-Scope-OrgID"))
+		assert.Equal(t, "snappy", r.Header.Get("Content-Encoding"))
+
+		compressed, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		uncompressed, err := snappy.Decode(nil, compressed)
+		require.NoError(t, err)
+
+		received = &prompb.ReadRequest{}
+		require.NoError(t, proto.Unmarshal(uncompressed, received))
+
+		data, err := proto.Marshal(expected)
+		require.NoError(t, err)
+
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Header().Set("Content-Encoding", "snappy")
+		_, err = w.Write(snappy.Encode(nil, data))
+		require.NoError(t, err)
+	}))
+	defer server.Close()
+
+	c, err := NewClient("", strings.TrimPrefix(server.URL, "http://"), "", "", "user-1")
+	require.NoError(t, err)
+
+	matchers := []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "series_1")}
+	actual, err := c.RemoteRead(matchers, start, end)
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+
+	require.NotNil(t, received)
+	require.Len(t, received.Queries, 1)
+	assert.Equal(t, int64(1000000), received.Queries[0].StartTimestampMs)
+	assert.Equal(t, int64(2000000), received.Queries[0].EndTimestampMs)
+	assert.Equal(t, []*prompb.LabelMatcher{{Type: prompb.LabelMatcher_EQ, Name: labels.MetricName, Value: "series_1"}}, received.Queries[0].Matchers)
+}
+
+func TestClient_RemoteReadShouldFailOnErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "server error", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	c, err := NewClient("", strings.TrimPrefix(server.URL, "http://"), "", "", "user-1")
+	require.NoError(t, err)
+
+	_, err = c.RemoteRead(nil, time.Unix(1000, 0), time.Unix(2000, 0))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "remote read failed with status 500")
+}
+
+func TestClient_SetTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(5 * time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+
+	address := strings.TrimPrefix(server.URL, "http://")
+	c, err := NewClient("", address, address, "", "user-1")
+	require.NoError(t, err)
+	c.SetTimeout(50 * time.Millisecond)
+
+	assertTimeout := func(t *testing.T, err error) {
+		require.Error(t, err)
+
+		var netErr net.Error
+		require.True(t, errors.As(err, &netErr), err.Error())
+		assert.True(t, netErr.Timeout(), err.Error())
+	}
+
+	t.Run("query", func(t *testing.T) {
+		_, _, err := c.QueryRaw("up")
+		assertTimeout(t, err)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	})
+
+	t.Run("post request", func(t *testing.T) {
+		_, err := c.PostRequest(server.URL, strings.NewReader(""))
+		assertTimeout(t, err)
+	})
+
+	t.Run("raw page", func(t *testing.T) {
+		_, err := c.GetAlertmanagerStatusPage(context.Background())
+		assertTimeout(t, err)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	})
+}
+
+func TestClient_QueryMethodsShouldAbortOnContextCancellation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server detects the client going away only once the request body has been read.
+		_, _ = io.Copy(io.Discard, r.Body)
+
+		select {
+		case <-time.After(5 * time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+
+	address := strings.TrimPrefix(server.URL, "http://")
+	c, err := NewClient("", address, "", "", "user-1")
+	require.NoError(t, err)
+
+	now := time.Now()
+	tests := map[string]func(ctx context.Context) error{
+		"query": func(ctx context.Context) error {
+			_, err := c.QueryCtx(ctx, "up", now)
+			return err
+		},
+		"query range": func(ctx context.Context) error {
+			_, err := c.QueryRangeCtx(ctx, "up", now.Add(-time.Hour), now, time.Minute)
+			return err
+		},
+		"series": func(ctx context.Context) error {
+			_, err := c.SeriesCtx(ctx, []string{"up"}, now.Add(-time.Hour), now)
+			return err
+		},
+		"label values": func(ctx context.Context) error {
+			_, err := c.LabelValuesCtx(ctx, "job", now.Add(-time.Hour), now, nil)
+			return err
+		},
+		"label names": func(ctx context.Context) error {
+			_, err := c.LabelNamesCtx(ctx, now.Add(-time.Hour), now)
+			return err
+		},
+	}
+
+	for testName, call := range tests {
+		t.Run(testName, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(50*time.Millisecond, cancel)
+
+			start := time.Now()
+			err := call(ctx)
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, context.Canceled), err.Error())
+			assert.Less(t, int64(time.Since(start)), int64(time.Second))
+		})
+	}
+
+	t.Run("client timeout applies as a ceiling", func(t *testing.T) {
+		c.SetTimeout(50 * time.Millisecond)
+		defer c.SetTimeout(5 * time.Second)
+
+		_, err := c.QueryCtx(context.Background(), "up", now)
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded), err.Error())
+	})
+}
+
+func TestClient_RetryBackoff(t *testing.T) {
+	const failures = 2
+
+	// newServer returns a server failing the first requests with a 503.
+	newServer := func(t *testing.T) (*httptest.Server, *atomic.Int32) {
+		requests := atomic.NewInt32(0)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if requests.Inc() <= failures {
+				http.Error(w, "not ready", http.StatusServiceUnavailable)
+				return
+			}
+
+			// Requests sent again must carry the same body.
+			require.NoError(t, r.ParseForm())
+			assert.Equal(t, "up", r.Form.Get("query"))
+
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+		}))
+		t.Cleanup(server.Close)
+
+		return server, requests
+	}
+
+	retryBackoff := backoff.Config{MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond, MaxRetries: 3}
+
+	t.Run("should retry queries until they succeed", func(t *testing.T) {
+		server, requests := newServer(t)
+
+		c, err := NewClient("", strings.TrimPrefix(server.URL, "http://"), "", "", "user-1")
+		require.NoError(t, err)
+		c.SetRetryBackoff(retryBackoff)
+
+		_, err = c.Query("up", time.Now())
+		require.NoError(t, err)
+		assert.Equal(t, int32(failures+1), requests.Load())
+	})
+
+	t.Run("should retry GET requests until they succeed", func(t *testing.T) {
+		server, requests := newServer(t)
+
+		c, err := NewClient("", strings.TrimPrefix(server.URL, "http://"), "", "", "user-1")
+		require.NoError(t, err)
+		c.SetRetryBackoff(retryBackoff)
+
+		res, _, err := c.QueryRaw("up")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, int32(failures+1), requests.Load())
+	})
+
+	t.Run("should give up once the max retries are exhausted", func(t *testing.T) {
+		server, requests := newServer(t)
+
+		c, err := NewClient("", strings.TrimPrefix(server.URL, "http://"), "", "", "user-1")
+		require.NoError(t, err)
+		c.SetRetryBackoff(backoff.Config{MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond, MaxRetries: 1})
+
+		res, _, err := c.QueryRaw("up")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+		assert.Equal(t, int32(2), requests.Load())
+	})
+
+	t.Run("should not retry by default", func(t *testing.T) {
+		server, requests := newServer(t)
+
+		c, err := NewClient("", strings.TrimPrefix(server.URL, "http://"), "", "", "user-1")
+		require.NoError(t, err)
+
+		res, _, err := c.QueryRaw("up")
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+		assert.Equal(t, int32(1), requests.Load())
+	})
+
+	t.Run("should not retry pushes", func(t *testing.T) {
+		server, requests := newServer(t)
+
+		c, err := NewClient(strings.TrimPrefix(server.URL, "http://"), "", "", "", "user-1")
+		require.NoError(t, err)
+		c.SetRetryBackoff(retryBackoff)
+
+		res, err := c.Push([]prompb.TimeSeries{{Labels: []prompb.Label{{Name: labels.MetricName, Value: "up"}}}})
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+		assert.Equal(t, int32(1), requests.Load())
+	})
+}
+
+func TestClient_PushAndQueryExemplars(t *testing.T) {
+	now := time.Unix(1000, 0)
+
+	// The server stores the pushed exemplars and returns them to the exemplars queries.
+	var pushed []prompb.TimeSeries
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/prom/push":
+			compressed, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			uncompressed, err := snappy.Decode(nil, compressed)
+			require.NoError(t, err)
+
+			req := &prompb.WriteRequest{}
+			require.NoError(t, proto.Unmarshal(uncompressed, req))
+			pushed = append(pushed, req.Timeseries...)
+
+		case "/api/prom/api/v1/query_exemplars":
+			var results []map[string]interface{}
+			for _, ts := range pushed {
+				seriesLabels := map[string]string{}
+				for _, l := range ts.Labels {
+					seriesLabels[l.Name] = l.Value
+				}
+
+				var exemplars []map[string]interface{}
+				for _, e := range ts.Exemplars {
+					exemplarLabels := map[string]string{}
+					for _, l := range e.Labels {
+						exemplarLabels[l.Name] = l.Value
+					}
+					exemplars = append(exemplars, map[string]interface{}{
+						"labels":    exemplarLabels,
+						"value":     fmt.Sprintf("%g", e.Value),
+						"timestamp": float64(e.Timestamp) / 1000,
+					})
+				}
+				results = append(results, map[string]interface{}{"seriesLabels": seriesLabels, "exemplars": exemplars})
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "data": results}))
+
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	address := strings.TrimPrefix(server.URL, "http://")
+	c, err := NewClient(address, address, "", "", "user-1")
+	require.NoError(t, err)
+
+	res, err := c.Push([]prompb.TimeSeries{{
+		Labels:    []prompb.Label{{Name: labels.MetricName, Value: "series_1"}},
+		Samples:   []prompb.Sample{{Value: 1, Timestamp: e2e.TimeToMilliseconds(now)}},
+		Exemplars: []prompb.Exemplar{{Labels: []prompb.Label{{Name: "trace_id", Value: "abc"}}, Value: 1, Timestamp: e2e.TimeToMilliseconds(now)}},
+	}})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	actual, err := c.QueryExemplars("series_1", now.Add(-time.Minute), now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, []promv1.ExemplarQueryResult{{
+		SeriesLabels: model.LabelSet{labels.MetricName: "series_1"},
+		Exemplars: []promv1.Exemplar{{
+			Labels:    model.LabelSet{"trace_id": "abc"},
+			Value:     1,
+			Timestamp: model.TimeFromUnixNano(now.UnixNano()),
+		}},
+	}}, actual)
+}
+
+func TestClient_RuleGroups(t *testing.T) {
+	ruler := newStubRuler(t)
+	defer ruler.Close()
+
+	c, err := NewClient("", "", "", strings.TrimPrefix(ruler.URL, "http://"), "user-1")
+	require.NoError(t, err)
+
+	ruleGroup := func(name string) rulefmt.RuleGroup {
+		var expr, record yaml.Node
+		expr.SetString("up")
+		record.SetString("test:" + name)
+
+		return rulefmt.RuleGroup{Name: name, Rules: []rulefmt.RuleNode{{Record: record, Expr: expr}}}
+	}
+
+	// No rule groups configured yet.
+	_, err = c.GetRuleGroups()
+	assert.Equal(t, ErrNotFound, err)
+
+	require.NoError(t, c.SetRuleGroup(ruleGroup("group-1"), "namespace/1"))
+	require.NoError(t, c.SetRuleGroup(ruleGroup("group-2"), "namespace/1"))
+	require.NoError(t, c.SetRuleGroup(ruleGroup("group-1"), "namespace-2"))
+
+	ruleGroups, err := c.GetRuleGroups()
+	require.NoError(t, err)
+	require.Len(t, ruleGroups, 2)
+	require.Len(t, ruleGroups["namespace/1"], 2)
+	require.Len(t, ruleGroups["namespace-2"], 1)
+	assert.Equal(t, "test:group-1", ruleGroups["namespace-2"][0].Rules[0].Record.Value)
+
+	res, err := c.GetRuleGroup("namespace/1", "group-2")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.NoError(t, res.Body.Close())
+
+	require.NoError(t, c.DeleteRuleGroup("namespace/1", "group-2"))
+	require.NoError(t, c.DeleteRuleNamespace("namespace-2"))
+	assert.Equal(t, ErrNotFound, c.DeleteRuleGroup("namespace/1", "group-2"))
+
+	res, err = c.GetRuleGroup("namespace/1", "group-2")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	require.NoError(t, res.Body.Close())
+
+	ruleGroups, err = c.GetRuleGroups()
+	require.NoError(t, err)
+	require.Len(t, ruleGroups, 1)
+	require.Len(t, ruleGroups["namespace/1"], 1)
+	assert.Equal(t, "group-1", ruleGroups["namespace/1"][0].Name)
+
+	prometheusRules, err := c.GetPrometheusRules()
+	require.NoError(t, err)
+	require.Len(t, prometheusRules, 1)
+	assert.Equal(t, "group-1", prometheusRules[0].Name)
+}
+
+// newStubRuler returns a server implementing the ruler configuration API, and the
+// Prometheus-compatible rules API listing the configured rule groups.
+func newStubRuler(t *testing.T) *httptest.Server {
+	var (
+		mtx        sync.Mutex
+		ruleGroups = map[string][]rulefmt.RuleGroup{}
+	)
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		assert.Equal(t, "user-1", r.Header.Get("X-Scope-OrgID"))
+
+		if r.URL.Path == "/api/prom/api/v1/rules" {
+			var groups []map[string]interface{}
+			for _, namespace := range ruleGroups {
+				for _, group := range namespace {
+					groups = append(groups, map[string]interface{}{"name": group.Name, "file": "", "interval": 60, "rules": []interface{}{}})
+				}
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "data": map[string]interface{}{"groups": groups}}))
+			return
+		}
+
+		// The namespace and group name are escaped, so the raw path is split.
+		parts := strings.Split(strings.TrimPrefix(strings.TrimPrefix(r.URL.EscapedPath(), "/api/v1/rules"), "/"), "/")
+		for i := range parts {
+			var err error
+			parts[i], err = url.PathUnescape(parts[i])
+			require.NoError(t, err)
+		}
+
+		findGroup := func(namespace, name string) int {
+			for i, group := range ruleGroups[namespace] {
+				if group.Name == name {
+					return i
+				}
+			}
+			return -1
+		}
+
+		switch {
+		case r.Method == http.MethodGet && parts[0] == "":
+			if len(ruleGroups) == 0 {
+				http.Error(w, "no rule groups found", http.StatusNotFound)
+				return
+			}
+			data, err := yaml.Marshal(ruleGroups)
+			require.NoError(t, err)
+			_, _ = w.Write(data)
+
+		case r.Method == http.MethodGet && len(parts) == 2:
+			i := findGroup(parts[0], parts[1])
+			if i < 0 {
+				http.Error(w, "group does not exist", http.StatusNotFound)
+				return
+			}
+			data, err := yaml.Marshal(ruleGroups[parts[0]][i])
+			require.NoError(t, err)
+			_, _ = w.Write(data)
+
+		case r.Method == http.MethodPost && len(parts) == 1:
+			data, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+
+			group := rulefmt.RuleGroup{}
+			require.NoError(t, yaml.Unmarshal(data, &group))
+			if i := findGroup(parts[0], group.Name); i >= 0 {
+				ruleGroups[parts[0]][i] = group
+			} else {
+				ruleGroups[parts[0]] = append(ruleGroups[parts[0]], group)
+			}
+			w.WriteHeader(http.StatusAccepted)
+
+		case r.Method == http.MethodDelete && len(parts) == 2:
+			i := findGroup(parts[0], parts[1])
+			if i < 0 {
+				http.Error(w, "group does not exist", http.StatusNotFound)
+				return
+			}
+			ruleGroups[parts[0]] = append(ruleGroups[parts[0]][:i], ruleGroups[parts[0]][i+1:]...)
+			if len(ruleGroups[parts[0]]) == 0 {
+				delete(ruleGroups, parts[0])
+			}
+			w.WriteHeader(http.StatusAccepted)
+
+		case r.Method == http.MethodDelete && len(parts) == 1:
+			if _, ok := ruleGroups[parts[0]]; !ok {
+				http.Error(w, "namespace does not exist", http.StatusNotFound)
+				return
+			}
+			delete(ruleGroups, parts[0])
+			w.WriteHeader(http.StatusAccepted)
+
+		default:
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+		}
+	}))
+}
+
+func TestClient_SetAndDeleteAlertmanagerConfig(t *testing.T) {
+	var (
+		mtx    sync.Mutex
+		stored *userConfig
+	)
+
+	alertmanager := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		assert.Equal(t, "user-1", r.Header.Get("X-Scope-OrgID"))
+		assert.Equal(t, "/api/v1/alerts", r.URL.Path)
+
+		switch r.Method {
+		case http.MethodPost:
+			data, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+
+			cfg := &userConfig{}
+			require.NoError(t, yaml.Unmarshal(data, cfg))
+			if cfg.AlertmanagerConfig == "" {
+				http.Error(w, "configuration provided is empty", http.StatusBadRequest)
+				return
+			}
+			stored = cfg
+			w.WriteHeader(http.StatusCreated)
+
+		case http.MethodDelete:
+			if stored == nil {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			stored = nil
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer alertmanager.Close()
+
+	c, err := NewClient("", "", strings.TrimPrefix(alertmanager.URL, "http://"), "", "user-1")
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	amConfig := "route:\n  receiver: dummy\nreceivers:\n  - name: dummy\n"
+	templates := map[string]string{"first.tpl": "{{ define \"first\" }}first{{ end }}"}
+
+	require.NoError(t, c.SetAlertmanagerConfig(ctx, amConfig, templates))
+	assert.Equal(t, &userConfig{AlertmanagerConfig: amConfig, TemplateFiles: templates}, stored)
+
+	err = c.SetAlertmanagerConfig(ctx, "", nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "setting config failed with status 400")
+
+	require.NoError(t, c.DeleteAlertmanagerConfig(ctx))
+	assert.Nil(t, stored)
+	assert.Equal(t, ErrNotFound, c.DeleteAlertmanagerConfig(ctx))
+}
+
+func TestClient_FlushAndShutdownIngester(t *testing.T) {
+	tests := map[string]struct {
+		call         func(c *Client, address string) error
+		expectedPath string
+	}{
+		"flush": {
+			call:         (*Client).FlushIngester,
+			expectedPath: "/ingester/flush",
+		},
+		"shutdown": {
+			call:         (*Client).TriggerIngesterShutdown,
+			expectedPath: "/ingester/shutdown",
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			status := atomic.NewInt32(http.StatusNoContent)
+			requests := atomic.NewInt32(0)
+
+			ingester := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Inc()
+				assert.Equal(t, http.MethodPost, r.Method)
+				assert.Equal(t, testData.expectedPath, r.URL.Path)
+
+				w.WriteHeader(int(status.Load()))
+			}))
+			defer ingester.Close()
+
+			address := strings.TrimPrefix(ingester.URL, "http://")
+			c, err := NewClient("", "", "", "", "user-1")
+			require.NoError(t, err)
+
+			require.NoError(t, testData.call(c, address))
+			assert.Equal(t, int32(1), requests.Load())
+
+			status.Store(http.StatusServiceUnavailable)
+			err = testData.call(c, address)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "failed with status 503")
+		})
+	}
+}
+
+func TestClient_GetRingStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/store-gateway/ring" {
+			http.NotFound(w, r)
+			return
+		}
+		assert.Equal(t, "application/json", r.Header.Get("Accept"))
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{
+			"shards": [
+				{"id": "store-gateway-1", "state": "ACTIVE", "address": "1.1.1.1:9095", "timestamp": "2022-01-01 00:00:00 +0000 UTC", "registered_timestamp": "", "zone": "zone-a", "tokens": [1, 3]},
+				{"id": "store-gateway-2", "state": "LEAVING", "address": "2.2.2.2:9095", "timestamp": "2022-01-01 00:00:00 +0000 UTC", "registered_timestamp": "", "zone": "zone-b", "tokens": [2]}
+			],
+			"now": "2022-01-01T00:00:00Z"
+		}`))
+	}))
+	defer server.Close()
+
+	address := strings.TrimPrefix(server.URL, "http://")
+	c, err := NewClient("", "", "", "", "user-1")
+	require.NoError(t, err)
+
+	status, err := c.GetRingStatus(address, "/store-gateway/ring")
+	require.NoError(t, err)
+	assert.Equal(t, &RingStatus{Instances: []RingInstance{
+		{ID: "store-gateway-1", State: "ACTIVE", Address: "1.1.1.1:9095", Zone: "zone-a", Tokens: []uint32{1, 3}},
+		{ID: "store-gateway-2", State: "LEAVING", Address: "2.2.2.2:9095", Zone: "zone-b", Tokens: []uint32{2}},
+	}}, status)
+
+	_, err = c.GetRingStatus(address, "/compactor/ring")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed with status 404")
+}
+
+func TestClient_PushRemoteWriteVersions(t *testing.T) {
+	series := []prompb.TimeSeries{{
+		Labels:    []prompb.Label{{Name: labels.MetricName, Value: "series_1"}, {Name: "job", Value: "test"}},
+		Samples:   []prompb.Sample{{Value: 1, Timestamp: 1000}, {Value: 2, Timestamp: 2000}},
+		Exemplars: []prompb.Exemplar{{Labels: []prompb.Label{{Name: "trace_id", Value: "abc"}}, Value: 2, Timestamp: 2000}},
+	}, {
+		Labels:  []prompb.Label{{Name: labels.MetricName, Value: "series_2"}, {Name: "job", Value: "test"}},
+		Samples: []prompb.Sample{{Value: -1, Timestamp: 1000}},
+	}}
+
+	expected := []cortexpb.PreallocTimeseries{{TimeSeries: &cortexpb.TimeSeries{
+		Labels:    []cortexpb.LabelAdapter{{Name: labels.MetricName, Value: "series_1"}, {Name: "job", Value: "test"}},
+		Samples:   []cortexpb.Sample{{Value: 1, TimestampMs: 1000}, {Value: 2, TimestampMs: 2000}},
+		Exemplars: []cortexpb.Exemplar{{Labels: []cortexpb.LabelAdapter{{Name: "trace_id", Value: "abc"}}, Value: 2, TimestampMs: 2000}},
+	}}, {TimeSeries: &cortexpb.TimeSeries{
+		Labels:  []cortexpb.LabelAdapter{{Name: labels.MetricName, Value: "series_2"}, {Name: "job", Value: "test"}},
+		Samples: []cortexpb.Sample{{Value: -1, TimestampMs: 1000}},
+	}}}
+
+	tests := map[string]struct {
+		push                func(c *Client, series []prompb.TimeSeries) (*http.Response, error)
+		expectedContentType string
+		expectedVersion     string
+		expectedStatus      int
+	}{
+		"Remote-Write 1.0": {
+			push:                (*Client).Push,
+			expectedContentType: "application/x-protobuf",
+			expectedVersion:     "0.1.0",
+			expectedStatus:      http.StatusOK,
+		},
+		"Remote-Write 2.0": {
+			push:                (*Client).PushV2,
+			expectedContentType: "application/x-protobuf;proto=io.prometheus.write.v2.Request",
+			expectedVersion:     "2.0.0",
+			expectedStatus:      http.StatusNoContent,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			var received []cortexpb.PreallocTimeseries
+			handler := push.Handler(100000, nil, func(_ context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+				// The series are copied, since the handler reuses them once done.
+				for _, ts := range req.Timeseries {
+					received = append(received, cortexpb.PreallocTimeseries{TimeSeries: &cortexpb.TimeSeries{
+						Labels:    append([]cortexpb.LabelAdapter(nil), ts.Labels...),
+						Samples:   append([]cortexpb.Sample(nil), ts.Samples...),
+						Exemplars: append([]cortexpb.Exemplar(nil), ts.Exemplars...),
+					}})
+				}
+				return &cortexpb.WriteResponse{}, nil
+			})
+
+			distributor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/api/prom/push", r.URL.Path)
+				assert.Equal(t, "user-1", r.Header.Get("X-Scope-OrgID"))
+				assert.Equal(t, testData.expectedContentType, r.Header.Get("Content-Type"))
+				assert.Equal(t, testData.expectedVersion, r.Header.Get("X-Prometheus-Remote-Write-Version"))
+
+				handler.ServeHTTP(w, r)
+			}))
+			defer distributor.Close()
+
+			c, err := NewClient(strings.TrimPrefix(distributor.URL, "http://"), "", "", "", "user-1")
+			require.NoError(t, err)
+
+			res, err := testData.push(c, series)
+			require.NoError(t, err)
+			require.Equal(t, testData.expectedStatus, res.StatusCode)
+			assert.Equal(t, expected, received)
+		})
+	}
+}
+
+func TestClient_DeleteSeries(t *testing.T) {
+	var (
+		status  = http.StatusNoContent
+		request *http.Request
+	)
+
+	querier := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request = r
+		w.WriteHeader(status)
+	}))
+	defer querier.Close()
+
+	c, err := NewClient("", strings.TrimPrefix(querier.URL, "http://"), "", "", "user-1")
+	require.NoError(t, err)
+
+	start := time.Unix(1000, 500*int64(time.Millisecond))
+	end := time.Unix(2000, 0)
+	require.NoError(t, c.DeleteSeries([]string{`series_1{job="test"}`, "series_2"}, start, end))
+
+	require.NotNil(t, request)
+	assert.Equal(t, http.MethodDelete, request.Method)
+	assert.Equal(t, "/api/prom/api/v1/series", request.URL.Path)
+	assert.Equal(t, "user-1", request.Header.Get("X-Scope-OrgID"))
+	assert.Equal(t, []string{`series_1{job="test"}`, "series_2"}, request.URL.Query()["match[]"])
+	assert.Equal(t, "1000.5", request.URL.Query().Get("start"))
+	assert.Equal(t, "2000", request.URL.Query().Get("end"))
+
+	status = http.StatusBadRequest
+	require.Error(t, c.DeleteSeries([]string{"series_1"}, start, end))
+}
+
+func TestClient_DeleteTenant(t *testing.T) {
+	var deleted bool
+
+	purger := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenantID := r.Header.Get("X-Scope-OrgID")
+		if tenantID == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/purger/delete_tenant":
+			deleted = true
+		case r.Method == http.MethodGet && r.URL.Path == "/purger/delete_tenant_status":
+			_, _ = fmt.Fprintf(w, `{"tenant_id":%q,"blocks_deleted":%t}`, tenantID, deleted)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer purger.Close()
+
+	c, err := NewClient("", strings.TrimPrefix(purger.URL, "http://"), "", "", "user-1")
+	require.NoError(t, err)
+
+	status, err := c.DeleteTenantStatus()
+	require.NoError(t, err)
+	assert.Equal(t, TenantDeletionStatus{TenantID: "user-1", BlocksDeleted: false}, status)
+
+	res, err := c.DeleteTenant()
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	status, err = c.DeleteTenantStatus()
+	require.NoError(t, err)
+	assert.Equal(t, TenantDeletionStatus{TenantID: "user-1", BlocksDeleted: true}, status)
+
+	// The status can't be got without a tenant.
+	c, err = NewClient("", strings.TrimPrefix(purger.URL, "http://"), "", "", "")
+	require.NoError(t, err)
+
+	_, err = c.DeleteTenantStatus()
+	require.Error(t, err)
+}
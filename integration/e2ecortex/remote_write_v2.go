@@ -0,0 +1,81 @@
+package e2ecortex
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// marshalRemoteWrite2 encodes the input series as a Remote-Write 2.0 io.prometheus.write.v2.Request,
+// whose labels reference a symbols table shared by all the series. Native histograms and metadata
+// are not supported.
+func marshalRemoteWrite2(timeseries []prompb.TimeSeries) []byte {
+	symbols := []string{""}
+	refs := map[string]uint64{"": 0}
+
+	labelRefs := func(lbls []prompb.Label) []byte {
+		var b []byte
+		for _, l := range lbls {
+			for _, s := range []string{l.Name, l.Value} {
+				ref, ok := refs[s]
+				if !ok {
+					ref = uint64(len(symbols))
+					refs[s] = ref
+					symbols = append(symbols, s)
+				}
+				b = append(b, proto.EncodeVarint(ref)...)
+			}
+		}
+		return b
+	}
+
+	var series []byte
+	for _, ts := range timeseries {
+		var b []byte
+		b = appendBytesField(b, 1, labelRefs(ts.Labels))
+
+		for _, s := range ts.Samples {
+			var sample []byte
+			sample = appendFixed64Field(sample, 1, math.Float64bits(s.Value))
+			sample = appendVarintField(sample, 2, uint64(s.Timestamp))
+			b = appendBytesField(b, 2, sample)
+		}
+
+		for _, e := range ts.Exemplars {
+			var exemplar []byte
+			exemplar = appendBytesField(exemplar, 1, labelRefs(e.Labels))
+			exemplar = appendFixed64Field(exemplar, 2, math.Float64bits(e.Value))
+			exemplar = appendVarintField(exemplar, 3, uint64(e.Timestamp))
+			b = appendBytesField(b, 4, exemplar)
+		}
+
+		series = appendBytesField(series, 5, b)
+	}
+
+	var req []byte
+	for _, s := range symbols {
+		req = appendBytesField(req, 4, []byte(s))
+	}
+	return append(req, series...)
+}
+
+func appendVarintField(b []byte, num int, v uint64) []byte {
+	b = append(b, proto.EncodeVarint(uint64(num)<<3|proto.WireVarint)...)
+	return append(b, proto.EncodeVarint(v)...)
+}
+
+func appendFixed64Field(b []byte, num int, v uint64) []byte {
+	b = append(b, proto.EncodeVarint(uint64(num)<<3|proto.WireFixed64)...)
+
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendBytesField(b []byte, num int, data []byte) []byte {
+	b = append(b, proto.EncodeVarint(uint64(num)<<3|proto.WireBytes)...)
+	b = append(b, proto.EncodeVarint(uint64(len(data)))...)
+	return append(b, data...)
+}
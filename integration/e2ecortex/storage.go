@@ -4,9 +4,11 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/oklog/ulid"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
 	"github.com/thanos-io/thanos/pkg/objstore"
 
 	"github.com/cortexproject/cortex/integration/e2e"
@@ -88,3 +90,72 @@ func (c *S3Client) Delete(prefix string) error {
 		return c.writer.Delete(context.Background(), entry)
 	})
 }
+
+// WaitForCompactedBlock polls the tenant's blocks in the storage, the same listing
+// scanned by the compactor and store-gateway, until a block whose compaction sources
+// count equals expectedSources shows up, and returns its ULID.
+func (c *S3Client) WaitForCompactedBlock(tenant string, expectedSources int, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for {
+		blockID, err := c.findCompactedBlock(ctx, tenant, expectedSources)
+		if err != nil {
+			return "", err
+		}
+		if blockID != "" {
+			return blockID, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("no block with %d compaction sources found for tenant %s within %s", expectedSources, tenant, timeout)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// findCompactedBlock returns the ID of the first block of the tenant whose compaction
+// sources count equals expectedSources, or an empty string if there's none.
+func (c *S3Client) findCompactedBlock(ctx context.Context, tenant string, expectedSources int) (string, error) {
+	prefix := fmt.Sprintf("%s/", tenant)
+	found := ""
+
+	err := c.reader.Iter(ctx, prefix, func(entry string) error {
+		if found != "" {
+			return nil
+		}
+
+		blockID := strings.TrimSuffix(strings.TrimPrefix(entry, prefix), "/")
+
+		// Skip keys which are not block IDs
+		if _, err := ulid.Parse(blockID); err != nil {
+			return nil
+		}
+
+		r, err := c.reader.Get(ctx, fmt.Sprintf("%s%s/%s", prefix, blockID, metadata.MetaFilename))
+		if c.reader.IsObjNotFoundErr(err) {
+			// The block upload is still in progress.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		meta, err := metadata.Read(r)
+		if err != nil {
+			return err
+		}
+
+		if len(meta.Compaction.Sources) == expectedSources {
+			found = blockID
+		}
+		return nil
+	})
+
+	// A timeout while listing is reported by the caller.
+	if err != nil && ctx.Err() != nil {
+		return "", nil
+	}
+	return found, err
+}
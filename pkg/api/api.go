@@ -1,17 +1,29 @@
 package api
 
 import (
+	"compress/gzip"
 	"context"
 	"flag"
+	"fmt"
+	"html/template"
 	"net/http"
 	"path"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/NYTimes/gziphandler"
 	"github.com/felixge/fgprof"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/prometheus/storage"
+	"github.com/weaveworks/common/instrument"
 	"github.com/weaveworks/common/middleware"
 	"github.com/weaveworks/common/server"
 
@@ -31,6 +43,9 @@ import (
 	"github.com/cortexproject/cortex/pkg/scheduler/schedulerpb"
 	"github.com/cortexproject/cortex/pkg/storegateway"
 	"github.com/cortexproject/cortex/pkg/storegateway/storegatewaypb"
+	"github.com/cortexproject/cortex/pkg/tenant"
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
 	"github.com/cortexproject/cortex/pkg/util/push"
 )
 
@@ -39,20 +54,65 @@ type DistributorPushWrapper func(next push.Func) push.Func
 type ConfigHandler func(actualCfg interface{}, defaultCfg interface{}) http.HandlerFunc
 
 type Config struct {
-	ResponseCompression bool `yaml:"response_compression_enabled"`
+	ResponseCompression      bool `yaml:"response_compression_enabled"`
+	ResponseCompressionLevel int  `yaml:"response_compression_level"`
+
+	// Limits enforced on the push API before the write requests reach the distributor.
+	PushMaxLabelNameLength  int `yaml:"push_max_label_name_length"`
+	PushMaxLabelValueLength int `yaml:"push_max_label_value_length"`
 
 	AlertmanagerHTTPPrefix string `yaml:"alertmanager_http_prefix"`
 	PrometheusHTTPPrefix   string `yaml:"prometheus_http_prefix"`
 
+	// Name of the header the tenant ID is read from when authenticating requests.
+	AuthHeaderName string `yaml:"auth_header_name"`
+
+	// Disables the routes registered under the legacy paths.
+	DisableLegacyRoutes bool `yaml:"disable_legacy_routes"`
+
+	// Registers the fgprof profiling endpoint.
+	EnableProfiling bool `yaml:"profiling_enabled"`
+
+	// Origins allowed to issue cross-origin requests to the API. CORS is disabled if
+	// both are empty.
+	CORSAllowedOrigins      flagext.StringSliceCSV `yaml:"cors_allowed_origins"`
+	CORSAllowedOriginsRegex string                 `yaml:"cors_allowed_origins_regex"`
+	CORSAllowedHeaders      flagext.StringSliceCSV `yaml:"cors_allowed_headers"`
+
+	// Limits the body size of the POST and PUT requests, except the push ones.
+	MaxRequestBodySize int64 `yaml:"max_request_body_size"`
+
+	// Replaces the built-in index page template, either inline or as a file path.
+	IndexPageTemplate string `yaml:"index_page_template"`
+
 	// The following configs are injected by the upstream caller.
 	ServerPrefix       string               `yaml:"-"`
 	LegacyHTTPPrefix   string               `yaml:"-"`
 	HTTPAuthMiddleware middleware.Interface `yaml:"-"`
 
+	// TenantResponseCompression, when set, is consulted for every request authenticated
+	// with a tenant ID to decide whether the response should be compressed, overriding
+	// the global ResponseCompression setting.
+	TenantResponseCompression func(userID string) bool `yaml:"-"`
+
+	// ErrorMapper, when set, maps the errors served by the registered handlers to the
+	// HTTP status code of the response. The errors are either the panics of the handlers
+	// or HTTPError for the responses with a 4xx or 5xx status code. Returning 0 keeps
+	// the original status code.
+	ErrorMapper func(error) int `yaml:"-"`
+
+	// Registerer the API metrics, like the per-route request duration, are registered
+	// to. If not set, the metrics are tracked but not exported.
+	Registerer prometheus.Registerer `yaml:"-"`
+
 	// This allows downstream projects to wrap the distributor push function
 	// and access the deserialized write requests before/after they are pushed.
+	// More wrappers can be installed with AddDistributorPushWrapper().
 	DistributorPushWrapper DistributorPushWrapper `yaml:"-"`
 
+	// The wrappers added with AddDistributorPushWrapper(), in registration order.
+	distributorPushWrappers []DistributorPushWrapper
+
 	// The CustomConfigHandler allows for providing a different handler for the
 	// `/config` endpoint. If this field is set _before_ the API module is
 	// initialized, the custom config handler will be used instead of
@@ -63,6 +123,19 @@ type Config struct {
 // RegisterFlags adds the flags required to config this to the given FlagSet.
 func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
 	f.BoolVar(&cfg.ResponseCompression, "api.response-compression-enabled", false, "Use GZIP compression for API responses. Some endpoints serve large YAML or JSON blobs which can benefit from compression.")
+	cfg.ResponseCompressionLevel = defaultResponseCompressionLevel
+	f.Var(gzipLevelValue{level: &cfg.ResponseCompressionLevel}, "api.response-compression-level", fmt.Sprintf("GZIP compression level used for API responses, between %d (best speed) and %d (best compression).", gzip.BestSpeed, gzip.BestCompression))
+	f.IntVar(&cfg.PushMaxLabelNameLength, "api.push-max-label-name-length", 0, "Reject write requests received by the push API containing label names longer than this, before they reach the distributor. 0 to disable.")
+	f.IntVar(&cfg.PushMaxLabelValueLength, "api.push-max-label-value-length", 0, "Reject write requests received by the push API containing label values longer than this, before they reach the distributor. 0 to disable.")
+	f.BoolVar(&cfg.DisableLegacyRoutes, "api.disable-legacy-routes", false, "Do not register the legacy HTTP routes, like the ones under the legacy HTTP prefix. Only the canonical routes are served.")
+	f.StringVar(&cfg.AuthHeaderName, "api.auth-header-name", "", "Name of the HTTP header the tenant ID is read from when authentication is enabled. Requests without the header are rejected. The gRPC requests read it from the matching metadata key, always accepting X-Scope-OrgID as well, which is sent by the internal calls between Cortex components. Defaults to X-Scope-OrgID if empty.")
+	f.Var(&cfg.CORSAllowedOrigins, "api.cors-allowed-origins", "Comma-separated list of origins allowed to issue cross-origin requests to the API, or * to allow any origin. CORS headers are not added if empty, unless -api.cors-allowed-origins-regex is set.")
+	f.StringVar(&cfg.CORSAllowedOriginsRegex, "api.cors-allowed-origins-regex", "", "Regular expression matching the origins allowed to issue cross-origin requests to the API, in addition to -api.cors-allowed-origins. The regex is anchored.")
+	cfg.CORSAllowedHeaders = defaultCORSAllowedHeaders
+	f.Var(&cfg.CORSAllowedHeaders, "api.cors-allowed-headers", "Comma-separated list of request headers allowed in the cross-origin requests, returned in the response to the preflight requests. The header configured with -api.auth-header-name is always allowed.")
+	f.Int64Var(&cfg.MaxRequestBodySize, "api.max-request-body-size", 0, "Maximum size in bytes of the body of the POST and PUT requests, except the push ones which are limited by -distributor.max-recv-msg-size. Requests exceeding it are rejected with 413. 0 to disable.")
+	f.StringVar(&cfg.IndexPageTemplate, "api.index-page-template", "", "Go HTML template rendering the index page instead of the built-in one, either inline or as the path of the file to read it from. The value is an inline template if it contains {{, otherwise a file path. The template is executed with a map of section names to their sorted links, each having the Path and Description fields, and can prefix the links with the HTTP path prefix using the AddPathPrefix function.")
+	f.BoolVar(&cfg.EnableProfiling, "api.profiling-enabled", true, "Register the /debug/fgprof profiling endpoint. The /debug/pprof endpoints are controlled by -server.register-instrumentation.")
 	cfg.RegisterFlagsWithPrefix("", f)
 }
 
@@ -72,13 +145,70 @@ func (cfg *Config) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
 	f.StringVar(&cfg.PrometheusHTTPPrefix, prefix+"http.prometheus-http-prefix", "/prometheus", "HTTP URL path under which the Prometheus api will be served.")
 }
 
+// defaultCORSAllowedHeaders are the request headers allowed in the cross-origin requests by default.
+var defaultCORSAllowedHeaders = flagext.StringSliceCSV{"Accept", "Authorization", "Content-Encoding", "Content-Type", "X-Scope-OrgID"}
+
+// defaultResponseCompressionLevel is the level gzip.DefaultCompression stands for.
+const defaultResponseCompressionLevel = 6
+
+// gzipLevelValue is a flag.Value rejecting GZIP compression levels out of the
+// [gzip.BestSpeed, gzip.BestCompression] range while parsing flags.
+type gzipLevelValue struct {
+	level *int
+}
+
+func (v gzipLevelValue) String() string {
+	if v.level == nil {
+		return ""
+	}
+	return strconv.Itoa(*v.level)
+}
+
+func (v gzipLevelValue) Set(s string) error {
+	level, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	if level < gzip.BestSpeed || level > gzip.BestCompression {
+		return fmt.Errorf("invalid GZIP compression level %d, must be between %d and %d", level, gzip.BestSpeed, gzip.BestCompression)
+	}
+
+	*v.level = level
+	return nil
+}
+
+// AddDistributorPushWrapper installs a wrapper of the distributor push function, in addition
+// to DistributorPushWrapper and the wrappers previously added. The wrappers are composed in
+// registration order, the first one being the outermost: DistributorPushWrapper, if set, comes
+// first, followed by the added ones.
+func (cfg *Config) AddDistributorPushWrapper(w DistributorPushWrapper) {
+	if w != nil {
+		cfg.distributorPushWrappers = append(cfg.distributorPushWrappers, w)
+	}
+}
+
 // Push either wraps the distributor push function as configured or returns the distributor push directly.
+// The label length limits, if enabled, are checked before calling the wrapped push function.
 func (cfg *Config) wrapDistributorPush(d *distributor.Distributor) push.Func {
+	return cfg.wrapPush(d.Push)
+}
+
+func (cfg *Config) wrapPush(pushFn push.Func) push.Func {
+	wrappers := cfg.distributorPushWrappers
 	if cfg.DistributorPushWrapper != nil {
-		return cfg.DistributorPushWrapper(d.Push)
+		wrappers = append([]DistributorPushWrapper{cfg.DistributorPushWrapper}, wrappers...)
 	}
 
-	return d.Push
+	// Wrap starting from the innermost wrapper, so that the first one is the outermost.
+	for i := len(wrappers) - 1; i >= 0; i-- {
+		pushFn = wrappers[i](pushFn)
+	}
+
+	if cfg.PushMaxLabelNameLength > 0 || cfg.PushMaxLabelValueLength > 0 {
+		pushFn = labelLengthLimitPushWrapper(cfg.PushMaxLabelNameLength, cfg.PushMaxLabelValueLength)(pushFn)
+	}
+
+	return pushFn
 }
 
 type API struct {
@@ -89,6 +219,41 @@ type API struct {
 	logger    log.Logger
 	sourceIPs *middleware.SourceIPExtractor
 	indexPage *IndexPageContent
+
+	// indexPageTemplate is the text of the template rendering the index page.
+	indexPageTemplate string
+
+	// gzipWrapper wraps handlers with GZIP response compression at the configured level.
+	gzipWrapper func(http.Handler) http.Handler
+
+	// corsOriginsRegex matches the origins allowed to issue cross-origin requests, nil if not configured.
+	corsOriginsRegex *regexp.Regexp
+
+	// registry the API metrics are registered to.
+	registry prometheus.Registerer
+
+	// requestDuration tracks the time spent serving the requests, by registered route.
+	requestDuration *prometheus.HistogramVec
+
+	// registeredRoutes tracks the registered "METHOD path" tuples, used to detect
+	// routes registered twice.
+	registeredRoutes map[string]struct{}
+
+	// routes holds the metadata of all the registered routes, served by the routes catalog.
+	routesMtx sync.Mutex
+	routes    []RouteInfo
+
+	// readinessChecks are the named checks evaluated by the readiness probe, on top of the modules state.
+	readinessChecksMtx sync.Mutex
+	readinessChecks    map[string]func(ctx context.Context) error
+}
+
+// RouteInfo describes a route registered to the API.
+type RouteInfo struct {
+	Path    string   `json:"path"`
+	Prefix  bool     `json:"prefix"`
+	Methods []string `json:"methods"`
+	Auth    bool     `json:"auth"`
 }
 
 func New(cfg Config, serverCfg server.Config, s *server.Server, logger log.Logger) (*API, error) {
@@ -105,18 +270,64 @@ func New(cfg Config, serverCfg server.Config, s *server.Server, logger log.Logge
 		}
 	}
 
+	// The compression level is not set when the config is not built from flags.
+	compressionLevel := cfg.ResponseCompressionLevel
+	if compressionLevel == 0 {
+		compressionLevel = defaultResponseCompressionLevel
+	}
+	gzipWrapper, err := gziphandler.NewGzipLevelHandler(compressionLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	var corsOriginsRegex *regexp.Regexp
+	if cfg.CORSAllowedOriginsRegex != "" {
+		corsOriginsRegex, err = regexp.Compile("^(?:" + cfg.CORSAllowedOriginsRegex + ")$")
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid CORS allowed origins regex")
+		}
+	}
+
+	indexPageTemplateText := indexPageTemplate
+	if cfg.IndexPageTemplate != "" {
+		indexPageTemplateText, err = loadIndexPageTemplate(cfg.IndexPageTemplate)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := newIndexPageTemplate("", indexPageTemplateText); err != nil {
+			return nil, errors.Wrap(err, "invalid index page template")
+		}
+	}
+
+	registry := cfg.Registerer
+	if registry == nil {
+		registry = prometheus.NewRegistry()
+	}
+
 	api := &API{
-		cfg:            cfg,
-		AuthMiddleware: cfg.HTTPAuthMiddleware,
-		server:         s,
-		logger:         logger,
-		sourceIPs:      sourceIPs,
-		indexPage:      newIndexPageContent(),
+		cfg:               cfg,
+		AuthMiddleware:    cfg.HTTPAuthMiddleware,
+		server:            s,
+		logger:            logger,
+		sourceIPs:         sourceIPs,
+		indexPage:         newIndexPageContent(),
+		indexPageTemplate: indexPageTemplateText,
+		gzipWrapper:       gzipWrapper,
+		corsOriginsRegex:  corsOriginsRegex,
+		registry:          registry,
+
+		registeredRoutes: map[string]struct{}{},
 	}
 
-	// If no authentication middleware is present in the config, use the default authentication middleware.
+	api.requestDuration = newRequestDurationHistogram(api.registry)
+
+	// If no authentication middleware is present in the config, use the default authentication middleware,
+	// reading the tenant ID from the configured header if any.
 	if cfg.HTTPAuthMiddleware == nil {
 		api.AuthMiddleware = middleware.AuthenticateUser
+		if cfg.AuthHeaderName != "" {
+			api.AuthMiddleware = authenticateUserFromHeader(cfg.AuthHeaderName)
+		}
 	}
 
 	return api, nil
@@ -124,41 +335,211 @@ func New(cfg Config, serverCfg server.Config, s *server.Server, logger log.Logge
 
 // RegisterRoute registers a single route enforcing HTTP methods. A single
 // route is expected to be specific about which HTTP methods are supported.
-func (a *API) RegisterRoute(path string, handler http.Handler, auth bool, method string, methods ...string) {
+// The registered route is returned, so that further matchers can be added to it.
+func (a *API) RegisterRoute(path string, handler http.Handler, auth bool, method string, methods ...string) *mux.Route {
+	return a.registerRoute(path, handler, auth, true, true, method, methods...)
+}
+
+// RegisterRouteUncompressed registers a single route like RegisterRoute, but its responses
+// are never compressed, even when the response compression is enabled. This is required
+// by routes streaming their response, given the compression buffers it.
+func (a *API) RegisterRouteUncompressed(path string, handler http.Handler, auth bool, method string, methods ...string) *mux.Route {
+	return a.registerRoute(path, handler, auth, false, true, method, methods...)
+}
+
+// RegisterRouteE registers a route like RegisterRoute, but returns an error without
+// registering it if the same path has already been registered for any of the methods.
+func (a *API) RegisterRouteE(path string, handler http.Handler, auth bool, method string, methods ...string) (*mux.Route, error) {
+	if err := a.checkDuplicateRoute(path, append([]string{method}, methods...)); err != nil {
+		return nil, err
+	}
+	return a.registerRoute(path, handler, auth, true, true, method, methods...), nil
+}
+
+// registerPushRoute registers a push route. The request body size of the push routes is
+// not limited by the max request body size, given it's limited by the push handler.
+func (a *API) registerPushRoute(path string, handler http.Handler) *mux.Route {
+	return a.registerRoute(path, handler, true, true, false, "POST")
+}
+
+func (a *API) registerRoute(path string, handler http.Handler, auth, compress, limitBody bool, method string, methods ...string) *mux.Route {
 	methods = append([]string{method}, methods...)
 
-	level.Debug(a.logger).Log("msg", "api: registering route", "methods", strings.Join(methods, ","), "path", path, "auth", auth)
+	level.Debug(a.logger).Log("msg", "api: registering route", "methods", strings.Join(methods, ","), "path", path, "auth", auth, "compress", compress)
 
-	if auth {
-		handler = a.AuthMiddleware.Wrap(handler)
+	// A duplicate route is still registered, but the first registered one takes precedence.
+	if err := a.checkDuplicateRoute(path, methods); err != nil {
+		level.Warn(a.logger).Log("msg", "api: route registered twice, only the first registration will serve requests", "err", err)
 	}
+	for _, m := range methods {
+		a.registeredRoutes[routeKey(m, path)] = struct{}{}
+	}
+	a.addRouteInfo(RouteInfo{Path: path, Methods: methods, Auth: auth})
 
-	if a.cfg.ResponseCompression {
-		handler = gziphandler.GzipHandler(handler)
+	if limitBody && a.cfg.MaxRequestBodySize > 0 && hasRequestBody(methods) {
+		handler = maxRequestBodySizeMiddleware(a.cfg.MaxRequestBodySize).Wrap(handler)
 	}
+	handler = a.instrumentRoute(path, a.wrapHandler(handler, auth, compress, methods))
+
+	if len(methods) == 0 {
+		return a.server.HTTP.Path(path).Handler(handler)
+	}
+	return a.server.HTTP.Path(path).Methods(a.routeMethods(methods)...).Handler(handler)
+}
+
+// checkDuplicateRoute returns an error if the path has already been registered for any of
+// the input methods.
+func (a *API) checkDuplicateRoute(path string, methods []string) error {
+	for _, m := range methods {
+		if _, ok := a.registeredRoutes[routeKey(m, path)]; ok {
+			return fmt.Errorf("route %s %s has already been registered", strings.ToUpper(m), path)
+		}
+	}
+	return nil
+}
+
+func routeKey(method, path string) string {
+	return strings.ToUpper(method) + " " + path
+}
+
+// RegisterRoutesWithPrefix registers a route matching all the paths with the given prefix.
+// The registered route is returned, so that further matchers can be added to it.
+func (a *API) RegisterRoutesWithPrefix(prefix string, handler http.Handler, auth bool, methods ...string) *mux.Route {
+	return a.registerRoutesWithPrefix(prefix, handler, auth, true, methods...)
+}
+
+// RegisterRoutesWithPrefixUncompressed registers routes like RegisterRoutesWithPrefix, but
+// their responses are never compressed, even when the response compression is enabled.
+func (a *API) RegisterRoutesWithPrefixUncompressed(prefix string, handler http.Handler, auth bool, methods ...string) *mux.Route {
+	return a.registerRoutesWithPrefix(prefix, handler, auth, false, methods...)
+}
+
+func (a *API) registerRoutesWithPrefix(prefix string, handler http.Handler, auth, compress bool, methods ...string) *mux.Route {
+	level.Debug(a.logger).Log("msg", "api: registering route", "methods", strings.Join(methods, ","), "prefix", prefix, "auth", auth, "compress", compress)
+	a.addRouteInfo(RouteInfo{Path: prefix, Prefix: true, Methods: methods, Auth: auth})
+
+	handler = a.instrumentRoute(prefix, a.wrapHandler(handler, auth, compress, methods))
 
 	if len(methods) == 0 {
-		a.server.HTTP.Path(path).Handler(handler)
-		return
+		return a.server.HTTP.PathPrefix(prefix).Handler(handler)
+	}
+	return a.server.HTTP.PathPrefix(prefix).Methods(a.routeMethods(methods)...).Handler(handler)
+}
+
+// routeMethods returns the HTTP methods matched by a route serving the input methods,
+// including the CORS preflight requests if CORS is enabled.
+func (a *API) routeMethods(methods []string) []string {
+	if !a.corsEnabled() {
+		return methods
+	}
+	return append(methods[:len(methods):len(methods)], http.MethodOptions)
+}
+
+func (a *API) corsEnabled() bool {
+	return len(a.cfg.CORSAllowedOrigins) > 0 || a.corsOriginsRegex != nil
+}
+
+// corsAllowedHeaders returns the request headers allowed in the cross-origin requests,
+// including the header the tenant ID is read from.
+func (a *API) corsAllowedHeaders() []string {
+	headers := a.cfg.CORSAllowedHeaders
+	if a.cfg.AuthHeaderName == "" {
+		return headers
+	}
+	for _, h := range headers {
+		if strings.EqualFold(h, a.cfg.AuthHeaderName) {
+			return headers
+		}
+	}
+	return append(headers[:len(headers):len(headers)], a.cfg.AuthHeaderName)
+}
+
+// corsOriginAllowed returns whether the origin is allowed to issue cross-origin requests.
+func (a *API) corsOriginAllowed(origin string) bool {
+	for _, o := range a.cfg.CORSAllowedOrigins {
+		if o == "*" || o == origin {
+			return true
+		}
 	}
-	a.server.HTTP.Path(path).Methods(methods...).Handler(handler)
+	return a.corsOriginsRegex != nil && a.corsOriginsRegex.MatchString(origin)
 }
 
-func (a *API) RegisterRoutesWithPrefix(prefix string, handler http.Handler, auth bool, methods ...string) {
-	level.Debug(a.logger).Log("msg", "api: registering route", "methods", strings.Join(methods, ","), "prefix", prefix, "auth", auth)
+func (a *API) addRouteInfo(info RouteInfo) {
+	methods := make([]string, 0, len(info.Methods))
+	for _, m := range info.Methods {
+		methods = append(methods, strings.ToUpper(m))
+	}
+	sort.Strings(methods)
+	info.Methods = methods
+
+	a.routesMtx.Lock()
+	a.routes = append(a.routes, info)
+	a.routesMtx.Unlock()
+}
+
+// wrapHandler wraps the handler of a route with the authentication, the response
+// compression and the CORS middlewares, if required.
+func (a *API) wrapHandler(handler http.Handler, auth, compress bool, methods []string) http.Handler {
+	if a.cfg.ErrorMapper != nil {
+		handler = errorMapperMiddleware(a.cfg.ErrorMapper).Wrap(handler)
+	}
+
+	// The compression depends on the tenant, so it must be decided once the request is authenticated.
+	if compress {
+		handler = a.compressionHandler(handler)
+	}
+
 	if auth {
 		handler = a.AuthMiddleware.Wrap(handler)
 	}
 
-	if a.cfg.ResponseCompression {
-		handler = gziphandler.GzipHandler(handler)
+	// The preflight requests carry no credentials, so CORS must be handled before authentication.
+	if a.corsEnabled() {
+		handler = corsMiddleware(a.corsOriginAllowed, methods, a.corsAllowedHeaders()).Wrap(handler)
 	}
 
-	if len(methods) == 0 {
-		a.server.HTTP.PathPrefix(prefix).Handler(handler)
-		return
+	return handler
+}
+
+func newRequestDurationHistogram(reg prometheus.Registerer) *prometheus.HistogramVec {
+	return promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "cortex_api_request_duration_seconds",
+		Help:    "Time (in seconds) spent serving the HTTP requests, by registered route and status code.",
+		Buckets: instrument.DefBuckets,
+	}, []string{"route", "status_code"})
+}
+
+// instrumentRoute tracks the duration of the requests served by the route registered with
+// the given path. The registered path, not the request URL, labels the observations, so
+// that the path templates like /api/v1/label/{name}/values don't blow up the cardinality.
+func (a *API) instrumentRoute(path string, handler http.Handler) http.Handler {
+	return instrumentRouteMiddleware(path, a.requestDuration).Wrap(handler)
+}
+
+// compressionHandler wraps the handler with GZIP response compression, based on the
+// global setting and, if configured, the per-tenant override. The tenant is read from
+// the request context, so the override only applies to the routes registered with auth.
+func (a *API) compressionHandler(handler http.Handler) http.Handler {
+	if a.cfg.TenantResponseCompression == nil {
+		if a.cfg.ResponseCompression {
+			return a.gzipWrapper(handler)
+		}
+		return handler
 	}
-	a.server.HTTP.PathPrefix(prefix).Methods(methods...).Handler(handler)
+
+	compressed := a.gzipWrapper(handler)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enabled := a.cfg.ResponseCompression
+		if userID, err := tenant.TenantID(r.Context()); err == nil {
+			enabled = a.cfg.TenantResponseCompression(userID)
+		}
+
+		if enabled {
+			compressed.ServeHTTP(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
 }
 
 // RegisterAPI registers the standard endpoints associated with a running Cortex.
@@ -167,8 +548,59 @@ func (a *API) RegisterAPI(httpPathPrefix string, actualCfg interface{}, defaultC
 	a.indexPage.AddLink(SectionAdminEndpoints, "/config?mode=diff", "Current Config (show only values that differ from the defaults)")
 
 	a.RegisterRoute("/config", a.cfg.configHandler(actualCfg, defaultCfg), false, "GET")
-	a.RegisterRoute("/", indexHandler(httpPathPrefix, a.indexPage), false, "GET")
-	a.RegisterRoute("/debug/fgprof", fgprof.Handler(), false, "GET")
+	a.RegisterRoute("/", indexHandlerWithTemplate(template.Must(newIndexPageTemplate(httpPathPrefix, a.indexPageTemplate)), a.indexPage), false, "GET")
+
+	if a.cfg.EnableProfiling {
+		a.RegisterRoute("/debug/fgprof", fgprof.Handler(), false, "GET")
+	}
+}
+
+// RegisterRouteCatalog registers an endpoint listing all the routes registered to the API,
+// including the ones registered after calling this function.
+func (a *API) RegisterRouteCatalog() {
+	a.indexPage.AddLink(SectionAdminEndpoints, "/api/v1/routes", "Registered HTTP Routes")
+	a.RegisterRoute("/api/v1/routes", http.HandlerFunc(a.routeCatalogHandler), false, "GET")
+}
+
+func (a *API) routeCatalogHandler(w http.ResponseWriter, _ *http.Request) {
+	a.routesMtx.Lock()
+	routes := append([]RouteInfo(nil), a.routes...)
+	a.routesMtx.Unlock()
+
+	// Sort the routes to keep the output stable across restarts.
+	sort.SliceStable(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		if routes[i].Prefix != routes[j].Prefix {
+			return !routes[i].Prefix
+		}
+		return strings.Join(routes[i].Methods, ",") < strings.Join(routes[j].Methods, ",")
+	})
+
+	util.WriteJSONResponse(w, routes)
+}
+
+// BuildInfo holds the version and build information of the running Cortex.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision"`
+	Branch    string `json:"branch"`
+	GoVersion string `json:"goVersion"`
+}
+
+type buildInfoResponse struct {
+	Status string    `json:"status"`
+	Data   BuildInfo `json:"data"`
+}
+
+// RegisterBuildInfo registers the endpoint returning the build information in the
+// same format of the Prometheus API.
+func (a *API) RegisterBuildInfo(info BuildInfo) {
+	a.indexPage.AddLink(SectionAdminEndpoints, "/api/v1/status/buildinfo", "Build Information")
+	a.RegisterRoute("/api/v1/status/buildinfo", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		util.WriteJSONResponse(w, buildInfoResponse{Status: "success", Data: info})
+	}), false, "GET")
 }
 
 // RegisterRuntimeConfig registers the endpoints associates with the runtime configuration
@@ -179,11 +611,19 @@ func (a *API) RegisterRuntimeConfig(runtimeConfigHandler http.HandlerFunc) {
 	a.RegisterRoute("/runtime_config", runtimeConfigHandler, false, "GET")
 }
 
+// RegisterEffectiveConfigHandler registers the endpoint serving the config in effect for
+// the tenant of the request, the base config with the tenant's runtime overrides applied.
+func (a *API) RegisterEffectiveConfigHandler(h http.Handler) {
+	a.indexPage.AddLink(SectionTenantAdmin, "/api/v1/status/config", "Effective Config (incl. the Tenant's Overrides)")
+
+	a.RegisterRoute("/api/v1/status/config", h, true, "GET")
+}
+
 // RegisterDistributor registers the endpoints associated with the distributor.
 func (a *API) RegisterDistributor(d *distributor.Distributor, pushConfig distributor.Config) {
 	distributorpb.RegisterDistributorServer(a.server.GRPC, d)
 
-	a.RegisterRoute("/api/v1/push", push.Handler(pushConfig.MaxRecvMsgSize, a.sourceIPs, a.cfg.wrapDistributorPush(d)), true, "POST")
+	a.registerPushRoute("/api/v1/push", push.Handler(pushConfig.MaxRecvMsgSize, a.sourceIPs, a.cfg.wrapDistributorPush(d)))
 
 	a.indexPage.AddLink(SectionAdminEndpoints, "/distributor/ring", "Distributor Ring Status")
 	a.indexPage.AddLink(SectionAdminEndpoints, "/distributor/all_user_stats", "Usage Statistics")
@@ -194,9 +634,17 @@ func (a *API) RegisterDistributor(d *distributor.Distributor, pushConfig distrib
 	a.RegisterRoute("/distributor/ha_tracker", d.HATracker, false, "GET")
 
 	// Legacy Routes
-	a.RegisterRoute(path.Join(a.cfg.LegacyHTTPPrefix, "/push"), push.Handler(pushConfig.MaxRecvMsgSize, a.sourceIPs, a.cfg.wrapDistributorPush(d)), true, "POST")
-	a.RegisterRoute("/all_user_stats", http.HandlerFunc(d.AllUserStatsHandler), false, "GET")
-	a.RegisterRoute("/ha-tracker", d.HATracker, false, "GET")
+	if !a.cfg.DisableLegacyRoutes {
+		a.registerPushRoute(path.Join(a.cfg.LegacyHTTPPrefix, "/push"), push.Handler(pushConfig.MaxRecvMsgSize, a.sourceIPs, a.cfg.wrapDistributorPush(d)))
+		a.RegisterRoute("/all_user_stats", http.HandlerFunc(d.AllUserStatsHandler), false, "GET")
+		a.RegisterRoute("/ha-tracker", d.HATracker, false, "GET")
+	}
+}
+
+// RegisterOTLPHandler registers the endpoint ingesting metrics pushed via OTLP/HTTP,
+// which are translated to a WriteRequest and pushed through the distributor.
+func (a *API) RegisterOTLPHandler(d *distributor.Distributor, pushConfig distributor.Config) {
+	a.registerPushRoute("/api/v1/otlp/v1/metrics", push.OTLPHandler(pushConfig.MaxRecvMsgSize, a.sourceIPs, a.cfg.wrapDistributorPush(d)))
 }
 
 // Ingester is defined as an interface to allow for alternative implementations
@@ -205,6 +653,8 @@ type Ingester interface {
 	client.IngesterServer
 	FlushHandler(http.ResponseWriter, *http.Request)
 	ShutdownHandler(http.ResponseWriter, *http.Request)
+	RingReregisterHandler(http.ResponseWriter, *http.Request)
+	StatsHandler(http.ResponseWriter, *http.Request)
 	Push(context.Context, *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error)
 }
 
@@ -216,15 +666,21 @@ func (a *API) RegisterIngester(i Ingester, pushConfig distributor.Config) {
 	a.indexPage.AddLink(SectionDangerous, "/ingester/shutdown", "Trigger Ingester Shutdown (Dangerous)")
 	a.RegisterRoute("/ingester/flush", http.HandlerFunc(i.FlushHandler), false, "GET", "POST")
 	a.RegisterRoute("/ingester/shutdown", http.HandlerFunc(i.ShutdownHandler), false, "GET", "POST")
-	a.RegisterRoute("/ingester/push", push.Handler(pushConfig.MaxRecvMsgSize, a.sourceIPs, i.Push), true, "POST") // For testing and debugging.
+	a.RegisterRoute("/ingester/ring/reregister", http.HandlerFunc(i.RingReregisterHandler), false, "POST") // For testing and debugging.
+	a.RegisterRoute("/ingester/stats", http.HandlerFunc(i.StatsHandler), false, "GET")                     // For testing and debugging.
+	a.registerPushRoute("/ingester/push", push.Handler(pushConfig.MaxRecvMsgSize, a.sourceIPs, i.Push))    // For testing and debugging.
 
 	// Legacy Routes
-	a.RegisterRoute("/flush", http.HandlerFunc(i.FlushHandler), false, "GET", "POST")
-	a.RegisterRoute("/shutdown", http.HandlerFunc(i.ShutdownHandler), false, "GET", "POST")
-	a.RegisterRoute("/push", push.Handler(pushConfig.MaxRecvMsgSize, a.sourceIPs, i.Push), true, "POST") // For testing and debugging.
+	if !a.cfg.DisableLegacyRoutes {
+		a.RegisterRoute("/flush", http.HandlerFunc(i.FlushHandler), false, "GET", "POST")
+		a.RegisterRoute("/shutdown", http.HandlerFunc(i.ShutdownHandler), false, "GET", "POST")
+		a.registerPushRoute("/push", push.Handler(pushConfig.MaxRecvMsgSize, a.sourceIPs, i.Push)) // For testing and debugging.
+	}
 }
 
 func (a *API) RegisterTenantDeletion(api *purger.TenantDeletionAPI) {
+	// Only the status is linked, given the deletion is requested with a POST.
+	a.indexPage.AddLink(SectionTenantAdmin, "/purger/delete_tenant_status", "Tenant Deletion Status")
 	a.RegisterRoute("/purger/delete_tenant", http.HandlerFunc(api.DeleteTenant), true, "POST")
 	a.RegisterRoute("/purger/delete_tenant_status", http.HandlerFunc(api.DeleteTenantStatus), true, "GET")
 }
@@ -235,7 +691,9 @@ func (a *API) RegisterRing(r *ring.Ring) {
 	a.RegisterRoute("/ingester/ring", r, false, "GET", "POST")
 
 	// Legacy Route
-	a.RegisterRoute("/ring", r, false, "GET", "POST")
+	if !a.cfg.DisableLegacyRoutes {
+		a.RegisterRoute("/ring", r, false, "GET", "POST")
+	}
 }
 
 // RegisterStoreGateway registers the ring UI page associated with the store-gateway.
@@ -266,7 +724,9 @@ func (a *API) RegisterQueryable(
 	// these routes are always registered to the default server
 	a.RegisterRoute("/api/v1/user_stats", http.HandlerFunc(distributor.UserStatsHandler), true, "GET")
 
-	a.RegisterRoute(path.Join(a.cfg.LegacyHTTPPrefix, "/user_stats"), http.HandlerFunc(distributor.UserStatsHandler), true, "GET")
+	if !a.cfg.DisableLegacyRoutes {
+		a.RegisterRoute(path.Join(a.cfg.LegacyHTTPPrefix, "/user_stats"), http.HandlerFunc(distributor.UserStatsHandler), true, "GET")
+	}
 }
 
 // RegisterQueryAPI registers the Prometheus API routes with the provided handler.
@@ -275,20 +735,39 @@ func (a *API) RegisterQueryAPI(handler http.Handler) {
 	a.RegisterRoute(path.Join(a.cfg.PrometheusHTTPPrefix, "/api/v1/query"), handler, true, "GET", "POST")
 	a.RegisterRoute(path.Join(a.cfg.PrometheusHTTPPrefix, "/api/v1/query_range"), handler, true, "GET", "POST")
 	a.RegisterRoute(path.Join(a.cfg.PrometheusHTTPPrefix, "/api/v1/query_exemplars"), handler, true, "GET", "POST")
+	a.RegisterRoute(path.Join(a.cfg.PrometheusHTTPPrefix, "/api/v1/format_query"), handler, true, "GET", "POST")
+	a.RegisterRoute(path.Join(a.cfg.PrometheusHTTPPrefix, "/api/v1/parse_query"), handler, true, "GET", "POST")
 	a.RegisterRoute(path.Join(a.cfg.PrometheusHTTPPrefix, "/api/v1/labels"), handler, true, "GET", "POST")
 	a.RegisterRoute(path.Join(a.cfg.PrometheusHTTPPrefix, "/api/v1/label/{name}/values"), handler, true, "GET")
 	a.RegisterRoute(path.Join(a.cfg.PrometheusHTTPPrefix, "/api/v1/series"), handler, true, "GET", "POST", "DELETE")
 	a.RegisterRoute(path.Join(a.cfg.PrometheusHTTPPrefix, "/api/v1/metadata"), handler, true, "GET")
 
 	// Register Legacy Routers
-	a.RegisterRoute(path.Join(a.cfg.LegacyHTTPPrefix, "/api/v1/read"), handler, true, "POST")
-	a.RegisterRoute(path.Join(a.cfg.LegacyHTTPPrefix, "/api/v1/query"), handler, true, "GET", "POST")
-	a.RegisterRoute(path.Join(a.cfg.LegacyHTTPPrefix, "/api/v1/query_range"), handler, true, "GET", "POST")
-	a.RegisterRoute(path.Join(a.cfg.LegacyHTTPPrefix, "/api/v1/query_exemplars"), handler, true, "GET", "POST")
-	a.RegisterRoute(path.Join(a.cfg.LegacyHTTPPrefix, "/api/v1/labels"), handler, true, "GET", "POST")
-	a.RegisterRoute(path.Join(a.cfg.LegacyHTTPPrefix, "/api/v1/label/{name}/values"), handler, true, "GET")
-	a.RegisterRoute(path.Join(a.cfg.LegacyHTTPPrefix, "/api/v1/series"), handler, true, "GET", "POST", "DELETE")
-	a.RegisterRoute(path.Join(a.cfg.LegacyHTTPPrefix, "/api/v1/metadata"), handler, true, "GET")
+	if !a.cfg.DisableLegacyRoutes {
+		a.RegisterRoute(path.Join(a.cfg.LegacyHTTPPrefix, "/api/v1/read"), handler, true, "POST")
+		a.RegisterRoute(path.Join(a.cfg.LegacyHTTPPrefix, "/api/v1/query"), handler, true, "GET", "POST")
+		a.RegisterRoute(path.Join(a.cfg.LegacyHTTPPrefix, "/api/v1/query_range"), handler, true, "GET", "POST")
+		a.RegisterRoute(path.Join(a.cfg.LegacyHTTPPrefix, "/api/v1/query_exemplars"), handler, true, "GET", "POST")
+		a.RegisterRoute(path.Join(a.cfg.LegacyHTTPPrefix, "/api/v1/format_query"), handler, true, "GET", "POST")
+		a.RegisterRoute(path.Join(a.cfg.LegacyHTTPPrefix, "/api/v1/parse_query"), handler, true, "GET", "POST")
+		a.RegisterRoute(path.Join(a.cfg.LegacyHTTPPrefix, "/api/v1/labels"), handler, true, "GET", "POST")
+		a.RegisterRoute(path.Join(a.cfg.LegacyHTTPPrefix, "/api/v1/label/{name}/values"), handler, true, "GET")
+		a.RegisterRoute(path.Join(a.cfg.LegacyHTTPPrefix, "/api/v1/series"), handler, true, "GET", "POST", "DELETE")
+		a.RegisterRoute(path.Join(a.cfg.LegacyHTTPPrefix, "/api/v1/metadata"), handler, true, "GET")
+	}
+}
+
+// RegisterCardinalityHandler registers the cardinality analysis routes, which report the
+// label names and label values cardinality of the tenant, with the provided handler.
+func (a *API) RegisterCardinalityHandler(h http.Handler) {
+	a.RegisterRoute(path.Join(a.cfg.PrometheusHTTPPrefix, "/api/v1/cardinality/label_names"), h, true, "GET", "POST")
+	a.RegisterRoute(path.Join(a.cfg.PrometheusHTTPPrefix, "/api/v1/cardinality/label_values"), h, true, "GET", "POST")
+
+	// Register Legacy Routers
+	if !a.cfg.DisableLegacyRoutes {
+		a.RegisterRoute(path.Join(a.cfg.LegacyHTTPPrefix, "/api/v1/cardinality/label_names"), h, true, "GET", "POST")
+		a.RegisterRoute(path.Join(a.cfg.LegacyHTTPPrefix, "/api/v1/cardinality/label_values"), h, true, "GET", "POST")
+	}
 }
 
 // RegisterQueryFrontend registers the Prometheus routes supported by the
@@ -319,6 +798,63 @@ func (a *API) RegisterServiceMapHandler(handler http.Handler) {
 	a.RegisterRoute("/services", handler, false, "GET")
 }
 
+// RegisterReadyHandler registers the readiness probe, which should report whether all
+// the modules have started and are ready to serve traffic.
+func (a *API) RegisterReadyHandler(handler http.Handler) {
+	a.indexPage.AddLink(SectionAdminEndpoints, "/ready", "Readiness Probe")
+	a.RegisterRoute("/ready", handler, false, "GET", "HEAD")
+}
+
+// AddReadinessCheck adds a named check evaluated by the readiness probe, like the ring health or
+// the connectivity to downstream services: the instance is reported ready only when all the checks
+// pass. Adding a check with the name of an existing one replaces it.
+func (a *API) AddReadinessCheck(name string, check func(ctx context.Context) error) {
+	a.readinessChecksMtx.Lock()
+	defer a.readinessChecksMtx.Unlock()
+
+	if a.readinessChecks == nil {
+		a.readinessChecks = map[string]func(ctx context.Context) error{}
+	}
+	a.readinessChecks[name] = check
+}
+
+// CheckReadiness runs the readiness checks added with AddReadinessCheck, returning an error listing
+// the failing ones, if any.
+func (a *API) CheckReadiness(ctx context.Context) error {
+	a.readinessChecksMtx.Lock()
+	checks := make(map[string]func(ctx context.Context) error, len(a.readinessChecks))
+	for name, check := range a.readinessChecks {
+		checks[name] = check
+	}
+	a.readinessChecksMtx.Unlock()
+
+	// Run the checks in a stable order, so that the failures are reported consistently.
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var failures []string
+	for _, name := range names {
+		if err := checks[name](ctx); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, ", "))
+	}
+	return nil
+}
+
+// RegisterLiveHandler registers the liveness probe, which should be cheap and only
+// report whether the process is up.
+func (a *API) RegisterLiveHandler(handler http.Handler) {
+	a.indexPage.AddLink(SectionAdminEndpoints, "/healthz", "Liveness Probe")
+	a.RegisterRoute("/healthz", handler, false, "GET", "HEAD")
+}
+
 func (a *API) RegisterMemberlistKV(handler http.Handler) {
 	a.indexPage.AddLink(SectionAdminEndpoints, "/memberlist", "Memberlist Status")
 	a.RegisterRoute("/memberlist", handler, false, "GET")
@@ -1,11 +1,35 @@
 package api
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
 	"testing"
 
+	"github.com/go-kit/log"
+	"github.com/gogo/protobuf/proto"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/weaveworks/common/server"
+	"github.com/weaveworks/common/user"
+	"google.golang.org/grpc"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/distributor"
+	"github.com/cortexproject/cortex/pkg/ring"
+	"github.com/cortexproject/cortex/pkg/util/concurrency"
+	"github.com/cortexproject/cortex/pkg/util/push"
 )
 
 type FakeLogger struct{}
@@ -60,3 +84,994 @@ func TestNewApiWithInvalidSourceIPExtractor(t *testing.T) {
 	require.Error(t, err)
 	require.Nil(t, api)
 }
+
+func TestResponseCompressionLevelFlag(t *testing.T) {
+	for _, tc := range []struct {
+		args          []string
+		expectedLevel int
+		expectedErr   bool
+	}{
+		{args: nil, expectedLevel: defaultResponseCompressionLevel},
+		{args: []string{"-api.response-compression-level=1"}, expectedLevel: 1},
+		{args: []string{"-api.response-compression-level=9"}, expectedLevel: 9},
+		{args: []string{"-api.response-compression-level=0"}, expectedErr: true},
+		{args: []string{"-api.response-compression-level=10"}, expectedErr: true},
+		{args: []string{"-api.response-compression-level=-1"}, expectedErr: true},
+	} {
+		t.Run(strings.Join(tc.args, " "), func(t *testing.T) {
+			cfg := Config{}
+			fs := flag.NewFlagSet("", flag.ContinueOnError)
+			cfg.RegisterFlags(fs)
+
+			err := fs.Parse(tc.args)
+			if tc.expectedErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedLevel, cfg.ResponseCompressionLevel)
+		})
+	}
+}
+
+func TestResponseCompressionLevel(t *testing.T) {
+	// The gzip header XFL byte signals the fastest or the best compression levels.
+	for level, expectedXFL := range map[int]byte{1: 4, 9: 2} {
+		cfg := Config{ResponseCompression: true, ResponseCompressionLevel: level}
+		serverCfg := server.Config{HTTPListenNetwork: server.DefaultNetwork}
+		s := &server.Server{HTTP: mux.NewRouter()}
+
+		api, err := New(cfg, serverCfg, s, &FakeLogger{})
+		require.NoError(t, err)
+
+		content := strings.Repeat("x", 4096)
+		api.RegisterRoute("/test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, err := w.Write([]byte(content))
+			assert.NoError(t, err)
+		}), false, "GET")
+
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp := httptest.NewRecorder()
+		s.HTTP.ServeHTTP(resp, req)
+
+		require.Equal(t, 200, resp.Code)
+		require.Equal(t, "gzip", resp.Header().Get("Content-Encoding"))
+		require.Greater(t, resp.Body.Len(), 8)
+		assert.Equal(t, expectedXFL, resp.Body.Bytes()[8], "level %d", level)
+	}
+}
+
+func TestNewApiWithInvalidResponseCompressionLevel(t *testing.T) {
+	cfg := Config{ResponseCompressionLevel: 10}
+	serverCfg := server.Config{HTTPListenNetwork: server.DefaultNetwork}
+
+	api, err := New(cfg, serverCfg, &server.Server{HTTP: mux.NewRouter()}, &FakeLogger{})
+	require.Error(t, err)
+	require.Nil(t, api)
+}
+
+func TestRegisterRouteUncompressed(t *testing.T) {
+	cfg := Config{ResponseCompression: true}
+	serverCfg := server.Config{HTTPListenNetwork: server.DefaultNetwork}
+	s := &server.Server{HTTP: mux.NewRouter()}
+
+	api, err := New(cfg, serverCfg, s, &FakeLogger{})
+	require.NoError(t, err)
+
+	// The response must be big enough to get compressed.
+	content := strings.Repeat("x", 4096)
+	streamingHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 2; i++ {
+			_, err := w.Write([]byte(content))
+			assert.NoError(t, err)
+			w.(http.Flusher).Flush()
+		}
+	})
+
+	api.RegisterRoute("/compressed", streamingHandler, false, "GET")
+	api.RegisterRouteUncompressed("/uncompressed", streamingHandler, false, "GET")
+	api.RegisterRoutesWithPrefixUncompressed("/uncompressed-prefix/", streamingHandler, false, "GET")
+
+	for _, tc := range []struct {
+		path               string
+		expectedCompressed bool
+	}{
+		{path: "/compressed", expectedCompressed: true},
+		{path: "/uncompressed", expectedCompressed: false},
+		{path: "/uncompressed-prefix/stream", expectedCompressed: false},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.path, nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			resp := httptest.NewRecorder()
+			s.HTTP.ServeHTTP(resp, req)
+
+			require.Equal(t, 200, resp.Code)
+			if tc.expectedCompressed {
+				assert.Equal(t, "gzip", resp.Header().Get("Content-Encoding"))
+				return
+			}
+
+			assert.Empty(t, resp.Header().Get("Content-Encoding"))
+			assert.True(t, resp.Flushed)
+			assert.Equal(t, content+content, resp.Body.String())
+		})
+	}
+}
+
+func TestRegisterRouteReturnsRoute(t *testing.T) {
+	serverCfg := server.Config{HTTPListenNetwork: server.DefaultNetwork}
+	s := &server.Server{HTTP: mux.NewRouter()}
+
+	api, err := New(Config{}, serverCfg, s, &FakeLogger{})
+	require.NoError(t, err)
+
+	okHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	api.RegisterRoute("/route", okHandler, false, "GET").Headers("X-Shard", "1")
+	api.RegisterRoutesWithPrefix("/prefix/", okHandler, false, "GET").Headers("X-Shard", "1")
+
+	for _, path := range []string{"/route", "/prefix/route"} {
+		t.Run(path, func(t *testing.T) {
+			// The request doesn't match the route without the header.
+			resp := httptest.NewRecorder()
+			s.HTTP.ServeHTTP(resp, httptest.NewRequest("GET", path, nil))
+			assert.Equal(t, http.StatusNotFound, resp.Code)
+
+			req := httptest.NewRequest("GET", path, nil)
+			req.Header.Set("X-Shard", "1")
+			resp = httptest.NewRecorder()
+			s.HTTP.ServeHTTP(resp, req)
+			assert.Equal(t, http.StatusOK, resp.Code)
+		})
+	}
+}
+
+func TestRegisterRouteDuplicate(t *testing.T) {
+	serverCfg := server.Config{HTTPListenNetwork: server.DefaultNetwork}
+	logs := &concurrency.SyncBuffer{}
+
+	api, err := New(Config{}, serverCfg, &server.Server{HTTP: mux.NewRouter()}, log.NewLogfmtLogger(logs))
+	require.NoError(t, err)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	// Registering the same path for different methods is legit.
+	api.RegisterRoute("/x", handler, false, "GET")
+	api.RegisterRoute("/x", handler, false, "POST")
+	assert.NotContains(t, logs.String(), "route registered twice")
+
+	api.RegisterRoute("/x", handler, false, "PUT", "get")
+	assert.Contains(t, logs.String(), "route GET /x has already been registered")
+
+	route, err := api.RegisterRouteE("/x", handler, false, "POST")
+	require.EqualError(t, err, "route POST /x has already been registered")
+	require.Nil(t, route)
+
+	route, err = api.RegisterRouteE("/y", handler, false, "POST")
+	require.NoError(t, err)
+	require.NotNil(t, route)
+}
+
+func TestRouteCatalog(t *testing.T) {
+	serverCfg := server.Config{HTTPListenNetwork: server.DefaultNetwork}
+	s := &server.Server{HTTP: mux.NewRouter(), GRPC: grpc.NewServer()}
+
+	api, err := New(Config{}, serverCfg, s, &FakeLogger{})
+	require.NoError(t, err)
+
+	api.RegisterRouteCatalog()
+	api.RegisterDistributor(&distributor.Distributor{}, distributor.Config{})
+	api.RegisterRoutesWithPrefix("/prefix/", http.NotFoundHandler(), false)
+
+	req := httptest.NewRequest("GET", "/api/v1/routes", nil)
+	resp := httptest.NewRecorder()
+	s.HTTP.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	var routes []RouteInfo
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &routes))
+
+	assert.Contains(t, routes, RouteInfo{Path: "/api/v1/push", Methods: []string{"POST"}, Auth: true})
+	assert.Contains(t, routes, RouteInfo{Path: "/api/v1/routes", Methods: []string{"GET"}, Auth: false})
+	assert.Contains(t, routes, RouteInfo{Path: "/prefix/", Prefix: true, Methods: []string{}, Auth: false})
+	assert.Contains(t, routes, RouteInfo{Path: "/distributor/ring", Methods: []string{"GET", "POST"}, Auth: false})
+
+	assert.True(t, sort.SliceIsSorted(routes, func(i, j int) bool {
+		return routes[i].Path < routes[j].Path
+	}))
+
+	// The output is stable.
+	resp2 := httptest.NewRecorder()
+	s.HTTP.ServeHTTP(resp2, req)
+	assert.Equal(t, resp.Body.String(), resp2.Body.String())
+}
+
+func TestAuthHeaderName(t *testing.T) {
+	cfg := Config{AuthHeaderName: "X-Tenant"}
+	serverCfg := server.Config{HTTPListenNetwork: server.DefaultNetwork}
+	s := &server.Server{HTTP: mux.NewRouter()}
+
+	api, err := New(cfg, serverCfg, s, &FakeLogger{})
+	require.NoError(t, err)
+
+	api.RegisterRoute("/test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		orgID, err := user.ExtractOrgID(r.Context())
+		require.NoError(t, err)
+		assert.Equal(t, orgID, r.Header.Get(user.OrgIDHeaderName))
+		_, _ = w.Write([]byte(orgID))
+	}), true, "GET")
+
+	for _, tc := range []struct {
+		name           string
+		headers        map[string]string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "tenant in the configured header",
+			headers:        map[string]string{"X-Tenant": "user-1"},
+			expectedStatus: http.StatusOK,
+			expectedBody:   "user-1",
+		},
+		{
+			name:           "tenant in the configured header overrides the default header",
+			headers:        map[string]string{"X-Tenant": "user-1", user.OrgIDHeaderName: "user-2"},
+			expectedStatus: http.StatusOK,
+			expectedBody:   "user-1",
+		},
+		{
+			name:           "tenant only in the default header",
+			headers:        map[string]string{user.OrgIDHeaderName: "user-2"},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "no tenant",
+			expectedStatus: http.StatusUnauthorized,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/test", nil)
+			for name, value := range tc.headers {
+				req.Header.Set(name, value)
+			}
+			resp := httptest.NewRecorder()
+			s.HTTP.ServeHTTP(resp, req)
+
+			require.Equal(t, tc.expectedStatus, resp.Code)
+			if tc.expectedBody != "" {
+				assert.Equal(t, tc.expectedBody, resp.Body.String())
+			}
+		})
+	}
+}
+
+func TestDisableLegacyRoutes(t *testing.T) {
+	okHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	canonicalRoutes := map[string]string{
+		"/prometheus/api/v1/query": "GET",
+		"/api/v1/push":             "POST",
+		"/distributor/ha_tracker":  "GET",
+		"/ingester/ring":           "GET",
+	}
+	legacyRoutes := map[string]string{
+		"/api/prom/api/v1/query": "GET",
+		"/api/prom/push":         "POST",
+		"/ha-tracker":            "GET",
+		"/ring":                  "GET",
+	}
+
+	for _, disabled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("disabled=%t", disabled), func(t *testing.T) {
+			cfg := Config{
+				PrometheusHTTPPrefix: "/prometheus",
+				LegacyHTTPPrefix:     "/api/prom",
+				DisableLegacyRoutes:  disabled,
+			}
+			serverCfg := server.Config{HTTPListenNetwork: server.DefaultNetwork}
+			s := &server.Server{HTTP: mux.NewRouter(), GRPC: grpc.NewServer()}
+
+			api, err := New(cfg, serverCfg, s, &FakeLogger{})
+			require.NoError(t, err)
+
+			api.RegisterQueryAPI(okHandler)
+			api.RegisterDistributor(&distributor.Distributor{}, distributor.Config{})
+			api.RegisterRing(&ring.Ring{})
+
+			matches := func(path, method string) bool {
+				return s.HTTP.Match(httptest.NewRequest(method, path, nil), &mux.RouteMatch{})
+			}
+
+			for path, method := range canonicalRoutes {
+				assert.True(t, matches(path, method), path)
+			}
+			for path, method := range legacyRoutes {
+				assert.Equal(t, !disabled, matches(path, method), path)
+			}
+
+			// The canonical routes keep serving requests.
+			req := httptest.NewRequest("GET", "/prometheus/api/v1/query", nil)
+			req.Header.Set(user.OrgIDHeaderName, "user-1")
+			resp := httptest.NewRecorder()
+			s.HTTP.ServeHTTP(resp, req)
+			assert.Equal(t, http.StatusOK, resp.Code)
+
+			req = httptest.NewRequest("GET", "/api/prom/api/v1/query", nil)
+			req.Header.Set(user.OrgIDHeaderName, "user-1")
+			resp = httptest.NewRecorder()
+			s.HTTP.ServeHTTP(resp, req)
+			if disabled {
+				assert.Equal(t, http.StatusNotFound, resp.Code)
+			} else {
+				assert.Equal(t, http.StatusOK, resp.Code)
+			}
+		})
+	}
+}
+
+func TestRegisterCardinalityHandler(t *testing.T) {
+	cfg := Config{
+		PrometheusHTTPPrefix: "/prometheus",
+		LegacyHTTPPrefix:     "/api/prom",
+	}
+	serverCfg := server.Config{HTTPListenNetwork: server.DefaultNetwork}
+	s := &server.Server{HTTP: mux.NewRouter()}
+
+	api, err := New(cfg, serverCfg, s, &FakeLogger{})
+	require.NoError(t, err)
+
+	api.RegisterCardinalityHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		orgID, err := user.ExtractOrgID(r.Context())
+		require.NoError(t, err)
+		_, _ = w.Write([]byte(orgID + " " + r.URL.Path))
+	}))
+
+	for _, p := range []string{
+		"/prometheus/api/v1/cardinality/label_names",
+		"/prometheus/api/v1/cardinality/label_values",
+		"/api/prom/api/v1/cardinality/label_names",
+		"/api/prom/api/v1/cardinality/label_values",
+	} {
+		for _, method := range []string{"GET", "POST"} {
+			t.Run(method+" "+p, func(t *testing.T) {
+				// Requests without a tenant are rejected.
+				resp := httptest.NewRecorder()
+				s.HTTP.ServeHTTP(resp, httptest.NewRequest(method, p, nil))
+				assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+				req := httptest.NewRequest(method, p, nil)
+				req.Header.Set(user.OrgIDHeaderName, "user-1")
+				resp = httptest.NewRecorder()
+				s.HTTP.ServeHTTP(resp, req)
+				require.Equal(t, http.StatusOK, resp.Code)
+				assert.Equal(t, "user-1 "+p, resp.Body.String())
+			})
+		}
+	}
+}
+
+func TestRegisterEffectiveConfigHandler(t *testing.T) {
+	serverCfg := server.Config{HTTPListenNetwork: server.DefaultNetwork}
+	s := &server.Server{HTTP: mux.NewRouter()}
+
+	api, err := New(Config{}, serverCfg, s, &FakeLogger{})
+	require.NoError(t, err)
+
+	api.RegisterEffectiveConfigHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		orgID, err := user.ExtractOrgID(r.Context())
+		require.NoError(t, err)
+		_, _ = w.Write([]byte(orgID))
+	}))
+
+	// The endpoint is tenant-scoped, so it requires auth.
+	resp := httptest.NewRecorder()
+	s.HTTP.ServeHTTP(resp, httptest.NewRequest("GET", "/api/v1/status/config", nil))
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	req := httptest.NewRequest("GET", "/api/v1/status/config", nil)
+	req.Header.Set(user.OrgIDHeaderName, "user-1")
+	resp = httptest.NewRecorder()
+	s.HTTP.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "user-1", resp.Body.String())
+}
+
+func TestRouteRequestDuration(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	cfg := Config{PrometheusHTTPPrefix: "/prometheus", Registerer: reg}
+	serverCfg := server.Config{HTTPListenNetwork: server.DefaultNetwork}
+	s := &server.Server{HTTP: mux.NewRouter()}
+
+	api, err := New(cfg, serverCfg, s, &FakeLogger{})
+	require.NoError(t, err)
+
+	api.RegisterRoute("/config", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("config"))
+	}), false, "GET")
+	api.RegisterRoute("/prometheus/api/v1/label/{name}/values", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), true, "GET")
+	api.RegisterRoutesWithPrefix("/prefix/", http.NotFoundHandler(), false, "GET")
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest("GET", "/config", nil),
+		httptest.NewRequest("GET", "/prometheus/api/v1/label/foo/values", nil),
+		httptest.NewRequest("GET", "/prometheus/api/v1/label/bar/values", nil),
+		httptest.NewRequest("GET", "/prefix/something", nil),
+	} {
+		req.Header.Set(user.OrgIDHeaderName, "user-1")
+		s.HTTP.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Requests failing the authentication are tracked too.
+	s.HTTP.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/prometheus/api/v1/label/foo/values", nil))
+
+	metrics, err := reg.Gather()
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+	assert.Equal(t, "cortex_api_request_duration_seconds", metrics[0].GetName())
+
+	counts := map[string]uint64{}
+	for _, m := range metrics[0].GetMetric() {
+		labels := map[string]string{}
+		for _, l := range m.GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+		counts[labels["route"]+" "+labels["status_code"]] = m.GetHistogram().GetSampleCount()
+	}
+
+	assert.Equal(t, map[string]uint64{
+		"/config 200": 1,
+		"/prometheus/api/v1/label/{name}/values 204": 2,
+		"/prometheus/api/v1/label/{name}/values 401": 1,
+		"/prefix/ 404": 1,
+	}, counts)
+}
+
+func TestBuildInfo(t *testing.T) {
+	serverCfg := server.Config{HTTPListenNetwork: server.DefaultNetwork}
+	s := &server.Server{HTTP: mux.NewRouter()}
+
+	api, err := New(Config{}, serverCfg, s, &FakeLogger{})
+	require.NoError(t, err)
+
+	api.RegisterBuildInfo(BuildInfo{
+		Version:   "1.13.0",
+		Revision:  "abcdef",
+		Branch:    "main",
+		GoVersion: "go1.17",
+	})
+
+	req := httptest.NewRequest("GET", "/api/v1/status/buildinfo", nil)
+	resp := httptest.NewRecorder()
+	s.HTTP.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "application/json", resp.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"status": "success",
+		"data": {
+			"version": "1.13.0",
+			"revision": "abcdef",
+			"branch": "main",
+			"goVersion": "go1.17"
+		}
+	}`, resp.Body.String())
+
+	// The endpoint is linked from the index page.
+	assert.Equal(t, "Build Information", api.indexPage.GetContent()[SectionAdminEndpoints]["/api/v1/status/buildinfo"])
+}
+
+func TestReadyAndLiveHandlers(t *testing.T) {
+	for _, tc := range []struct {
+		path       string
+		statusCode int
+		register   func(a *API, h http.Handler)
+		linkName   string
+	}{
+		{
+			path:       "/ready",
+			statusCode: http.StatusServiceUnavailable,
+			register:   (*API).RegisterReadyHandler,
+			linkName:   "Readiness Probe",
+		},
+		{
+			path:       "/healthz",
+			statusCode: http.StatusOK,
+			register:   (*API).RegisterLiveHandler,
+			linkName:   "Liveness Probe",
+		},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			serverCfg := server.Config{HTTPListenNetwork: server.DefaultNetwork}
+			s := &server.Server{HTTP: mux.NewRouter()}
+
+			api, err := New(Config{}, serverCfg, s, &FakeLogger{})
+			require.NoError(t, err)
+
+			tc.register(api, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(tc.statusCode)
+			}))
+
+			// No tenant ID is set, so the request would be rejected if the route required auth.
+			req := httptest.NewRequest("GET", tc.path, nil)
+			resp := httptest.NewRecorder()
+			s.HTTP.ServeHTTP(resp, req)
+
+			assert.Equal(t, tc.statusCode, resp.Code)
+			assert.Equal(t, tc.linkName, api.indexPage.GetContent()[SectionAdminEndpoints][tc.path])
+		})
+	}
+}
+
+func TestAddReadinessCheck(t *testing.T) {
+	var ringErr, storeGatewayErr error
+
+	api, err := New(Config{}, server.Config{}, &server.Server{HTTP: mux.NewRouter()}, &FakeLogger{})
+	require.NoError(t, err)
+	require.NoError(t, api.CheckReadiness(context.Background()))
+
+	api.AddReadinessCheck("store-gateway", func(context.Context) error { return storeGatewayErr })
+	api.AddReadinessCheck("ring", func(context.Context) error { return errors.New("replaced") })
+	api.AddReadinessCheck("ring", func(context.Context) error { return ringErr })
+
+	for _, tc := range []struct {
+		name            string
+		ringErr         error
+		storeGatewayErr error
+		expectedErr     string
+	}{
+		{
+			name: "all checks pass",
+		},
+		{
+			name:            "one check fails",
+			storeGatewayErr: errors.New("unreachable"),
+			expectedErr:     "store-gateway: unreachable",
+		},
+		{
+			name:            "all checks fail",
+			ringErr:         errors.New("not ready"),
+			storeGatewayErr: errors.New("unreachable"),
+			expectedErr:     "ring: not ready, store-gateway: unreachable",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ringErr, storeGatewayErr = tc.ringErr, tc.storeGatewayErr
+
+			err := api.CheckReadiness(context.Background())
+			if tc.expectedErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func TestProfilingEndpoint(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("enabled=%t", enabled), func(t *testing.T) {
+			serverCfg := server.Config{HTTPListenNetwork: server.DefaultNetwork}
+			s := &server.Server{HTTP: mux.NewRouter()}
+
+			api, err := New(Config{EnableProfiling: enabled}, serverCfg, s, &FakeLogger{})
+			require.NoError(t, err)
+
+			api.RegisterAPI("", struct{}{}, struct{}{})
+
+			req := httptest.NewRequest("GET", "/debug/fgprof", nil)
+			assert.Equal(t, enabled, s.HTTP.Match(req, &mux.RouteMatch{}))
+
+			if !enabled {
+				resp := httptest.NewRecorder()
+				s.HTTP.ServeHTTP(resp, req)
+				assert.Equal(t, http.StatusNotFound, resp.Code)
+			}
+		})
+	}
+}
+
+func TestCORS(t *testing.T) {
+	tests := map[string]struct {
+		cfg             Config
+		method          string
+		headers         map[string]string
+		expectedStatus  int
+		expectedHeaders map[string]string
+	}{
+		"allowed origin": {
+			cfg:             Config{CORSAllowedOrigins: []string{"https://dashboard.example.com"}},
+			method:          http.MethodGet,
+			headers:         map[string]string{"Origin": "https://dashboard.example.com"},
+			expectedStatus:  http.StatusOK,
+			expectedHeaders: map[string]string{"Access-Control-Allow-Origin": "https://dashboard.example.com"},
+		},
+		"allowed origin by regex": {
+			cfg:             Config{CORSAllowedOriginsRegex: `https://.*\.example\.com`},
+			method:          http.MethodGet,
+			headers:         map[string]string{"Origin": "https://dashboard.example.com"},
+			expectedStatus:  http.StatusOK,
+			expectedHeaders: map[string]string{"Access-Control-Allow-Origin": "https://dashboard.example.com"},
+		},
+		"disallowed origin": {
+			cfg:             Config{CORSAllowedOrigins: []string{"https://dashboard.example.com"}, CORSAllowedOriginsRegex: `https://.*\.example\.com`},
+			method:          http.MethodGet,
+			headers:         map[string]string{"Origin": "https://example.org"},
+			expectedStatus:  http.StatusOK,
+			expectedHeaders: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		"CORS disabled": {
+			method:          http.MethodGet,
+			headers:         map[string]string{"Origin": "https://dashboard.example.com"},
+			expectedStatus:  http.StatusOK,
+			expectedHeaders: map[string]string{"Access-Control-Allow-Origin": "", "Vary": ""},
+		},
+		"preflight request": {
+			cfg:    Config{CORSAllowedOrigins: []string{"*"}, CORSAllowedHeaders: []string{"Content-Type", "X-Scope-OrgID"}},
+			method: http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                         "https://dashboard.example.com",
+				"Access-Control-Request-Method":  "POST",
+				"Access-Control-Request-Headers": "Content-Type, X-Not-Allowed",
+			},
+			expectedStatus: http.StatusNoContent,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Origin":  "https://dashboard.example.com",
+				"Access-Control-Allow-Methods": "GET, POST",
+				"Access-Control-Allow-Headers": "Content-Type, X-Scope-OrgID",
+			},
+		},
+		"preflight request allowing the auth header": {
+			cfg:    Config{CORSAllowedOrigins: []string{"*"}, CORSAllowedHeaders: []string{"Content-Type"}, AuthHeaderName: "X-Tenant"},
+			method: http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                        "https://dashboard.example.com",
+				"Access-Control-Request-Method": "GET",
+			},
+			expectedStatus: http.StatusNoContent,
+			expectedHeaders: map[string]string{
+				"Access-Control-Allow-Headers": "Content-Type, X-Tenant",
+			},
+		},
+		"preflight request from a disallowed origin": {
+			cfg:    Config{CORSAllowedOrigins: []string{"https://dashboard.example.com"}},
+			method: http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                        "https://example.org",
+				"Access-Control-Request-Method": "POST",
+			},
+			expectedStatus:  http.StatusForbidden,
+			expectedHeaders: map[string]string{"Access-Control-Allow-Origin": "", "Access-Control-Allow-Methods": ""},
+		},
+		"OPTIONS request which is not a preflight": {
+			cfg:             Config{CORSAllowedOrigins: []string{"*"}},
+			method:          http.MethodOptions,
+			headers:         map[string]string{"Origin": "https://dashboard.example.com"},
+			expectedStatus:  http.StatusNoContent,
+			expectedHeaders: map[string]string{"Access-Control-Allow-Methods": ""},
+		},
+		"OPTIONS request without origin": {
+			cfg:             Config{CORSAllowedOrigins: []string{"*"}},
+			method:          http.MethodOptions,
+			expectedStatus:  http.StatusNoContent,
+			expectedHeaders: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			serverCfg := server.Config{HTTPListenNetwork: server.DefaultNetwork}
+			s := &server.Server{HTTP: mux.NewRouter()}
+
+			api, err := New(testData.cfg, serverCfg, s, &FakeLogger{})
+			require.NoError(t, err)
+
+			called := false
+			api.RegisterRoute("/prometheus/api/v1/query_range", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				called = true
+				w.WriteHeader(http.StatusOK)
+			}), true, "GET", "POST")
+
+			req := httptest.NewRequest(testData.method, "/prometheus/api/v1/query_range", nil)
+			for name, value := range testData.headers {
+				req.Header.Set(name, value)
+			}
+			// The preflight requests carry no credentials.
+			if testData.method != http.MethodOptions {
+				req.Header.Set("X-Scope-OrgID", "user-1")
+			}
+
+			resp := httptest.NewRecorder()
+			s.HTTP.ServeHTTP(resp, req)
+
+			assert.Equal(t, testData.expectedStatus, resp.Code)
+			assert.Equal(t, testData.method != http.MethodOptions, called)
+			for name, value := range testData.expectedHeaders {
+				assert.Equal(t, value, resp.Header().Get(name), name)
+			}
+		})
+	}
+}
+
+func TestCORSOptionsRequestsNeverReachTheHandler(t *testing.T) {
+	serverCfg := server.Config{HTTPListenNetwork: server.DefaultNetwork}
+	s := &server.Server{HTTP: mux.NewRouter()}
+
+	api, err := New(Config{CORSAllowedOrigins: []string{"*"}}, serverCfg, s, &FakeLogger{})
+	require.NoError(t, err)
+
+	called := false
+	api.RegisterRoute("/ingester/shutdown", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		called = true
+	}), false, "GET", "POST")
+
+	for _, origin := range []string{"", "https://dashboard.example.com"} {
+		req := httptest.NewRequest(http.MethodOptions, "/ingester/shutdown", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		resp := httptest.NewRecorder()
+		s.HTTP.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusNoContent, resp.Code)
+	}
+	assert.False(t, called)
+}
+
+func TestCORSInvalidRegex(t *testing.T) {
+	serverCfg := server.Config{HTTPListenNetwork: server.DefaultNetwork}
+	s := &server.Server{HTTP: mux.NewRouter()}
+
+	_, err := New(Config{CORSAllowedOriginsRegex: "("}, serverCfg, s, &FakeLogger{})
+	require.Error(t, err)
+}
+
+func TestMaxRequestBodySize(t *testing.T) {
+	const limit = 10
+
+	tests := map[string]struct {
+		path           string
+		body           string
+		unknownLength  bool
+		expectedStatus int
+	}{
+		"body within the limit": {
+			path:           "/purger/delete_tenant",
+			body:           strings.Repeat("a", limit),
+			expectedStatus: http.StatusOK,
+		},
+		"body exceeding the limit": {
+			path:           "/purger/delete_tenant",
+			body:           strings.Repeat("a", limit+1),
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+		"body of unknown length exceeding the limit": {
+			path:           "/purger/delete_tenant",
+			body:           strings.Repeat("a", limit+1),
+			unknownLength:  true,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+		"push route body exceeding the limit": {
+			path:           "/api/v1/push",
+			body:           strings.Repeat("a", limit+1),
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			serverCfg := server.Config{HTTPListenNetwork: server.DefaultNetwork}
+			s := &server.Server{HTTP: mux.NewRouter()}
+
+			api, err := New(Config{MaxRequestBodySize: limit}, serverCfg, s, &FakeLogger{})
+			require.NoError(t, err)
+
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if _, err := io.ReadAll(r.Body); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			})
+			api.RegisterRoute("/purger/delete_tenant", handler, false, "POST")
+			api.registerPushRoute("/api/v1/push", handler)
+
+			req := httptest.NewRequest("POST", testData.path, strings.NewReader(testData.body))
+			req.Header.Set("X-Scope-OrgID", "user-1")
+			if testData.unknownLength {
+				req.ContentLength = -1
+			}
+
+			resp := httptest.NewRecorder()
+			s.HTTP.ServeHTTP(resp, req)
+
+			assert.Equal(t, testData.expectedStatus, resp.Code)
+		})
+	}
+}
+
+func TestRegisterQueryAPIFormatAndParseQuery(t *testing.T) {
+	cfg := Config{
+		PrometheusHTTPPrefix: "/prometheus",
+		LegacyHTTPPrefix:     "/api/prom",
+	}
+	serverCfg := server.Config{HTTPListenNetwork: server.DefaultNetwork}
+	s := &server.Server{HTTP: mux.NewRouter()}
+
+	api, err := New(cfg, serverCfg, s, &FakeLogger{})
+	require.NoError(t, err)
+
+	var forwarded []string
+	api.RegisterQueryAPI(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = append(forwarded, r.Method+" "+r.URL.Path)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	var expected []string
+	for _, endpoint := range []string{"format_query", "parse_query"} {
+		for _, prefix := range []string{"/prometheus", "/api/prom"} {
+			p := prefix + "/api/v1/" + endpoint
+
+			for _, method := range []string{"GET", "POST"} {
+				req := httptest.NewRequest(method, p+"?query=foo", nil)
+				req.Header.Set("X-Scope-OrgID", "user-1")
+				resp := httptest.NewRecorder()
+				s.HTTP.ServeHTTP(resp, req)
+				assert.Equal(t, http.StatusOK, resp.Code, method+" "+p)
+				expected = append(expected, method+" "+p)
+
+				// The route requires authentication.
+				req = httptest.NewRequest(method, p+"?query=foo", nil)
+				resp = httptest.NewRecorder()
+				s.HTTP.ServeHTTP(resp, req)
+				assert.Equal(t, http.StatusUnauthorized, resp.Code, method+" "+p)
+			}
+		}
+	}
+
+	assert.Equal(t, expected, forwarded)
+}
+
+func TestRegisterOTLPHandler(t *testing.T) {
+	// A minimal OTLP export request, with a single gauge "foo" whose data point has value 1.
+	dataPoint := append(protoField(4, proto.WireFixed64), 0, 0, 0, 0, 0, 0, 0xf0, 0x3f)
+	gauge := protoMessage(1, dataPoint)
+	metric := append(protoMessage(1, []byte("foo")), protoMessage(5, gauge)...)
+	payload := protoMessage(1, protoMessage(2, protoMessage(2, metric)))
+
+	var gzipped bytes.Buffer
+	gzipWriter := gzip.NewWriter(&gzipped)
+	_, err := gzipWriter.Write(payload)
+	require.NoError(t, err)
+	require.NoError(t, gzipWriter.Close())
+
+	for _, encoding := range []string{"", "gzip"} {
+		t.Run("content encoding: "+encoding, func(t *testing.T) {
+			var received []*cortexpb.WriteRequest
+			cfg := Config{
+				DistributorPushWrapper: func(push.Func) push.Func {
+					return func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+						userID, err := user.ExtractOrgID(ctx)
+						assert.NoError(t, err)
+						assert.Equal(t, "user-1", userID)
+
+						received = append(received, req)
+						return &cortexpb.WriteResponse{}, nil
+					}
+				},
+			}
+			serverCfg := server.Config{HTTPListenNetwork: server.DefaultNetwork}
+			s := &server.Server{HTTP: mux.NewRouter()}
+
+			api, err := New(cfg, serverCfg, s, &FakeLogger{})
+			require.NoError(t, err)
+			api.RegisterOTLPHandler(nil, distributor.Config{MaxRecvMsgSize: 1024})
+
+			body := payload
+			if encoding == "gzip" {
+				body = gzipped.Bytes()
+			}
+
+			req := httptest.NewRequest("POST", "/api/v1/otlp/v1/metrics", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/x-protobuf")
+			req.Header.Set("Content-Encoding", encoding)
+			req.Header.Set("X-Scope-OrgID", "user-1")
+			resp := httptest.NewRecorder()
+			s.HTTP.ServeHTTP(resp, req)
+			require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+
+			require.Len(t, received, 1)
+			require.Len(t, received[0].Timeseries, 1)
+			assert.Equal(t, []cortexpb.LabelAdapter{{Name: "__name__", Value: "foo"}}, received[0].Timeseries[0].Labels)
+			assert.Equal(t, []cortexpb.Sample{{Value: 1}}, received[0].Timeseries[0].Samples)
+
+			// The route requires authentication.
+			req = httptest.NewRequest("POST", "/api/v1/otlp/v1/metrics", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/x-protobuf")
+			req.Header.Set("Content-Encoding", encoding)
+			resp = httptest.NewRecorder()
+			s.HTTP.ServeHTTP(resp, req)
+			assert.Equal(t, http.StatusUnauthorized, resp.Code)
+			assert.Len(t, received, 1)
+		})
+	}
+}
+
+func protoField(num, wireType int) []byte {
+	return proto.EncodeVarint(uint64(num<<3 | wireType))
+}
+
+func protoMessage(num int, b []byte) []byte {
+	m := append(protoField(num, proto.WireBytes), proto.EncodeVarint(uint64(len(b)))...)
+	return append(m, b...)
+}
+
+func TestDistributorPushWrappers(t *testing.T) {
+	var calls []string
+	recordingWrapper := func(name string) DistributorPushWrapper {
+		return func(next push.Func) push.Func {
+			return func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+				calls = append(calls, name)
+				return next(ctx, req)
+			}
+		}
+	}
+	innermost := func(context.Context, *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+		calls = append(calls, "push")
+		return &cortexpb.WriteResponse{}, nil
+	}
+
+	tests := map[string]struct {
+		setup         func(cfg *Config)
+		expectedCalls []string
+	}{
+		"no wrappers": {
+			setup:         func(cfg *Config) {},
+			expectedCalls: []string{"push"},
+		},
+		"nil wrapper": {
+			setup:         func(cfg *Config) { cfg.AddDistributorPushWrapper(nil) },
+			expectedCalls: []string{"push"},
+		},
+		"only DistributorPushWrapper": {
+			setup:         func(cfg *Config) { cfg.DistributorPushWrapper = recordingWrapper("field") },
+			expectedCalls: []string{"field", "push"},
+		},
+		"added wrappers are composed in registration order": {
+			setup: func(cfg *Config) {
+				cfg.AddDistributorPushWrapper(recordingWrapper("first"))
+				cfg.AddDistributorPushWrapper(recordingWrapper("second"))
+				cfg.AddDistributorPushWrapper(recordingWrapper("third"))
+			},
+			expectedCalls: []string{"first", "second", "third", "push"},
+		},
+		"DistributorPushWrapper is the outermost": {
+			setup: func(cfg *Config) {
+				cfg.AddDistributorPushWrapper(recordingWrapper("first"))
+				cfg.DistributorPushWrapper = recordingWrapper("field")
+				cfg.AddDistributorPushWrapper(recordingWrapper("second"))
+			},
+			expectedCalls: []string{"field", "first", "second", "push"},
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			calls = nil
+
+			cfg := Config{}
+			testData.setup(&cfg)
+
+			_, err := cfg.wrapPush(innermost)(context.Background(), &cortexpb.WriteRequest{})
+			require.NoError(t, err)
+			assert.Equal(t, testData.expectedCalls, calls)
+		})
+	}
+}
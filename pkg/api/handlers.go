@@ -2,9 +2,16 @@ package api
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"html/template"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"path"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/go-kit/log"
@@ -30,34 +37,58 @@ import (
 
 const (
 	SectionAdminEndpoints = "Admin Endpoints:"
+	SectionTenantAdmin    = "Tenant Admin Endpoints:"
 	SectionDangerous      = "Dangerous:"
+
+	// There is not standardised content-type for YAML, text/plain ensures the
+	// YAML is displayed in the browser instead of offered as a download.
+	yamlContentType = "text/plain; charset=utf-8"
 )
 
 func newIndexPageContent() *IndexPageContent {
 	return &IndexPageContent{
-		content: map[string]map[string]string{},
+		content: map[string][]IndexPageLink{},
 	}
 }
 
-// IndexPageContent is a map of sections to path -> description.
+// IndexPageContent is a map of sections to their links.
 type IndexPageContent struct {
 	mu      sync.Mutex
-	content map[string]map[string]string
+	content map[string][]IndexPageLink
+}
+
+// IndexPageLink is a link rendered in a section of the index page.
+type IndexPageLink struct {
+	Path        string
+	Description string
+	Weight      int
 }
 
+// AddLink adds a link to the section, with weight 0.
 func (pc *IndexPageContent) AddLink(section, path, description string) {
+	pc.AddLinkWithWeight(section, path, description, 0)
+}
+
+// AddLinkWithWeight adds a link to the section. The links of a section are rendered
+// sorted by weight and then description, links with the same weight and description
+// keeping the order they have been added in. Adding a path already in the section
+// replaces its link.
+func (pc *IndexPageContent) AddLinkWithWeight(section, path, description string, weight int) {
 	pc.mu.Lock()
 	defer pc.mu.Unlock()
 
-	sectionMap := pc.content[section]
-	if sectionMap == nil {
-		sectionMap = make(map[string]string)
-		pc.content[section] = sectionMap
+	link := IndexPageLink{Path: path, Description: description, Weight: weight}
+	links := pc.content[section]
+	for i := range links {
+		if links[i].Path == path {
+			links[i] = link
+			return
+		}
 	}
-
-	sectionMap[path] = description
+	pc.content[section] = append(links, link)
 }
 
+// GetContent returns a map of sections to path -> description.
 func (pc *IndexPageContent) GetContent() map[string]map[string]string {
 	pc.mu.Lock()
 	defer pc.mu.Unlock()
@@ -65,14 +96,33 @@ func (pc *IndexPageContent) GetContent() map[string]map[string]string {
 	result := map[string]map[string]string{}
 	for k, v := range pc.content {
 		sm := map[string]string{}
-		for smK, smV := range v {
-			sm[smK] = smV
+		for _, link := range v {
+			sm[link.Path] = link.Description
 		}
 		result[k] = sm
 	}
 	return result
 }
 
+// GetLinks returns a map of sections to their links, sorted in rendering order.
+func (pc *IndexPageContent) GetLinks() map[string][]IndexPageLink {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	result := map[string][]IndexPageLink{}
+	for k, v := range pc.content {
+		links := append([]IndexPageLink(nil), v...)
+		sort.SliceStable(links, func(i, j int) bool {
+			if links[i].Weight != links[j].Weight {
+				return links[i].Weight < links[j].Weight
+			}
+			return links[i].Description < links[j].Description
+		})
+		result[k] = links
+	}
+	return result
+}
+
 var indexPageTemplate = `
 <!DOCTYPE html>
 <html>
@@ -85,8 +135,8 @@ var indexPageTemplate = `
 		{{ range $s, $links := . }}
 		<p>{{ $s }}</p>
 		<ul>
-			{{ range $path, $desc := $links }}
-				<li><a href="{{ AddPathPrefix $path }}">{{ $desc }}</a></li>
+			{{ range $links }}
+				<li><a href="{{ AddPathPrefix .Path }}">{{ .Description }}</a></li>
 			{{ end }}
 		</ul>
 		{{ end }}
@@ -94,27 +144,185 @@ var indexPageTemplate = `
 </html>`
 
 func indexHandler(httpPathPrefix string, content *IndexPageContent) http.HandlerFunc {
+	return indexHandlerWithTemplate(template.Must(newIndexPageTemplate(httpPathPrefix, indexPageTemplate)), content)
+}
+
+// indexHandlerWithTemplate renders the index page content with the given template.
+func indexHandlerWithTemplate(templ *template.Template, content *IndexPageContent) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := templ.Execute(w, content.GetLinks())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// newIndexPageTemplate parses an index page template, whose AddPathPrefix function
+// prefixes the links with the given HTTP path prefix.
+func newIndexPageTemplate(httpPathPrefix, text string) (*template.Template, error) {
 	templ := template.New("main")
 	templ.Funcs(map[string]interface{}{
 		"AddPathPrefix": func(link string) string {
-			return path.Join(httpPathPrefix, link)
+			return addPathPrefix(httpPathPrefix, link)
 		},
 	})
-	template.Must(templ.Parse(indexPageTemplate))
+	return templ.Parse(text)
+}
 
-	return func(w http.ResponseWriter, r *http.Request) {
-		err := templ.Execute(w, content.GetContent())
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+// loadIndexPageTemplate returns the text of the configured index page template. The
+// config is an inline template if it contains an action, otherwise the path of the
+// file to read the template from.
+func loadIndexPageTemplate(cfg string) (string, error) {
+	if strings.Contains(cfg, "{{") {
+		return cfg, nil
+	}
+
+	b, err := ioutil.ReadFile(cfg)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read the index page template")
 	}
+	return string(b), nil
+}
+
+// addPathPrefix prefixes the path of the link with the given prefix, so that the
+// index page links resolve when Cortex is served under a sub-path. The query
+// string and trailing slash of the link, if any, are preserved.
+func addPathPrefix(prefix, link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return path.Join(prefix, link)
+	}
+
+	p := path.Join(prefix, u.Path)
+	if strings.HasSuffix(u.Path, "/") && !strings.HasSuffix(p, "/") {
+		p += "/"
+	}
+	u.Path = p
+
+	return u.String()
 }
 
 func (cfg *Config) configHandler(actualCfg interface{}, defaultCfg interface{}) http.HandlerFunc {
+	newHandler := DefaultConfigHandler
 	if cfg.CustomConfigHandler != nil {
-		return cfg.CustomConfigHandler(actualCfg, defaultCfg)
+		newHandler = cfg.CustomConfigHandler
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Set the content type before anything is written, so that it's not sniffed
+		// by the response compression wrapper. The handler can still override it.
+		w.Header().Set("Content-Type", yamlContentType)
+
+		// The secrets are redacted on every request, so that the handler always gets
+		// the current config values.
+		newHandler(RedactSecrets(actualCfg), RedactSecrets(defaultCfg))(w, r)
+	}
+}
+
+// redactedSecret replaces the values of the config fields tagged with secret:"true".
+const redactedSecret = "*****"
+
+// RedactSecrets returns a copy of the input config where the non-empty string fields
+// tagged with secret:"true" are replaced with redactedSecret, walking nested structs,
+// pointers, slices, arrays, maps and interfaces. The input config is returned as is if
+// it can't contain any struct.
+func RedactSecrets(cfg interface{}) interface{} {
+	v := reflect.ValueOf(cfg)
+	if !v.IsValid() {
+		return cfg
+	}
+	return redactValue(v).Interface()
+}
+
+func redactValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() || !mayContainSecrets(v.Type().Elem()) {
+			return v
+		}
+		redacted := reflect.New(v.Elem().Type())
+		redacted.Elem().Set(redactValue(v.Elem()))
+		return redacted
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		redacted := reflect.New(v.Type()).Elem()
+		redacted.Set(redactValue(v.Elem()))
+		return redacted
+
+	case reflect.Slice:
+		if v.IsNil() || !mayContainSecrets(v.Type().Elem()) {
+			return v
+		}
+		redacted := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			redacted.Index(i).Set(redactValue(v.Index(i)))
+		}
+		return redacted
+
+	case reflect.Array:
+		if !mayContainSecrets(v.Type().Elem()) {
+			return v
+		}
+		redacted := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			redacted.Index(i).Set(redactValue(v.Index(i)))
+		}
+		return redacted
+
+	case reflect.Map:
+		if v.IsNil() || !mayContainSecrets(v.Type().Elem()) {
+			return v
+		}
+		redacted := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			redacted.SetMapIndex(iter.Key(), redactValue(iter.Value()))
+		}
+		return redacted
+
+	case reflect.Struct:
+		redacted := reflect.New(v.Type()).Elem()
+		redacted.Set(v)
+
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				// Unexported fields are not marshalled.
+				continue
+			}
+
+			if field.Tag.Get("secret") == "true" && field.Type.Kind() == reflect.String {
+				if v.Field(i).String() != "" {
+					redacted.Field(i).SetString(redactedSecret)
+				}
+				continue
+			}
+
+			if mayContainSecrets(field.Type) {
+				redacted.Field(i).Set(redactValue(v.Field(i)))
+			}
+		}
+		return redacted
+
+	default:
+		return v
+	}
+}
+
+// mayContainSecrets returns whether the values of the input type may contain a struct,
+// and so a field tagged with secret:"true".
+func mayContainSecrets(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Struct, reflect.Interface:
+		return true
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return mayContainSecrets(t.Elem())
+	default:
+		return false
 	}
-	return DefaultConfigHandler(actualCfg, defaultCfg)
 }
 
 func DefaultConfigHandler(actualCfg interface{}, defaultCfg interface{}) http.HandlerFunc {
@@ -147,10 +355,58 @@ func DefaultConfigHandler(actualCfg interface{}, defaultCfg interface{}) http.Ha
 			output = actualCfg
 		}
 
+		if r.URL.Query().Get("format") == "json" {
+			writeConfigJSONResponse(w, output)
+			return
+		}
+
 		util.WriteYAMLResponse(w, output)
 	}
 }
 
+// writeConfigJSONResponse writes the config as indented JSON. The config is marshalled
+// to YAML first, so that the JSON output has the same structure and keys as the YAML one.
+func writeConfigJSONResponse(w http.ResponseWriter, cfg interface{}) {
+	obj, ok := cfg.(map[interface{}]interface{})
+	if !ok {
+		var err error
+		if obj, err = util.YAMLMarshalUnmarshal(cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	data, err := json.MarshalIndent(yamlToJSONValue(obj), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+// yamlToJSONValue converts the maps unmarshalled from YAML, keyed by interface{},
+// into maps keyed by string, which can be marshalled to JSON.
+func yamlToJSONValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			out[fmt.Sprint(key)] = yamlToJSONValue(value)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, value := range v {
+			out[i] = yamlToJSONValue(value)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
 // NewQuerierHandler returns a HTTP handler that can be used by the querier service to
 // either register with the frontend worker query processor or with the external HTTP
 // server to fulfill the Prometheus query API.
@@ -241,34 +497,40 @@ func NewQuerierHandler(
 	promRouter := route.New().WithPrefix(path.Join(prefix, "/api/v1"))
 	api.Register(promRouter)
 
-	legacyPromRouter := route.New().WithPrefix(path.Join(legacyPrefix, "/api/v1"))
-	api.Register(legacyPromRouter)
-
 	// TODO(gotjosh): This custom handler is temporary until we're able to vendor the changes in:
 	// https://github.com/prometheus/prometheus/pull/7125/files
 	router.Path(path.Join(prefix, "/api/v1/metadata")).Handler(querier.MetadataHandler(distributor))
 	router.Path(path.Join(prefix, "/api/v1/read")).Handler(querier.RemoteReadHandler(queryable, logger))
 	router.Path(path.Join(prefix, "/api/v1/read")).Methods("POST").Handler(promRouter)
-	router.Path(path.Join(prefix, "/api/v1/query")).Methods("GET", "POST").Handler(promRouter)
+	router.Path(path.Join(prefix, "/api/v1/query")).Methods("GET", "POST").Handler(querier.AnalyzeQueryHandler(queryable, promRouter))
 	router.Path(path.Join(prefix, "/api/v1/query_range")).Methods("GET", "POST").Handler(promRouter)
 	router.Path(path.Join(prefix, "/api/v1/query_exemplars")).Methods("GET", "POST").Handler(promRouter)
+	router.Path(path.Join(prefix, "/api/v1/format_query")).Methods("GET", "POST").Handler(querier.FormatQueryHandler())
+	router.Path(path.Join(prefix, "/api/v1/parse_query")).Methods("GET", "POST").Handler(querier.ParseQueryHandler())
 	router.Path(path.Join(prefix, "/api/v1/labels")).Methods("GET", "POST").Handler(promRouter)
 	router.Path(path.Join(prefix, "/api/v1/label/{name}/values")).Methods("GET").Handler(promRouter)
-	router.Path(path.Join(prefix, "/api/v1/series")).Methods("GET", "POST", "DELETE").Handler(promRouter)
+	router.Path(path.Join(prefix, "/api/v1/series")).Methods("GET", "POST", "DELETE").Handler(querier.SeriesSortHandler(promRouter))
 	router.Path(path.Join(prefix, "/api/v1/metadata")).Methods("GET").Handler(promRouter)
 
-	// TODO(gotjosh): This custom handler is temporary until we're able to vendor the changes in:
-	// https://github.com/prometheus/prometheus/pull/7125/files
-	router.Path(path.Join(legacyPrefix, "/api/v1/metadata")).Handler(querier.MetadataHandler(distributor))
-	router.Path(path.Join(legacyPrefix, "/api/v1/read")).Handler(querier.RemoteReadHandler(queryable, logger))
-	router.Path(path.Join(legacyPrefix, "/api/v1/read")).Methods("POST").Handler(legacyPromRouter)
-	router.Path(path.Join(legacyPrefix, "/api/v1/query")).Methods("GET", "POST").Handler(legacyPromRouter)
-	router.Path(path.Join(legacyPrefix, "/api/v1/query_range")).Methods("GET", "POST").Handler(legacyPromRouter)
-	router.Path(path.Join(legacyPrefix, "/api/v1/query_exemplars")).Methods("GET", "POST").Handler(legacyPromRouter)
-	router.Path(path.Join(legacyPrefix, "/api/v1/labels")).Methods("GET", "POST").Handler(legacyPromRouter)
-	router.Path(path.Join(legacyPrefix, "/api/v1/label/{name}/values")).Methods("GET").Handler(legacyPromRouter)
-	router.Path(path.Join(legacyPrefix, "/api/v1/series")).Methods("GET", "POST", "DELETE").Handler(legacyPromRouter)
-	router.Path(path.Join(legacyPrefix, "/api/v1/metadata")).Methods("GET").Handler(legacyPromRouter)
+	if !cfg.DisableLegacyRoutes {
+		legacyPromRouter := route.New().WithPrefix(path.Join(legacyPrefix, "/api/v1"))
+		api.Register(legacyPromRouter)
+
+		// TODO(gotjosh): This custom handler is temporary until we're able to vendor the changes in:
+		// https://github.com/prometheus/prometheus/pull/7125/files
+		router.Path(path.Join(legacyPrefix, "/api/v1/metadata")).Handler(querier.MetadataHandler(distributor))
+		router.Path(path.Join(legacyPrefix, "/api/v1/read")).Handler(querier.RemoteReadHandler(queryable, logger))
+		router.Path(path.Join(legacyPrefix, "/api/v1/read")).Methods("POST").Handler(legacyPromRouter)
+		router.Path(path.Join(legacyPrefix, "/api/v1/query")).Methods("GET", "POST").Handler(querier.AnalyzeQueryHandler(queryable, legacyPromRouter))
+		router.Path(path.Join(legacyPrefix, "/api/v1/query_range")).Methods("GET", "POST").Handler(legacyPromRouter)
+		router.Path(path.Join(legacyPrefix, "/api/v1/query_exemplars")).Methods("GET", "POST").Handler(legacyPromRouter)
+		router.Path(path.Join(legacyPrefix, "/api/v1/format_query")).Methods("GET", "POST").Handler(querier.FormatQueryHandler())
+		router.Path(path.Join(legacyPrefix, "/api/v1/parse_query")).Methods("GET", "POST").Handler(querier.ParseQueryHandler())
+		router.Path(path.Join(legacyPrefix, "/api/v1/labels")).Methods("GET", "POST").Handler(legacyPromRouter)
+		router.Path(path.Join(legacyPrefix, "/api/v1/label/{name}/values")).Methods("GET").Handler(legacyPromRouter)
+		router.Path(path.Join(legacyPrefix, "/api/v1/series")).Methods("GET", "POST", "DELETE").Handler(querier.SeriesSortHandler(legacyPromRouter))
+		router.Path(path.Join(legacyPrefix, "/api/v1/metadata")).Methods("GET").Handler(legacyPromRouter)
+	}
 
 	// Track execution time.
 	return stats.NewWallTimeMiddleware().Wrap(router)
@@ -1,14 +1,26 @@
 package api
 
 import (
+	"compress/gzip"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
 
+	"github.com/NYTimes/gziphandler"
+	"github.com/go-kit/log"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/middleware"
+	"github.com/weaveworks/common/server"
+
+	"github.com/cortexproject/cortex/pkg/chunk/purger"
 )
 
 func TestIndexHandlerPrefix(t *testing.T) {
@@ -36,6 +48,140 @@ func TestIndexHandlerPrefix(t *testing.T) {
 	}
 }
 
+func TestIndexHandlerSubPath(t *testing.T) {
+	c := newIndexPageContent()
+	c.AddLink(SectionAdminEndpoints, "/config?mode=diff", "Current Config (show only values that differ from the defaults)")
+	c.AddLink(SectionAdminEndpoints, "/distributor/ring", "Distributor Ring Status")
+	c.AddLink(SectionAdminEndpoints, "/api/prom/user_stats", "Legacy User Stats")
+	c.AddLink(SectionAdminEndpoints, "/multitenant_alertmanager/", "Alertmanager")
+	c.AddLink(SectionDangerous, "/ingester/shutdown", "Trigger Ingester Shutdown (Dangerous)")
+
+	h := indexHandler("/cortex/", c)
+
+	req := httptest.NewRequest("GET", "/cortex/", nil)
+	resp := httptest.NewRecorder()
+
+	h.ServeHTTP(resp, req)
+
+	require.Equal(t, 200, resp.Code)
+	for _, link := range []string{
+		"<a href=\"/cortex/config?mode=diff\">",
+		"<a href=\"/cortex/distributor/ring\">",
+		"<a href=\"/cortex/api/prom/user_stats\">",
+		"<a href=\"/cortex/multitenant_alertmanager/\">",
+		"<a href=\"/cortex/ingester/shutdown\">",
+	} {
+		assert.Contains(t, resp.Body.String(), link)
+	}
+}
+
+func TestIndexPageLinksOrder(t *testing.T) {
+	c := newIndexPageContent()
+	c.AddLinkWithWeight(SectionAdminEndpoints, "/z", "Zeta", -1)
+	c.AddLink(SectionAdminEndpoints, "/b", "Beta")
+	c.AddLinkWithWeight(SectionAdminEndpoints, "/last", "Alpha", 10)
+	c.AddLink(SectionAdminEndpoints, "/a", "Alpha")
+	c.AddLink(SectionAdminEndpoints, "/a2", "Alpha")
+	c.AddLink(SectionDangerous, "/shutdown", "Shutdown")
+
+	assert.Equal(t, []IndexPageLink{
+		{Path: "/z", Description: "Zeta", Weight: -1},
+		{Path: "/a", Description: "Alpha"},
+		{Path: "/a2", Description: "Alpha"},
+		{Path: "/b", Description: "Beta"},
+		{Path: "/last", Description: "Alpha", Weight: 10},
+	}, c.GetLinks()[SectionAdminEndpoints])
+
+	// Adding a path again replaces its link.
+	c.AddLinkWithWeight(SectionAdminEndpoints, "/z", "Zeta", 20)
+	assert.Equal(t, "/z", c.GetLinks()[SectionAdminEndpoints][4].Path)
+	assert.Len(t, c.GetLinks()[SectionAdminEndpoints], 5)
+
+	resp := httptest.NewRecorder()
+	indexHandler("", c).ServeHTTP(resp, httptest.NewRequest("GET", "/", nil))
+	require.Equal(t, 200, resp.Code)
+
+	body := resp.Body.String()
+	var positions []int
+	for _, path := range []string{"/a", "/a2", "/b", "/last", "/z"} {
+		pos := strings.Index(body, fmt.Sprintf("<a href=%q>", path))
+		require.NotEqual(t, -1, pos, path)
+		positions = append(positions, pos)
+	}
+	assert.True(t, sort.IntsAreSorted(positions), "links are not rendered in order")
+}
+
+func TestIndexPageTenantAdminSection(t *testing.T) {
+	serverCfg := server.Config{HTTPListenNetwork: server.DefaultNetwork}
+	s := &server.Server{HTTP: mux.NewRouter()}
+
+	api, err := New(Config{}, serverCfg, s, &FakeLogger{})
+	require.NoError(t, err)
+
+	api.RegisterAPI("", nil, nil)
+	api.RegisterTenantDeletion(&purger.TenantDeletionAPI{})
+
+	links := api.indexPage.GetLinks()
+	assert.Equal(t, []IndexPageLink{{Path: "/purger/delete_tenant_status", Description: "Tenant Deletion Status"}}, links[SectionTenantAdmin])
+	for _, link := range links[SectionAdminEndpoints] {
+		assert.NotContains(t, link.Path, "/purger/")
+	}
+
+	resp := httptest.NewRecorder()
+	s.HTTP.ServeHTTP(resp, httptest.NewRequest("GET", "/", nil))
+	require.Equal(t, 200, resp.Code)
+
+	body := resp.Body.String()
+	sectionPos := strings.Index(body, SectionTenantAdmin)
+	require.NotEqual(t, -1, sectionPos)
+	linkPos := strings.Index(body, `<a href="/purger/delete_tenant_status">Tenant Deletion Status</a>`)
+	require.NotEqual(t, -1, linkPos)
+	assert.Greater(t, linkPos, sectionPos)
+}
+
+func TestIndexPageTemplate(t *testing.T) {
+	const customTemplate = `<title>Acme</title>{{ range $s, $links := . }}{{ range $links }}<a href="{{ AddPathPrefix .Path }}">{{ .Description }}</a>{{ end }}{{ end }}`
+
+	templateFile := filepath.Join(t.TempDir(), "index.html")
+	require.NoError(t, ioutil.WriteFile(templateFile, []byte(customTemplate), 0644))
+
+	for name, cfg := range map[string]string{
+		"inline template": customTemplate,
+		"template file":   templateFile,
+	} {
+		t.Run(name, func(t *testing.T) {
+			serverCfg := server.Config{HTTPListenNetwork: server.DefaultNetwork}
+			s := &server.Server{HTTP: mux.NewRouter()}
+
+			api, err := New(Config{IndexPageTemplate: cfg}, serverCfg, s, &FakeLogger{})
+			require.NoError(t, err)
+
+			api.indexPage.AddLink(SectionAdminEndpoints, "/ingester/ring", "Ingester Ring")
+			api.RegisterAPI("/cortex", nil, nil)
+
+			resp := httptest.NewRecorder()
+			s.HTTP.ServeHTTP(resp, httptest.NewRequest("GET", "/", nil))
+
+			require.Equal(t, 200, resp.Code)
+			assert.Contains(t, resp.Body.String(), "<title>Acme</title>")
+			assert.Contains(t, resp.Body.String(), `<a href="/cortex/ingester/ring">Ingester Ring</a>`)
+			assert.NotContains(t, resp.Body.String(), "<h1>Cortex</h1>")
+		})
+	}
+}
+
+func TestIndexPageTemplateInvalid(t *testing.T) {
+	serverCfg := server.Config{HTTPListenNetwork: server.DefaultNetwork}
+
+	_, err := New(Config{IndexPageTemplate: "{{ range . }}"}, serverCfg, &server.Server{HTTP: mux.NewRouter()}, &FakeLogger{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid index page template")
+
+	_, err = New(Config{IndexPageTemplate: filepath.Join(t.TempDir(), "missing.html")}, serverCfg, &server.Server{HTTP: mux.NewRouter()}, &FakeLogger{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to read the index page template")
+}
+
 func TestIndexPageContent(t *testing.T) {
 	c := newIndexPageContent()
 	c.AddLink(SectionAdminEndpoints, "/ingester/ring", "Ingester Ring")
@@ -189,3 +335,255 @@ func TestConfigOverrideHandler(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, []byte("config"), body)
 }
+
+func TestConfigHandlerContentTypeWithResponseCompression(t *testing.T) {
+	// The response must be big enough to get compressed.
+	largeConfig := struct {
+		Value string `yaml:"value"`
+	}{Value: strings.Repeat("x", 4096)}
+
+	for _, tc := range []struct {
+		name          string
+		customHandler ConfigHandler
+	}{
+		{
+			name: "default config handler",
+		},
+		{
+			name: "custom config handler not setting the content type",
+			customHandler: func(_ interface{}, _ interface{}) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					_, err := w.Write([]byte("value: " + largeConfig.Value))
+					assert.NoError(t, err)
+				}
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a := &API{
+				cfg:            Config{ResponseCompression: true, CustomConfigHandler: tc.customHandler},
+				AuthMiddleware: middleware.AuthenticateUser,
+				server:         &server.Server{HTTP: mux.NewRouter()},
+				logger:         log.NewNopLogger(),
+				indexPage:      newIndexPageContent(),
+				gzipWrapper:    gziphandler.GzipHandler,
+
+				requestDuration:  newRequestDurationHistogram(prometheus.NewRegistry()),
+				registeredRoutes: map[string]struct{}{},
+			}
+			a.RegisterAPI("", largeConfig, largeConfig)
+
+			req := httptest.NewRequest("GET", "/config", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			resp := httptest.NewRecorder()
+
+			a.server.HTTP.ServeHTTP(resp, req)
+
+			require.Equal(t, 200, resp.Code)
+			assert.Equal(t, "gzip", resp.Header().Get("Content-Encoding"))
+			assert.Equal(t, yamlContentType, resp.Header().Get("Content-Type"))
+
+			reader, err := gzip.NewReader(resp.Body)
+			require.NoError(t, err)
+			body, err := ioutil.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, "value: "+largeConfig.Value, strings.TrimSpace(string(body)))
+		})
+	}
+}
+
+func TestPerTenantResponseCompression(t *testing.T) {
+	for _, authHeaderName := range []string{"", "X-Tenant"} {
+		t.Run(fmt.Sprintf("auth header name %q", authHeaderName), func(t *testing.T) {
+			var authMiddleware middleware.Interface = middleware.AuthenticateUser
+			headerName := "X-Scope-OrgID"
+			if authHeaderName != "" {
+				authMiddleware = authenticateUserFromHeader(authHeaderName)
+				headerName = authHeaderName
+			}
+
+			a := &API{
+				cfg: Config{
+					AuthHeaderName:      authHeaderName,
+					ResponseCompression: true,
+					TenantResponseCompression: func(userID string) bool {
+						return userID != "user-without-compression"
+					},
+				},
+				AuthMiddleware: authMiddleware,
+				server:         &server.Server{HTTP: mux.NewRouter()},
+				logger:         log.NewNopLogger(),
+				indexPage:      newIndexPageContent(),
+				gzipWrapper:    gziphandler.GzipHandler,
+
+				requestDuration:  newRequestDurationHistogram(prometheus.NewRegistry()),
+				registeredRoutes: map[string]struct{}{},
+			}
+
+			// The response must be big enough to get compressed.
+			content := strings.Repeat("x", 4096)
+			a.RegisterRoute("/test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, err := w.Write([]byte(content))
+				assert.NoError(t, err)
+			}), true, "GET")
+
+			for _, tc := range []struct {
+				userID             string
+				expectedCompressed bool
+			}{
+				{userID: "user-with-compression", expectedCompressed: true},
+				{userID: "user-without-compression", expectedCompressed: false},
+			} {
+				t.Run(tc.userID, func(t *testing.T) {
+					req := httptest.NewRequest("GET", "/test", nil)
+					req.Header.Set("Accept-Encoding", "gzip")
+					req.Header.Set(headerName, tc.userID)
+					resp := httptest.NewRecorder()
+
+					a.server.HTTP.ServeHTTP(resp, req)
+					require.Equal(t, 200, resp.Code)
+
+					if !tc.expectedCompressed {
+						assert.Empty(t, resp.Header().Get("Content-Encoding"))
+						assert.Equal(t, content, resp.Body.String())
+						return
+					}
+
+					assert.Equal(t, "gzip", resp.Header().Get("Content-Encoding"))
+					reader, err := gzip.NewReader(resp.Body)
+					require.NoError(t, err)
+					body, err := ioutil.ReadAll(reader)
+					require.NoError(t, err)
+					assert.Equal(t, content, string(body))
+				})
+			}
+		})
+	}
+}
+
+func TestConfigHandlerRedactsSecrets(t *testing.T) {
+	type storageConfig struct {
+		AccessKey string `yaml:"access_key"`
+		SecretKey string `yaml:"secret_key" secret:"true"`
+	}
+	type secretsConfigMock struct {
+		Password      string                   `yaml:"password" secret:"true"`
+		EmptyPassword string                   `yaml:"empty_password" secret:"true"`
+		Storage       storageConfig            `yaml:"storage"`
+		StoragePtr    *storageConfig           `yaml:"storage_ptr"`
+		StorageSlice  []storageConfig          `yaml:"storage_slice"`
+		StorageMap    map[string]storageConfig `yaml:"storage_map"`
+		StorageArray  [1]*storageConfig        `yaml:"storage_array"`
+		StorageIface  interface{}              `yaml:"storage_iface"`
+	}
+
+	defaultCfg := &secretsConfigMock{}
+	actualCfg := &secretsConfigMock{
+		Password:   "password",
+		Storage:    storageConfig{AccessKey: "access", SecretKey: "secret"},
+		StoragePtr: &storageConfig{AccessKey: "access-ptr", SecretKey: "secret-ptr"},
+		StorageSlice: []storageConfig{
+			{AccessKey: "access-slice", SecretKey: "secret-slice"},
+		},
+		StorageMap: map[string]storageConfig{
+			"tenant": {AccessKey: "access-map", SecretKey: "secret-map"},
+		},
+		StorageArray: [1]*storageConfig{{AccessKey: "access-array", SecretKey: "secret-array"}},
+		StorageIface: storageConfig{AccessKey: "access-iface", SecretKey: "secret-iface"},
+	}
+
+	h := (&Config{}).configHandler(actualCfg, defaultCfg)
+
+	for _, mode := range []string{"", "diff"} {
+		t.Run("mode="+mode, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://test.com/config?mode="+mode, nil)
+			resp := httptest.NewRecorder()
+			h(resp, req)
+
+			require.Equal(t, 200, resp.Code)
+			body := resp.Body.String()
+			assert.Contains(t, body, "password: '*****'")
+			assert.Contains(t, body, "access_key: access\n")
+			assert.Contains(t, body, "access_key: access-ptr\n")
+			assert.Contains(t, body, "access_key: access-slice\n")
+			assert.Contains(t, body, "access_key: access-map\n")
+			assert.Contains(t, body, "access_key: access-array\n")
+			assert.Contains(t, body, "access_key: access-iface\n")
+			assert.Contains(t, body, "  secret_key: '*****'")
+			assert.NotContains(t, body, "secret-ptr")
+			assert.NotContains(t, body, "secret-slice")
+			assert.NotContains(t, body, "secret-map")
+			assert.NotContains(t, body, "secret-array")
+			assert.NotContains(t, body, "secret-iface")
+			assert.NotContains(t, body, ": secret\n")
+			assert.NotContains(t, body, ": password\n")
+		})
+	}
+
+	// The redaction doesn't modify the actual config.
+	assert.Equal(t, "password", actualCfg.Password)
+	assert.Equal(t, "secret", actualCfg.Storage.SecretKey)
+	assert.Equal(t, "secret-ptr", actualCfg.StoragePtr.SecretKey)
+	assert.Equal(t, "secret-slice", actualCfg.StorageSlice[0].SecretKey)
+	assert.Equal(t, "secret-map", actualCfg.StorageMap["tenant"].SecretKey)
+	assert.Equal(t, "secret-array", actualCfg.StorageArray[0].SecretKey)
+	assert.Equal(t, "secret-iface", actualCfg.StorageIface.(storageConfig).SecretKey)
+}
+
+func TestConfigHandlerJSONFormat(t *testing.T) {
+	defaultCfg := newDefaultDiffConfigMock()
+	actualCfg := newDefaultDiffConfigMock()
+	actualCfg.MyInt = 10
+	actualCfg.MyNestedStruct.MyBool = true
+
+	h := (&Config{}).configHandler(actualCfg, defaultCfg)
+
+	for _, tc := range []struct {
+		query    string
+		expected string
+	}{
+		{
+			query: "format=json",
+			expected: `{
+				"my_int": 10,
+				"my_float": 6.66,
+				"my_slice": ["value1", "value2"],
+				"my_nested_struct": {
+					"my_string": "string1",
+					"my_bool": true,
+					"my_empty_struct": {}
+				}
+			}`,
+		},
+		{
+			query: "format=json&mode=diff",
+			expected: `{
+				"my_int": 10,
+				"my_nested_struct": {
+					"my_bool": true
+				}
+			}`,
+		},
+	} {
+		t.Run(tc.query, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://test.com/config?"+tc.query, nil)
+			resp := httptest.NewRecorder()
+			h(resp, req)
+
+			require.Equal(t, 200, resp.Code)
+			assert.Equal(t, "application/json", resp.Header().Get("Content-Type"))
+			assert.JSONEq(t, tc.expected, resp.Body.String())
+			// The output is indented.
+			assert.Contains(t, resp.Body.String(), "\n  \"my_int\": 10")
+		})
+	}
+
+	// The default format is still YAML.
+	req := httptest.NewRequest("GET", "http://test.com/config?mode=diff", nil)
+	resp := httptest.NewRecorder()
+	h(resp, req)
+
+	require.Equal(t, 200, resp.Code)
+	assert.Equal(t, yamlContentType, resp.Header().Get("Content-Type"))
+	assert.Equal(t, "my_int: 10\nmy_nested_struct:\n  my_bool: true\n", resp.Body.String())
+}
@@ -1,13 +1,30 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/weaveworks/common/httpgrpc"
 	"github.com/weaveworks/common/middleware"
+	"github.com/weaveworks/common/user"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	"github.com/cortexproject/cortex/pkg/chunk/purger"
+	"github.com/cortexproject/cortex/pkg/cortexpb"
 	"github.com/cortexproject/cortex/pkg/querier/queryrange"
 	"github.com/cortexproject/cortex/pkg/tenant"
+	"github.com/cortexproject/cortex/pkg/util/push"
 )
 
 // middleware for setting cache gen header to let consumer of response know all previous responses could be invalid due to delete operation
@@ -27,3 +44,406 @@ func getHTTPCacheGenNumberHeaderSetterMiddleware(cacheGenNumbersLoader purger.To
 		})
 	})
 }
+
+// HTTPError is the error passed to the Config.ErrorMapper for the responses served
+// by the handlers with a 4xx or 5xx status code.
+type HTTPError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e HTTPError) Error() string {
+	return e.Message
+}
+
+// errorMapperMiddleware returns a middleware replacing the status code of the error
+// responses, and of the handlers panicking with an error, with the one returned by mapper.
+// The handlers panicking with http.ErrAbortHandler are not recovered, so that the net/http
+// server aborts the response as they requested.
+func errorMapperMiddleware(mapper func(error) int) middleware.Interface {
+	return middleware.Func(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ew := &errorMapperResponseWriter{ResponseWriter: w}
+
+			defer func() {
+				if p := recover(); p != nil {
+					err, ok := p.(error)
+					if !ok || ew.wroteHeader || errors.Is(err, http.ErrAbortHandler) {
+						panic(p)
+					}
+
+					status := mapper(err)
+					if status == 0 {
+						status = http.StatusInternalServerError
+					}
+					http.Error(w, err.Error(), status)
+					return
+				}
+
+				ew.flushError(mapper)
+			}()
+
+			next.ServeHTTP(ew, r)
+		})
+	})
+}
+
+// errorMapperResponseWriter buffers the error responses until the handler returns,
+// so that their status code can be replaced. Other responses are not buffered.
+type errorMapperResponseWriter struct {
+	http.ResponseWriter
+
+	wroteHeader bool
+	errStatus   int
+	errBody     bytes.Buffer
+}
+
+func (w *errorMapperResponseWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader || w.errStatus != 0 {
+		return
+	}
+	if statusCode >= 400 {
+		w.errStatus = statusCode
+		return
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *errorMapperResponseWriter) Write(b []byte) (int, error) {
+	if w.errStatus != 0 {
+		return w.errBody.Write(b)
+	}
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *errorMapperResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && w.errStatus == 0 {
+		w.wroteHeader = true
+		f.Flush()
+	}
+}
+
+func (w *errorMapperResponseWriter) flushError(mapper func(error) int) {
+	if w.errStatus == 0 {
+		return
+	}
+
+	status := mapper(HTTPError{StatusCode: w.errStatus, Message: strings.TrimSpace(w.errBody.String())})
+	if status == 0 {
+		status = w.errStatus
+	}
+	w.ResponseWriter.WriteHeader(status)
+	_, _ = w.ResponseWriter.Write(w.errBody.Bytes())
+}
+
+// authenticateUserFromHeader returns a middleware reading the tenant ID from the given
+// header, instead of the default X-Scope-OrgID. The tenant ID is injected into the request
+// context and in the X-Scope-OrgID header, for the handlers reading it from there.
+func authenticateUserFromHeader(headerName string) middleware.Interface {
+	return middleware.Func(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			orgID := r.Header.Get(headerName)
+			if orgID == "" {
+				http.Error(w, fmt.Sprintf("no org id in the %s header", headerName), http.StatusUnauthorized)
+				return
+			}
+
+			r = r.Clone(user.InjectOrgID(r.Context(), orgID))
+			r.Header.Set(user.OrgIDHeaderName, orgID)
+			next.ServeHTTP(w, r)
+		})
+	})
+}
+
+// GRPCAuthInterceptors returns the gRPC unary and stream server interceptors authenticating the
+// requests consistently with the HTTP routes registered with auth. The tenant ID is read from the
+// request metadata key matching the configured auth header name and injected into the request
+// context. The X-Scope-OrgID metadata key, set by the internal calls between Cortex components, is
+// always accepted as well. Requests without exactly one tenant ID are rejected with
+// codes.Unauthenticated. If no auth header name is configured, the default interceptors reading
+// X-Scope-OrgID are returned. A custom HTTPAuthMiddleware is not applied to gRPC requests.
+//
+// gRPC interceptors are server options, so they must be installed in the server config, with
+// server.Config.GRPCMiddleware and GRPCStreamMiddleware, before the server is constructed.
+func GRPCAuthInterceptors(cfg Config) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	if cfg.AuthHeaderName == "" {
+		return middleware.ServerUserHeaderInterceptor, middleware.StreamServerUserHeaderInterceptor
+	}
+
+	extract := grpcOrgIDExtractor(cfg.AuthHeaderName)
+
+	unary := func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := extract(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+
+	stream := func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := extract(ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, serverStreamWithContext{ServerStream: ss, ctx: ctx})
+	}
+
+	return unary, stream
+}
+
+// grpcOrgIDExtractor returns a function injecting into the context the tenant ID read from the
+// metadata key matching headerName, or from the X-Scope-OrgID one if missing.
+func grpcOrgIDExtractor(headerName string) func(context.Context) (context.Context, error) {
+	keys := []string{strings.ToLower(headerName), strings.ToLower(user.OrgIDHeaderName)}
+
+	return func(ctx context.Context) (context.Context, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		for _, key := range keys {
+			orgIDs := md.Get(key)
+			if len(orgIDs) == 0 {
+				continue
+			}
+			if len(orgIDs) != 1 || orgIDs[0] == "" {
+				break
+			}
+			return user.InjectOrgID(ctx, orgIDs[0]), nil
+		}
+		return nil, status.Errorf(codes.Unauthenticated, "no org id in the %s or %s metadata", headerName, user.OrgIDHeaderName)
+	}
+}
+
+// serverStreamWithContext overrides the context of the wrapped server stream.
+type serverStreamWithContext struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (ss serverStreamWithContext) Context() context.Context {
+	return ss.ctx
+}
+
+// instrumentRouteMiddleware observes the duration of the requests, labeled with the route
+// and the status code of the response.
+func instrumentRouteMiddleware(route string, duration *prometheus.HistogramVec) middleware.Interface {
+	return middleware.Func(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := &statusResponseWriter{ResponseWriter: w}
+
+			defer func() {
+				duration.WithLabelValues(route, strconv.Itoa(sw.status())).Observe(time.Since(start).Seconds())
+			}()
+
+			next.ServeHTTP(sw, r)
+		})
+	})
+}
+
+// statusResponseWriter records the status code of the response.
+type statusResponseWriter struct {
+	http.ResponseWriter
+
+	statusCode int
+}
+
+func (w *statusResponseWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusResponseWriter) Write(b []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.statusCode == 0 {
+			w.statusCode = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+// status returns the status code of the response, 200 if the handler didn't write any.
+func (w *statusResponseWriter) status() int {
+	if w.statusCode == 0 {
+		return http.StatusOK
+	}
+	return w.statusCode
+}
+
+// labelLengthLimitPushWrapper rejects write requests containing label names or values longer than
+// the configured limits, before they reach the distributor. A limit of 0 disables the check.
+func labelLengthLimitPushWrapper(maxNameLength, maxValueLength int) DistributorPushWrapper {
+	return func(next push.Func) push.Func {
+		return func(ctx context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+			for _, ts := range req.Timeseries {
+				for _, l := range ts.Labels {
+					if maxNameLength > 0 && len(l.Name) > maxNameLength {
+						return nil, httpgrpc.Errorf(http.StatusBadRequest, "label name too long: %.200q (length: %d, limit: %d)", l.Name, len(l.Name), maxNameLength)
+					}
+					if maxValueLength > 0 && len(l.Value) > maxValueLength {
+						return nil, httpgrpc.Errorf(http.StatusBadRequest, "label value too long for label %q: %.200q (length: %d, limit: %d)", l.Name, l.Value, len(l.Value), maxValueLength)
+					}
+				}
+			}
+
+			return next(ctx, req)
+		}
+	}
+}
+
+// corsMiddleware returns a middleware adding the CORS headers to the responses of the
+// requests coming from an allowed origin. The OPTIONS requests are always replied to
+// without calling the wrapped handler: with 403 if the origin is not allowed, with 204
+// otherwise, along with the allowed methods and headers for the preflight requests.
+func corsMiddleware(allowOrigin func(origin string) bool, methods, headers []string) middleware.Interface {
+	allowMethods := strings.ToUpper(strings.Join(methods, ", "))
+	allowHeaders := strings.Join(headers, ", ")
+
+	return middleware.Func(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				if r.Method == http.MethodOptions {
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// The response depends on the origin, so caches must not share it across origins.
+			w.Header().Add("Vary", "Origin")
+			if !allowOrigin(origin) {
+				if r.Method == http.MethodOptions {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+
+			if r.Method != http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if r.Header.Get("Access-Control-Request-Method") != "" {
+				if allowMethods != "" {
+					w.Header().Set("Access-Control-Allow-Methods", allowMethods)
+				}
+				if allowHeaders != "" {
+					w.Header().Set("Access-Control-Allow-Headers", allowHeaders)
+				}
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	})
+}
+
+// hasRequestBody returns whether any of the methods is expected to carry a request body
+// limited by maxRequestBodySizeMiddleware.
+func hasRequestBody(methods []string) bool {
+	for _, m := range methods {
+		if m = strings.ToUpper(m); m == http.MethodPost || m == http.MethodPut {
+			return true
+		}
+	}
+	return false
+}
+
+// maxRequestBodySizeMiddleware returns a middleware limiting the body size of the POST and
+// PUT requests to limit bytes. The requests exceeding it are replied to with 413.
+func maxRequestBodySizeMiddleware(limit int64) middleware.Interface {
+	return middleware.Func(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost && r.Method != http.MethodPut {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if r.ContentLength > limit {
+				http.Error(w, requestBodyTooLargeMessage(limit), http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			// The content length is unknown, so the limit is enforced while the handler reads the body.
+			body := &limitedRequestBody{ReadCloser: http.MaxBytesReader(w, r.Body, limit), limit: limit}
+			r.Body = body
+			next.ServeHTTP(&limitedRequestBodyResponseWriter{ResponseWriter: w, body: body}, r)
+		})
+	})
+}
+
+func requestBodyTooLargeMessage(limit int64) string {
+	return fmt.Sprintf("request body too large, limit: %d bytes", limit)
+}
+
+// limitedRequestBody tracks whether reading the request body failed because of the size limit.
+type limitedRequestBody struct {
+	io.ReadCloser
+	limit    int64
+	read     int64
+	exceeded bool
+}
+
+func (b *limitedRequestBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+
+	// The reader returned by http.MaxBytesReader fails once the limit has been read.
+	if err != nil && err != io.EOF && b.read >= b.limit {
+		b.exceeded = true
+	}
+	return n, err
+}
+
+// limitedRequestBodyResponseWriter replaces the response of the handler with a 413 one
+// if the handler failed to read the request body because of the size limit.
+type limitedRequestBodyResponseWriter struct {
+	http.ResponseWriter
+	body *limitedRequestBody
+
+	wroteHeader bool
+	discard     bool
+}
+
+func (w *limitedRequestBodyResponseWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	if w.body.exceeded {
+		w.discard = true
+		http.Error(w.ResponseWriter, requestBodyTooLargeMessage(w.body.limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *limitedRequestBodyResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.discard {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *limitedRequestBodyResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.discard {
+		f.Flush()
+	}
+}
@@ -0,0 +1,263 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/server"
+	"github.com/weaveworks/common/user"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/util/push"
+)
+
+func TestLabelLengthLimitPushWrapper(t *testing.T) {
+	const (
+		maxNameLength  = 10
+		maxValueLength = 20
+	)
+
+	for _, tc := range []struct {
+		name           string
+		labels         []cortexpb.LabelAdapter
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "labels within the limits",
+			labels:         []cortexpb.LabelAdapter{{Name: "__name__", Value: "foo"}, {Name: "job", Value: "test"}},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "label name too long",
+			labels:         []cortexpb.LabelAdapter{{Name: "__name__", Value: "foo"}, {Name: strings.Repeat("a", maxNameLength+1), Value: "test"}},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   strings.Repeat("a", maxNameLength+1),
+		},
+		{
+			name:           "label value too long",
+			labels:         []cortexpb.LabelAdapter{{Name: "__name__", Value: "foo"}, {Name: "job", Value: strings.Repeat("b", maxValueLength+1)}},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `label value too long for label "job"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pushed := false
+			pushFn := labelLengthLimitPushWrapper(maxNameLength, maxValueLength)(func(_ context.Context, _ *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+				pushed = true
+				return &cortexpb.WriteResponse{}, nil
+			})
+
+			req := cortexpb.WriteRequest{Timeseries: []cortexpb.PreallocTimeseries{{TimeSeries: &cortexpb.TimeSeries{
+				Labels:  tc.labels,
+				Samples: []cortexpb.Sample{{Value: 1, TimestampMs: 1}},
+			}}}}
+			data, err := req.Marshal()
+			require.NoError(t, err)
+
+			httpReq := httptest.NewRequest("POST", "/api/v1/push", bytes.NewReader(snappy.Encode(nil, data)))
+			resp := httptest.NewRecorder()
+			push.Handler(100000, nil, pushFn).ServeHTTP(resp, httpReq)
+
+			assert.Equal(t, tc.expectedStatus, resp.Code)
+			assert.Contains(t, resp.Body.String(), tc.expectedBody)
+			assert.Equal(t, tc.expectedStatus == http.StatusOK, pushed)
+		})
+	}
+}
+
+func TestErrorMapperMiddleware(t *testing.T) {
+	errUnavailable := errors.New("unavailable")
+
+	mapper := func(err error) int {
+		var httpErr HTTPError
+		if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusBadRequest && strings.Contains(httpErr.Message, "limit") {
+			return http.StatusTooManyRequests
+		}
+		if errors.Is(err, errUnavailable) {
+			return http.StatusServiceUnavailable
+		}
+		return 0
+	}
+
+	cfg := Config{ErrorMapper: mapper}
+	serverCfg := server.Config{HTTPListenNetwork: server.DefaultNetwork}
+	s := &server.Server{HTTP: mux.NewRouter()}
+
+	api, err := New(cfg, serverCfg, s, &FakeLogger{})
+	require.NoError(t, err)
+
+	api.RegisterRoute("/ok", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}), false, "GET")
+	api.RegisterRoute("/limit", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "per-user series limit exceeded", http.StatusBadRequest)
+	}), false, "GET")
+	api.RegisterRoute("/bad-request", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid parameter", http.StatusBadRequest)
+	}), false, "GET")
+	api.RegisterRoute("/panic", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(errUnavailable)
+	}), false, "GET")
+
+	for _, tc := range []struct {
+		path           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{path: "/ok", expectedStatus: http.StatusOK, expectedBody: "ok"},
+		{path: "/limit", expectedStatus: http.StatusTooManyRequests, expectedBody: "per-user series limit exceeded\n"},
+		{path: "/bad-request", expectedStatus: http.StatusBadRequest, expectedBody: "invalid parameter\n"},
+		{path: "/panic", expectedStatus: http.StatusServiceUnavailable, expectedBody: "unavailable\n"},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			s.HTTP.ServeHTTP(resp, httptest.NewRequest("GET", tc.path, nil))
+
+			assert.Equal(t, tc.expectedStatus, resp.Code)
+			assert.Equal(t, tc.expectedBody, resp.Body.String())
+		})
+	}
+
+	t.Run("should not recover http.ErrAbortHandler", func(t *testing.T) {
+		api.RegisterRoute("/abort", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		}), false, "GET")
+
+		resp := httptest.NewRecorder()
+		assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+			s.HTTP.ServeHTTP(resp, httptest.NewRequest("GET", "/abort", nil))
+		})
+		assert.Empty(t, resp.Body.String())
+	})
+
+	t.Run("should not recover a wrapped http.ErrAbortHandler", func(t *testing.T) {
+		wrapped := fmt.Errorf("aborting: %w", http.ErrAbortHandler)
+		api.RegisterRoute("/abort-wrapped", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic(wrapped)
+		}), false, "GET")
+
+		assert.PanicsWithValue(t, wrapped, func() {
+			s.HTTP.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/abort-wrapped", nil))
+		})
+	})
+}
+
+func TestGRPCAuthInterceptors(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		authHeaderName string
+		md             metadata.MD
+		expectedOrgID  string
+		expectedErr    string
+	}{
+		{
+			name:          "tenant in the default metadata key",
+			md:            metadata.Pairs(user.OrgIDHeaderName, "user-1"),
+			expectedOrgID: "user-1",
+		},
+		{
+			name:        "no metadata",
+			expectedErr: "no org id",
+		},
+		{
+			name:        "multiple tenants",
+			md:          metadata.Pairs(user.OrgIDHeaderName, "user-1", user.OrgIDHeaderName, "user-2"),
+			expectedErr: "no org id",
+		},
+		{
+			name:           "tenant in the configured metadata key",
+			authHeaderName: "X-Tenant",
+			md:             metadata.Pairs("X-Tenant", "user-1", user.OrgIDHeaderName, "user-2"),
+			expectedOrgID:  "user-1",
+		},
+		{
+			name:           "tenant only in the default metadata key, like the internal calls",
+			authHeaderName: "X-Tenant",
+			md:             metadata.Pairs(user.OrgIDHeaderName, "user-2"),
+			expectedOrgID:  "user-2",
+		},
+		{
+			name:           "no metadata with the configured metadata key",
+			authHeaderName: "X-Tenant",
+			expectedErr:    "no org id in the X-Tenant or X-Scope-OrgID metadata",
+		},
+		{
+			name:           "empty tenant in the configured metadata key",
+			authHeaderName: "X-Tenant",
+			md:             metadata.Pairs("X-Tenant", ""),
+			expectedErr:    "no org id in the X-Tenant or X-Scope-OrgID metadata",
+		},
+		{
+			name:           "multiple tenants in the configured metadata key",
+			authHeaderName: "X-Tenant",
+			md:             metadata.Pairs("X-Tenant", "user-1", "X-Tenant", "user-2"),
+			expectedErr:    "no org id in the X-Tenant or X-Scope-OrgID metadata",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			unary, stream := GRPCAuthInterceptors(Config{AuthHeaderName: tc.authHeaderName})
+
+			ctx := context.Background()
+			if tc.md != nil {
+				ctx = metadata.NewIncomingContext(ctx, tc.md)
+			}
+
+			assertResult := func(t *testing.T, orgID string, err error) {
+				if tc.expectedErr != "" {
+					require.Error(t, err)
+					if tc.authHeaderName != "" {
+						assert.Equal(t, codes.Unauthenticated, status.Code(err))
+					}
+					assert.Contains(t, err.Error(), tc.expectedErr)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, tc.expectedOrgID, orgID)
+			}
+
+			t.Run("unary", func(t *testing.T) {
+				var orgID string
+				_, err := unary(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, _ interface{}) (interface{}, error) {
+					var err error
+					orgID, err = user.ExtractOrgID(ctx)
+					return nil, err
+				})
+				assertResult(t, orgID, err)
+			})
+
+			t.Run("stream", func(t *testing.T) {
+				var orgID string
+				err := stream(nil, &mockServerStream{ctx: ctx}, &grpc.StreamServerInfo{}, func(_ interface{}, ss grpc.ServerStream) error {
+					var err error
+					orgID, err = user.ExtractOrgID(ss.Context())
+					return err
+				})
+				assertResult(t, orgID, err)
+			})
+		})
+	}
+}
+
+type mockServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (ss *mockServerStream) Context() context.Context {
+	return ss.ctx
+}
@@ -14,7 +14,7 @@ import (
 
 // Config configures the database.
 type Config struct {
-	URI           string `yaml:"uri"`
+	URI           string `yaml:"uri" secret:"true"`
 	MigrationsDir string `yaml:"migrations_dir"`
 	PasswordFile  string `yaml:"password_file"`
 
@@ -296,9 +296,13 @@ func New(cfg Config) (*Cortex, error) {
 		tenant.WithDefaultResolver(tenant.NewMultiResolver())
 	}
 
+	// The gRPC interceptors must be installed before the server is constructed, reading the tenant ID
+	// from the configured auth header, as well as from X-Scope-OrgID for the internal calls.
+	grpcAuth, grpcStreamAuth := api.GRPCAuthInterceptors(cfg.API)
+
 	// Don't check auth header on TransferChunks, as we weren't originally
 	// sending it and this could cause transfers to fail on update.
-	cfg.API.HTTPAuthMiddleware = fakeauth.SetupAuthMiddleware(&cfg.Server, cfg.AuthEnabled,
+	cfg.API.HTTPAuthMiddleware = fakeauth.SetupAuthMiddleware(&cfg.Server, cfg.AuthEnabled, grpcAuth, grpcStreamAuth,
 		// Also don't check auth for these gRPC methods, since single call is used for multiple users (or no user like health check).
 		[]string{
 			"/grpc.health.v1.Health/Check",
@@ -366,7 +370,8 @@ func (t *Cortex) Run() error {
 
 	// before starting servers, register /ready handler and gRPC health check service.
 	// It should reflect entire Cortex.
-	t.Server.HTTP.Path("/ready").Handler(t.readyHandler(sm))
+	t.API.RegisterReadyHandler(t.readyHandler(sm))
+	t.API.RegisterLiveHandler(liveHandler())
 	grpc_health_v1.RegisterHealthServer(t.Server.GRPC, grpcutil.NewHealthCheck(sm))
 
 	// Let's listen for events from this manager, and log them.
@@ -459,6 +464,22 @@ func (t *Cortex) readyHandler(sm *services.Manager) http.HandlerFunc {
 			}
 		}
 
+		// Run the additional checks added by the modules, listing the failing ones.
+		if t.API != nil {
+			if err := t.API.CheckReadiness(r.Context()); err != nil {
+				http.Error(w, "Not ready: "+err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+
 		util.WriteTextResponse(w, "ready")
 	}
 }
+
+// liveHandler reports the process as alive as long as it's able to serve HTTP requests,
+// regardless of the modules state.
+func liveHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		util.WriteTextResponse(w, "ok")
+	}
+}
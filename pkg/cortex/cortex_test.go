@@ -3,9 +3,12 @@ package cortex
 import (
 	"bytes"
 	"context"
+	"errors"
 	"flag"
 	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"strconv"
 	"strings"
 	"testing"
@@ -13,6 +16,8 @@ import (
 
 	"github.com/cortexproject/cortex/pkg/cortex/storage"
 
+	"github.com/go-kit/log"
+	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -21,6 +26,7 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 
+	"github.com/cortexproject/cortex/pkg/api"
 	"github.com/cortexproject/cortex/pkg/frontend/v1/frontendv1pb"
 	"github.com/cortexproject/cortex/pkg/ingester"
 	"github.com/cortexproject/cortex/pkg/ring"
@@ -202,6 +208,34 @@ func TestGrpcAuthMiddleware(t *testing.T) {
 	}
 }
 
+func TestReadyHandlerShouldEvaluateTheReadinessChecks(t *testing.T) {
+	ctx := context.Background()
+
+	sm, err := services.NewManager(services.NewIdleService(nil, nil))
+	require.NoError(t, err)
+	require.NoError(t, services.StartManagerAndAwaitHealthy(ctx, sm))
+	defer func() {
+		require.NoError(t, services.StopManagerAndAwaitStopped(ctx, sm))
+	}()
+
+	a, err := api.New(api.Config{}, server.Config{}, &server.Server{HTTP: mux.NewRouter()}, log.NewNopLogger())
+	require.NoError(t, err)
+	c := &Cortex{API: a}
+
+	var ringErr error
+	a.AddReadinessCheck("ring", func(context.Context) error { return ringErr })
+
+	resp := httptest.NewRecorder()
+	c.readyHandler(sm)(resp, httptest.NewRequest("GET", "/ready", nil))
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	ringErr = errors.New("not ready")
+	resp = httptest.NewRecorder()
+	c.readyHandler(sm)(resp, httptest.NewRequest("GET", "/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+	assert.Equal(t, "Not ready: ring: not ready\n", resp.Body.String())
+}
+
 func TestFlagDefaults(t *testing.T) {
 	c := Config{}
 
@@ -10,6 +10,7 @@ import (
 	"github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/version"
 	prom_storage "github.com/prometheus/prometheus/storage"
 	"github.com/thanos-io/thanos/pkg/discovery/dns"
 	httpgrpc_server "github.com/weaveworks/common/httpgrpc/server"
@@ -81,6 +82,19 @@ func newDefaultConfig() *Config {
 func (t *Cortex) initAPI() (services.Service, error) {
 	t.Cfg.API.ServerPrefix = t.Cfg.Server.PathPrefix
 	t.Cfg.API.LegacyHTTPPrefix = t.Cfg.HTTPPrefix
+	t.Cfg.API.TenantResponseCompression = func(userID string) bool {
+		// Overrides are initialized after the API, but before any request is served.
+		if t.Overrides != nil {
+			if enabled, ok := t.Overrides.ResponseCompressionEnabled(userID); ok {
+				return enabled
+			}
+		}
+		return t.Cfg.API.ResponseCompression
+	}
+
+	if t.Cfg.API.Registerer == nil {
+		t.Cfg.API.Registerer = prometheus.DefaultRegisterer
+	}
 
 	a, err := api.New(t.Cfg.API, t.Cfg.Server, t.Server, util_log.Logger)
 	if err != nil {
@@ -89,6 +103,20 @@ func (t *Cortex) initAPI() (services.Service, error) {
 
 	t.API = a
 	t.API.RegisterAPI(t.Cfg.Server.PathPrefix, t.Cfg, newDefaultConfig())
+	t.API.RegisterRouteCatalog()
+	t.API.RegisterEffectiveConfigHandler(effectiveConfigHandler(api.RedactSecrets(t.Cfg), func(userID string) *validation.Limits {
+		// The tenant limits are initialized after the API, but before any request is served.
+		if t.TenantLimits == nil {
+			return nil
+		}
+		return t.TenantLimits.ByUserID(userID)
+	}))
+	t.API.RegisterBuildInfo(api.BuildInfo{
+		Version:   version.Version,
+		Revision:  version.Revision,
+		Branch:    version.Branch,
+		GoVersion: version.GoVersion,
+	})
 
 	return nil, nil
 }
@@ -180,6 +208,7 @@ func (t *Cortex) initOverridesExporter() (services.Service, error) {
 func (t *Cortex) initDistributorService() (serv services.Service, err error) {
 	t.Cfg.Distributor.DistributorRing.ListenPort = t.Cfg.Server.GRPCListenPort
 	t.Cfg.Distributor.ShuffleShardingLookbackPeriod = t.Cfg.Querier.ShuffleShardingIngestersLookbackPeriod
+	t.Cfg.Distributor.MaxIngestersPerQuery = t.Cfg.Querier.MaxIngestersPerQuery
 
 	// Check whether the distributor can join the distributors ring, which is
 	// whenever it's not running as an internal dependency (ie. querier or
@@ -196,6 +225,7 @@ func (t *Cortex) initDistributorService() (serv services.Service, err error) {
 
 func (t *Cortex) initDistributor() (serv services.Service, err error) {
 	t.API.RegisterDistributor(t.Distributor, t.Cfg.Distributor)
+	t.API.RegisterOTLPHandler(t.Distributor, t.Cfg.Distributor)
 
 	return nil, nil
 }
@@ -288,6 +318,9 @@ func (t *Cortex) initQuerier() (serv services.Service, err error) {
 		prometheus.DefaultRegisterer,
 		util_log.Logger,
 	)
+	if t.Cfg.Querier.QueryIngestersWithinHeaderEnabled {
+		internalQuerierRouter = querier.NewQueryIngestersWithinMiddleware(t.Cfg.Querier.QueryStoreAfter, util_log.Logger).Wrap(internalQuerierRouter)
+	}
 
 	// If the querier is running standalone without the query-frontend or query-scheduler, we must register it's internal
 	// HTTP handler externally and provide the external Cortex Server HTTP handler to the frontend worker
@@ -446,6 +479,8 @@ func (t *Cortex) initQueryFrontendTripperware() (serv services.Service, err erro
 }
 
 func (t *Cortex) initQueryFrontend() (serv services.Service, err error) {
+	t.Cfg.Frontend.PrometheusHTTPPrefix = t.Cfg.API.PrometheusHTTPPrefix
+
 	roundTripper, frontendV1, frontendV2, err := frontend.InitFrontend(t.Cfg.Frontend, t.Overrides, t.Cfg.Server.GRPCListenPort, util_log.Logger, prometheus.DefaultRegisterer)
 	if err != nil {
 		return nil, err
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/weaveworks/common/server"
@@ -78,6 +79,7 @@ func TestAPIConfig(t *testing.T) {
 			cortex.Server.HTTP = mux.NewRouter()
 
 			cortex.Cfg = *actualCfg
+			cortex.Cfg.API.Registerer = prometheus.NewRegistry()
 			if tc.actualCfg != nil {
 				tc.actualCfg(&cortex.Cfg)
 			}
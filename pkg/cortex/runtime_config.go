@@ -9,6 +9,7 @@ import (
 
 	"github.com/cortexproject/cortex/pkg/ingester"
 	"github.com/cortexproject/cortex/pkg/ring/kv"
+	"github.com/cortexproject/cortex/pkg/tenant"
 	"github.com/cortexproject/cortex/pkg/util"
 	"github.com/cortexproject/cortex/pkg/util/runtimeconfig"
 	"github.com/cortexproject/cortex/pkg/util/validation"
@@ -185,3 +186,32 @@ func runtimeConfigHandler(runtimeCfgManager *runtimeconfig.Manager, defaultLimit
 		util.WriteYAMLResponse(w, output)
 	}
 }
+
+// effectiveConfigHandler serves the config in effect for the tenant of the request: the base
+// config, with the limits replaced by the tenant's runtime overrides, if any. The tenant
+// overrides already default to the base limits, given they're loaded on top of them.
+func effectiveConfigHandler(baseCfg interface{}, tenantLimits func(userID string) *validation.Limits) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := tenant.TenantID(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		cfg, err := util.YAMLMarshalUnmarshal(baseCfg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if limits := tenantLimits(userID); limits != nil {
+			cfg["limits"], err = util.YAMLMarshalUnmarshal(limits)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		util.WriteYAMLResponse(w, cfg)
+	}
+}
@@ -1,11 +1,15 @@
 package cortex
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+	"gopkg.in/yaml.v2"
 
 	"github.com/cortexproject/cortex/pkg/util/validation"
 )
@@ -109,3 +113,48 @@ overrides:
 		assert.Nil(t, actual)
 	}
 }
+
+func TestEffectiveConfigHandler(t *testing.T) {
+	baseCfg := newDefaultConfig()
+	baseCfg.HTTPPrefix = "/base"
+	baseCfg.LimitsConfig.IngestionRate = 100
+	baseCfg.LimitsConfig.IngestionBurstSize = 200
+
+	// The tenant overrides are loaded on top of the base limits.
+	tenantLimits := baseCfg.LimitsConfig
+	tenantLimits.IngestionRate = 500
+	overrides := map[string]*validation.Limits{"user-1": &tenantLimits}
+
+	handler := effectiveConfigHandler(baseCfg, func(userID string) *validation.Limits {
+		return overrides[userID]
+	})
+
+	for _, tc := range []struct {
+		userID                     string
+		expectedIngestionRate      float64
+		expectedIngestionBurstSize int
+	}{
+		{userID: "user-1", expectedIngestionRate: 500, expectedIngestionBurstSize: 200},
+		{userID: "user-2", expectedIngestionRate: 100, expectedIngestionBurstSize: 200},
+	} {
+		t.Run(tc.userID, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v1/status/config", nil)
+			req = req.WithContext(user.InjectOrgID(req.Context(), tc.userID))
+			resp := httptest.NewRecorder()
+			handler(resp, req)
+			require.Equal(t, http.StatusOK, resp.Code)
+
+			var cfg Config
+			require.NoError(t, yaml.Unmarshal(resp.Body.Bytes(), &cfg))
+			assert.Equal(t, "/base", cfg.HTTPPrefix)
+			assert.Equal(t, tc.expectedIngestionRate, cfg.LimitsConfig.IngestionRate)
+			assert.Equal(t, tc.expectedIngestionBurstSize, cfg.LimitsConfig.IngestionBurstSize)
+		})
+	}
+
+	t.Run("no tenant", func(t *testing.T) {
+		resp := httptest.NewRecorder()
+		handler(resp, httptest.NewRequest("GET", "/api/v1/status/config", nil))
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+}
@@ -140,8 +140,9 @@ type Config struct {
 	// this (and should never use it) but this feature is used by other projects built on top of it
 	SkipLabelNameValidation bool `yaml:"-"`
 
-	// This config is dynamically injected because defined in the querier config.
+	// These configs are dynamically injected because defined in the querier config.
 	ShuffleShardingLookbackPeriod time.Duration `yaml:"-"`
+	MaxIngestersPerQuery          int           `yaml:"-"`
 
 	// Limits for distributor
 	InstanceLimits InstanceLimits `yaml:"instance_limits"`
@@ -945,6 +946,95 @@ func (d *Distributor) LabelValuesForLabelNameStream(ctx context.Context, from, t
 	}, matchers...)
 }
 
+// LabelValuesForLabelNameIterator is like LabelValuesForLabelNameStream, but calls f with each batch of
+// label values as soon as it's received from an ingester, instead of loading all of them in memory.
+// The batches aren't deduplicated across ingesters, and f is never called concurrently. Once f returns
+// false, the label values aren't streamed from the ingesters anymore, without failing the request.
+func (d *Distributor) LabelValuesForLabelNameIterator(ctx context.Context, from, to model.Time, labelName model.LabelName, f func(values []string) bool, matchers ...*labels.Matcher) error {
+	replicationSet, err := d.GetIngestersForMetadata(ctx)
+	if err != nil {
+		return err
+	}
+
+	req, err := ingester_client.ToLabelValuesRequest(labelName, from, to, matchers)
+	if err != nil {
+		return err
+	}
+
+	// The streams are canceled once stopped, while the context of the whole request isn't, so that
+	// the request doesn't fail.
+	var (
+		mtx     sync.Mutex
+		stopped bool
+		stop    = make(chan struct{})
+	)
+	isStopped := func() bool {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return stopped
+	}
+	// Must be called with mtx held.
+	stopLocked := func() {
+		if !stopped {
+			stopped = true
+			close(stop)
+		}
+	}
+
+	_, err = d.ForReplicationSet(ctx, replicationSet, func(ctx context.Context, client ingester_client.IngesterClient) (interface{}, error) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			select {
+			case <-stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		stream, err := client.LabelValuesStream(ctx, req)
+		if err != nil {
+			// The streams are canceled once stopped, which isn't a failure.
+			if isStopped() {
+				return nil, nil
+			}
+			return nil, err
+		}
+		defer stream.CloseSend() //nolint:errcheck
+
+		for {
+			resp, err := stream.Recv()
+			if err == io.EOF {
+				return nil, nil
+			} else if err != nil {
+				if isStopped() {
+					return nil, nil
+				}
+				return nil, err
+			}
+
+			mtx.Lock()
+			if !stopped && !f(resp.LabelValues) {
+				stopLocked()
+			}
+			done := stopped
+			mtx.Unlock()
+
+			if done {
+				return nil, nil
+			}
+		}
+	})
+
+	// The replication set returns once enough ingesters succeeded, while the streams of the
+	// others may still be running: stop them, so that f is never called after returning.
+	mtx.Lock()
+	stopLocked()
+	mtx.Unlock()
+
+	return err
+}
+
 func (d *Distributor) LabelNamesCommon(ctx context.Context, from, to model.Time, f func(ctx context.Context, rs ring.ReplicationSet, req *ingester_client.LabelNamesRequest) ([]interface{}, error)) ([]string, error) {
 	replicationSet, err := d.GetIngestersForMetadata(ctx)
 	if err != nil {
@@ -1079,6 +1169,105 @@ func (d *Distributor) MetricsForLabelMatchersStream(ctx context.Context, from, t
 	}, matchers...)
 }
 
+// MetricsForLabelMatchersIterator is like MetricsForLabelMatchersStream, but calls f with each batch of
+// series as soon as it's received from an ingester, instead of loading all of them in memory. The series
+// aren't deduplicated across ingesters, and f is never called concurrently. Once f returns false, the
+// series aren't streamed from the ingesters anymore, without failing the request.
+func (d *Distributor) MetricsForLabelMatchersIterator(ctx context.Context, from, through model.Time, f func(metrics []metric.Metric) bool, matchers ...*labels.Matcher) error {
+	replicationSet, err := d.GetIngestersForMetadata(ctx)
+	if err != nil {
+		return err
+	}
+
+	req, err := ingester_client.ToMetricsForLabelMatchersRequest(from, through, matchers)
+	if err != nil {
+		return err
+	}
+
+	queryLimiter := limiter.QueryLimiterFromContextWithFallback(ctx)
+
+	// The streams are canceled once stopped, while the context of the whole request isn't, so that
+	// the request doesn't fail.
+	var (
+		mtx     sync.Mutex
+		stopped bool
+		stop    = make(chan struct{})
+	)
+	isStopped := func() bool {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return stopped
+	}
+	// Must be called with mtx held.
+	stopLocked := func() {
+		if !stopped {
+			stopped = true
+			close(stop)
+		}
+	}
+
+	_, err = d.ForReplicationSet(ctx, replicationSet, func(ctx context.Context, client ingester_client.IngesterClient) (interface{}, error) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			select {
+			case <-stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		stream, err := client.MetricsForLabelMatchersStream(ctx, req)
+		if err != nil {
+			// The streams are canceled once stopped, which isn't a failure.
+			if isStopped() {
+				return nil, nil
+			}
+			return nil, err
+		}
+		defer stream.CloseSend() //nolint:errcheck
+
+		for {
+			resp, err := stream.Recv()
+			if err == io.EOF {
+				return nil, nil
+			} else if err != nil {
+				if isStopped() {
+					return nil, nil
+				}
+				return nil, err
+			}
+
+			metrics := make([]metric.Metric, 0, len(resp.Metric))
+			for _, m := range resp.Metric {
+				if err := queryLimiter.AddSeries(m.Labels); err != nil {
+					return nil, err
+				}
+				metrics = append(metrics, metric.Metric{Metric: cortexpb.FromLabelAdaptersToMetricWithCopy(m.Labels)})
+			}
+
+			mtx.Lock()
+			if !stopped && !f(metrics) {
+				stopLocked()
+			}
+			done := stopped
+			mtx.Unlock()
+
+			if done {
+				return nil, nil
+			}
+		}
+	})
+
+	// The replication set returns once enough ingesters succeeded, while the streams of the
+	// others may still be running: stop them, so that f is never called after returning.
+	mtx.Lock()
+	stopLocked()
+	mtx.Unlock()
+
+	return err
+}
+
 func (d *Distributor) metricsForLabelMatchersCommon(ctx context.Context, from, through model.Time, f func(context.Context, ring.ReplicationSet, *ingester_client.MetricsForLabelMatchersRequest, *map[model.Fingerprint]model.Metric, *sync.Mutex, *limiter.QueryLimiter) error, matchers ...*labels.Matcher) ([]metric.Metric, error) {
 	replicationSet, err := d.GetIngestersForMetadata(ctx)
 	queryLimiter := limiter.QueryLimiterFromContextWithFallback(ctx)
@@ -1110,8 +1299,9 @@ func (d *Distributor) metricsForLabelMatchersCommon(ctx context.Context, from, t
 	return result, nil
 }
 
-// MetricsMetadata returns all metric metadata of a user.
-func (d *Distributor) MetricsMetadata(ctx context.Context) ([]scrape.MetricMetadata, error) {
+// MetricsMetadata returns the metric metadata of a user, for at most limit metrics and with at
+// most limitPerMetric metadata per metric. A limit lower or equal to 0 means unlimited.
+func (d *Distributor) MetricsMetadata(ctx context.Context, limit, limitPerMetric int) ([]scrape.MetricMetadata, error) {
 	replicationSet, err := d.GetIngestersForMetadata(ctx)
 	if err != nil {
 		return nil, err
@@ -1128,6 +1318,7 @@ func (d *Distributor) MetricsMetadata(ctx context.Context) ([]scrape.MetricMetad
 
 	result := []scrape.MetricMetadata{}
 	dedupTracker := map[cortexpb.MetricMetadata]struct{}{}
+	metadataPerMetric := map[string]int{}
 	for _, resp := range resps {
 		r := resp.(*ingester_client.MetricsMetadataResponse)
 		for _, m := range r.Metadata {
@@ -1136,7 +1327,17 @@ func (d *Distributor) MetricsMetadata(ctx context.Context) ([]scrape.MetricMetad
 			if ok {
 				continue
 			}
+
+			count, ok := metadataPerMetric[m.MetricFamilyName]
+			if !ok && limit > 0 && len(metadataPerMetric) >= limit {
+				continue
+			}
+			if limitPerMetric > 0 && count >= limitPerMetric {
+				continue
+			}
+
 			dedupTracker[*m] = struct{}{}
+			metadataPerMetric[m.MetricFamilyName] = count + 1
 
 			result = append(result, scrape.MetricMetadata{
 				Metric: m.MetricFamilyName,
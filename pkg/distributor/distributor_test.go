@@ -32,10 +32,12 @@ import (
 	"google.golang.org/grpc/status"
 
 	"github.com/cortexproject/cortex/pkg/chunk/encoding"
+	"github.com/cortexproject/cortex/pkg/chunk/purger"
 	"github.com/cortexproject/cortex/pkg/cortexpb"
 	"github.com/cortexproject/cortex/pkg/ingester"
 	"github.com/cortexproject/cortex/pkg/ingester/client"
 	"github.com/cortexproject/cortex/pkg/prom1/storage/metric"
+	"github.com/cortexproject/cortex/pkg/querier"
 	"github.com/cortexproject/cortex/pkg/ring"
 	ring_client "github.com/cortexproject/cortex/pkg/ring/client"
 	"github.com/cortexproject/cortex/pkg/ring/kv"
@@ -1071,6 +1073,44 @@ func TestDistributor_QueryStream_ShouldReturnErrorIfMaxChunksPerQueryLimitIsReac
 	assert.Contains(t, err.Error(), "the query hit the max number of chunks limit")
 }
 
+func TestDistributor_QueryStream_ShouldReturnErrorIfMaxIngestersPerQueryLimitIsReached(t *testing.T) {
+	const numIngesters = 100
+
+	allSeriesMatchers := []*labels.Matcher{
+		labels.MustNewMatcher(labels.MatchRegexp, model.MetricNameLabel, ".+"),
+	}
+
+	for _, testData := range []struct {
+		maxIngestersPerQuery int
+		expectedErr          bool
+	}{
+		{maxIngestersPerQuery: 0},
+		{maxIngestersPerQuery: numIngesters},
+		{maxIngestersPerQuery: numIngesters - 1, expectedErr: true},
+	} {
+		t.Run(fmt.Sprintf("max ingesters per query: %d", testData.maxIngestersPerQuery), func(t *testing.T) {
+			ctx := user.InjectOrgID(context.Background(), "user")
+
+			ds, _, _, _ := prepare(t, prepConfig{
+				numIngesters:         numIngesters,
+				happyIngesters:       numIngesters,
+				numDistributors:      1,
+				shardByAllLabels:     true,
+				maxIngestersPerQuery: testData.maxIngestersPerQuery,
+			})
+
+			_, err := ds[0].QueryStream(ctx, math.MinInt32, math.MaxInt32, allSeriesMatchers...)
+			if !testData.expectedErr {
+				require.NoError(t, err)
+				return
+			}
+
+			require.Error(t, err)
+			assert.Equal(t, validation.LimitError(fmt.Sprintf(errMaxIngestersPerQuery, numIngesters, testData.maxIngestersPerQuery)), err)
+		})
+	}
+}
+
 func TestDistributor_QueryStream_ShouldReturnErrorIfMaxSeriesPerQueryLimitIsReached(t *testing.T) {
 	const maxSeriesLimit = 10
 
@@ -1123,6 +1163,66 @@ func TestDistributor_QueryStream_ShouldReturnErrorIfMaxSeriesPerQueryLimitIsReac
 
 }
 
+func TestDistributor_QuerierStreamingSelect_ShouldHonorMaxFetchedSeriesPerQuery(t *testing.T) {
+	const maxSeriesLimit = 10
+
+	ctx := user.InjectOrgID(context.Background(), "user")
+	limits := &validation.Limits{}
+	flagext.DefaultValues(limits)
+	limits.MaxFetchedSeriesPerQuery = maxSeriesLimit
+
+	overrides, err := validation.NewOverrides(*limits, nil)
+	require.NoError(t, err)
+
+	// Prepare distributors.
+	ds, _, _, _ := prepare(t, prepConfig{
+		numIngesters:     3,
+		happyIngesters:   3,
+		numDistributors:  1,
+		shardByAllLabels: true,
+		limits:           limits,
+	})
+
+	// The querier adds the query limiter to the context from the tenant limits, and the
+	// distributor enforces it on the series received from ingesters.
+	querierCfg := querier.Config{}
+	flagext.DefaultValues(&querierCfg)
+	querierCfg.IngesterStreaming = true
+	queryable, _, _ := querier.New(querierCfg, overrides, ds[0], nil, purger.NewNoopTombstonesLoader(), nil, log.NewNopLogger())
+
+	selectAllSeries := func() (int, error) {
+		q, err := queryable.Querier(ctx, 0, time.Now().UnixMilli())
+		require.NoError(t, err)
+
+		set := q.Select(false, nil, labels.MustNewMatcher(labels.MatchRegexp, model.MetricNameLabel, ".+"))
+		count := 0
+		for set.Next() {
+			count++
+		}
+		return count, set.Err()
+	}
+
+	// Push a number of series equal to the max series limit, but not exceeding it.
+	_, err = ds[0].Push(ctx, makeWriteRequest(0, maxSeriesLimit, 0))
+	require.NoError(t, err)
+
+	count, err := selectAllSeries()
+	require.NoError(t, err)
+	assert.Equal(t, maxSeriesLimit, count)
+
+	// Push another series to exceed the limit.
+	writeReq := &cortexpb.WriteRequest{}
+	writeReq.Timeseries = append(writeReq.Timeseries,
+		makeWriteRequestTimeseries([]cortexpb.LabelAdapter{{Name: model.MetricNameLabel, Value: "another_series"}}, 0, 0),
+	)
+	_, err = ds[0].Push(ctx, writeReq)
+	require.NoError(t, err)
+
+	_, err = selectAllSeries()
+	require.Error(t, err)
+	assert.Equal(t, validation.LimitError(fmt.Sprintf(limiter.ErrMaxSeriesHit, maxSeriesLimit)), err)
+}
+
 func TestDistributor_QueryStream_ShouldReturnErrorIfMaxChunkBytesPerQueryLimitIsReached(t *testing.T) {
 	const seriesToAdd = 10
 
@@ -2142,6 +2242,227 @@ func BenchmarkDistributor_MetricsForLabelMatchers(b *testing.B) {
 	}
 }
 
+func TestDistributor_LabelValuesForLabelNameIterator(t *testing.T) {
+	const numSeries = 1000
+
+	ds, ingesters, _, _ := prepare(t, prepConfig{
+		numIngesters:      3,
+		happyIngesters:    3,
+		numDistributors:   1,
+		shardByAllLabels:  true,
+		replicationFactor: 3,
+	})
+
+	ctx := user.InjectOrgID(context.Background(), "test")
+	now := model.Now()
+
+	series := make([]labels.Labels, 0, numSeries)
+	for i := 0; i < numSeries; i++ {
+		series = append(series, labels.Labels{{Name: labels.MetricName, Value: "test_1"}, {Name: "id", Value: fmt.Sprintf("%04d", i)}})
+	}
+	_, err := ds[0].Push(ctx, mockWriteRequest(series, 1, now.Unix()))
+	require.NoError(t, err)
+
+	matcher := mustNewMatcher(labels.MatchEqual, model.MetricNameLabel, "test_1")
+	expected, err := ds[0].LabelValuesForLabelNameStream(ctx, now, now, "id", matcher)
+	require.NoError(t, err)
+	require.Len(t, expected, numSeries)
+
+	t.Run("should return the same label values of the batch path", func(t *testing.T) {
+		valueSet := map[string]struct{}{}
+		err := ds[0].LabelValuesForLabelNameIterator(ctx, now, now, "id", func(values []string) bool {
+			assert.LessOrEqual(t, len(values), mockLabelValuesStreamBatchSize)
+			for _, v := range values {
+				valueSet[v] = struct{}{}
+			}
+			return true
+		}, matcher)
+		require.NoError(t, err)
+
+		actual := make([]string, 0, len(valueSet))
+		for v := range valueSet {
+			actual = append(actual, v)
+		}
+		sort.Strings(actual)
+		assert.Equal(t, expected, actual)
+	})
+
+	t.Run("should stop streaming without failing once the callback returns false", func(t *testing.T) {
+		calls := 0
+		err := ds[0].LabelValuesForLabelNameIterator(ctx, now, now, "id", func(values []string) bool {
+			calls++
+			return false
+		}, matcher)
+		require.NoError(t, err)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("should fail if too many ingesters fail", func(t *testing.T) {
+		ingesters[0].happy.Store(false)
+		ingesters[1].happy.Store(false)
+		defer ingesters[0].happy.Store(true)
+		defer ingesters[1].happy.Store(true)
+
+		err := ds[0].LabelValuesForLabelNameIterator(ctx, now, now, "id", func(values []string) bool {
+			return true
+		}, matcher)
+		require.Error(t, err)
+	})
+
+	t.Run("should not call the callback once returned, while a slow ingester is still streaming", func(t *testing.T) {
+		// The delay isn't reset, given it's read by the slow ingester stream, not synchronized
+		// with the test: this must be the last test case.
+		const delay = 100 * time.Millisecond
+		ingesters[2].queryDelay = delay
+
+		// The callback isn't synchronized with the caller, like in the querier.
+		valueSet := map[string]struct{}{}
+		returned := atomic.NewBool(false)
+		err := ds[0].LabelValuesForLabelNameIterator(ctx, now, now, "id", func(values []string) bool {
+			assert.False(t, returned.Load(), "callback called after returning")
+			for _, v := range values {
+				valueSet[v] = struct{}{}
+			}
+			return true
+		}, matcher)
+		require.NoError(t, err)
+		returned.Store(true)
+
+		// Read the values while the slow ingester stream would run.
+		deadline := time.Now().Add(2 * delay)
+		for time.Now().Before(deadline) {
+			count := 0
+			for range valueSet {
+				count++
+			}
+			require.Equal(t, numSeries, count)
+			time.Sleep(time.Millisecond)
+		}
+	})
+}
+
+func TestDistributor_MetricsForLabelMatchersIterator(t *testing.T) {
+	const numSeries = 100
+
+	ds, ingesters, _, _ := prepare(t, prepConfig{
+		numIngesters:      3,
+		happyIngesters:    3,
+		numDistributors:   1,
+		shardByAllLabels:  true,
+		replicationFactor: 3,
+	})
+
+	ctx := user.InjectOrgID(context.Background(), "test")
+	now := model.Now()
+
+	series := make([]labels.Labels, 0, numSeries)
+	for i := 0; i < numSeries; i++ {
+		series = append(series, labels.Labels{{Name: labels.MetricName, Value: "test_1"}, {Name: "id", Value: fmt.Sprintf("%04d", i)}})
+	}
+	_, err := ds[0].Push(ctx, mockWriteRequest(series, 1, now.Unix()))
+	require.NoError(t, err)
+
+	matcher := mustNewMatcher(labels.MatchEqual, model.MetricNameLabel, "test_1")
+	expected, err := ds[0].MetricsForLabelMatchersStream(ctx, now, now, matcher)
+	require.NoError(t, err)
+	require.Len(t, expected, numSeries)
+
+	t.Run("should return the same series of the batch path", func(t *testing.T) {
+		metricSet := map[model.Fingerprint]model.Metric{}
+		err := ds[0].MetricsForLabelMatchersIterator(ctx, now, now, func(metrics []metric.Metric) bool {
+			for _, m := range metrics {
+				metricSet[m.Metric.Fingerprint()] = m.Metric
+			}
+			return true
+		}, matcher)
+		require.NoError(t, err)
+
+		actual := make([]metric.Metric, 0, len(metricSet))
+		for _, m := range metricSet {
+			actual = append(actual, metric.Metric{Metric: m})
+		}
+		assert.ElementsMatch(t, expected, actual)
+	})
+
+	t.Run("should stop fetching the series without failing once the callback returns false", func(t *testing.T) {
+		const maxSeries = 10
+
+		received := 0
+		err := ds[0].MetricsForLabelMatchersIterator(ctx, now, now, func(metrics []metric.Metric) bool {
+			received += len(metrics)
+			return received < maxSeries
+		}, matcher)
+		require.NoError(t, err)
+
+		// The mock ingesters send a series per message, and no message is received once stopped.
+		assert.Equal(t, maxSeries, received)
+	})
+
+	t.Run("should fail if too many ingesters fail", func(t *testing.T) {
+		ingesters[0].happy.Store(false)
+		ingesters[1].happy.Store(false)
+		defer ingesters[0].happy.Store(true)
+		defer ingesters[1].happy.Store(true)
+
+		err := ds[0].MetricsForLabelMatchersIterator(ctx, now, now, func(metrics []metric.Metric) bool {
+			return true
+		}, matcher)
+		require.Error(t, err)
+	})
+}
+
+func BenchmarkDistributor_LabelValues(b *testing.B) {
+	const (
+		numIngesters = 3
+		numSeries    = 10000
+	)
+
+	ds, _, _, _ := prepare(b, prepConfig{
+		numIngesters:      numIngesters,
+		happyIngesters:    numIngesters,
+		numDistributors:   1,
+		shardByAllLabels:  true,
+		replicationFactor: 3,
+	})
+
+	ctx := user.InjectOrgID(context.Background(), "test")
+	now := model.Now()
+
+	series := make([]labels.Labels, 0, numSeries)
+	for i := 0; i < numSeries; i++ {
+		series = append(series, labels.Labels{{Name: labels.MetricName, Value: "test_1"}, {Name: "id", Value: strconv.Itoa(i)}})
+	}
+	_, err := ds[0].Push(ctx, mockWriteRequest(series, 1, now.Unix()))
+	require.NoError(b, err)
+
+	matcher := mustNewMatcher(labels.MatchEqual, model.MetricNameLabel, "test_1")
+
+	b.Run("batch", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			values, err := ds[0].LabelValuesForLabelNameStream(ctx, now, now, "id", matcher)
+			require.NoError(b, err)
+			require.Len(b, values, numSeries)
+		}
+	})
+
+	// The iterator collects the label values like the querier does when -querier.max-label-values is set.
+	b.Run("iterator", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			valueSet := map[string]struct{}{}
+			err := ds[0].LabelValuesForLabelNameIterator(ctx, now, now, "id", func(values []string) bool {
+				for _, v := range values {
+					valueSet[v] = struct{}{}
+				}
+				return true
+			}, matcher)
+			require.NoError(b, err)
+			require.Len(b, valueSet, numSeries)
+		}
+	})
+}
+
 func TestDistributor_MetricsMetadata(t *testing.T) {
 	const numIngesters = 5
 
@@ -2187,7 +2508,7 @@ func TestDistributor_MetricsMetadata(t *testing.T) {
 			require.NoError(t, err)
 
 			// Assert on metric metadata
-			metadata, err := ds[0].MetricsMetadata(ctx)
+			metadata, err := ds[0].MetricsMetadata(ctx, 0, 0)
 			require.NoError(t, err)
 			assert.Equal(t, 10, len(metadata))
 
@@ -2200,6 +2521,89 @@ func TestDistributor_MetricsMetadata(t *testing.T) {
 	}
 }
 
+func TestDistributor_MetricsMetadataLimits(t *testing.T) {
+	const (
+		numMetrics          = 10
+		numHelpsPerMetric   = 3
+		totalMetadataPushed = numMetrics * numHelpsPerMetric
+	)
+
+	tests := map[string]struct {
+		limit, limitPerMetric     int
+		expectedMetrics           int
+		expectedMetadataPerMetric int
+	}{
+		"unlimited": {
+			expectedMetrics:           numMetrics,
+			expectedMetadataPerMetric: numHelpsPerMetric,
+		},
+		"negative limits mean unlimited": {
+			limit:                     -1,
+			limitPerMetric:            -1,
+			expectedMetrics:           numMetrics,
+			expectedMetadataPerMetric: numHelpsPerMetric,
+		},
+		"limit": {
+			limit:                     4,
+			expectedMetrics:           4,
+			expectedMetadataPerMetric: numHelpsPerMetric,
+		},
+		"limit per metric": {
+			limitPerMetric:            2,
+			expectedMetrics:           numMetrics,
+			expectedMetadataPerMetric: 2,
+		},
+		"limit and limit per metric": {
+			limit:                     4,
+			limitPerMetric:            1,
+			expectedMetrics:           4,
+			expectedMetadataPerMetric: 1,
+		},
+	}
+
+	// Create distributor
+	ds, _, _, _ := prepare(t, prepConfig{
+		numIngesters:     3,
+		happyIngesters:   3,
+		numDistributors:  1,
+		shardByAllLabels: true,
+	})
+
+	// Push metadata, with different helps for the same metric.
+	ctx := user.InjectOrgID(context.Background(), "test")
+
+	req := &cortexpb.WriteRequest{}
+	for i := 0; i < numMetrics; i++ {
+		for j := 0; j < numHelpsPerMetric; j++ {
+			req.Metadata = append(req.Metadata, &cortexpb.MetricMetadata{
+				MetricFamilyName: fmt.Sprintf("metric_%d", i),
+				Type:             cortexpb.COUNTER,
+				Help:             fmt.Sprintf("help %d for metric_%d", j, i),
+			})
+		}
+	}
+	_, err := ds[0].Push(ctx, req)
+	require.NoError(t, err)
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			metadata, err := ds[0].MetricsMetadata(ctx, testData.limit, testData.limitPerMetric)
+			require.NoError(t, err)
+
+			metadataPerMetric := map[string]int{}
+			for _, m := range metadata {
+				metadataPerMetric[m.Metric]++
+			}
+
+			assert.Len(t, metadataPerMetric, testData.expectedMetrics)
+			for metric, count := range metadataPerMetric {
+				assert.Equal(t, testData.expectedMetadataPerMetric, count, metric)
+			}
+			assert.LessOrEqual(t, len(metadata), totalMetadataPushed)
+		})
+	}
+}
+
 func mustNewMatcher(t labels.MatchType, n, v string) *labels.Matcher {
 	m, err := labels.NewMatcher(t, n, v)
 	if err != nil {
@@ -2235,6 +2639,7 @@ type prepConfig struct {
 	replicationFactor            int
 	enableTracker                bool
 	errFail                      error
+	maxIngestersPerQuery         int
 }
 
 func prepare(tb testing.TB, cfg prepConfig) ([]*Distributor, []*mockIngester, []*prometheus.Registry, *ring.Ring) {
@@ -2331,6 +2736,7 @@ func prepare(tb testing.TB, cfg prepConfig) ([]*Distributor, []*mockIngester, []
 		distributorCfg.SkipLabelNameValidation = cfg.skipLabelNameValidation
 		distributorCfg.InstanceLimits.MaxInflightPushRequests = cfg.maxInflightRequests
 		distributorCfg.InstanceLimits.MaxIngestionRate = cfg.maxIngestionRate
+		distributorCfg.MaxIngestersPerQuery = cfg.maxIngestersPerQuery
 
 		if cfg.shuffleShardEnabled {
 			distributorCfg.ShardingStrategy = util.ShardingStrategyShuffle
@@ -2743,6 +3149,77 @@ func (i *mockIngester) MetricsForLabelMatchers(ctx context.Context, req *client.
 	return &response, nil
 }
 
+func (i *mockIngester) LabelValues(ctx context.Context, req *client.LabelValuesRequest, opts ...grpc.CallOption) (*client.LabelValuesResponse, error) {
+	time.Sleep(i.queryDelay)
+	i.Lock()
+	defer i.Unlock()
+
+	i.trackCall("LabelValues")
+
+	if !i.happy.Load() {
+		return nil, errFail
+	}
+
+	return i.labelValues(req)
+}
+
+// mockLabelValuesStreamBatchSize is the number of label values sent by the mock ingester in each
+// message of the label values stream.
+const mockLabelValuesStreamBatchSize = 128
+
+func (i *mockIngester) LabelValuesStream(ctx context.Context, req *client.LabelValuesRequest, opts ...grpc.CallOption) (client.Ingester_LabelValuesStreamClient, error) {
+	time.Sleep(i.queryDelay)
+	i.Lock()
+	defer i.Unlock()
+
+	i.trackCall("LabelValuesStream")
+
+	if !i.happy.Load() {
+		return nil, errFail
+	}
+
+	resp, err := i.labelValues(req)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*client.LabelValuesStreamResponse{}
+	for j := 0; j < len(resp.LabelValues); j += mockLabelValuesStreamBatchSize {
+		k := j + mockLabelValuesStreamBatchSize
+		if k > len(resp.LabelValues) {
+			k = len(resp.LabelValues)
+		}
+		results = append(results, &client.LabelValuesStreamResponse{LabelValues: resp.LabelValues[j:k]})
+	}
+
+	return &labelValuesStream{ctx: ctx, results: results}, nil
+}
+
+func (i *mockIngester) labelValues(req *client.LabelValuesRequest) (*client.LabelValuesResponse, error) {
+	labelName, _, _, matchers, err := client.FromLabelValuesRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	valueSet := map[string]struct{}{}
+	for _, ts := range i.timeseries {
+		if !match(ts.Labels, matchers) {
+			continue
+		}
+		for _, l := range ts.Labels {
+			if l.Name == labelName {
+				valueSet[l.Value] = struct{}{}
+			}
+		}
+	}
+
+	response := client.LabelValuesResponse{}
+	for v := range valueSet {
+		response.LabelValues = append(response.LabelValues, v)
+	}
+	return &response, nil
+}
+
 func (i *mockIngester) MetricsMetadata(ctx context.Context, req *client.MetricsMetadataRequest, opts ...grpc.CallOption) (*client.MetricsMetadataResponse, error) {
 	i.Lock()
 	defer i.Unlock()
@@ -2756,6 +3233,7 @@ func (i *mockIngester) MetricsMetadata(ctx context.Context, req *client.MetricsM
 	resp := &client.MetricsMetadataResponse{}
 	for _, sets := range i.metadata {
 		for m := range sets {
+			m := m
 			resp.Metadata = append(resp.Metadata, &m)
 		}
 	}
@@ -2811,6 +3289,30 @@ func (s *queryStream) Recv() (*client.QueryStreamResponse, error) {
 	return result, nil
 }
 
+type labelValuesStream struct {
+	grpc.ClientStream
+	ctx     context.Context
+	i       int
+	results []*client.LabelValuesStreamResponse
+}
+
+func (*labelValuesStream) CloseSend() error {
+	return nil
+}
+
+func (s *labelValuesStream) Recv() (*client.LabelValuesStreamResponse, error) {
+	// Like a real stream, stop receiving once canceled.
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+	if s.i >= len(s.results) {
+		return nil, io.EOF
+	}
+	result := s.results[s.i]
+	s.i++
+	return result, nil
+}
+
 type metricsForLabelMatchersStream struct {
 	grpc.ClientStream
 	i       int
@@ -2,6 +2,7 @@ package distributor
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"sort"
 	"time"
@@ -23,6 +24,8 @@ import (
 	"github.com/cortexproject/cortex/pkg/util/validation"
 )
 
+const errMaxIngestersPerQuery = "the query would fan out to %d ingesters, exceeding the max number of ingesters per query limit (limit: %d ingesters)"
+
 // Query multiple ingesters and returns a Matrix of samples.
 func (d *Distributor) Query(ctx context.Context, from, to model.Time, matchers ...*labels.Matcher) (model.Matrix, error) {
 	var matrix model.Matrix
@@ -105,8 +108,22 @@ func (d *Distributor) QueryStream(ctx context.Context, from, to model.Time, matc
 }
 
 // GetIngestersForQuery returns a replication set including all ingesters that should be queried
-// to fetch series matching input label matchers.
+// to fetch series matching input label matchers. An error is returned if the query would fan out
+// to more ingesters than allowed.
 func (d *Distributor) GetIngestersForQuery(ctx context.Context, matchers ...*labels.Matcher) (ring.ReplicationSet, error) {
+	replicationSet, err := d.getIngestersForQuery(ctx, matchers...)
+	if err != nil {
+		return ring.ReplicationSet{}, err
+	}
+
+	if limit := d.cfg.MaxIngestersPerQuery; limit > 0 && len(replicationSet.Instances) > limit {
+		return ring.ReplicationSet{}, validation.LimitError(fmt.Sprintf(errMaxIngestersPerQuery, len(replicationSet.Instances), limit))
+	}
+
+	return replicationSet, nil
+}
+
+func (d *Distributor) getIngestersForQuery(ctx context.Context, matchers ...*labels.Matcher) (ring.ReplicationSet, error) {
 	userID, err := tenant.TenantID(ctx)
 	if err != nil {
 		return ring.ReplicationSet{}, err
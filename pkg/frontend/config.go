@@ -20,7 +20,11 @@ type CombinedFrontendConfig struct {
 	FrontendV1 v1.Config               `yaml:",inline"`
 	FrontendV2 v2.Config               `yaml:",inline"`
 
-	DownstreamURL string `yaml:"downstream_url"`
+	DownstreamURL        string `yaml:"downstream_url"`
+	DownstreamPathPrefix string `yaml:"downstream_path_prefix"`
+
+	// This config is dynamically injected because defined in the API config.
+	PrometheusHTTPPrefix string `yaml:"-"`
 }
 
 func (cfg *CombinedFrontendConfig) RegisterFlags(f *flag.FlagSet) {
@@ -29,6 +33,7 @@ func (cfg *CombinedFrontendConfig) RegisterFlags(f *flag.FlagSet) {
 	cfg.FrontendV2.RegisterFlags(f)
 
 	f.StringVar(&cfg.DownstreamURL, "frontend.downstream-url", "", "URL of downstream Prometheus.")
+	f.StringVar(&cfg.DownstreamPathPrefix, "frontend.downstream-path-prefix", "", "Path prefix of the Prometheus HTTP API of the downstream queriers. When set, the Prometheus HTTP API prefix of the requests received by the query-frontend is replaced with this prefix before forwarding them to queriers. Ignored if -frontend.downstream-url is set.")
 }
 
 // InitFrontend initializes frontend (either V1 -- without scheduler, or V2 -- with scheduler) or no frontend at
@@ -60,7 +65,7 @@ func InitFrontend(cfg CombinedFrontendConfig, limits v1.Limits, grpcListenPort i
 		}
 
 		fr, err := v2.NewFrontend(cfg.FrontendV2, log, reg)
-		return transport.AdaptGrpcRoundTripperToHTTPRoundTripper(fr), nil, fr, err
+		return cfg.wrapDownstreamPathPrefix(transport.AdaptGrpcRoundTripperToHTTPRoundTripper(fr)), nil, fr, err
 
 	default:
 		// No scheduler = use original frontend.
@@ -68,6 +73,13 @@ func InitFrontend(cfg CombinedFrontendConfig, limits v1.Limits, grpcListenPort i
 		if err != nil {
 			return nil, nil, nil, err
 		}
-		return transport.AdaptGrpcRoundTripperToHTTPRoundTripper(fr), fr, nil, nil
+		return cfg.wrapDownstreamPathPrefix(transport.AdaptGrpcRoundTripperToHTTPRoundTripper(fr)), fr, nil, nil
+	}
+}
+
+func (cfg CombinedFrontendConfig) wrapDownstreamPathPrefix(rt http.RoundTripper) http.RoundTripper {
+	if cfg.DownstreamPathPrefix == "" {
+		return rt
 	}
+	return newPathPrefixRoundTripper(cfg.PrometheusHTTPPrefix, cfg.DownstreamPathPrefix, rt)
 }
@@ -233,6 +233,45 @@ func TestFrontend_ReturnsRequestBodyTooLargeError(t *testing.T) {
 	testFrontend(t, config, nil, test, false, nil)
 }
 
+func TestFrontend_ForwardsRequestsToDownstreamPathPrefix(t *testing.T) {
+	observedURLs := make(chan string, 2)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		observedURLs <- r.URL.String()
+
+		_, err := w.Write([]byte(responseBody))
+		require.NoError(t, err)
+	})
+
+	config := defaultFrontendConfig()
+	config.PrometheusHTTPPrefix = "/prometheus"
+	config.DownstreamPathPrefix = "/internal/prometheus"
+
+	test := func(addr string) {
+		for _, tc := range []struct {
+			path        string
+			expectedURL string
+		}{
+			{path: "/prometheus" + query, expectedURL: "/internal/prometheus" + query},
+			// Requests outside of the Prometheus HTTP prefix are forwarded as is.
+			{path: "/api/prom" + query, expectedURL: "/api/prom" + query},
+		} {
+			req, err := http.NewRequest("GET", fmt.Sprintf("http://%s%s", addr, tc.path), nil)
+			require.NoError(t, err)
+			err = user.InjectOrgIDIntoHTTPRequest(user.InjectOrgID(context.Background(), "1"), req)
+			require.NoError(t, err)
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			require.Equal(t, 200, resp.StatusCode)
+			require.NoError(t, resp.Body.Close())
+
+			assert.Equal(t, tc.expectedURL, <-observedURLs)
+		}
+	}
+
+	testFrontend(t, config, handler, test, false, nil)
+}
+
 func testFrontend(t *testing.T, config CombinedFrontendConfig, handler http.Handler, test func(addr string), matchMaxConcurrency bool, l log.Logger) {
 	logger := log.NewNopLogger()
 	if l != nil {
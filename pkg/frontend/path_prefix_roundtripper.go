@@ -0,0 +1,34 @@
+package frontend
+
+import (
+	"net/http"
+	"strings"
+)
+
+// RoundTripper that replaces the path prefix of the requests before forwarding them.
+type pathPrefixRoundTripper struct {
+	prefix           string
+	downstreamPrefix string
+	next             http.RoundTripper
+}
+
+func newPathPrefixRoundTripper(prefix, downstreamPrefix string, next http.RoundTripper) http.RoundTripper {
+	return &pathPrefixRoundTripper{
+		prefix:           strings.TrimSuffix(prefix, "/"),
+		downstreamPrefix: strings.TrimSuffix(downstreamPrefix, "/"),
+		next:             next,
+	}
+}
+
+func (p pathPrefixRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.URL.Path != p.prefix && !strings.HasPrefix(r.URL.Path, p.prefix+"/") {
+		return p.next.RoundTrip(r)
+	}
+
+	// Requests are forwarded to queriers using the RequestURI, so it must be rewritten too.
+	r = r.Clone(r.Context())
+	r.URL.Path = p.downstreamPrefix + strings.TrimPrefix(r.URL.Path, p.prefix)
+	r.URL.RawPath = ""
+	r.RequestURI = r.URL.RequestURI()
+	return p.next.RoundTrip(r)
+}
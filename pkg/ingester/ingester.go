@@ -26,6 +26,7 @@ import (
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/tsdb"
 	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/wal"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
 	"github.com/thanos-io/thanos/pkg/objstore"
 	"github.com/thanos-io/thanos/pkg/shipper"
@@ -860,6 +861,69 @@ func (i *Ingester) ShutdownHandler(w http.ResponseWriter, _ *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// RingReregisterHandler forces the ingester to heartbeat the ring, re-registering
+// itself with its current tokens if it has been forgotten. Mainly used for testing
+// ring transitions.
+func (i *Ingester) RingReregisterHandler(w http.ResponseWriter, r *http.Request) {
+	if err := i.lifecycler.Heartbeat(r.Context()); err != nil {
+		level.Error(i.logger).Log("msg", "failed to re-register the ingester in the ring", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// IngesterStats holds the in-memory series and WAL statistics of an ingester,
+// summed across all the tenants.
+type IngesterStats struct {
+	HeadSeries   uint64 `json:"headSeries"`
+	ActiveSeries int    `json:"activeSeries"`
+	WALSegments  int    `json:"walSegments"`
+}
+
+// StatsHandler returns the ingester in-memory series and WAL statistics in JSON format.
+// Mainly used for testing and debugging.
+func (i *Ingester) StatsHandler(w http.ResponseWriter, _ *http.Request) {
+	stats := IngesterStats{}
+
+	for _, userID := range i.getTSDBUsers() {
+		db := i.getTSDB(userID)
+		if db == nil {
+			continue
+		}
+
+		stats.HeadSeries += db.Head().NumSeries()
+		stats.ActiveSeries += db.activeSeries.Active()
+
+		segments, err := walSegmentsCount(filepath.Join(db.db.Dir(), "wal"))
+		if err != nil {
+			level.Error(i.logger).Log("msg", "failed to count the WAL segments", "user", userID, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		stats.WALSegments += segments
+	}
+
+	util.WriteJSONResponse(w, stats)
+}
+
+// walSegmentsCount returns the number of segments in the input WAL directory,
+// or 0 if the WAL is disabled.
+func walSegmentsCount(dir string) (int, error) {
+	first, last, err := wal.Segments(dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if first < 0 {
+		return 0, nil
+	}
+	return last - first + 1, nil
+}
+
 // check that ingester has finished starting, i.e. it is in Running or Stopping state.
 // Why Stopping? Because ingester still runs, even when it is transferring data out in Stopping state.
 // Ingester handles this state on its own (via `stopped` flag).
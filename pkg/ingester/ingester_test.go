@@ -3,6 +3,7 @@ package ingester
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -4155,3 +4156,35 @@ func generateSamplesForLabel(l labels.Labels, count int) *cortexpb.WriteRequest
 
 	return cortexpb.ToWriteRequest(lbls, samples, nil, cortexpb.API)
 }
+
+func TestIngester_StatsHandler(t *testing.T) {
+	i, err := prepareIngesterWithBlocksStorage(t, defaultIngesterTestConfig(t), nil)
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), i))
+	defer services.StopAndAwaitTerminated(context.Background(), i) //nolint:errcheck
+
+	// Wait until it's ACTIVE.
+	test.Poll(t, 1*time.Second, ring.ACTIVE, func() interface{} {
+		return i.lifecycler.GetState()
+	})
+
+	getStats := func() IngesterStats {
+		resp := httptest.NewRecorder()
+		i.StatsHandler(resp, httptest.NewRequest("GET", "/ingester/stats", nil))
+		require.Equal(t, http.StatusOK, resp.Code)
+
+		stats := IngesterStats{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &stats))
+		return stats
+	}
+
+	assert.Equal(t, IngesterStats{}, getStats())
+
+	pushSingleSampleWithMetadata(t, i)
+	pushSingleSampleAtTime(t, i, util.TimeToMillis(time.Now()))
+
+	stats := getStats()
+	assert.Equal(t, uint64(1), stats.HeadSeries)
+	assert.Equal(t, 1, stats.ActiveSeries)
+	assert.Equal(t, 1, stats.WALSegments)
+}
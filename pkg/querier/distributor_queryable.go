@@ -2,26 +2,52 @@ package querier
 
 import (
 	"context"
+	"fmt"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/go-kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/model/exemplar"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/scrape"
 	"github.com/prometheus/prometheus/storage"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/cortexproject/cortex/pkg/cortexpb"
 	"github.com/cortexproject/cortex/pkg/ingester/client"
 	"github.com/cortexproject/cortex/pkg/prom1/storage/metric"
 	"github.com/cortexproject/cortex/pkg/querier/series"
+	"github.com/cortexproject/cortex/pkg/tenant"
 	"github.com/cortexproject/cortex/pkg/util"
 	"github.com/cortexproject/cortex/pkg/util/chunkcompat"
+	"github.com/cortexproject/cortex/pkg/util/concurrency"
 	"github.com/cortexproject/cortex/pkg/util/math"
 	"github.com/cortexproject/cortex/pkg/util/spanlogger"
+	"github.com/cortexproject/cortex/pkg/util/validation"
 )
 
+const (
+	errMaxChunksPerSeries        = "the query hit the max number of chunks per series limit (series: %s, limit: %d chunks)"
+	errSeriesWithoutMatchers     = "the series request would select all the series of the tenant, a more specific matcher is required (matchers: %s)"
+	errTenantInMaintenance       = "the tenant %s is in maintenance, queries are temporarily unavailable"
+	errInvalidExemplarsTimeRange = "invalid exemplars query time range: start time %s is after end time %s"
+	errIngesterCallTimeout       = "the call to ingesters exceeded the timeout of %s: %w"
+	errMaxLabelValues            = "the query hit the max number of label values limit: limit of %d label values exceeded"
+
+	warnIngesterStorageBoundary = "query spans ingester/storage boundary at time %s, results combine data from both sources"
+	warnMaxSeriesPerLabelNames  = "the label names query hit the max number of series examined (limit: %d series), results may be incomplete"
+	warnMaxLabelNames           = "the label names query hit the max number of label names (limit: %d label names), results may be incomplete"
+)
+
+// chunkSeriesContextCheckInterval is the number of chunk series decoded between two checks
+// of the query context, to stop decoding the chunks of a query which has been canceled.
+const chunkSeriesContextCheckInterval = 128
+
 // Distributor is the read interface to the distributor, made an interface here
 // to reduce package coupling.
 type Distributor interface {
@@ -30,65 +56,185 @@ type Distributor interface {
 	QueryExemplars(ctx context.Context, from, to model.Time, matchers ...[]*labels.Matcher) (*client.ExemplarQueryResponse, error)
 	LabelValuesForLabelName(ctx context.Context, from, to model.Time, label model.LabelName, matchers ...*labels.Matcher) ([]string, error)
 	LabelValuesForLabelNameStream(ctx context.Context, from, to model.Time, label model.LabelName, matchers ...*labels.Matcher) ([]string, error)
+	LabelValuesForLabelNameIterator(ctx context.Context, from, to model.Time, label model.LabelName, f func(values []string) bool, matchers ...*labels.Matcher) error
 	LabelNames(context.Context, model.Time, model.Time) ([]string, error)
 	LabelNamesStream(context.Context, model.Time, model.Time) ([]string, error)
 	MetricsForLabelMatchers(ctx context.Context, from, through model.Time, matchers ...*labels.Matcher) ([]metric.Metric, error)
 	MetricsForLabelMatchersStream(ctx context.Context, from, through model.Time, matchers ...*labels.Matcher) ([]metric.Metric, error)
-	MetricsMetadata(ctx context.Context) ([]scrape.MetricMetadata, error)
+	MetricsForLabelMatchersIterator(ctx context.Context, from, through model.Time, f func(metrics []metric.Metric) bool, matchers ...*labels.Matcher) error
+	MetricsMetadata(ctx context.Context, limit, limitPerMetric int) ([]scrape.MetricMetadata, error)
 }
 
-func newDistributorQueryable(distributor Distributor, streaming bool, streamingMetdata bool, iteratorFn chunkIteratorFunc, queryIngestersWithin time.Duration) QueryableWithFilter {
+func newDistributorQueryable(distributor Distributor, cfg Config, iteratorFn chunkIteratorFunc, limits *validation.Overrides, reg prometheus.Registerer) QueryableWithFilter {
 	return distributorQueryable{
-		distributor:          distributor,
-		streaming:            streaming,
-		streamingMetdata:     streamingMetdata,
-		iteratorFn:           iteratorFn,
-		queryIngestersWithin: queryIngestersWithin,
+		distributor:                   distributor,
+		limits:                        limits,
+		streaming:                     cfg.IngesterStreaming,
+		streamingMetdata:              cfg.IngesterMetadataStreaming,
+		iteratorFn:                    iteratorFn,
+		queryIngestersWithin:          cfg.QueryIngestersWithin,
+		querySplitInterval:            cfg.IngesterQuerySplitInterval,
+		ingesterCallTimeout:           cfg.IngesterCallTimeout,
+		ingesterCallTimeoutAsWarning:  cfg.IngesterCallTimeoutAsWarning,
+		haDedup:                       cfg.HADedupEnabled,
+		queryRangeInErrors:            cfg.QueryRangeInErrors,
+		rejectSeriesWithoutMatchers:   cfg.RejectSeriesWithoutMatchers,
+		boundaryWarning:               cfg.IngesterStorageBoundaryWarning,
+		skipStaleOnlySeries:           cfg.IngesterSkipStaleOnlySeries,
+		maxConcurrentMetadataRequests: cfg.MaxConcurrentMetadataRequestsPerQuery,
+		maxLabelValues:                cfg.MaxLabelValues,
+		chunkSeriesDecodeConcurrency:  cfg.IngesterChunkSeriesDecodeConcurrency,
+		metrics:                       newDistributorQueryableMetrics(reg),
+	}
+}
+
+type distributorQueryableMetrics struct {
+	seriesMetadataRequests  prometheus.Counter
+	seriesMetadataCacheHits prometheus.Counter
+
+	metadataRequestsInflight prometheus.Gauge
+	metadataRequestsQueued   prometheus.Counter
+
+	queriesSkippedIngesters prometheus.Counter
+}
+
+func newDistributorQueryableMetrics(reg prometheus.Registerer) *distributorQueryableMetrics {
+	return &distributorQueryableMetrics{
+		seriesMetadataRequests: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_querier_series_metadata_requests_total",
+			Help: "Total number of series metadata requested to ingesters by the querier, including the ones served by the request-scoped cache.",
+		}),
+		seriesMetadataCacheHits: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_querier_series_metadata_cache_hits_total",
+			Help: "Total number of series metadata requests served by the request-scoped cache instead of querying ingesters.",
+		}),
+		metadataRequestsInflight: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "cortex_querier_ingester_metadata_requests_inflight",
+			Help: "Current number of in-flight series, label names and label values requests to ingesters.",
+		}),
+		metadataRequestsQueued: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_querier_ingester_metadata_requests_queued_total",
+			Help: "Total number of series, label names and label values requests to ingesters queued because of the max concurrent metadata requests per query limit.",
+		}),
+		queriesSkippedIngesters: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cortex_querier_queries_skipped_ingesters_total",
+			Help: "Total number of queries not sent to ingesters because their time range is older than the query ingesters within period, so they are fully served by the long-term storage.",
+		}),
 	}
 }
 
 type distributorQueryable struct {
-	distributor          Distributor
-	streaming            bool
-	streamingMetdata     bool
-	iteratorFn           chunkIteratorFunc
-	queryIngestersWithin time.Duration
+	distributor                   Distributor
+	limits                        *validation.Overrides
+	streaming                     bool
+	streamingMetdata              bool
+	iteratorFn                    chunkIteratorFunc
+	queryIngestersWithin          time.Duration
+	querySplitInterval            time.Duration
+	ingesterCallTimeout           time.Duration
+	ingesterCallTimeoutAsWarning  bool
+	haDedup                       bool
+	queryRangeInErrors            bool
+	rejectSeriesWithoutMatchers   bool
+	boundaryWarning               bool
+	skipStaleOnlySeries           bool
+	maxConcurrentMetadataRequests int
+	chunkSeriesDecodeConcurrency  int
+	maxLabelValues                int
+	metrics                       *distributorQueryableMetrics
 }
 
 func (d distributorQueryable) Querier(ctx context.Context, mint, maxt int64) (storage.Querier, error) {
+	var metadataRequestsSem chan struct{}
+	if d.maxConcurrentMetadataRequests > 0 {
+		metadataRequestsSem = make(chan struct{}, d.maxConcurrentMetadataRequests)
+	}
+
 	return &distributorQuerier{
-		distributor:          d.distributor,
-		ctx:                  ctx,
-		mint:                 mint,
-		maxt:                 maxt,
-		streaming:            d.streaming,
-		streamingMetadata:    d.streamingMetdata,
-		chunkIterFn:          d.iteratorFn,
-		queryIngestersWithin: d.queryIngestersWithin,
+		distributor:                  d.distributor,
+		limits:                       d.limits,
+		ctx:                          ctx,
+		mint:                         mint,
+		maxt:                         maxt,
+		streaming:                    d.streaming,
+		streamingMetadata:            d.streamingMetdata,
+		chunkIterFn:                  d.iteratorFn,
+		queryIngestersWithin:         d.queryIngestersWithin,
+		querySplitInterval:           d.querySplitInterval,
+		ingesterCallTimeout:          d.ingesterCallTimeout,
+		ingesterCallTimeoutAsWarning: d.ingesterCallTimeoutAsWarning,
+		haDedup:                      d.haDedup,
+		queryRangeInErrors:           d.queryRangeInErrors,
+		rejectSeriesWithoutMatchers:  d.rejectSeriesWithoutMatchers,
+		boundaryWarning:              d.boundaryWarning,
+		skipStaleOnlySeries:          d.skipStaleOnlySeries,
+		chunkSeriesDecodeConcurrency: d.chunkSeriesDecodeConcurrency,
+		maxLabelValues:               d.maxLabelValues,
+		metrics:                      d.metrics,
+		seriesMetadataCache:          map[string][]metric.Metric{},
+		metadataRequestsSem:          metadataRequestsSem,
 	}, nil
 }
 
-func (d distributorQueryable) UseQueryable(now time.Time, _, queryMaxT int64) bool {
+func (d distributorQueryable) UseQueryable(now time.Time, queryMinT, queryMaxT int64) bool {
+	return d.useQueryableWithContext(context.Background(), now, queryMinT, queryMaxT)
+}
+
+func (d distributorQueryable) useQueryableWithContext(ctx context.Context, now time.Time, _, queryMaxT int64) bool {
 	// Include ingester only if maxt is within QueryIngestersWithin w.r.t. current time.
-	return d.queryIngestersWithin == 0 || queryMaxT >= util.TimeToMillis(now.Add(-d.queryIngestersWithin))
+	queryIngestersWithin := queryIngestersWithinFromContext(ctx, d.queryIngestersWithin)
+	return queryIngestersWithin == 0 || queryMaxT >= util.TimeToMillis(now.Add(-queryIngestersWithin))
 }
 
 type distributorQuerier struct {
-	distributor          Distributor
-	ctx                  context.Context
-	mint, maxt           int64
-	streaming            bool
-	streamingMetadata    bool
-	chunkIterFn          chunkIteratorFunc
-	queryIngestersWithin time.Duration
+	distributor                  Distributor
+	limits                       *validation.Overrides
+	ctx                          context.Context
+	mint, maxt                   int64
+	streaming                    bool
+	streamingMetadata            bool
+	chunkIterFn                  chunkIteratorFunc
+	queryIngestersWithin         time.Duration
+	querySplitInterval           time.Duration
+	ingesterCallTimeout          time.Duration
+	ingesterCallTimeoutAsWarning bool
+	haDedup                      bool
+	queryRangeInErrors           bool
+	rejectSeriesWithoutMatchers  bool
+	boundaryWarning              bool
+	skipStaleOnlySeries          bool
+	chunkSeriesDecodeConcurrency int
+	maxLabelValues               int
+	metrics                      *distributorQueryableMetrics
+
+	// The querier is created for a single query, so the series metadata fetched from
+	// ingesters can be reused by the following Select calls with the same matchers.
+	seriesMetadataCacheMtx sync.Mutex
+	seriesMetadataCache    map[string][]metric.Metric
+
+	// Bounds the concurrent metadata requests to ingesters issued by the query, nil if unlimited.
+	metadataRequestsSem chan struct{}
 }
 
 // Select implements storage.Querier interface.
-// The bool passed is ignored because the series is always sorted.
-func (q *distributorQuerier) Select(_ bool, sp *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+// The series are sorted unless sortSeries is false and the series are queried from the ingesters
+// without streaming. The streaming path always sorts them, since it needs to merge several sets.
+func (q *distributorQuerier) Select(sortSeries bool, sp *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
 	log, ctx := spanlogger.New(q.ctx, "distributorQuerier.Select")
 	defer log.Span.Finish()
 
+	// Fail the query with a clear error while the tenant is in maintenance,
+	// rather than returning partial data.
+	if q.limits != nil {
+		userID, err := tenant.TenantID(ctx)
+		if err != nil {
+			return storage.ErrSeriesSet(err)
+		}
+		if q.limits.QueryMaintenanceMode(userID) {
+			return storage.ErrSeriesSet(validation.LimitError(fmt.Sprintf(errTenantInMaintenance, userID)))
+		}
+	}
+
 	minT, maxT := q.mint, q.maxt
 	if sp != nil {
 		minT, maxT = sp.Start, sp.End
@@ -102,31 +248,32 @@ func (q *distributorQuerier) Select(_ bool, sp *storage.SelectHints, matchers ..
 	// Also, in the recent versions of Prometheus, we pass in the hint but with Func set to "series".
 	// See: https://github.com/prometheus/prometheus/pull/8050
 	if sp != nil && sp.Func == "series" {
-		var (
-			ms  []metric.Metric
-			err error
-		)
-
-		if q.streamingMetadata {
-			ms, err = q.distributor.MetricsForLabelMatchersStream(ctx, model.Time(q.mint), model.Time(q.maxt), matchers...)
-		} else {
-			ms, err = q.distributor.MetricsForLabelMatchers(ctx, model.Time(q.mint), model.Time(q.maxt), matchers...)
+		if q.rejectSeriesWithoutMatchers && isMatchAll(matchers) {
+			return storage.ErrSeriesSet(validation.LimitError(fmt.Sprintf(errSeriesWithoutMatchers, util.LabelMatchersToString(matchers))))
 		}
 
+		ms, warnings, err := q.seriesMetadata(ctx, matchers)
 		if err != nil {
-			return storage.ErrSeriesSet(err)
+			return storage.ErrSeriesSet(q.annotateErr(err, q.mint, q.maxt))
+		}
+
+		set := series.MetricsToSeriesSet(ms)
+
+		if len(warnings) > 0 {
+			return series.NewSeriesSetWithWarnings(set, warnings)
 		}
-		return series.MetricsToSeriesSet(ms)
+		return set
 	}
 
 	// If queryIngestersWithin is enabled, we do manipulate the query mint to query samples up until
 	// now - queryIngestersWithin, because older time ranges are covered by the storage. This
 	// optimization is particularly important for the blocks storage where the blocks retention in the
 	// ingesters could be way higher than queryIngestersWithin.
-	if q.queryIngestersWithin > 0 {
+	var warnings storage.Warnings
+	if queryIngestersWithin := queryIngestersWithinFromContext(ctx, q.queryIngestersWithin); queryIngestersWithin > 0 {
 		now := time.Now()
 		origMinT := minT
-		minT = math.Max64(minT, util.TimeToMillis(now.Add(-q.queryIngestersWithin)))
+		minT = math.Max64(minT, util.TimeToMillis(now.Add(-queryIngestersWithin)))
 
 		if origMinT != minT {
 			level.Debug(log).Log("msg", "the min time of the query to ingesters has been manipulated", "original", origMinT, "updated", minT)
@@ -134,27 +281,230 @@ func (q *distributorQuerier) Select(_ bool, sp *storage.SelectHints, matchers ..
 
 		if minT > maxT {
 			level.Debug(log).Log("msg", "empty query time range after min time manipulation")
+			q.metrics.queriesSkippedIngesters.Inc()
 			return storage.EmptySeriesSet()
 		}
+
+		// The older part of the time range is queried from the storage, so the results
+		// are stitched together from both sources.
+		if q.boundaryWarning && origMinT != minT {
+			warnings = append(warnings, fmt.Errorf(warnIngesterStorageBoundary, util.TimeFromMillis(minT).UTC().Format(time.RFC3339Nano)))
+		}
 	}
 
+	var (
+		set           storage.SeriesSet
+		fetchedSeries int
+	)
 	if q.streaming {
-		return q.streamingSelect(ctx, minT, maxT, matchers)
+		set, fetchedSeries = q.streamingSelect(ctx, minT, maxT, matchers)
+		set = q.annotateSeriesSetErr(set, minT, maxT)
+	} else {
+		callCtx, cancel := q.ingesterCallContext(ctx)
+		matrix, err := q.distributor.Query(callCtx, model.Time(minT), model.Time(maxT), matchers...)
+		cancel()
+
+		callWarnings, err := q.ingesterCallErr(ctx, callCtx, err)
+		if err != nil {
+			return storage.ErrSeriesSet(q.annotateErr(err, minT, maxT))
+		}
+		warnings = append(warnings, callWarnings...)
+		fetchedSeries = len(matrix)
+
+		// Using MatrixToSeriesSet (and in turn NewConcreteSeriesSet), sorts the series,
+		// which is skipped if the caller doesn't need them sorted.
+		if sortSeries {
+			set = series.MatrixToSeriesSet(matrix)
+		} else {
+			set = series.MatrixToUnsortedSeriesSet(matrix)
+		}
 	}
 
-	matrix, err := q.distributor.Query(ctx, model.Time(minT), model.Time(maxT), matchers...)
+	log.Span.LogKV("min_time", minT, "max_time", maxT, "streaming", q.streaming, "series", fetchedSeries)
+
+	if len(warnings) > 0 {
+		return series.NewSeriesSetWithWarnings(set, warnings)
+	}
+	return set
+}
+
+// seriesMetadata returns the series matching the input matchers in the querier time range,
+// fetching them from ingesters only the first time they're requested by this querier.
+func (q *distributorQuerier) seriesMetadata(ctx context.Context, matchers []*labels.Matcher) ([]metric.Metric, storage.Warnings, error) {
+	key := fmt.Sprintf("%d:%d:%s", q.mint, q.maxt, util.LabelMatchersToString(matchers))
+
+	q.metrics.seriesMetadataRequests.Inc()
+
+	q.seriesMetadataCacheMtx.Lock()
+	ms, ok := q.seriesMetadataCache[key]
+	q.seriesMetadataCacheMtx.Unlock()
+
+	if ok {
+		q.metrics.seriesMetadataCacheHits.Inc()
+		return ms, nil, nil
+	}
+
+	release, err := q.acquireMetadataRequestSlot(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	callCtx, cancel := q.ingesterCallContext(ctx)
+	if q.streamingMetadata {
+		ms, err = q.distributor.MetricsForLabelMatchersStream(callCtx, model.Time(q.mint), model.Time(q.maxt), matchers...)
+	} else {
+		ms, err = q.distributor.MetricsForLabelMatchers(callCtx, model.Time(q.mint), model.Time(q.maxt), matchers...)
+	}
+	cancel()
+	release()
+
+	// The empty results of a timed out call are not cached, so that the following
+	// Select calls can try again.
 	if err != nil {
-		return storage.ErrSeriesSet(err)
+		warnings, err := q.ingesterCallErr(ctx, callCtx, err)
+		return nil, warnings, err
 	}
 
-	// Using MatrixToSeriesSet (and in turn NewConcreteSeriesSet), sorts the series.
-	return series.MatrixToSeriesSet(matrix)
+	q.seriesMetadataCacheMtx.Lock()
+	q.seriesMetadataCache[key] = ms
+	q.seriesMetadataCacheMtx.Unlock()
+
+	return ms, nil, nil
 }
 
-func (q *distributorQuerier) streamingSelect(ctx context.Context, minT, maxT int64, matchers []*labels.Matcher) storage.SeriesSet {
-	results, err := q.distributor.QueryStream(ctx, model.Time(minT), model.Time(maxT), matchers...)
+// acquireMetadataRequestSlot waits until the query is allowed to issue another metadata
+// request to ingesters, and returns the function to call once the request is done.
+func (q *distributorQuerier) acquireMetadataRequestSlot(ctx context.Context) (func(), error) {
+	if q.metadataRequestsSem != nil {
+		select {
+		case q.metadataRequestsSem <- struct{}{}:
+		default:
+			q.metrics.metadataRequestsQueued.Inc()
+
+			select {
+			case q.metadataRequestsSem <- struct{}{}:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	}
+
+	q.metrics.metadataRequestsInflight.Inc()
+	return func() {
+		q.metrics.metadataRequestsInflight.Dec()
+		if q.metadataRequestsSem != nil {
+			<-q.metadataRequestsSem
+		}
+	}, nil
+}
+
+// streamingSelect queries the series from ingesters with the streaming RPCs, and returns them
+// along with the number of series received. The series received by multiple sub-ranges of a
+// split query are counted once per sub-range.
+func (q *distributorQuerier) streamingSelect(ctx context.Context, minT, maxT int64, matchers []*labels.Matcher) (storage.SeriesSet, int) {
+	userID, err := tenant.TenantID(ctx)
 	if err != nil {
-		return storage.ErrSeriesSet(err)
+		return storage.ErrSeriesSet(err), 0
+	}
+
+	var (
+		set           storage.SeriesSet
+		fetchedSeries int
+	)
+	if q.querySplitInterval > 0 && maxT-minT > q.querySplitInterval.Milliseconds() {
+		set, fetchedSeries = q.splitStreamingSelect(ctx, userID, minT, maxT, matchers)
+	} else {
+		callCtx, cancel := q.ingesterCallContext(ctx)
+		results, err := q.distributor.QueryStream(callCtx, model.Time(minT), model.Time(maxT), matchers...)
+		cancel()
+
+		if err != nil {
+			warnings, err := q.ingesterCallErr(ctx, callCtx, err)
+			if err != nil {
+				return storage.ErrSeriesSet(err), 0
+			}
+			return series.NewSeriesSetWithWarnings(storage.EmptySeriesSet(), warnings), 0
+		}
+
+		set = q.queryStreamResponseToSeriesSet(ctx, userID, results, minT, maxT)
+		fetchedSeries = len(results.Timeseries) + len(results.Chunkseries)
+	}
+
+	if q.haDedup && q.limits != nil {
+		set = dedupHAReplicas(set, q.limits.HAClusterLabel(userID), q.limits.HAReplicaLabel(userID))
+	}
+
+	// Filtered once the series received as timeseries and chunks are merged, since a series
+	// may only have stale markers in one of them.
+	if q.skipStaleOnlySeries {
+		set = newStaleOnlySeriesFilter(set, minT, maxT)
+	}
+
+	return set, fetchedSeries
+}
+
+// splitStreamingSelect splits the [minT, maxT] time range into sub-ranges of querySplitInterval,
+// queries them concurrently and merges the results.
+func (q *distributorQuerier) splitStreamingSelect(ctx context.Context, userID string, minT, maxT int64, matchers []*labels.Matcher) (storage.SeriesSet, int) {
+	ranges := splitTimeRange(minT, maxT, q.querySplitInterval.Milliseconds())
+	sets := make([]storage.SeriesSet, len(ranges))
+	fetchedSeries := make([]int, len(ranges))
+
+	g, gCtx := errgroup.WithContext(ctx)
+	for i, r := range ranges {
+		// Need to reassign as the original variables will change and can't be relied on in a goroutine.
+		i, r := i, r
+		g.Go(func() error {
+			callCtx, cancel := q.ingesterCallContext(gCtx)
+			results, err := q.distributor.QueryStream(callCtx, model.Time(r[0]), model.Time(r[1]), matchers...)
+			cancel()
+
+			if err != nil {
+				warnings, err := q.ingesterCallErr(gCtx, callCtx, err)
+				if err != nil {
+					return err
+				}
+				sets[i] = series.NewSeriesSetWithWarnings(storage.EmptySeriesSet(), warnings)
+				return nil
+			}
+
+			sets[i] = q.queryStreamResponseToSeriesSet(gCtx, userID, results, r[0], r[1])
+			fetchedSeries[i] = len(results.Timeseries) + len(results.Chunkseries)
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return storage.ErrSeriesSet(err), 0
+	}
+
+	total := 0
+	for _, n := range fetchedSeries {
+		total += n
+	}
+
+	// The same series may be returned by multiple sub-ranges (and chunks may overlap the
+	// sub-range boundaries), so we rely on the chained merge to stitch them back into a
+	// single series, deduplicating samples with the same timestamp.
+	return storage.NewMergeSeriesSet(sets, storage.ChainedSeriesMerge), total
+}
+
+// splitTimeRange splits the inclusive [minT, maxT] time range into consecutive, non
+// overlapping, inclusive sub-ranges whose length is at most interval.
+func splitTimeRange(minT, maxT, interval int64) [][2]int64 {
+	var ranges [][2]int64
+
+	for start := minT; start <= maxT; start += interval {
+		ranges = append(ranges, [2]int64{start, math.Min64(start+interval-1, maxT)})
+	}
+
+	return ranges
+}
+
+func (q *distributorQuerier) queryStreamResponseToSeriesSet(ctx context.Context, userID string, results *client.QueryStreamResponse, minT, maxT int64) storage.SeriesSet {
+	maxChunksPerSeries := 0
+	if q.limits != nil {
+		maxChunksPerSeries = q.limits.MaxChunksPerSeries(userID)
 	}
 
 	sets := []storage.SeriesSet(nil)
@@ -162,28 +512,49 @@ func (q *distributorQuerier) streamingSelect(ctx context.Context, minT, maxT int
 		sets = append(sets, newTimeSeriesSeriesSet(results.Timeseries))
 	}
 
-	serieses := make([]storage.Series, 0, len(results.Chunkseries))
-	for _, result := range results.Chunkseries {
-		// Sometimes the ingester can send series that have no data.
-		if len(result.Chunks) == 0 {
-			continue
-		}
+	// Each series is decoded at the same index it has been received at, so that the order
+	// is preserved when decoding them concurrently.
+	decoded := make([]storage.Series, len(results.Chunkseries))
+	decode := func(i int) error {
+		s, err := q.decodeChunkSeries(results.Chunkseries[i], maxChunksPerSeries, minT, maxT)
+		decoded[i] = s
+		return err
+	}
 
-		ls := cortexpb.FromLabelAdaptersToLabels(result.Labels)
-		sort.Sort(ls)
+	if q.chunkSeriesDecodeConcurrency > 1 {
+		jobs := make([]interface{}, len(results.Chunkseries))
+		for i := range jobs {
+			jobs[i] = i
+		}
 
-		chunks, err := chunkcompat.FromChunks(ls, result.Chunks)
+		// The context is checked before decoding each series, and the first error cancels the others.
+		err := concurrency.ForEach(ctx, jobs, q.chunkSeriesDecodeConcurrency, func(_ context.Context, job interface{}) error {
+			return decode(job.(int))
+		})
 		if err != nil {
 			return storage.ErrSeriesSet(err)
 		}
+	} else {
+		for i := range results.Chunkseries {
+			if i%chunkSeriesContextCheckInterval == 0 {
+				select {
+				case <-ctx.Done():
+					return storage.ErrSeriesSet(ctx.Err())
+				default:
+				}
+			}
+
+			if err := decode(i); err != nil {
+				return storage.ErrSeriesSet(err)
+			}
+		}
+	}
 
-		serieses = append(serieses, &chunkSeries{
-			labels:            ls,
-			chunks:            chunks,
-			chunkIteratorFunc: q.chunkIterFn,
-			mint:              minT,
-			maxt:              maxT,
-		})
+	serieses := make([]storage.Series, 0, len(decoded))
+	for _, s := range decoded {
+		if s != nil {
+			serieses = append(serieses, s)
+		}
 	}
 
 	if len(serieses) > 0 {
@@ -200,19 +571,101 @@ func (q *distributorQuerier) streamingSelect(ctx context.Context, minT, maxT int
 	return storage.NewMergeSeriesSet(sets, storage.ChainedSeriesMerge)
 }
 
+// decodeChunkSeries decodes the chunks of a series received from ingesters. The returned
+// series is nil if the series has no chunks.
+func (q *distributorQuerier) decodeChunkSeries(result client.TimeSeriesChunk, maxChunksPerSeries int, minT, maxT int64) (storage.Series, error) {
+	// Sometimes the ingester can send series that have no data.
+	if len(result.Chunks) == 0 {
+		return nil, nil
+	}
+
+	ls := cortexpb.FromLabelAdaptersToLabels(result.Labels)
+	sort.Sort(ls)
+
+	// Protect the querier from a single series carrying an huge number of chunks,
+	// before decoding them.
+	if maxChunksPerSeries > 0 && len(result.Chunks) > maxChunksPerSeries {
+		return nil, validation.LimitError(fmt.Sprintf(errMaxChunksPerSeries, ls.String(), maxChunksPerSeries))
+	}
+
+	chunks, err := chunkcompat.FromChunks(ls, result.Chunks)
+	if err != nil {
+		return nil, err
+	}
+
+	return &chunkSeries{
+		labels:            ls,
+		chunks:            chunks,
+		chunkIteratorFunc: q.chunkIterFn,
+		mint:              minT,
+		maxt:              maxT,
+	}, nil
+}
+
 func (q *distributorQuerier) LabelValues(name string, matchers ...*labels.Matcher) ([]string, storage.Warnings, error) {
 	var (
 		lvs []string
 		err error
 	)
 
-	if q.streamingMetadata {
-		lvs, err = q.distributor.LabelValuesForLabelNameStream(q.ctx, model.Time(q.mint), model.Time(q.maxt), model.LabelName(name), matchers...)
+	release, err := q.acquireMetadataRequestSlot(q.ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer release()
+
+	callCtx, cancel := q.ingesterCallContext(q.ctx)
+	defer cancel()
+
+	if q.maxLabelValues > 0 {
+		lvs, err = q.labelValuesWithLimit(callCtx, name, matchers)
+	} else if q.streamingMetadata {
+		lvs, err = q.distributor.LabelValuesForLabelNameStream(callCtx, model.Time(q.mint), model.Time(q.maxt), model.LabelName(name), matchers...)
 	} else {
-		lvs, err = q.distributor.LabelValuesForLabelName(q.ctx, model.Time(q.mint), model.Time(q.maxt), model.LabelName(name), matchers...)
+		lvs, err = q.distributor.LabelValuesForLabelName(callCtx, model.Time(q.mint), model.Time(q.maxt), model.LabelName(name), matchers...)
+	}
+	if err != nil {
+		warnings, err := q.ingesterCallErr(q.ctx, callCtx, err)
+		return nil, warnings, q.annotateErr(err, q.mint, q.maxt)
+	}
+
+	return lvs, nil, nil
+}
+
+// labelValuesWithLimit streams the label values from ingesters, failing as soon as more than
+// maxLabelValues unique values are received, instead of loading all of them in memory first.
+func (q *distributorQuerier) labelValuesWithLimit(ctx context.Context, name string, matchers []*labels.Matcher) ([]string, error) {
+	var (
+		valueSet = map[string]struct{}{}
+		exceeded bool
+	)
+
+	err := q.distributor.LabelValuesForLabelNameIterator(ctx, model.Time(q.mint), model.Time(q.maxt), model.LabelName(name), func(values []string) bool {
+		for _, v := range values {
+			valueSet[v] = struct{}{}
+			if len(valueSet) > q.maxLabelValues {
+				exceeded = true
+				return false
+			}
+		}
+		return true
+	}, matchers...)
+	if err != nil {
+		return nil, err
 	}
+	if exceeded {
+		return nil, validation.LimitError(fmt.Sprintf(errMaxLabelValues, q.maxLabelValues))
+	}
+
+	values := make([]string, 0, len(valueSet))
+	for v := range valueSet {
+		values = append(values, v)
+	}
+
+	// The label values are returned sorted, like the ones of the other paths.
+	sort.Strings(values)
 
-	return lvs, nil, err
+	return values, nil
 }
 
 func (q *distributorQuerier) LabelNames(matchers ...*labels.Matcher) ([]string, storage.Warnings, error) {
@@ -228,13 +681,23 @@ func (q *distributorQuerier) LabelNames(matchers ...*labels.Matcher) ([]string,
 		err error
 	)
 
+	release, err := q.acquireMetadataRequestSlot(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer release()
+
+	callCtx, cancel := q.ingesterCallContext(ctx)
+	defer cancel()
+
 	if q.streamingMetadata {
-		ln, err = q.distributor.LabelNamesStream(ctx, model.Time(q.mint), model.Time(q.maxt))
+		ln, err = q.distributor.LabelNamesStream(callCtx, model.Time(q.mint), model.Time(q.maxt))
 	} else {
-		ln, err = q.distributor.LabelNames(ctx, model.Time(q.mint), model.Time(q.maxt))
+		ln, err = q.distributor.LabelNames(callCtx, model.Time(q.mint), model.Time(q.maxt))
 	}
 
-	return ln, nil, err
+	warnings, err := q.ingesterCallErr(ctx, callCtx, err)
+	return ln, warnings, q.annotateErr(err, q.mint, q.maxt)
 }
 
 // labelNamesWithMatchers performs the LabelNames call by calling ingester's MetricsForLabelMatchers method
@@ -242,27 +705,69 @@ func (q *distributorQuerier) labelNamesWithMatchers(matchers ...*labels.Matcher)
 	log, ctx := spanlogger.New(q.ctx, "distributorQuerier.labelNamesWithMatchers")
 	defer log.Span.Finish()
 
+	maxSeries, maxLabelNames := 0, 0
+	if q.limits != nil {
+		userID, err := tenant.TenantID(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		maxSeries = q.limits.MaxSeriesPerLabelNamesQuery(userID)
+		maxLabelNames = q.limits.MaxLabelNamesPerQuery(userID)
+	}
+
 	var (
-		ms  []metric.Metric
-		err error
+		warnings storage.Warnings
+		namesMap = make(map[string]struct{})
 	)
 
-	if q.streamingMetadata {
-		ms, err = q.distributor.MetricsForLabelMatchersStream(ctx, model.Time(q.mint), model.Time(q.maxt), matchers...)
-	} else {
-		ms, err = q.distributor.MetricsForLabelMatchers(ctx, model.Time(q.mint), model.Time(q.maxt), matchers...)
+	// addNames adds the label names of the input series, returning false once the max label
+	// names limit is hit. In such case, the names collected so far are returned.
+	addNames := func(m model.Metric) bool {
+		for name := range m {
+			if _, ok := namesMap[string(name)]; ok {
+				continue
+			}
+			if maxLabelNames > 0 && len(namesMap) >= maxLabelNames {
+				warnings = append(warnings, fmt.Errorf(warnMaxLabelNames, maxLabelNames))
+				return false
+			}
+			namesMap[string(name)] = struct{}{}
+		}
+		return true
 	}
 
+	release, err := q.acquireMetadataRequestSlot(ctx)
 	if err != nil {
 		return nil, nil, err
 	}
-	namesMap := make(map[string]struct{})
 
-	for _, m := range ms {
-		for name := range m.Metric {
-			namesMap[string(name)] = struct{}{}
+	callCtx, cancel := q.ingesterCallContext(ctx)
+	if maxSeries > 0 {
+		var limited bool
+		limited, err = q.labelNamesWithSeriesLimit(callCtx, maxSeries, addNames, matchers)
+		if limited {
+			warnings = append(warnings, fmt.Errorf(warnMaxSeriesPerLabelNames, maxSeries))
+		}
+	} else {
+		var ms []metric.Metric
+		if q.streamingMetadata {
+			ms, err = q.distributor.MetricsForLabelMatchersStream(callCtx, model.Time(q.mint), model.Time(q.maxt), matchers...)
+		} else {
+			ms, err = q.distributor.MetricsForLabelMatchers(callCtx, model.Time(q.mint), model.Time(q.maxt), matchers...)
+		}
+		for _, m := range ms {
+			if !addNames(m.Metric) {
+				break
+			}
 		}
 	}
+	cancel()
+	release()
+
+	if err != nil {
+		warnings, err := q.ingesterCallErr(ctx, callCtx, err)
+		return nil, warnings, q.annotateErr(err, q.mint, q.maxt)
+	}
 
 	names := make([]string, 0, len(namesMap))
 	for name := range namesMap {
@@ -270,49 +775,172 @@ func (q *distributorQuerier) labelNamesWithMatchers(matchers ...*labels.Matcher)
 	}
 	sort.Strings(names)
 
-	return names, nil, nil
+	return names, warnings, nil
+}
+
+// labelNamesWithSeriesLimit streams the series from ingesters, passing each of them to addNames
+// until it returns false, and stops fetching them once more than maxSeries unique series are
+// received. The returned bool is true if the series limit has been hit.
+func (q *distributorQuerier) labelNamesWithSeriesLimit(ctx context.Context, maxSeries int, addNames func(model.Metric) bool, matchers []*labels.Matcher) (bool, error) {
+	// The series are deduplicated, given they're received from each ingester holding a replica.
+	seen := make(map[model.Fingerprint]struct{}, maxSeries)
+
+	limited := false
+	err := q.distributor.MetricsForLabelMatchersIterator(ctx, model.Time(q.mint), model.Time(q.maxt), func(metrics []metric.Metric) bool {
+		for _, m := range metrics {
+			fp := m.Metric.Fingerprint()
+			if _, ok := seen[fp]; ok {
+				continue
+			}
+			if len(seen) >= maxSeries {
+				limited = true
+				return false
+			}
+			seen[fp] = struct{}{}
+
+			if !addNames(m.Metric) {
+				return false
+			}
+		}
+		return true
+	}, matchers...)
+
+	return limited, err
+}
+
+// ingesterCallContext returns the context to call the distributor with, bounded by the
+// ingester call timeout if configured.
+func (q *distributorQuerier) ingesterCallContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if q.ingesterCallTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, q.ingesterCallTimeout)
+}
+
+// ingesterCallErr wraps the error of a distributor call which exceeded the ingester call
+// timeout, returning it as a warning if configured so. The errors not caused by the
+// timeout, including the cancellation of the query itself, are returned as is.
+func (q *distributorQuerier) ingesterCallErr(ctx, callCtx context.Context, err error) (storage.Warnings, error) {
+	if err == nil || q.ingesterCallTimeout <= 0 || ctx.Err() != nil || callCtx.Err() != context.DeadlineExceeded {
+		return nil, err
+	}
+
+	err = fmt.Errorf(errIngesterCallTimeout, q.ingesterCallTimeout, err)
+	if q.ingesterCallTimeoutAsWarning {
+		return storage.Warnings{err}, nil
+	}
+	return nil, err
 }
 
 func (q *distributorQuerier) Close() error {
 	return nil
 }
 
+// annotateErr adds the tenant and the queried time range to the input error, if enabled.
+func (q *distributorQuerier) annotateErr(err error, minT, maxT int64) error {
+	if err == nil || !q.queryRangeInErrors {
+		return err
+	}
+
+	userID, _ := tenant.TenantID(q.ctx)
+	return errors.Wrapf(err, "query failed (tenant: %s, start: %s, end: %s)", userID, util.FormatTimeMillis(minT), util.FormatTimeMillis(maxT))
+}
+
+// annotateSeriesSetErr wraps the input series set so that its error, if any, gets the tenant
+// and the queried time range added, if enabled.
+func (q *distributorQuerier) annotateSeriesSetErr(set storage.SeriesSet, minT, maxT int64) storage.SeriesSet {
+	if !q.queryRangeInErrors {
+		return set
+	}
+
+	return &annotatedErrSeriesSet{SeriesSet: set, querier: q, minT: minT, maxT: maxT}
+}
+
+type annotatedErrSeriesSet struct {
+	storage.SeriesSet
+
+	querier    *distributorQuerier
+	minT, maxT int64
+}
+
+func (s *annotatedErrSeriesSet) Err() error {
+	return s.querier.annotateErr(s.SeriesSet.Err(), s.minT, s.maxT)
+}
+
 type distributorExemplarQueryable struct {
-	distributor Distributor
+	distributor          Distributor
+	queryIngestersWithin time.Duration
 }
 
-func newDistributorExemplarQueryable(d Distributor) storage.ExemplarQueryable {
+func newDistributorExemplarQueryable(d Distributor, queryIngestersWithin time.Duration) storage.ExemplarQueryable {
 	return &distributorExemplarQueryable{
-		distributor: d,
+		distributor:          d,
+		queryIngestersWithin: queryIngestersWithin,
 	}
 }
 
 func (d distributorExemplarQueryable) ExemplarQuerier(ctx context.Context) (storage.ExemplarQuerier, error) {
 	return &distributorExemplarQuerier{
-		distributor: d.distributor,
-		ctx:         ctx,
+		distributor:          d.distributor,
+		ctx:                  ctx,
+		queryIngestersWithin: d.queryIngestersWithin,
 	}, nil
 }
 
 type distributorExemplarQuerier struct {
-	distributor Distributor
-	ctx         context.Context
+	distributor          Distributor
+	ctx                  context.Context
+	queryIngestersWithin time.Duration
 }
 
 // Select querys for exemplars, prometheus' storage.ExemplarQuerier's Select function takes the time range as two int64 values.
 func (q *distributorExemplarQuerier) Select(start, end int64, matchers ...[]*labels.Matcher) ([]exemplar.QueryResult, error) {
+	if start > end {
+		return nil, fmt.Errorf(errInvalidExemplarsTimeRange, util.TimeFromMillis(start).UTC().Format(time.RFC3339Nano), util.TimeFromMillis(end).UTC().Format(time.RFC3339Nano))
+	}
+
+	// Exemplars are only stored in the ingesters, so there's no point in querying them
+	// beyond the max lookback of the queries sent to ingesters.
+	if queryIngestersWithin := queryIngestersWithinFromContext(q.ctx, q.queryIngestersWithin); queryIngestersWithin > 0 {
+		start = math.Max64(start, util.TimeToMillis(time.Now().Add(-queryIngestersWithin)))
+		if start > end {
+			return []exemplar.QueryResult{}, nil
+		}
+	}
+
 	allResults, err := q.distributor.QueryExemplars(q.ctx, model.Time(start), model.Time(end), matchers...)
 
 	if err != nil {
 		return nil, err
 	}
 
-	var e exemplar.QueryResult
 	ret := make([]exemplar.QueryResult, len(allResults.Timeseries))
 	for i, ts := range allResults.Timeseries {
-		e.SeriesLabels = cortexpb.FromLabelAdaptersToLabels(ts.Labels)
-		e.Exemplars = cortexpb.FromExemplarProtosToExemplars(ts.Exemplars)
-		ret[i] = e
+		ret[i] = exemplar.QueryResult{
+			SeriesLabels: cortexpb.FromLabelAdaptersToLabels(ts.Labels),
+			Exemplars:    cortexpb.FromExemplarProtosToExemplars(ts.Exemplars),
+		}
 	}
 	return ret, nil
 }
+
+// isMatchAll returns whether the input matchers select all the series. Every series has
+// a metric name, so the matchers on the metric name matching any non-empty value are
+// considered match-all too, like the matchers matching the empty value.
+func isMatchAll(matchers []*labels.Matcher) bool {
+	for _, m := range matchers {
+		if m.Matches("") {
+			continue
+		}
+		if m.Name == labels.MetricName && m.Matches(" ") {
+			matchesAnyName := (m.Type == labels.MatchNotEqual && m.Value == "") ||
+				(m.Type == labels.MatchRegexp && m.Value == ".+") ||
+				(m.Type == labels.MatchNotRegexp && m.Value == "")
+			if matchesAnyName {
+				continue
+			}
+		}
+		return false
+	}
+	return true
+}
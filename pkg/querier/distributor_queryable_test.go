@@ -2,12 +2,24 @@ package querier
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/exemplar"
 	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/value"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -21,6 +33,8 @@ import (
 	"github.com/cortexproject/cortex/pkg/prom1/storage/metric"
 	"github.com/cortexproject/cortex/pkg/util"
 	"github.com/cortexproject/cortex/pkg/util/chunkcompat"
+	"github.com/cortexproject/cortex/pkg/util/test"
+	"github.com/cortexproject/cortex/pkg/util/validation"
 )
 
 const (
@@ -45,7 +59,7 @@ func TestDistributorQuerier(t *testing.T) {
 		},
 		nil)
 
-	queryable := newDistributorQueryable(d, false, false, nil, 0)
+	queryable := newDistributorQueryable(d, Config{}, nil, nil, nil)
 	querier, err := queryable.Querier(context.Background(), mint, maxt)
 	require.NoError(t, err)
 
@@ -66,14 +80,16 @@ func TestDistributorQuerier(t *testing.T) {
 
 func TestDistributorQuerier_SelectShouldHonorQueryIngestersWithin(t *testing.T) {
 	now := time.Now()
+	overrideDuration := func(d time.Duration) *time.Duration { return &d }
 
 	tests := map[string]struct {
-		querySeries          bool
-		queryIngestersWithin time.Duration
-		queryMinT            int64
-		queryMaxT            int64
-		expectedMinT         int64
-		expectedMaxT         int64
+		querySeries                  bool
+		queryIngestersWithin         time.Duration
+		queryIngestersWithinOverride *time.Duration
+		queryMinT                    int64
+		queryMaxT                    int64
+		expectedMinT                 int64
+		expectedMaxT                 int64
 	}{
 		"should not manipulate query time range if queryIngestersWithin is disabled": {
 			queryIngestersWithin: 0,
@@ -111,6 +127,30 @@ func TestDistributorQuerier_SelectShouldHonorQueryIngestersWithin(t *testing.T)
 			expectedMinT:         util.TimeToMillis(now.Add(-100 * time.Minute)),
 			expectedMaxT:         util.TimeToMillis(now.Add(-90 * time.Minute)),
 		},
+		"should widen the query time range if overridden by a greater queryIngestersWithin": {
+			queryIngestersWithin:         time.Hour,
+			queryIngestersWithinOverride: overrideDuration(2 * time.Hour),
+			queryMinT:                    util.TimeToMillis(now.Add(-100 * time.Minute)),
+			queryMaxT:                    util.TimeToMillis(now.Add(-90 * time.Minute)),
+			expectedMinT:                 util.TimeToMillis(now.Add(-100 * time.Minute)),
+			expectedMaxT:                 util.TimeToMillis(now.Add(-90 * time.Minute)),
+		},
+		"should not manipulate query time range if queryIngestersWithin is disabled by the override": {
+			queryIngestersWithin:         time.Hour,
+			queryIngestersWithinOverride: overrideDuration(0),
+			queryMinT:                    util.TimeToMillis(now.Add(-100 * time.Minute)),
+			queryMaxT:                    util.TimeToMillis(now.Add(-90 * time.Minute)),
+			expectedMinT:                 util.TimeToMillis(now.Add(-100 * time.Minute)),
+			expectedMaxT:                 util.TimeToMillis(now.Add(-90 * time.Minute)),
+		},
+		"should narrow the query time range if overridden by a lower queryIngestersWithin": {
+			queryIngestersWithin:         0,
+			queryIngestersWithinOverride: overrideDuration(time.Hour),
+			queryMinT:                    util.TimeToMillis(now.Add(-100 * time.Minute)),
+			queryMaxT:                    util.TimeToMillis(now.Add(-30 * time.Minute)),
+			expectedMinT:                 util.TimeToMillis(now.Add(-60 * time.Minute)),
+			expectedMaxT:                 util.TimeToMillis(now.Add(-30 * time.Minute)),
+		},
 	}
 
 	for _, streamingEnabled := range []bool{false, true} {
@@ -123,7 +163,10 @@ func TestDistributorQuerier_SelectShouldHonorQueryIngestersWithin(t *testing.T)
 				distributor.On("MetricsForLabelMatchersStream", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return([]metric.Metric{}, nil)
 
 				ctx := user.InjectOrgID(context.Background(), "test")
-				queryable := newDistributorQueryable(distributor, streamingEnabled, streamingEnabled, nil, testData.queryIngestersWithin)
+				if testData.queryIngestersWithinOverride != nil {
+					ctx = InjectQueryIngestersWithin(ctx, *testData.queryIngestersWithinOverride)
+				}
+				queryable := newDistributorQueryable(distributor, Config{IngesterStreaming: streamingEnabled, IngesterMetadataStreaming: streamingEnabled, QueryIngestersWithin: testData.queryIngestersWithin}, nil, nil, nil)
 				querier, err := queryable.Querier(ctx, testData.queryMinT, testData.queryMaxT)
 				require.NoError(t, err)
 
@@ -150,7 +193,7 @@ func TestDistributorQuerier_SelectShouldHonorQueryIngestersWithin(t *testing.T)
 
 func TestDistributorQueryableFilter(t *testing.T) {
 	d := &MockDistributor{}
-	dq := newDistributorQueryable(d, false, false, nil, 1*time.Hour)
+	dq := newDistributorQueryable(d, Config{QueryIngestersWithin: 1 * time.Hour}, nil, nil, nil)
 
 	now := time.Now()
 
@@ -162,6 +205,70 @@ func TestDistributorQueryableFilter(t *testing.T) {
 
 	// Same query, hour+1ms later, is not sent to ingesters.
 	require.False(t, dq.UseQueryable(now.Add(time.Hour).Add(1*time.Millisecond), queryMinT, queryMaxT))
+
+	// Unless queryIngestersWithin is widened for the query.
+	ctx := InjectQueryIngestersWithin(context.Background(), 2*time.Hour)
+	require.True(t, useQueryable(ctx, dq, now.Add(time.Hour).Add(1*time.Millisecond), queryMinT, queryMaxT))
+
+	// The query is not sent to ingesters if queryIngestersWithin is narrowed for the query.
+	ctx = InjectQueryIngestersWithin(context.Background(), time.Minute)
+	require.True(t, dq.UseQueryable(now.Add(time.Minute).Add(1*time.Millisecond), queryMinT, queryMaxT))
+	require.False(t, useQueryable(ctx, dq, now.Add(time.Minute).Add(1*time.Millisecond), queryMinT, queryMaxT))
+}
+
+func TestDistributorQuerier_IngesterStorageBoundaryWarning(t *testing.T) {
+	const queryIngestersWithin = time.Hour
+
+	now := time.Now()
+
+	tests := map[string]struct {
+		queryMinT       int64
+		boundaryWarning bool
+		expectedWarning bool
+	}{
+		"query spanning the boundary with warning disabled": {
+			queryMinT: util.TimeToMillis(now.Add(-2 * time.Hour)),
+		},
+		"query spanning the boundary with warning enabled": {
+			queryMinT:       util.TimeToMillis(now.Add(-2 * time.Hour)),
+			boundaryWarning: true,
+			expectedWarning: true,
+		},
+		"query within the ingesters time range with warning enabled": {
+			queryMinT:       util.TimeToMillis(now.Add(-30 * time.Minute)),
+			boundaryWarning: true,
+		},
+	}
+
+	for _, streamingEnabled := range []bool{false, true} {
+		for testName, testData := range tests {
+			t.Run(fmt.Sprintf("%s (streaming enabled: %t)", testName, streamingEnabled), func(t *testing.T) {
+				d := &MockDistributor{}
+				d.On("Query", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(model.Matrix{}, nil)
+				d.On("QueryStream", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&client.QueryStreamResponse{}, nil)
+
+				ctx := user.InjectOrgID(context.Background(), "test")
+				queryable := newDistributorQueryable(d, Config{IngesterStreaming: streamingEnabled, IngesterMetadataStreaming: streamingEnabled, QueryIngestersWithin: queryIngestersWithin, IngesterStorageBoundaryWarning: testData.boundaryWarning}, nil, nil, nil)
+				querier, err := queryable.Querier(ctx, testData.queryMinT, util.TimeToMillis(now))
+				require.NoError(t, err)
+
+				seriesSet := querier.Select(true, nil)
+				require.NoError(t, seriesSet.Err())
+
+				if !testData.expectedWarning {
+					assert.Empty(t, seriesSet.Warnings())
+					return
+				}
+
+				// The boundary is the min time of the query sent to ingesters.
+				require.Len(t, d.Calls, 1)
+				boundary := util.TimeFromMillis(int64(d.Calls[0].Arguments.Get(1).(model.Time)))
+
+				require.Len(t, seriesSet.Warnings(), 1)
+				assert.EqualError(t, seriesSet.Warnings()[0], fmt.Sprintf(warnIngesterStorageBoundary, boundary.UTC().Format(time.RFC3339Nano)))
+			})
+		}
+	}
 }
 
 func TestIngesterStreaming(t *testing.T) {
@@ -198,7 +305,7 @@ func TestIngesterStreaming(t *testing.T) {
 		nil)
 
 	ctx := user.InjectOrgID(context.Background(), "0")
-	queryable := newDistributorQueryable(d, true, true, mergeChunks, 0)
+	queryable := newDistributorQueryable(d, Config{IngesterStreaming: true, IngesterMetadataStreaming: true}, mergeChunks, nil, nil)
 	querier, err := queryable.Querier(ctx, mint, maxt)
 	require.NoError(t, err)
 
@@ -274,7 +381,7 @@ func TestIngesterStreamingMixedResults(t *testing.T) {
 		nil)
 
 	ctx := user.InjectOrgID(context.Background(), "0")
-	queryable := newDistributorQueryable(d, true, true, mergeChunks, 0)
+	queryable := newDistributorQueryable(d, Config{IngesterStreaming: true, IngesterMetadataStreaming: true}, mergeChunks, nil, nil)
 	querier, err := queryable.Querier(ctx, mint, maxt)
 	require.NoError(t, err)
 
@@ -294,50 +401,1420 @@ func TestIngesterStreamingMixedResults(t *testing.T) {
 	require.NoError(t, seriesSet.Err())
 }
 
-func verifySeries(t *testing.T, series storage.Series, l labels.Labels, samples []cortexpb.Sample) {
-	require.Equal(t, l, series.Labels())
+func TestIngesterStreaming_SplitQueryShouldStitchSeriesAcrossSubRanges(t *testing.T) {
+	const (
+		mint = 0
+		maxt = 9999
+	)
 
-	it := series.Iterator()
-	for _, s := range samples {
-		require.True(t, it.Next())
-		require.Nil(t, it.Err())
-		ts, v := it.At()
-		require.Equal(t, s.Value, v)
-		require.Equal(t, s.TimestampMs, ts)
+	// The "one" series has a chunk crossing the boundary between the two sub-ranges,
+	// so it's returned by both sub-queries.
+	firstChunk := []cortexpb.Sample{
+		{Value: 1, TimestampMs: 1000},
+		{Value: 2, TimestampMs: 3000},
+		{Value: 3, TimestampMs: 5000},
 	}
-	require.False(t, it.Next())
-	require.Nil(t, it.Err())
+	secondChunk := []cortexpb.Sample{
+		{Value: 4, TimestampMs: 7000},
+		{Value: 5, TimestampMs: 9000},
+	}
+	s2 := []cortexpb.Sample{
+		{Value: 1, TimestampMs: 2000},
+		{Value: 2, TimestampMs: 4000},
+	}
+
+	d := &MockDistributor{}
+	d.On("QueryStream", mock.Anything, model.Time(0), model.Time(4999), mock.Anything).Return(
+		&client.QueryStreamResponse{
+			Chunkseries: []client.TimeSeriesChunk{
+				{
+					Labels: []cortexpb.LabelAdapter{{Name: labels.MetricName, Value: "one"}},
+					Chunks: convertToChunks(t, firstChunk),
+				},
+				{
+					Labels: []cortexpb.LabelAdapter{{Name: labels.MetricName, Value: "two"}},
+					Chunks: convertToChunks(t, s2),
+				},
+			},
+		},
+		nil)
+	d.On("QueryStream", mock.Anything, model.Time(5000), model.Time(9999), mock.Anything).Return(
+		&client.QueryStreamResponse{
+			Chunkseries: []client.TimeSeriesChunk{
+				{
+					Labels: []cortexpb.LabelAdapter{{Name: labels.MetricName, Value: "one"}},
+					Chunks: append(convertToChunks(t, firstChunk), convertToChunks(t, secondChunk)...),
+				},
+			},
+		},
+		nil)
+
+	ctx := user.InjectOrgID(context.Background(), "0")
+	queryable := newDistributorQueryable(d, Config{IngesterStreaming: true, IngesterMetadataStreaming: true, IngesterQuerySplitInterval: 5 * time.Second}, mergeChunks, nil, nil)
+	querier, err := queryable.Querier(ctx, mint, maxt)
+	require.NoError(t, err)
+
+	seriesSet := querier.Select(true, &storage.SelectHints{Start: mint, End: maxt}, labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".*"))
+	require.NoError(t, seriesSet.Err())
+
+	require.True(t, seriesSet.Next())
+	verifySeries(t, seriesSet.At(), labels.Labels{{Name: labels.MetricName, Value: "one"}}, append(firstChunk, secondChunk...))
+
+	require.True(t, seriesSet.Next())
+	verifySeries(t, seriesSet.At(), labels.Labels{{Name: labels.MetricName, Value: "two"}}, s2)
+
+	require.False(t, seriesSet.Next())
+	require.NoError(t, seriesSet.Err())
+
+	d.AssertNumberOfCalls(t, "QueryStream", 2)
 }
-func TestDistributorQuerier_LabelNames(t *testing.T) {
-	someMatchers := []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "foo", "bar")}
-	labelNames := []string{"foo", "job"}
 
-	for _, streamingEnabled := range []bool{false, true} {
-		t.Run("with matchers", func(t *testing.T) {
-			metrics := []metric.Metric{
-				{Metric: model.Metric{"foo": "bar"}},
-				{Metric: model.Metric{"job": "baz"}},
-				{Metric: model.Metric{"job": "baz", "foo": "boom"}},
-			}
-			d := &MockDistributor{}
-			d.On("MetricsForLabelMatchers", mock.Anything, model.Time(mint), model.Time(maxt), someMatchers).
-				Return(metrics, nil)
-			d.On("MetricsForLabelMatchersStream", mock.Anything, model.Time(mint), model.Time(maxt), someMatchers).
-				Return(metrics, nil)
+func TestIngesterStreaming_MaxChunksPerSeries(t *testing.T) {
+	const (
+		mint = 0
+		maxt = 10000
+	)
 
-			queryable := newDistributorQueryable(d, false, streamingEnabled, nil, 0)
-			querier, err := queryable.Querier(context.Background(), mint, maxt)
+	chunks := append(convertToChunks(t, []cortexpb.Sample{{Value: 1, TimestampMs: 1000}}), convertToChunks(t, []cortexpb.Sample{{Value: 2, TimestampMs: 2000}})...)
+	chunks = append(chunks, convertToChunks(t, []cortexpb.Sample{{Value: 3, TimestampMs: 3000}})...)
+
+	d := &MockDistributor{}
+	d.On("QueryStream", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(
+		&client.QueryStreamResponse{
+			Chunkseries: []client.TimeSeriesChunk{
+				{
+					Labels: []cortexpb.LabelAdapter{{Name: labels.MetricName, Value: "one"}},
+					Chunks: chunks[:1],
+				},
+				{
+					Labels: []cortexpb.LabelAdapter{{Name: labels.MetricName, Value: "two"}},
+					Chunks: chunks,
+				},
+			},
+		},
+		nil)
+
+	for _, testData := range []struct {
+		maxChunksPerSeries int
+		expectedErr        error
+	}{
+		{maxChunksPerSeries: 0},
+		{maxChunksPerSeries: 3},
+		{
+			maxChunksPerSeries: 2,
+			expectedErr:        validation.LimitError(fmt.Sprintf(errMaxChunksPerSeries, `{__name__="two"}`, 2)),
+		},
+	} {
+		t.Run(fmt.Sprintf("max chunks per series: %d", testData.maxChunksPerSeries), func(t *testing.T) {
+			limits := DefaultLimitsConfig()
+			limits.MaxChunksPerSeries = testData.maxChunksPerSeries
+			overrides, err := validation.NewOverrides(limits, nil)
 			require.NoError(t, err)
 
-			names, warnings, err := querier.LabelNames(someMatchers...)
+			ctx := user.InjectOrgID(context.Background(), "0")
+			queryable := newDistributorQueryable(d, Config{IngesterStreaming: true, IngesterMetadataStreaming: true}, mergeChunks, overrides, nil)
+			querier, err := queryable.Querier(ctx, mint, maxt)
 			require.NoError(t, err)
-			assert.Empty(t, warnings)
-			assert.Equal(t, labelNames, names)
+
+			seriesSet := querier.Select(true, &storage.SelectHints{Start: mint, End: maxt})
+			if testData.expectedErr != nil {
+				require.Equal(t, testData.expectedErr, seriesSet.Err())
+				return
+			}
+
+			require.NoError(t, seriesSet.Err())
+			require.True(t, seriesSet.Next())
+			require.True(t, seriesSet.Next())
+			require.False(t, seriesSet.Next())
+		})
+	}
+}
+
+func TestIngesterStreaming_HADedup(t *testing.T) {
+	const (
+		mint = 0
+		maxt = 10000
+	)
+
+	replicaA := []cortexpb.Sample{{Value: 1, TimestampMs: 1000}, {Value: 2, TimestampMs: 2000}, {Value: 3, TimestampMs: 3000}}
+	replicaB := []cortexpb.Sample{{Value: 20, TimestampMs: 2000}, {Value: 40, TimestampMs: 4000}}
+	other := []cortexpb.Sample{{Value: 5, TimestampMs: 1000}}
+
+	d := &MockDistributor{}
+	d.On("QueryStream", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(
+		&client.QueryStreamResponse{
+			Chunkseries: []client.TimeSeriesChunk{
+				{
+					Labels: []cortexpb.LabelAdapter{{Name: labels.MetricName, Value: "one"}, {Name: "__replica__", Value: "b"}, {Name: "cluster", Value: "c1"}},
+					Chunks: convertToChunks(t, replicaB),
+				},
+				{
+					Labels: []cortexpb.LabelAdapter{{Name: labels.MetricName, Value: "one"}, {Name: "__replica__", Value: "a"}, {Name: "cluster", Value: "c1"}},
+					Chunks: convertToChunks(t, replicaA),
+				},
+				{
+					Labels: []cortexpb.LabelAdapter{{Name: labels.MetricName, Value: "two"}, {Name: "__replica__", Value: "a"}},
+					Chunks: convertToChunks(t, other),
+				},
+			},
+		},
+		nil)
+
+	overrides, err := validation.NewOverrides(DefaultLimitsConfig(), nil)
+	require.NoError(t, err)
+
+	ctx := user.InjectOrgID(context.Background(), "0")
+	queryable := newDistributorQueryable(d, Config{IngesterStreaming: true, IngesterMetadataStreaming: true, HADedupEnabled: true}, mergeChunks, overrides, nil)
+	querier, err := queryable.Querier(ctx, mint, maxt)
+	require.NoError(t, err)
+
+	seriesSet := querier.Select(true, &storage.SelectHints{Start: mint, End: maxt})
+	require.NoError(t, seriesSet.Err())
+
+	// The replicas are merged into a single series without the replica label, preferring
+	// the samples of the most complete replica.
+	require.True(t, seriesSet.Next())
+	verifySeries(t, seriesSet.At(), labels.FromStrings(labels.MetricName, "one", "cluster", "c1"), []cortexpb.Sample{
+		{Value: 1, TimestampMs: 1000}, {Value: 2, TimestampMs: 2000}, {Value: 3, TimestampMs: 3000}, {Value: 40, TimestampMs: 4000},
+	})
+
+	// Series without the cluster label are left untouched.
+	require.True(t, seriesSet.Next())
+	verifySeries(t, seriesSet.At(), labels.FromStrings(labels.MetricName, "two", "__replica__", "a"), other)
+
+	require.False(t, seriesSet.Next())
+	require.NoError(t, seriesSet.Err())
+}
+
+func TestIngesterStreaming_HADedupOffsetReplicas(t *testing.T) {
+	const (
+		mint = 0
+		maxt = 200000
+	)
+
+	// The replicas scrape every 15s at offset times and their counters slightly differ.
+	// Replica a misses 2 scrapes, between 45s and 90s.
+	var replicaA, replicaB []cortexpb.Sample
+	for ts := int64(0); ts <= 150000; ts += 15000 {
+		if ts != 60000 && ts != 75000 {
+			replicaA = append(replicaA, cortexpb.Sample{Value: float64(ts / 1000), TimestampMs: ts})
+		}
+	}
+	for ts := int64(5000); ts <= 110000; ts += 15000 {
+		replicaB = append(replicaB, cortexpb.Sample{Value: float64(ts/1000 - 2), TimestampMs: ts})
+	}
+
+	d := &MockDistributor{}
+	d.On("QueryStream", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(
+		&client.QueryStreamResponse{
+			Chunkseries: []client.TimeSeriesChunk{
+				{
+					Labels: []cortexpb.LabelAdapter{{Name: labels.MetricName, Value: "one"}, {Name: "__replica__", Value: "b"}, {Name: "cluster", Value: "c1"}},
+					Chunks: convertToChunks(t, replicaB),
+				},
+				{
+					Labels: []cortexpb.LabelAdapter{{Name: labels.MetricName, Value: "one"}, {Name: "__replica__", Value: "a"}, {Name: "cluster", Value: "c1"}},
+					Chunks: convertToChunks(t, replicaA),
+				},
+			},
+		},
+		nil)
+
+	overrides, err := validation.NewOverrides(DefaultLimitsConfig(), nil)
+	require.NoError(t, err)
+
+	ctx := user.InjectOrgID(context.Background(), "0")
+	queryable := newDistributorQueryable(d, Config{IngesterStreaming: true, IngesterMetadataStreaming: true, HADedupEnabled: true}, mergeChunks, overrides, nil)
+	querier, err := queryable.Querier(ctx, mint, maxt)
+	require.NoError(t, err)
+
+	seriesSet := querier.Select(true, &storage.SelectHints{Start: mint, End: maxt})
+	require.NoError(t, seriesSet.Err())
+
+	// The samples of replica b are only used within the gap of replica a, so the counter
+	// never goes backwards.
+	require.True(t, seriesSet.Next())
+	verifySeries(t, seriesSet.At(), labels.FromStrings(labels.MetricName, "one", "cluster", "c1"), []cortexpb.Sample{
+		{Value: 0, TimestampMs: 0}, {Value: 15, TimestampMs: 15000}, {Value: 30, TimestampMs: 30000}, {Value: 45, TimestampMs: 45000},
+		{Value: 63, TimestampMs: 65000}, {Value: 78, TimestampMs: 80000},
+		{Value: 90, TimestampMs: 90000}, {Value: 105, TimestampMs: 105000}, {Value: 120, TimestampMs: 120000}, {Value: 135, TimestampMs: 135000}, {Value: 150, TimestampMs: 150000},
+	})
+
+	require.False(t, seriesSet.Next())
+	require.NoError(t, seriesSet.Err())
+}
+
+func TestDistributorQuerier_QueryRangeInErrors(t *testing.T) {
+	const (
+		mint = 1000
+		maxt = 5000
+	)
+
+	d := &MockDistributor{}
+	d.On("QueryStream", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&client.QueryStreamResponse{}, errors.New("query stream failed"))
+	d.On("LabelNames", mock.Anything, mock.Anything, mock.Anything).Return([]string(nil), errors.New("label names failed"))
+
+	ctx := user.InjectOrgID(context.Background(), "user-1")
+	queryable := newDistributorQueryable(d, Config{IngesterStreaming: true, QueryRangeInErrors: true}, mergeChunks, nil, nil)
+	querier, err := queryable.Querier(ctx, mint, maxt)
+	require.NoError(t, err)
+
+	seriesSet := querier.Select(true, &storage.SelectHints{Start: mint, End: maxt})
+	require.Error(t, seriesSet.Err())
+	assert.Contains(t, seriesSet.Err().Error(), "query stream failed")
+	assert.Contains(t, seriesSet.Err().Error(), "tenant: user-1")
+	assert.Contains(t, seriesSet.Err().Error(), "start: "+util.FormatTimeMillis(mint))
+	assert.Contains(t, seriesSet.Err().Error(), "end: "+util.FormatTimeMillis(maxt))
+
+	_, _, err = querier.LabelNames()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "label names failed")
+	assert.Contains(t, err.Error(), "tenant: user-1")
+	assert.Contains(t, err.Error(), "start: "+util.FormatTimeMillis(mint))
+	assert.Contains(t, err.Error(), "end: "+util.FormatTimeMillis(maxt))
+}
+
+func TestDistributorQuerier_RejectSeriesWithoutMatchers(t *testing.T) {
+	const (
+		mint = 0
+		maxt = 10000
+	)
+
+	tests := map[string]struct {
+		matchers    []*labels.Matcher
+		expectedErr bool
+	}{
+		"empty matchers": {
+			expectedErr: true,
+		},
+		"any metric name": {
+			matchers:    []*labels.Matcher{labels.MustNewMatcher(labels.MatchNotEqual, labels.MetricName, "")},
+			expectedErr: true,
+		},
+		"any metric name with regex": {
+			matchers:    []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+")},
+			expectedErr: true,
+		},
+		"any metric name and a matcher matching the empty value": {
+			matchers: []*labels.Matcher{
+				labels.MustNewMatcher(labels.MatchNotEqual, labels.MetricName, ""),
+				labels.MustNewMatcher(labels.MatchRegexp, "job", ".*"),
+			},
+			expectedErr: true,
+		},
+		"specific metric name": {
+			matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "foo")},
+		},
+		"any metric name with a specific label": {
+			matchers: []*labels.Matcher{
+				labels.MustNewMatcher(labels.MatchNotEqual, labels.MetricName, ""),
+				labels.MustNewMatcher(labels.MatchEqual, "job", "test"),
+			},
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			d := &MockDistributor{}
+			d.On("MetricsForLabelMatchers", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(
+				[]metric.Metric{{Metric: model.Metric{model.MetricNameLabel: "foo", "job": "test"}}}, nil)
+
+			for _, enabled := range []bool{false, true} {
+				ctx := user.InjectOrgID(context.Background(), "0")
+				queryable := newDistributorQueryable(d, Config{RejectSeriesWithoutMatchers: enabled}, nil, nil, nil)
+				querier, err := queryable.Querier(ctx, mint, maxt)
+				require.NoError(t, err)
+
+				seriesSet := querier.Select(true, &storage.SelectHints{Start: mint, End: maxt, Func: "series"}, testData.matchers...)
+				if enabled && testData.expectedErr {
+					require.Error(t, seriesSet.Err())
+					assert.Equal(t, validation.LimitError(fmt.Sprintf(errSeriesWithoutMatchers, util.LabelMatchersToString(testData.matchers))), seriesSet.Err())
+					continue
+				}
+				require.NoError(t, seriesSet.Err())
+			}
+
+			// Ingesters are never queried when the request is rejected.
+			expectedCalls := 2
+			if testData.expectedErr {
+				expectedCalls = 1
+			}
+			d.AssertNumberOfCalls(t, "MetricsForLabelMatchers", expectedCalls)
+		})
+	}
+}
+
+func TestDistributorQuerier_MaxConcurrentMetadataRequests(t *testing.T) {
+	const (
+		maxConcurrent = 2
+		numRequests   = 6
+	)
+
+	var (
+		mtx         sync.Mutex
+		inflight    int
+		maxInflight int
+		unblock     = make(chan struct{})
+	)
+
+	d := &MockDistributor{}
+	d.On("MetricsForLabelMatchers", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(func(mock.Arguments) {
+		mtx.Lock()
+		inflight++
+		if inflight > maxInflight {
+			maxInflight = inflight
+		}
+		mtx.Unlock()
+
+		<-unblock
+
+		mtx.Lock()
+		inflight--
+		mtx.Unlock()
+	}).Return([]metric.Metric{{Metric: model.Metric{model.MetricNameLabel: "foo"}}}, nil)
+
+	reg := prometheus.NewPedanticRegistry()
+	ctx := user.InjectOrgID(context.Background(), "0")
+	queryable := newDistributorQueryable(d, Config{MaxConcurrentMetadataRequestsPerQuery: maxConcurrent}, nil, nil, reg)
+	querier, err := queryable.Querier(ctx, 0, 10000)
+	require.NoError(t, err)
+
+	wg := sync.WaitGroup{}
+	wg.Add(numRequests)
+	for i := 0; i < numRequests; i++ {
+		go func(i int) {
+			defer wg.Done()
+
+			names, _, err := querier.LabelNames(labels.MustNewMatcher(labels.MatchEqual, "job", fmt.Sprintf("job-%d", i)))
+			require.NoError(t, err)
+			assert.Equal(t, []string{labels.MetricName}, names)
+		}(i)
+	}
+
+	// Wait until the max number of concurrent requests has been issued, and the others are queued.
+	test.Poll(t, time.Second, float64(numRequests-maxConcurrent), func() interface{} {
+		return testutil.ToFloat64(queryable.(distributorQueryable).metrics.metadataRequestsQueued)
+	})
+	assert.Equal(t, float64(maxConcurrent), testutil.ToFloat64(queryable.(distributorQueryable).metrics.metadataRequestsInflight))
+
+	close(unblock)
+	wg.Wait()
+
+	assert.Equal(t, maxConcurrent, maxInflight)
+	assert.Equal(t, float64(0), testutil.ToFloat64(queryable.(distributorQueryable).metrics.metadataRequestsInflight))
+	d.AssertNumberOfCalls(t, "MetricsForLabelMatchers", numRequests)
+}
+
+func TestDistributorQuerier_SeriesMetadataCache(t *testing.T) {
+	const (
+		mint = 0
+		maxt = 10000
+	)
+
+	d := &MockDistributor{}
+	d.On("MetricsForLabelMatchers", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(
+		[]metric.Metric{{Metric: model.Metric{model.MetricNameLabel: "foo", "job": "test"}}}, nil)
+
+	reg := prometheus.NewPedanticRegistry()
+	ctx := user.InjectOrgID(context.Background(), "0")
+	queryable := newDistributorQueryable(d, Config{}, nil, nil, reg)
+
+	fooMatcher := labels.MustNewMatcher(labels.MatchEqual, model.MetricNameLabel, "foo")
+	barMatcher := labels.MustNewMatcher(labels.MatchEqual, model.MetricNameLabel, "bar")
+	hints := &storage.SelectHints{Start: mint, End: maxt, Func: "series"}
+
+	querier, err := queryable.Querier(ctx, mint, maxt)
+	require.NoError(t, err)
+
+	// Identical metadata requests within the same querier only hit the ingesters once.
+	for _, matcher := range []*labels.Matcher{fooMatcher, fooMatcher, barMatcher} {
+		seriesSet := querier.Select(true, hints, matcher)
+		require.True(t, seriesSet.Next())
+		require.NoError(t, seriesSet.Err())
+	}
+	d.AssertNumberOfCalls(t, "MetricsForLabelMatchers", 2)
+
+	// The cache is not shared across queriers.
+	querier, err = queryable.Querier(ctx, mint, maxt)
+	require.NoError(t, err)
+	require.True(t, querier.Select(true, hints, fooMatcher).Next())
+	d.AssertNumberOfCalls(t, "MetricsForLabelMatchers", 3)
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP cortex_querier_series_metadata_cache_hits_total Total number of series metadata requests served by the request-scoped cache instead of querying ingesters.
+		# TYPE cortex_querier_series_metadata_cache_hits_total counter
+		cortex_querier_series_metadata_cache_hits_total 1
+		# HELP cortex_querier_series_metadata_requests_total Total number of series metadata requested to ingesters by the querier, including the ones served by the request-scoped cache.
+		# TYPE cortex_querier_series_metadata_requests_total counter
+		cortex_querier_series_metadata_requests_total 4
+	`), "cortex_querier_series_metadata_cache_hits_total", "cortex_querier_series_metadata_requests_total"))
+}
+
+func TestDistributorQuerier_QueriesSkippedIngestersMetric(t *testing.T) {
+	now := time.Now()
+
+	d := &MockDistributor{}
+	d.On("QueryStream", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&client.QueryStreamResponse{}, nil)
+
+	reg := prometheus.NewPedanticRegistry()
+	ctx := user.InjectOrgID(context.Background(), "0")
+	queryable := newDistributorQueryable(d, Config{IngesterStreaming: true, IngesterMetadataStreaming: true, QueryIngestersWithin: time.Hour}, nil, nil, reg)
+
+	for _, queryMaxT := range []int64{
+		// Within the query ingesters within period.
+		util.TimeToMillis(now.Add(-30 * time.Minute)),
+		// Older than the query ingesters within period, so the query is skipped.
+		util.TimeToMillis(now.Add(-90 * time.Minute)),
+	} {
+		querier, err := queryable.Querier(ctx, util.TimeToMillis(now.Add(-100*time.Minute)), queryMaxT)
+		require.NoError(t, err)
+
+		seriesSet := querier.Select(true, nil)
+		require.False(t, seriesSet.Next())
+		require.NoError(t, seriesSet.Err())
+	}
+	d.AssertNumberOfCalls(t, "QueryStream", 1)
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP cortex_querier_queries_skipped_ingesters_total Total number of queries not sent to ingesters because their time range is older than the query ingesters within period, so they are fully served by the long-term storage.
+		# TYPE cortex_querier_queries_skipped_ingesters_total counter
+		cortex_querier_queries_skipped_ingesters_total 1
+	`), "cortex_querier_queries_skipped_ingesters_total"))
+}
+
+func TestDistributorQuerier_IngesterCallTimeout(t *testing.T) {
+	const timeout = 20 * time.Millisecond
+
+	someMatchers := []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "foo")}
+
+	selectCall := func(hints *storage.SelectHints) func(q storage.Querier) (storage.Warnings, error) {
+		return func(q storage.Querier) (storage.Warnings, error) {
+			set := q.Select(true, hints, someMatchers...)
+			for set.Next() {
+				require.NotNil(t, set.At())
+			}
+			return set.Warnings(), set.Err()
+		}
+	}
+
+	calls := map[string]struct {
+		streaming bool
+		call      func(q storage.Querier) (storage.Warnings, error)
+	}{
+		"select": {
+			call: selectCall(nil),
+		},
+		"streaming select": {
+			streaming: true,
+			call:      selectCall(nil),
+		},
+		"series": {
+			call: selectCall(&storage.SelectHints{Func: "series"}),
+		},
+		"label values": {
+			call: func(q storage.Querier) (storage.Warnings, error) {
+				_, warnings, err := q.LabelValues("foo")
+				return warnings, err
+			},
+		},
+		"label names": {
+			call: func(q storage.Querier) (storage.Warnings, error) {
+				_, warnings, err := q.LabelNames()
+				return warnings, err
+			},
+		},
+		"label names with matchers": {
+			call: func(q storage.Querier) (storage.Warnings, error) {
+				_, warnings, err := q.LabelNames(someMatchers...)
+				return warnings, err
+			},
+		},
+	}
+
+	for callName, callData := range calls {
+		for _, asWarning := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s, as warning: %t", callName, asWarning), func(t *testing.T) {
+				d := &slowDistributor{delay: time.Minute}
+				ctx := user.InjectOrgID(context.Background(), "0")
+				queryable := newDistributorQueryable(d, Config{IngesterStreaming: callData.streaming, IngesterCallTimeout: timeout, IngesterCallTimeoutAsWarning: asWarning}, nil, nil, nil)
+				querier, err := queryable.Querier(ctx, mint, maxt)
+				require.NoError(t, err)
+
+				warnings, err := callData.call(querier)
+				if asWarning {
+					require.NoError(t, err)
+					require.Len(t, warnings, 1)
+					assert.Contains(t, warnings[0].Error(), "the call to ingesters exceeded the timeout of 20ms")
+					assert.True(t, errors.Is(warnings[0], context.DeadlineExceeded))
+				} else {
+					require.Error(t, err)
+					assert.Contains(t, err.Error(), "the call to ingesters exceeded the timeout of 20ms")
+					assert.True(t, errors.Is(err, context.DeadlineExceeded))
+					assert.Empty(t, warnings)
+				}
+			})
+		}
+
+		t.Run(fmt.Sprintf("%s, within the timeout", callName), func(t *testing.T) {
+			d := &slowDistributor{delay: time.Millisecond}
+			ctx := user.InjectOrgID(context.Background(), "0")
+			queryable := newDistributorQueryable(d, Config{IngesterStreaming: callData.streaming, IngesterCallTimeout: time.Minute}, nil, nil, nil)
+			querier, err := queryable.Querier(ctx, mint, maxt)
+			require.NoError(t, err)
+
+			warnings, err := callData.call(querier)
+			require.NoError(t, err)
+			assert.Empty(t, warnings)
+		})
+	}
+
+	t.Run("should not apply any timeout if disabled", func(t *testing.T) {
+		d := &slowDistributor{delay: 2 * timeout}
+		ctx := user.InjectOrgID(context.Background(), "0")
+		queryable := newDistributorQueryable(d, Config{}, nil, nil, nil)
+		querier, err := queryable.Querier(ctx, mint, maxt)
+		require.NoError(t, err)
+
+		names, warnings, err := querier.LabelNames()
+		require.NoError(t, err)
+		assert.Empty(t, warnings)
+		assert.Equal(t, []string{"foo"}, names)
+	})
+}
+
+// slowDistributor is a distributor taking delay to answer each call, unless the context
+// is done before.
+type slowDistributor struct {
+	emptyDistributor
+	delay time.Duration
+}
+
+func (d *slowDistributor) wait(ctx context.Context) error {
+	select {
+	case <-time.After(d.delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *slowDistributor) Query(ctx context.Context, _, _ model.Time, _ ...*labels.Matcher) (model.Matrix, error) {
+	return nil, d.wait(ctx)
+}
+
+func (d *slowDistributor) QueryStream(ctx context.Context, _, _ model.Time, _ ...*labels.Matcher) (*client.QueryStreamResponse, error) {
+	if err := d.wait(ctx); err != nil {
+		return nil, err
+	}
+	return &client.QueryStreamResponse{}, nil
+}
+
+func (d *slowDistributor) LabelValuesForLabelName(ctx context.Context, _, _ model.Time, _ model.LabelName, _ ...*labels.Matcher) ([]string, error) {
+	return nil, d.wait(ctx)
+}
+
+func (d *slowDistributor) LabelNames(ctx context.Context, _, _ model.Time) ([]string, error) {
+	if err := d.wait(ctx); err != nil {
+		return nil, err
+	}
+	return []string{"foo"}, nil
+}
+
+func (d *slowDistributor) MetricsForLabelMatchers(ctx context.Context, _, _ model.Time, _ ...*labels.Matcher) ([]metric.Metric, error) {
+	return nil, d.wait(ctx)
+}
+
+func TestDistributorQuerier_SelectShouldLogSpanFields(t *testing.T) {
+	now := time.Now()
+	minT, maxT := util.TimeToMillis(now.Add(-2*time.Hour)), util.TimeToMillis(now)
+
+	for _, streaming := range []bool{false, true} {
+		t.Run(fmt.Sprintf("streaming: %t", streaming), func(t *testing.T) {
+			mockTracer := mocktracer.New()
+			opentracing.SetGlobalTracer(mockTracer)
+			defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+			d := &MockDistributor{}
+			d.On("Query", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(model.Matrix{
+				{Metric: model.Metric{"foo": "1"}},
+				{Metric: model.Metric{"foo": "2"}},
+			}, nil)
+			d.On("QueryStream", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&client.QueryStreamResponse{
+				Timeseries: []cortexpb.TimeSeries{
+					{Labels: []cortexpb.LabelAdapter{{Name: "foo", Value: "1"}}},
+					{Labels: []cortexpb.LabelAdapter{{Name: "foo", Value: "2"}}},
+				},
+			}, nil)
+
+			// The min time is manipulated by the query ingesters within period.
+			ctx := user.InjectOrgID(context.Background(), "0")
+			queryable := newDistributorQueryable(d, Config{IngesterStreaming: streaming, QueryIngestersWithin: time.Hour}, nil, nil, nil)
+			querier, err := queryable.Querier(ctx, minT, maxT)
+			require.NoError(t, err)
+
+			set := querier.Select(true, &storage.SelectHints{Start: minT, End: maxT})
+			require.NoError(t, set.Err())
+
+			var selectSpan *mocktracer.MockSpan
+			for _, span := range mockTracer.FinishedSpans() {
+				if span.OperationName == "distributorQuerier.Select" {
+					selectSpan = span
+				}
+			}
+			require.NotNil(t, selectSpan)
+
+			fields := map[string]string{}
+			for _, record := range selectSpan.Logs() {
+				for _, field := range record.Fields {
+					fields[field.Key] = field.ValueString
+				}
+			}
+
+			require.Contains(t, fields, "min_time")
+			actualMinT, err := strconv.ParseInt(fields["min_time"], 10, 64)
+			require.NoError(t, err)
+			assert.GreaterOrEqual(t, actualMinT, util.TimeToMillis(now.Add(-time.Hour)))
+			assert.Equal(t, strconv.FormatInt(maxT, 10), fields["max_time"])
+			assert.Equal(t, strconv.FormatBool(streaming), fields["streaming"])
+			assert.Equal(t, "2", fields["series"])
+		})
+	}
+}
+
+func TestDistributorExemplarQuerier_Select(t *testing.T) {
+	now := time.Now()
+	matchers := [][]*labels.Matcher{{labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "test")}}
+
+	t.Run("should fail if the start time is after the end time", func(t *testing.T) {
+		d := &MockDistributor{}
+
+		queryable := newDistributorExemplarQueryable(d, 0)
+		querier, err := queryable.ExemplarQuerier(context.Background())
+		require.NoError(t, err)
+
+		_, err = querier.Select(util.TimeToMillis(now), util.TimeToMillis(now.Add(-time.Hour)), matchers...)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid exemplars query time range")
+		d.AssertNotCalled(t, "QueryExemplars", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("should not manipulate the time range if query ingesters within is disabled", func(t *testing.T) {
+		start, end := util.TimeToMillis(now.Add(-48*time.Hour)), util.TimeToMillis(now)
+
+		d := &MockDistributor{}
+		d.On("QueryExemplars", mock.Anything, model.Time(start), model.Time(end), matchers).Return(&client.ExemplarQueryResponse{}, nil)
+
+		queryable := newDistributorExemplarQueryable(d, 0)
+		querier, err := queryable.ExemplarQuerier(context.Background())
+		require.NoError(t, err)
+
+		_, err = querier.Select(start, end, matchers...)
+		require.NoError(t, err)
+		d.AssertNumberOfCalls(t, "QueryExemplars", 1)
+	})
+
+	t.Run("should clamp the start time to the query ingesters within period", func(t *testing.T) {
+		start, end := util.TimeToMillis(now.Add(-48*time.Hour)), util.TimeToMillis(now)
+
+		d := &MockDistributor{}
+		d.On("QueryExemplars", mock.Anything, mock.Anything, model.Time(end), matchers).Return(&client.ExemplarQueryResponse{
+			Timeseries: []cortexpb.TimeSeries{{
+				Labels:    []cortexpb.LabelAdapter{{Name: labels.MetricName, Value: "test"}},
+				Exemplars: []cortexpb.Exemplar{{Labels: []cortexpb.LabelAdapter{{Name: "traceID", Value: "123"}}, Value: 1, TimestampMs: end}},
+			}},
+		}, nil)
+
+		queryable := newDistributorExemplarQueryable(d, time.Hour)
+		querier, err := queryable.ExemplarQuerier(context.Background())
+		require.NoError(t, err)
+
+		results, err := querier.Select(start, end, matchers...)
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		assert.Equal(t, labels.FromStrings(labels.MetricName, "test"), results[0].SeriesLabels)
+
+		require.Len(t, d.Calls, 1)
+		from := d.Calls[0].Arguments.Get(1).(model.Time)
+		assert.GreaterOrEqual(t, int64(from), util.TimeToMillis(now.Add(-time.Hour)))
+		assert.LessOrEqual(t, int64(from), util.TimeToMillis(time.Now().Add(-time.Hour)))
+	})
+
+	t.Run("should not query the ingesters if the time range is older than the query ingesters within period", func(t *testing.T) {
+		d := &MockDistributor{}
+
+		queryable := newDistributorExemplarQueryable(d, time.Hour)
+		querier, err := queryable.ExemplarQuerier(context.Background())
+		require.NoError(t, err)
+
+		results, err := querier.Select(util.TimeToMillis(now.Add(-48*time.Hour)), util.TimeToMillis(now.Add(-2*time.Hour)), matchers...)
+		require.NoError(t, err)
+		assert.Empty(t, results)
+		d.AssertNotCalled(t, "QueryExemplars", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("should return the labels and exemplars of each series", func(t *testing.T) {
+		start, end := util.TimeToMillis(now.Add(-time.Hour)), util.TimeToMillis(now)
+
+		d := &MockDistributor{}
+		d.On("QueryExemplars", mock.Anything, model.Time(start), model.Time(end), matchers).Return(&client.ExemplarQueryResponse{
+			Timeseries: []cortexpb.TimeSeries{{
+				Labels: []cortexpb.LabelAdapter{{Name: labels.MetricName, Value: "test"}, {Name: "series", Value: "1"}},
+				Exemplars: []cortexpb.Exemplar{
+					{Labels: []cortexpb.LabelAdapter{{Name: "traceID", Value: "1"}}, Value: 1, TimestampMs: start},
+					{Labels: []cortexpb.LabelAdapter{{Name: "traceID", Value: "2"}}, Value: 2, TimestampMs: end},
+				},
+			}, {
+				Labels:    []cortexpb.LabelAdapter{{Name: labels.MetricName, Value: "test"}, {Name: "series", Value: "2"}},
+				Exemplars: []cortexpb.Exemplar{{Labels: []cortexpb.LabelAdapter{{Name: "traceID", Value: "3"}}, Value: 3, TimestampMs: end}},
+			}, {
+				Labels: []cortexpb.LabelAdapter{{Name: labels.MetricName, Value: "test"}, {Name: "series", Value: "3"}},
+			}},
+		}, nil)
+
+		queryable := newDistributorExemplarQueryable(d, 0)
+		querier, err := queryable.ExemplarQuerier(context.Background())
+		require.NoError(t, err)
+
+		results, err := querier.Select(start, end, matchers...)
+		require.NoError(t, err)
+		assert.Equal(t, []exemplar.QueryResult{{
+			SeriesLabels: labels.FromStrings(labels.MetricName, "test", "series", "1"),
+			Exemplars: []exemplar.Exemplar{
+				{Labels: labels.FromStrings("traceID", "1"), Value: 1, Ts: start},
+				{Labels: labels.FromStrings("traceID", "2"), Value: 2, Ts: end},
+			},
+		}, {
+			SeriesLabels: labels.FromStrings(labels.MetricName, "test", "series", "2"),
+			Exemplars:    []exemplar.Exemplar{{Labels: labels.FromStrings("traceID", "3"), Value: 3, Ts: end}},
+		}, {
+			SeriesLabels: labels.FromStrings(labels.MetricName, "test", "series", "3"),
+			Exemplars:    []exemplar.Exemplar{},
+		}}, results)
+	})
+}
+
+func TestSplitTimeRange(t *testing.T) {
+	assert.Equal(t, [][2]int64{{0, 9}}, splitTimeRange(0, 9, 10))
+	assert.Equal(t, [][2]int64{{0, 9}, {10, 10}}, splitTimeRange(0, 10, 10))
+	assert.Equal(t, [][2]int64{{5, 14}, {15, 24}, {25, 27}}, splitTimeRange(5, 27, 10))
+}
+
+func verifySeries(t *testing.T, series storage.Series, l labels.Labels, samples []cortexpb.Sample) {
+	require.Equal(t, l, series.Labels())
+
+	it := series.Iterator()
+	for _, s := range samples {
+		require.True(t, it.Next())
+		require.Nil(t, it.Err())
+		ts, v := it.At()
+		require.Equal(t, s.Value, v)
+		require.Equal(t, s.TimestampMs, ts)
+	}
+	require.False(t, it.Next())
+	require.Nil(t, it.Err())
+}
+func TestDistributorQuerier_LabelNames(t *testing.T) {
+	someMatchers := []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "foo", "bar")}
+	labelNames := []string{"foo", "job"}
+
+	for _, streamingEnabled := range []bool{false, true} {
+		t.Run("with matchers", func(t *testing.T) {
+			metrics := []metric.Metric{
+				{Metric: model.Metric{"foo": "bar"}},
+				{Metric: model.Metric{"job": "baz"}},
+				{Metric: model.Metric{"job": "baz", "foo": "boom"}},
+			}
+			d := &MockDistributor{}
+			d.On("MetricsForLabelMatchers", mock.Anything, model.Time(mint), model.Time(maxt), someMatchers).
+				Return(metrics, nil)
+			d.On("MetricsForLabelMatchersStream", mock.Anything, model.Time(mint), model.Time(maxt), someMatchers).
+				Return(metrics, nil)
+
+			queryable := newDistributorQueryable(d, Config{IngesterMetadataStreaming: streamingEnabled}, nil, nil, nil)
+			querier, err := queryable.Querier(context.Background(), mint, maxt)
+			require.NoError(t, err)
+
+			names, warnings, err := querier.LabelNames(someMatchers...)
+			require.NoError(t, err)
+			assert.Empty(t, warnings)
+			assert.Equal(t, labelNames, names)
+		})
+	}
+}
+
+func TestDistributorQuerier_LabelNamesMaxSeries(t *testing.T) {
+	const (
+		numSeries = 10000
+		batchSize = 100
+	)
+	someMatchers := []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, "foo", ".+")}
+
+	// Each series has a unique label name, so that the number of names returned
+	// matches the number of series examined.
+	metrics := make([]metric.Metric, 0, numSeries)
+	for i := 0; i < numSeries; i++ {
+		metrics = append(metrics, metric.Metric{Metric: model.Metric{"foo": "bar", model.LabelName(fmt.Sprintf("label_%05d", i)): "value"}})
+	}
+
+	// The series are streamed in batches, the first one being received twice like from
+	// two ingesters holding a replica.
+	batches := [][]metric.Metric{metrics[:batchSize]}
+	for i := 0; i < numSeries; i += batchSize {
+		batches = append(batches, metrics[i:i+batchSize])
+	}
+
+	for _, testData := range []struct {
+		maxSeries        int
+		expectedNames    int
+		expectedBatches  int
+		expectedWarnings storage.Warnings
+	}{
+		{maxSeries: 0, expectedNames: numSeries + 1},
+		{maxSeries: numSeries, expectedNames: numSeries + 1, expectedBatches: len(batches)},
+		{
+			maxSeries:        250,
+			expectedNames:    250 + 1,
+			expectedBatches:  4,
+			expectedWarnings: storage.Warnings{fmt.Errorf(warnMaxSeriesPerLabelNames, 250)},
+		},
+	} {
+		t.Run(fmt.Sprintf("max series: %d", testData.maxSeries), func(t *testing.T) {
+			d := &MockDistributor{}
+			d.On("MetricsForLabelMatchers", mock.Anything, model.Time(mint), model.Time(maxt), someMatchers).Return(metrics, nil)
+			d.On("MetricsForLabelMatchersIterator", mock.Anything, model.Time(mint), model.Time(maxt), someMatchers).Return(batches, nil)
+
+			// Count the batches fetched, to check the series aren't fetched anymore once the limit is hit.
+			fetchedBatches := 0
+
+			limits := DefaultLimitsConfig()
+			limits.MaxSeriesPerLabelNamesQuery = testData.maxSeries
+			overrides, err := validation.NewOverrides(limits, nil)
+			require.NoError(t, err)
+
+			ctx := user.InjectOrgID(context.Background(), "0")
+			queryable := newDistributorQueryable(&batchCountingDistributor{MockDistributor: d, fetched: &fetchedBatches}, Config{}, nil, overrides, nil)
+			querier, err := queryable.Querier(ctx, mint, maxt)
+			require.NoError(t, err)
+
+			names, warnings, err := querier.LabelNames(someMatchers...)
+			require.NoError(t, err)
+			assert.Equal(t, testData.expectedWarnings, warnings)
+			assert.Len(t, names, testData.expectedNames)
+			assert.True(t, sort.StringsAreSorted(names))
+			assert.Equal(t, testData.expectedBatches, fetchedBatches)
+
+			if testData.maxSeries > 0 {
+				d.AssertNotCalled(t, "MetricsForLabelMatchers", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+// batchCountingDistributor counts the batches of series fetched with MetricsForLabelMatchersIterator.
+type batchCountingDistributor struct {
+	*MockDistributor
+	fetched *int
+}
+
+func (d *batchCountingDistributor) MetricsForLabelMatchersIterator(ctx context.Context, from, to model.Time, f func(metrics []metric.Metric) bool, matchers ...*labels.Matcher) error {
+	return d.MockDistributor.MetricsForLabelMatchersIterator(ctx, from, to, func(metrics []metric.Metric) bool {
+		*d.fetched++
+		return f(metrics)
+	}, matchers...)
+}
+
+func TestDistributorQuerier_LabelNamesMaxLabelNames(t *testing.T) {
+	const numSeries = 1000
+	someMatchers := []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, "foo", ".+")}
+
+	// Each series has a unique label name, besides the shared "foo" one.
+	metrics := make([]metric.Metric, 0, numSeries)
+	for i := 0; i < numSeries; i++ {
+		metrics = append(metrics, metric.Metric{Metric: model.Metric{"foo": "bar", model.LabelName(fmt.Sprintf("label_%05d", i)): "value"}})
+	}
+
+	for _, testData := range []struct {
+		maxLabelNames    int
+		expectedNames    int
+		expectedWarnings storage.Warnings
+	}{
+		{maxLabelNames: 0, expectedNames: numSeries + 1},
+		{maxLabelNames: numSeries + 1, expectedNames: numSeries + 1},
+		{
+			maxLabelNames:    numSeries,
+			expectedNames:    numSeries,
+			expectedWarnings: storage.Warnings{fmt.Errorf(warnMaxLabelNames, numSeries)},
+		},
+		{
+			maxLabelNames:    10,
+			expectedNames:    10,
+			expectedWarnings: storage.Warnings{fmt.Errorf(warnMaxLabelNames, 10)},
+		},
+	} {
+		t.Run(fmt.Sprintf("max label names: %d", testData.maxLabelNames), func(t *testing.T) {
+			d := &MockDistributor{}
+			d.On("MetricsForLabelMatchers", mock.Anything, model.Time(mint), model.Time(maxt), someMatchers).Return(metrics, nil)
+
+			limits := DefaultLimitsConfig()
+			limits.MaxLabelNamesPerQuery = testData.maxLabelNames
+			overrides, err := validation.NewOverrides(limits, nil)
+			require.NoError(t, err)
+
+			ctx := user.InjectOrgID(context.Background(), "0")
+			queryable := newDistributorQueryable(d, Config{}, nil, overrides, nil)
+			querier, err := queryable.Querier(ctx, mint, maxt)
+			require.NoError(t, err)
+
+			names, warnings, err := querier.LabelNames(someMatchers...)
+			require.NoError(t, err)
+			assert.Equal(t, testData.expectedWarnings, warnings)
+			assert.Len(t, names, testData.expectedNames)
+			assert.True(t, sort.StringsAreSorted(names))
+		})
+	}
+}
+
+func TestDistributorQuerier_MaintenanceMode(t *testing.T) {
+	d := &MockDistributor{}
+	d.On("QueryStream", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&client.QueryStreamResponse{}, nil)
+	d.On("MetricsForLabelMatchers", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return([]metric.Metric{}, nil)
+
+	maintenanceLimits := DefaultLimitsConfig()
+	maintenanceLimits.QueryMaintenanceMode = true
+	overrides, err := validation.NewOverrides(DefaultLimitsConfig(), staticTenantLimits{"maintenance": &maintenanceLimits})
+	require.NoError(t, err)
+
+	matcher := labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "foo")
+
+	for _, hints := range []*storage.SelectHints{
+		{Start: mint, End: maxt},
+		{Start: mint, End: maxt, Func: "series"},
+	} {
+		for _, userID := range []string{"maintenance", "other"} {
+			t.Run(fmt.Sprintf("tenant: %s, func: %s", userID, hints.Func), func(t *testing.T) {
+				ctx := user.InjectOrgID(context.Background(), userID)
+				queryable := newDistributorQueryable(d, Config{IngesterStreaming: true}, nil, overrides, nil)
+				querier, err := queryable.Querier(ctx, mint, maxt)
+				require.NoError(t, err)
+
+				seriesSet := querier.Select(true, hints, matcher)
+				require.False(t, seriesSet.Next())
+
+				if userID == "maintenance" {
+					assert.Equal(t, validation.LimitError(fmt.Sprintf(errTenantInMaintenance, userID)), seriesSet.Err())
+					return
+				}
+				assert.NoError(t, seriesSet.Err())
+			})
+		}
+	}
+}
+
+// staticTenantLimits implements validation.TenantLimits with a fixed set of per-tenant limits.
+type staticTenantLimits map[string]*validation.Limits
+
+func (l staticTenantLimits) ByUserID(userID string) *validation.Limits {
+	return l[userID]
+}
+
+func (l staticTenantLimits) AllByUserID() map[string]*validation.Limits {
+	return l
+}
+
+func TestDistributorQuerier_SelectSortSeries(t *testing.T) {
+	// The distributor returns the series in reverse order.
+	matrix := model.Matrix{
+		{Metric: model.Metric{model.MetricNameLabel: "c"}, Values: []model.SamplePair{{Timestamp: mint, Value: 3}}},
+		{Metric: model.Metric{model.MetricNameLabel: "b"}, Values: []model.SamplePair{{Timestamp: mint, Value: 2}}},
+		{Metric: model.Metric{model.MetricNameLabel: "a"}, Values: []model.SamplePair{{Timestamp: mint, Value: 1}}},
+	}
+
+	d := &MockDistributor{}
+	d.On("Query", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(matrix, nil)
+
+	for _, testData := range []struct {
+		sortSeries    bool
+		expectedNames []string
+	}{
+		{sortSeries: true, expectedNames: []string{"a", "b", "c"}},
+		{sortSeries: false, expectedNames: []string{"c", "b", "a"}},
+	} {
+		t.Run(fmt.Sprintf("sort series: %t", testData.sortSeries), func(t *testing.T) {
+			ctx := user.InjectOrgID(context.Background(), "0")
+			queryable := newDistributorQueryable(d, Config{}, nil, nil, nil)
+			querier, err := queryable.Querier(ctx, mint, maxt)
+			require.NoError(t, err)
+
+			seriesSet := querier.Select(testData.sortSeries, &storage.SelectHints{Start: mint, End: maxt})
+
+			var names []string
+			for seriesSet.Next() {
+				names = append(names, seriesSet.At().Labels().Get(labels.MetricName))
+			}
+			require.NoError(t, seriesSet.Err())
+			assert.Equal(t, testData.expectedNames, names)
+		})
+	}
+}
+
+func TestDistributorQuerier_SkipStaleOnlySeries(t *testing.T) {
+	const (
+		mint = 0
+		maxt = 10000
+	)
+
+	stale := math.Float64frombits(value.StaleNaN)
+	staleSamples := []cortexpb.Sample{{TimestampMs: 1000, Value: stale}, {TimestampMs: 2000, Value: stale}}
+
+	d := &MockDistributor{}
+	d.On("QueryStream", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(
+		&client.QueryStreamResponse{
+			Chunkseries: []client.TimeSeriesChunk{
+				{
+					Labels: []cortexpb.LabelAdapter{{Name: labels.MetricName, Value: "stale_only"}},
+					Chunks: convertToChunks(t, staleSamples),
+				},
+				{
+					// Only the chunks are stale, the series has a real sample received as timeseries.
+					Labels: []cortexpb.LabelAdapter{{Name: labels.MetricName, Value: "stale_in_chunks"}},
+					Chunks: convertToChunks(t, staleSamples),
+				},
+				{
+					Labels: []cortexpb.LabelAdapter{{Name: labels.MetricName, Value: "real"}},
+					Chunks: convertToChunks(t, []cortexpb.Sample{{TimestampMs: 1000, Value: 1}, {TimestampMs: 2000, Value: stale}}),
+				},
+			},
+			Timeseries: []cortexpb.TimeSeries{
+				{
+					Labels:  []cortexpb.LabelAdapter{{Name: labels.MetricName, Value: "stale_in_chunks"}},
+					Samples: []cortexpb.Sample{{TimestampMs: 3000, Value: 1}},
+				},
+			},
+		},
+		nil)
+
+	for _, skipStaleOnlySeries := range []bool{false, true} {
+		t.Run(fmt.Sprintf("skip stale only series: %t", skipStaleOnlySeries), func(t *testing.T) {
+			ctx := user.InjectOrgID(context.Background(), "0")
+			queryable := newDistributorQueryable(d, Config{IngesterStreaming: true, IngesterMetadataStreaming: true, IngesterSkipStaleOnlySeries: skipStaleOnlySeries}, mergeChunks, nil, nil)
+			querier, err := queryable.Querier(ctx, mint, maxt)
+			require.NoError(t, err)
+
+			seriesSet := querier.Select(true, &storage.SelectHints{Start: mint, End: maxt})
+
+			var names []string
+			for seriesSet.Next() {
+				names = append(names, seriesSet.At().Labels().Get(labels.MetricName))
+			}
+			require.NoError(t, seriesSet.Err())
+
+			if skipStaleOnlySeries {
+				assert.Equal(t, []string{"real", "stale_in_chunks"}, names)
+			} else {
+				assert.Equal(t, []string{"real", "stale_in_chunks", "stale_only"}, names)
+			}
+		})
+	}
+}
+
+func TestDistributorQuerier_SelectCanceledWhileDecodingChunks(t *testing.T) {
+	const (
+		mint      = 0
+		maxt      = 10000
+		numSeries = 10 * chunkSeriesContextCheckInterval
+	)
+
+	chunks := convertToChunks(t, []cortexpb.Sample{{TimestampMs: 1000, Value: 1}})
+	response := &client.QueryStreamResponse{}
+	for i := 0; i < numSeries; i++ {
+		response.Chunkseries = append(response.Chunkseries, client.TimeSeriesChunk{
+			Labels: []cortexpb.LabelAdapter{{Name: labels.MetricName, Value: fmt.Sprintf("series_%d", i)}},
+			Chunks: chunks,
+		})
+	}
+
+	for _, canceled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("canceled: %t", canceled), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(user.InjectOrgID(context.Background(), "0"))
+			defer cancel()
+
+			// The client goes away once the ingesters have responded, before the chunks are decoded.
+			d := &MockDistributor{}
+			d.On("QueryStream", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(response, nil).Run(func(mock.Arguments) {
+				if canceled {
+					cancel()
+				}
+			})
+
+			queryable := newDistributorQueryable(d, Config{IngesterStreaming: true, IngesterMetadataStreaming: true}, mergeChunks, nil, nil)
+			querier, err := queryable.Querier(ctx, mint, maxt)
+			require.NoError(t, err)
+
+			seriesSet := querier.Select(true, &storage.SelectHints{Start: mint, End: maxt})
+
+			count := 0
+			for seriesSet.Next() {
+				count++
+			}
+
+			if canceled {
+				assert.Equal(t, context.Canceled, seriesSet.Err())
+				assert.Zero(t, count)
+			} else {
+				require.NoError(t, seriesSet.Err())
+				assert.Equal(t, numSeries, count)
+			}
+		})
+	}
+}
+
+func TestDistributorQuerier_LabelValuesWithLimit(t *testing.T) {
+	// The label values are received in batches, which repeat the values received from other ingesters.
+	batches := [][]string{{"c", "a"}, {"b"}, {"a", "c"}, {"d"}}
+
+	tests := map[string]struct {
+		maxLabelValues int
+		expectedValues []string
+		expectedErr    error
+	}{
+		"should return the sorted label values within the limit": {
+			maxLabelValues: 4,
+			expectedValues: []string{"a", "b", "c", "d"},
+		},
+		"should fail once the limit is exceeded": {
+			maxLabelValues: 3,
+			expectedErr:    validation.LimitError(fmt.Sprintf(errMaxLabelValues, 3)),
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			var received int
+
+			d := &MockDistributor{}
+			d.On("LabelValuesForLabelNameIterator", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(batches, nil).Run(func(mock.Arguments) {
+				received++
+			})
+
+			ctx := user.InjectOrgID(context.Background(), "0")
+			queryable := newDistributorQueryable(d, Config{IngesterMetadataStreaming: true, MaxLabelValues: testData.maxLabelValues}, nil, nil, nil)
+			querier, err := queryable.Querier(ctx, mint, maxt)
+			require.NoError(t, err)
+
+			values, warnings, err := querier.LabelValues("foo")
+			assert.Equal(t, 1, received)
+			if testData.expectedErr != nil {
+				assert.Equal(t, testData.expectedErr, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, testData.expectedValues, values)
+			assert.Empty(t, warnings)
+
+			// The batch paths aren't used when the limit is set.
+			d.AssertNotCalled(t, "LabelValuesForLabelName", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			d.AssertNotCalled(t, "LabelValuesForLabelNameStream", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestDistributorQuerier_SelectMergesSeriesReceivedAsSamplesAndChunks(t *testing.T) {
+	const (
+		mint = 0
+		maxt = 10000
+	)
+
+	d := &MockDistributor{}
+	d.On("QueryStream", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(
+		&client.QueryStreamResponse{
+			Chunkseries: []client.TimeSeriesChunk{
+				{
+					Labels: []cortexpb.LabelAdapter{{Name: labels.MetricName, Value: "foo"}, {Name: "job", Value: "test"}},
+					Chunks: convertToChunks(t, []cortexpb.Sample{{TimestampMs: 1000, Value: 1}, {TimestampMs: 2000, Value: 2}}),
+				},
+			},
+			Timeseries: []cortexpb.TimeSeries{
+				{
+					// Same series, with the labels not sorted and an overlapping sample.
+					Labels:  []cortexpb.LabelAdapter{{Name: "job", Value: "test"}, {Name: labels.MetricName, Value: "foo"}},
+					Samples: []cortexpb.Sample{{TimestampMs: 2000, Value: 2}, {TimestampMs: 3000, Value: 3}},
+				},
+			},
+		},
+		nil)
+
+	ctx := user.InjectOrgID(context.Background(), "0")
+	queryable := newDistributorQueryable(d, Config{IngesterStreaming: true, IngesterMetadataStreaming: true}, mergeChunks, nil, nil)
+	querier, err := queryable.Querier(ctx, mint, maxt)
+	require.NoError(t, err)
+
+	seriesSet := querier.Select(true, &storage.SelectHints{Start: mint, End: maxt})
+
+	require.True(t, seriesSet.Next())
+	s := seriesSet.At()
+	assert.Equal(t, labels.FromStrings(labels.MetricName, "foo", "job", "test"), s.Labels())
+
+	var samples []model.SamplePair
+	it := s.Iterator()
+	for it.Next() {
+		ts, v := it.At()
+		samples = append(samples, model.SamplePair{Timestamp: model.Time(ts), Value: model.SampleValue(v)})
+	}
+	require.NoError(t, it.Err())
+	assert.Equal(t, []model.SamplePair{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 2}, {Timestamp: 3000, Value: 3}}, samples)
+
+	require.False(t, seriesSet.Next())
+	require.NoError(t, seriesSet.Err())
+}
+
+func BenchmarkDistributorQuerier_Select(b *testing.B) {
+	const numSeries = 10000
+
+	matrix := make(model.Matrix, 0, numSeries)
+	for i := numSeries; i > 0; i-- {
+		matrix = append(matrix, &model.SampleStream{
+			Metric: model.Metric{model.MetricNameLabel: "test", "series_id": model.LabelValue(fmt.Sprintf("%06d", i))},
+			Values: []model.SamplePair{{Timestamp: mint, Value: 1}},
+		})
+	}
+
+	d := &MockDistributor{}
+	d.On("Query", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(matrix, nil)
+
+	ctx := user.InjectOrgID(context.Background(), "0")
+	queryable := newDistributorQueryable(d, Config{}, nil, nil, nil)
+
+	for _, sortSeries := range []bool{true, false} {
+		b.Run(fmt.Sprintf("sort series: %t", sortSeries), func(b *testing.B) {
+			b.ReportAllocs()
+
+			for n := 0; n < b.N; n++ {
+				querier, err := queryable.Querier(ctx, mint, maxt)
+				require.NoError(b, err)
+
+				seriesSet := querier.Select(sortSeries, &storage.SelectHints{Start: mint, End: maxt})
+				for seriesSet.Next() {
+					seriesSet.At()
+				}
+				require.NoError(b, seriesSet.Err())
+			}
+		})
+	}
+}
+
+func TestDistributorQuerier_SelectChunkSeriesDecodeConcurrency(t *testing.T) {
+	const (
+		mint      = 0
+		maxt      = 10000
+		numSeries = 1000
+	)
+
+	chunks := convertToChunks(t, []cortexpb.Sample{{TimestampMs: 1000, Value: 1}})
+
+	// The series are received in reverse order, along with some series without chunks.
+	var response client.QueryStreamResponse
+	var expectedNames []string
+	for i := numSeries - 1; i >= 0; i-- {
+		series := client.TimeSeriesChunk{
+			Labels: []cortexpb.LabelAdapter{{Name: labels.MetricName, Value: fmt.Sprintf("series_%04d", i)}},
+		}
+		if i%10 != 0 {
+			series.Chunks = chunks
+			expectedNames = append([]string{series.Labels[0].Value}, expectedNames...)
+		}
+		response.Chunkseries = append(response.Chunkseries, series)
+	}
+
+	// A series whose chunks can't be decoded fails the query.
+	invalidResponse := client.QueryStreamResponse{Chunkseries: append([]client.TimeSeriesChunk{}, response.Chunkseries...)}
+	invalidResponse.Chunkseries[numSeries/2] = client.TimeSeriesChunk{
+		Labels: []cortexpb.LabelAdapter{{Name: labels.MetricName, Value: "invalid"}},
+		Chunks: []client.Chunk{{Encoding: 255}},
+	}
+
+	for _, decodeConcurrency := range []int{0, 1, 8} {
+		t.Run(fmt.Sprintf("decode concurrency: %d", decodeConcurrency), func(t *testing.T) {
+			for _, invalid := range []bool{false, true} {
+				d := &MockDistributor{}
+				if invalid {
+					d.On("QueryStream", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&invalidResponse, nil)
+				} else {
+					d.On("QueryStream", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&response, nil)
+				}
+
+				ctx := user.InjectOrgID(context.Background(), "0")
+				queryable := newDistributorQueryable(d, Config{IngesterStreaming: true, IngesterMetadataStreaming: true, IngesterChunkSeriesDecodeConcurrency: decodeConcurrency}, mergeChunks, nil, nil)
+				querier, err := queryable.Querier(ctx, mint, maxt)
+				require.NoError(t, err)
+
+				seriesSet := querier.Select(true, &storage.SelectHints{Start: mint, End: maxt})
+
+				var names []string
+				for seriesSet.Next() {
+					names = append(names, seriesSet.At().Labels().Get(labels.MetricName))
+				}
+
+				if invalid {
+					require.EqualError(t, seriesSet.Err(), "unknown chunk encoding: 255")
+					assert.Empty(t, names)
+				} else {
+					require.NoError(t, seriesSet.Err())
+					assert.Equal(t, expectedNames, names)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDistributorQuerier_SelectChunkSeriesDecodeConcurrency(b *testing.B) {
+	const (
+		mint            = 0
+		maxt            = 10000
+		numSeries       = 10000
+		chunksPerSeries = 10
+	)
+
+	var samples []cortexpb.Sample
+	for ts := int64(0); ts < 120; ts++ {
+		samples = append(samples, cortexpb.Sample{TimestampMs: ts, Value: float64(ts)})
+	}
+	seriesChunks := convertToChunks(b, samples)
+
+	var response client.QueryStreamResponse
+	for i := 0; i < numSeries; i++ {
+		series := client.TimeSeriesChunk{
+			Labels: []cortexpb.LabelAdapter{{Name: labels.MetricName, Value: "test"}, {Name: "series_id", Value: fmt.Sprintf("%06d", i)}},
+		}
+		for c := 0; c < chunksPerSeries; c++ {
+			series.Chunks = append(series.Chunks, seriesChunks...)
+		}
+		response.Chunkseries = append(response.Chunkseries, series)
+	}
+
+	d := &MockDistributor{}
+	d.On("QueryStream", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(&response, nil)
+
+	ctx := user.InjectOrgID(context.Background(), "0")
+
+	for _, decodeConcurrency := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("decode concurrency: %d", decodeConcurrency), func(b *testing.B) {
+			queryable := newDistributorQueryable(d, Config{IngesterStreaming: true, IngesterMetadataStreaming: true, IngesterChunkSeriesDecodeConcurrency: decodeConcurrency}, mergeChunks, nil, nil)
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for n := 0; n < b.N; n++ {
+				querier, err := queryable.Querier(ctx, mint, maxt)
+				require.NoError(b, err)
+
+				seriesSet := querier.Select(true, &storage.SelectHints{Start: mint, End: maxt})
+				require.NoError(b, seriesSet.Err())
+			}
 		})
 	}
 }
 
-func convertToChunks(t *testing.T, samples []cortexpb.Sample) []client.Chunk {
+func convertToChunks(t testing.TB, samples []cortexpb.Sample) []client.Chunk {
 	// We need to make sure that there is atleast one chunk present,
 	// else no series will be selected.
 	promChunk, err := encoding.NewForEncoding(encoding.PrometheusXorChunk)
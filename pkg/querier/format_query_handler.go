@@ -0,0 +1,31 @@
+package querier
+
+import (
+	"net/http"
+
+	"github.com/prometheus/prometheus/promql/parser"
+
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+type formatQueryResult struct {
+	Status    string `json:"status"`
+	Data      string `json:"data,omitempty"`
+	ErrorType string `json:"errorType,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// FormatQueryHandler formats the PromQL expression of the "query" parameter, like the
+// Prometheus /api/v1/format_query endpoint.
+func FormatQueryHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expr, err := parser.ParseExpr(r.FormValue("query"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			util.WriteJSONResponse(w, formatQueryResult{Status: statusError, ErrorType: "bad_data", Error: err.Error()})
+			return
+		}
+
+		util.WriteJSONResponse(w, formatQueryResult{Status: statusSuccess, Data: expr.String()})
+	})
+}
@@ -0,0 +1,41 @@
+package querier
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatQueryHandler(t *testing.T) {
+	tests := map[string]struct {
+		query          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		"valid query": {
+			query:          `sum(rate(foo{bar="baz"}[5m]))by(job)`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"status":"success","data":"sum by(job) (rate(foo{bar=\"baz\"}[5m]))"}`,
+		},
+		"invalid query": {
+			query:          `sum(`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"status":"error","errorType":"bad_data","error":"1:5: parse error: unclosed left parenthesis"}`,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v1/format_query?"+url.Values{"query": []string{testData.query}}.Encode(), nil)
+			resp := httptest.NewRecorder()
+			FormatQueryHandler().ServeHTTP(resp, req)
+
+			require.Equal(t, testData.expectedStatus, resp.Code)
+			assert.JSONEq(t, testData.expectedBody, resp.Body.String())
+		})
+	}
+}
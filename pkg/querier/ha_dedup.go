@@ -0,0 +1,154 @@
+package querier
+
+import (
+	"sort"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/cortexproject/cortex/pkg/querier/series"
+)
+
+// dedupHAReplicas deduplicates series which only differ by the HA replica label. The distributor
+// usually deduplicates HA pairs at write time, but during failover windows samples from both
+// replicas may reach the ingesters. Only series carrying both the HA cluster and replica labels
+// are deduplicated: each group of replicas is merged into a single series, without the replica
+// label, preferring the samples of the most complete replica.
+func dedupHAReplicas(set storage.SeriesSet, clusterLabel, replicaLabel string) storage.SeriesSet {
+	var (
+		result   []storage.Series
+		groups   = map[string][]storage.Series{}
+		groupLbs = map[string]labels.Labels{}
+	)
+
+	for set.Next() {
+		s := set.At()
+		lbls := s.Labels()
+
+		if lbls.Get(clusterLabel) == "" || lbls.Get(replicaLabel) == "" {
+			result = append(result, s)
+			continue
+		}
+
+		dedupLbls := labels.NewBuilder(lbls).Del(replicaLabel).Labels()
+		key := dedupLbls.String()
+		groups[key] = append(groups[key], s)
+		groupLbs[key] = dedupLbls
+	}
+
+	if err := set.Err(); err != nil {
+		return storage.ErrSeriesSet(err)
+	}
+
+	for key, replicas := range groups {
+		merged, err := mergeHAReplicas(groupLbs[key], replicas)
+		if err != nil {
+			return storage.ErrSeriesSet(err)
+		}
+		result = append(result, merged)
+	}
+
+	// Removing the replica label may change the series order, so we need to sort them again.
+	return series.NewSeriesSetWithWarnings(series.NewConcreteSeriesSet(result), set.Warnings())
+}
+
+// mergeHAReplicas merges the samples of the input replicas into a single series with the given
+// labels. HA replicas scrape the same targets at offset times, so their samples are never
+// interleaved: the replica with the most samples is taken as is, and the samples of the other
+// replicas are only used to fill its gaps, in the style of the Thanos penalty deduplication.
+func mergeHAReplicas(lbls labels.Labels, replicas []storage.Series) (storage.Series, error) {
+	samples := make([][]model.SamplePair, 0, len(replicas))
+	for _, replica := range replicas {
+		var replicaSamples []model.SamplePair
+
+		it := replica.Iterator()
+		for it.Next() {
+			t, v := it.At()
+			replicaSamples = append(replicaSamples, model.SamplePair{Timestamp: model.Time(t), Value: model.SampleValue(v)})
+		}
+		if err := it.Err(); err != nil {
+			return nil, err
+		}
+
+		samples = append(samples, replicaSamples)
+	}
+
+	// Keep the input order on ties, so that the result is stable.
+	sort.SliceStable(samples, func(i, j int) bool {
+		return len(samples[i]) > len(samples[j])
+	})
+
+	// Without an estimate of the scrape interval, we can't tell a gap from the offset between
+	// the replicas, so we only keep the most complete one.
+	interval := estimateScrapeInterval(samples)
+	if interval <= 0 {
+		return series.NewConcreteSeries(lbls, samples[0]), nil
+	}
+
+	merged := samples[0]
+	for _, other := range samples[1:] {
+		merged = fillGaps(merged, other, interval)
+	}
+
+	return series.NewConcreteSeries(lbls, merged), nil
+}
+
+// estimateScrapeInterval returns the median interval between the consecutive samples of the
+// replicas, or 0 if no replica has at least 2 samples.
+func estimateScrapeInterval(samples [][]model.SamplePair) int64 {
+	var deltas []int64
+	for _, replicaSamples := range samples {
+		for i := 1; i < len(replicaSamples); i++ {
+			deltas = append(deltas, int64(replicaSamples[i].Timestamp-replicaSamples[i-1].Timestamp))
+		}
+	}
+	if len(deltas) == 0 {
+		return 0
+	}
+
+	sort.Slice(deltas, func(i, j int) bool { return deltas[i] < deltas[j] })
+	return deltas[len(deltas)/2]
+}
+
+// fillGaps fills the gaps of base with the samples of other. A gap is an interval between two
+// consecutive samples of base larger than 1.5 times the scrape interval, as well as the time
+// before the first and after the last sample of base. Only the samples of other at least half
+// a scrape interval away from the samples of base are used, so that the replicas samples are
+// never interleaved.
+func fillGaps(base, other []model.SamplePair, interval int64) []model.SamplePair {
+	if len(base) == 0 {
+		return other
+	}
+
+	var (
+		out       = make([]model.SamplePair, 0, len(base))
+		margin    = model.Time(interval / 2)
+		threshold = model.Time(interval + interval/2)
+		j         = 0
+	)
+
+	// Before the first sample of base.
+	for ; j < len(other) && other[j].Timestamp < base[0].Timestamp; j++ {
+		if other[j].Timestamp < base[0].Timestamp-margin {
+			out = append(out, other[j])
+		}
+	}
+
+	for i, s := range base {
+		out = append(out, s)
+
+		last := i == len(base)-1
+		gap := last || base[i+1].Timestamp-s.Timestamp > threshold
+		for ; j < len(other) && (last || other[j].Timestamp < base[i+1].Timestamp); j++ {
+			if !gap || other[j].Timestamp <= s.Timestamp+margin {
+				continue
+			}
+			if last || other[j].Timestamp < base[i+1].Timestamp-margin {
+				out = append(out, other[j])
+			}
+		}
+	}
+
+	return out
+}
@@ -1,7 +1,9 @@
 package querier
 
 import (
+	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/cortexproject/cortex/pkg/util"
 )
@@ -24,10 +26,25 @@ type metadataResult struct {
 }
 
 // MetadataHandler returns metric metadata held by Cortex for a given tenant.
-// It is kept and returned as a set.
+// It is kept and returned as a set. Like in Prometheus, the limit parameter caps the number of
+// metrics returned and the limit_per_metric one the number of metadata returned per metric.
 func MetadataHandler(d Distributor) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		resp, err := d.MetricsMetadata(r.Context())
+		limit, err := parseMetadataLimit(r, "limit")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			util.WriteJSONResponse(w, metadataResult{Status: statusError, Error: err.Error()})
+			return
+		}
+
+		limitPerMetric, err := parseMetadataLimit(r, "limit_per_metric")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			util.WriteJSONResponse(w, metadataResult{Status: statusError, Error: err.Error()})
+			return
+		}
+
+		resp, err := d.MetricsMetadata(r.Context(), limit, limitPerMetric)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			util.WriteJSONResponse(w, metadataResult{Status: statusError, Error: err.Error()})
@@ -49,3 +66,17 @@ func MetadataHandler(d Distributor) http.Handler {
 		util.WriteJSONResponse(w, metadataResult{Status: statusSuccess, Data: metrics})
 	})
 }
+
+// parseMetadataLimit returns the value of the given limit parameter, 0 (unlimited) if not set.
+func parseMetadataLimit(r *http.Request, name string) (int, error) {
+	value := r.FormValue(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s parameter %q: %w", name, value, err)
+	}
+	return limit, nil
+}
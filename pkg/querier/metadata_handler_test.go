@@ -14,7 +14,7 @@ import (
 
 func TestMetadataHandler_Success(t *testing.T) {
 	d := &MockDistributor{}
-	d.On("MetricsMetadata", mock.Anything).Return(
+	d.On("MetricsMetadata", mock.Anything, 0, 0).Return(
 		[]scrape.MetricMetadata{
 			{Metric: "alertmanager_dispatcher_aggregation_groups", Help: "Number of active aggregation groups", Type: "gauge", Unit: ""},
 		},
@@ -52,7 +52,7 @@ func TestMetadataHandler_Success(t *testing.T) {
 
 func TestMetadataHandler_Error(t *testing.T) {
 	d := &MockDistributor{}
-	d.On("MetricsMetadata", mock.Anything).Return([]scrape.MetricMetadata{}, fmt.Errorf("no user id"))
+	d.On("MetricsMetadata", mock.Anything, 0, 0).Return([]scrape.MetricMetadata{}, fmt.Errorf("no user id"))
 
 	handler := MetadataHandler(d)
 
@@ -75,3 +75,64 @@ func TestMetadataHandler_Error(t *testing.T) {
 
 	require.JSONEq(t, expectedJSON, string(responseBody))
 }
+
+func TestMetadataHandler_Limits(t *testing.T) {
+	tests := map[string]struct {
+		query                  string
+		expectedLimit          int
+		expectedLimitPerMetric int
+		expectedStatus         int
+		expectedErr            string
+	}{
+		"no limits": {
+			expectedStatus: http.StatusOK,
+		},
+		"limit": {
+			query:          "limit=5",
+			expectedLimit:  5,
+			expectedStatus: http.StatusOK,
+		},
+		"limit and limit per metric": {
+			query:                  "limit=5&limit_per_metric=1",
+			expectedLimit:          5,
+			expectedLimitPerMetric: 1,
+			expectedStatus:         http.StatusOK,
+		},
+		"negative limit": {
+			query:          "limit=-1",
+			expectedLimit:  -1,
+			expectedStatus: http.StatusOK,
+		},
+		"invalid limit": {
+			query:          "limit=foo",
+			expectedStatus: http.StatusBadRequest,
+			expectedErr:    `invalid limit parameter "foo": strconv.Atoi: parsing "foo": invalid syntax`,
+		},
+		"invalid limit per metric": {
+			query:          "limit_per_metric=1.5",
+			expectedStatus: http.StatusBadRequest,
+			expectedErr:    `invalid limit_per_metric parameter "1.5": strconv.Atoi: parsing "1.5": invalid syntax`,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			d := &MockDistributor{}
+			d.On("MetricsMetadata", mock.Anything, testData.expectedLimit, testData.expectedLimitPerMetric).Return([]scrape.MetricMetadata{}, nil)
+
+			request, err := http.NewRequest("GET", "/metadata?"+testData.query, nil)
+			require.NoError(t, err)
+
+			recorder := httptest.NewRecorder()
+			MetadataHandler(d).ServeHTTP(recorder, request)
+			require.Equal(t, testData.expectedStatus, recorder.Result().StatusCode)
+
+			if testData.expectedErr != "" {
+				require.JSONEq(t, fmt.Sprintf(`{"status": "error", "error": %q}`, testData.expectedErr), recorder.Body.String())
+				d.AssertNotCalled(t, "MetricsMetadata", mock.Anything, mock.Anything, mock.Anything)
+				return
+			}
+			d.AssertNumberOfCalls(t, "MetricsMetadata", 1)
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package querier
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQueryHandler(t *testing.T) {
+	tests := map[string]struct {
+		query          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		"valid query": {
+			query:          `sum by(job) (rate(foo{bar="baz"}[5m])) > 1`,
+			expectedStatus: http.StatusOK,
+			expectedBody: `{
+				"status": "success",
+				"data": {
+					"type": "binaryExpr",
+					"op": ">",
+					"bool": false,
+					"matching": null,
+					"lhs": {
+						"type": "aggregation",
+						"op": "sum",
+						"grouping": ["job"],
+						"without": false,
+						"param": null,
+						"expr": {
+							"type": "call",
+							"func": {"name": "rate", "argTypes": ["matrix"], "variadic": 0, "returnType": "vector"},
+							"args": [{
+								"type": "matrixSelector",
+								"name": "foo",
+								"range": 300000,
+								"offset": 0,
+								"timestamp": null,
+								"startOrEnd": null,
+								"matchers": [
+									{"name": "bar", "value": "baz", "type": "="},
+									{"name": "__name__", "value": "foo", "type": "="}
+								]
+							}]
+						}
+					},
+					"rhs": {"type": "numberLiteral", "val": "1"}
+				}
+			}`,
+		},
+		"invalid query": {
+			query:          `sum(`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"status":"error","errorType":"bad_data","error":"1:5: parse error: unclosed left parenthesis"}`,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/v1/parse_query?"+url.Values{"query": []string{testData.query}}.Encode(), nil)
+			resp := httptest.NewRecorder()
+			ParseQueryHandler().ServeHTTP(resp, req)
+
+			require.Equal(t, testData.expectedStatus, resp.Code)
+			assert.JSONEq(t, testData.expectedBody, resp.Body.String())
+		})
+	}
+}
@@ -37,17 +37,29 @@ import (
 
 // Config contains the configuration require to create a querier
 type Config struct {
-	MaxConcurrent             int           `yaml:"max_concurrent"`
-	Timeout                   time.Duration `yaml:"timeout"`
-	Iterators                 bool          `yaml:"iterators"`
-	BatchIterators            bool          `yaml:"batch_iterators"`
-	IngesterStreaming         bool          `yaml:"ingester_streaming"`
-	IngesterMetadataStreaming bool          `yaml:"ingester_metadata_streaming"`
-	MaxSamples                int           `yaml:"max_samples"`
-	QueryIngestersWithin      time.Duration `yaml:"query_ingesters_within"`
-	QueryStoreForLabels       bool          `yaml:"query_store_for_labels_enabled"`
-	AtModifierEnabled         bool          `yaml:"at_modifier_enabled"`
-	EnablePerStepStats        bool          `yaml:"per_step_stats_enabled"`
+	MaxConcurrent                         int           `yaml:"max_concurrent"`
+	Timeout                               time.Duration `yaml:"timeout"`
+	Iterators                             bool          `yaml:"iterators"`
+	BatchIterators                        bool          `yaml:"batch_iterators"`
+	IngesterStreaming                     bool          `yaml:"ingester_streaming"`
+	IngesterMetadataStreaming             bool          `yaml:"ingester_metadata_streaming"`
+	MaxSamples                            int           `yaml:"max_samples"`
+	QueryIngestersWithin                  time.Duration `yaml:"query_ingesters_within"`
+	QueryIngestersWithinHeaderEnabled     bool          `yaml:"query_ingesters_within_header_enabled"`
+	IngesterQuerySplitInterval            time.Duration `yaml:"ingester_query_split_interval"`
+	IngesterCallTimeout                   time.Duration `yaml:"ingester_call_timeout"`
+	IngesterCallTimeoutAsWarning          bool          `yaml:"ingester_call_timeout_as_warning"`
+	HADedupEnabled                        bool          `yaml:"ha_dedup_enabled"`
+	QueryRangeInErrors                    bool          `yaml:"query_range_in_errors_enabled"`
+	RejectSeriesWithoutMatchers           bool          `yaml:"reject_series_without_matchers"`
+	MaxConcurrentMetadataRequestsPerQuery int           `yaml:"max_concurrent_metadata_requests_per_query"`
+	IngesterChunkSeriesDecodeConcurrency  int           `yaml:"ingester_chunk_series_decode_concurrency"`
+	MaxLabelValues                        int           `yaml:"max_label_values"`
+	IngesterStorageBoundaryWarning        bool          `yaml:"ingester_storage_boundary_warning_enabled"`
+	IngesterSkipStaleOnlySeries           bool          `yaml:"ingester_skip_stale_only_series"`
+	QueryStoreForLabels                   bool          `yaml:"query_store_for_labels_enabled"`
+	AtModifierEnabled                     bool          `yaml:"at_modifier_enabled"`
+	EnablePerStepStats                    bool          `yaml:"per_step_stats_enabled"`
 
 	// QueryStoreAfter the time after which queries should also be sent to the store and not just ingesters.
 	QueryStoreAfter    time.Duration `yaml:"query_store_after"`
@@ -72,6 +84,8 @@ type Config struct {
 	StoreGatewayClient    ClientConfig `yaml:"store_gateway_client"`
 
 	ShuffleShardingIngestersLookbackPeriod time.Duration `yaml:"shuffle_sharding_ingesters_lookback_period"`
+
+	MaxIngestersPerQuery int `yaml:"max_ingesters_per_query"`
 }
 
 var (
@@ -91,6 +105,18 @@ func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
 	f.BoolVar(&cfg.IngesterMetadataStreaming, "querier.ingester-metadata-streaming", false, "Use streaming RPCs for metadata APIs from ingester.")
 	f.IntVar(&cfg.MaxSamples, "querier.max-samples", 50e6, "Maximum number of samples a single query can load into memory.")
 	f.DurationVar(&cfg.QueryIngestersWithin, "querier.query-ingesters-within", 0, "Maximum lookback beyond which queries are not sent to ingester. 0 means all queries are sent to ingester.")
+	f.BoolVar(&cfg.QueryIngestersWithinHeaderEnabled, "querier.query-ingesters-within-header-enabled", false, "Allow to override -querier.query-ingesters-within for a single query with the X-Cortex-Query-Ingesters-Within request header. The header value must be greater than -querier.query-store-after, or 0 to send the query to ingesters regardless of its time range. An invalid value is ignored.")
+	f.DurationVar(&cfg.IngesterQuerySplitInterval, "querier.ingester-query-split-interval", 0, "Split queries to ingesters spanning more than this interval into sub-ranges of this length, which are queried concurrently and merged. Only applies when ingester streaming is enabled. 0 disables splitting.")
+	f.DurationVar(&cfg.IngesterCallTimeout, "querier.ingester-call-timeout", 0, "Timeout of each call to ingesters issued by the querier, like the series, label names and label values requests. 0 means no timeout other than the query one.")
+	f.BoolVar(&cfg.IngesterCallTimeoutAsWarning, "querier.ingester-call-timeout-as-warning", false, "Return a warning along with the results fetched from the other sources, instead of failing the query, when a call to ingesters exceeds -querier.ingester-call-timeout.")
+	f.BoolVar(&cfg.HADedupEnabled, "querier.ha-dedup-enabled", false, "Deduplicate series received from ingesters which only differ by the HA replica label, merging their samples into a single series without the replica label. Only series carrying both the tenant's HA cluster and replica labels are deduplicated. Only applies when ingester streaming is enabled.")
+	f.BoolVar(&cfg.QueryRangeInErrors, "querier.query-range-in-errors-enabled", false, "Include the tenant and the queried time range in the errors returned when querying ingesters.")
+	f.BoolVar(&cfg.RejectSeriesWithoutMatchers, "querier.reject-series-without-matchers", false, "Reject series API requests whose matchers select all the series of the tenant, like {__name__!=\"\"}, instead of fetching all of them from ingesters.")
+	f.IntVar(&cfg.MaxConcurrentMetadataRequestsPerQuery, "querier.max-concurrent-metadata-requests-per-query", 0, "Maximum number of concurrent series, label names and label values requests to ingesters issued by a single query. The exceeding requests are queued. 0 to disable the limit.")
+	f.IntVar(&cfg.IngesterChunkSeriesDecodeConcurrency, "querier.ingester-chunk-series-decode-concurrency", 1, "Maximum number of chunk series received from ingesters decoded concurrently by a single query. Only applies when ingester streaming is enabled. 1 to decode them serially.")
+	f.IntVar(&cfg.MaxLabelValues, "querier.max-label-values", 0, "Maximum number of unique label values a single label values request can receive from ingesters. When set, the label values are streamed from ingesters and the request fails as soon as the limit is exceeded, instead of loading all of them in memory first. 0 to disable the limit.")
+	f.BoolVar(&cfg.IngesterStorageBoundaryWarning, "querier.ingester-storage-boundary-warning-enabled", false, "Return a warning for queries whose time range spans the boundary between the data queried from ingesters and the long-term storage, set by -querier.query-ingesters-within.")
+	f.BoolVar(&cfg.IngesterSkipStaleOnlySeries, "querier.ingester-skip-stale-only-series", false, "Skip the series received from ingesters which only have stale markers within the queried time range. Only applies when ingester streaming is enabled.")
 	f.BoolVar(&cfg.QueryStoreForLabels, "querier.query-store-for-labels-enabled", false, "Query long-term store for series, label values and label names APIs. Works only with blocks engine.")
 	f.BoolVar(&cfg.AtModifierEnabled, "querier.at-modifier-enabled", false, "Enable the @ modifier in PromQL.")
 	f.BoolVar(&cfg.EnablePerStepStats, "querier.per-step-stats-enabled", false, "Enable returning samples stats per steps in query response.")
@@ -101,6 +127,7 @@ func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
 	f.StringVar(&cfg.StoreGatewayAddresses, "querier.store-gateway-addresses", "", "Comma separated list of store-gateway addresses in DNS Service Discovery format. This option should be set when using the blocks storage and the store-gateway sharding is disabled (when enabled, the store-gateway instances form a ring and addresses are picked from the ring).")
 	f.DurationVar(&cfg.LookbackDelta, "querier.lookback-delta", 5*time.Minute, "Time since the last sample after which a time series is considered stale and ignored by expression evaluations.")
 	f.DurationVar(&cfg.ShuffleShardingIngestersLookbackPeriod, "querier.shuffle-sharding-ingesters-lookback-period", 0, "When distributor's sharding strategy is shuffle-sharding and this setting is > 0, queriers fetch in-memory series from the minimum set of required ingesters, selecting only ingesters which may have received series since 'now - lookback period'. The lookback period should be greater or equal than the configured 'query store after' and 'query ingesters within'. If this setting is 0, queriers always query all ingesters (ingesters shuffle sharding on read path is disabled).")
+	f.IntVar(&cfg.MaxIngestersPerQuery, "querier.max-ingesters-per-query", 0, "Maximum number of ingesters a single query can fan out to. Queries which would be sent to more ingesters are rejected. 0 to disable.")
 }
 
 // Validate the config
@@ -138,11 +165,11 @@ func getChunksIteratorFunction(cfg Config) chunkIteratorFunc {
 	return mergeChunks
 }
 
-// New builds a queryable and promql engine.
+// New builds a queryable and promql engine. The returned queryable also implements QueryPlanner.
 func New(cfg Config, limits *validation.Overrides, distributor Distributor, stores []QueryableWithFilter, tombstonesLoader purger.TombstonesLoader, reg prometheus.Registerer, logger log.Logger) (storage.SampleAndChunkQueryable, storage.ExemplarQueryable, *promql.Engine) {
 	iteratorFunc := getChunksIteratorFunction(cfg)
 
-	distributorQueryable := newDistributorQueryable(distributor, cfg.IngesterStreaming, cfg.IngesterMetadataStreaming, iteratorFunc, cfg.QueryIngestersWithin)
+	distributorQueryable := newDistributorQueryable(distributor, cfg, iteratorFunc, limits, reg)
 
 	ns := make([]QueryableWithFilter, len(stores))
 	for ix, s := range stores {
@@ -152,7 +179,7 @@ func New(cfg Config, limits *validation.Overrides, distributor Distributor, stor
 		}
 	}
 	queryable := NewQueryable(distributorQueryable, ns, iteratorFunc, cfg, limits, tombstonesLoader)
-	exemplarQueryable := newDistributorExemplarQueryable(distributor)
+	exemplarQueryable := newDistributorExemplarQueryable(distributor, cfg.QueryIngestersWithin)
 
 	lazyQueryable := storage.QueryableFunc(func(ctx context.Context, mint int64, maxt int64) (storage.Querier, error) {
 		querier, err := queryable.Querier(ctx, mint, maxt)
@@ -175,7 +202,8 @@ func New(cfg Config, limits *validation.Overrides, distributor Distributor, stor
 			return cfg.DefaultEvaluationInterval.Milliseconds()
 		},
 	})
-	return NewSampleAndChunkQueryable(lazyQueryable), exemplarQueryable, engine
+	planner := queryPlanner{engine: engine, distributor: distributorQueryable, stores: ns}
+	return plannedSampleAndChunkQueryable{NewSampleAndChunkQueryable(lazyQueryable), planner}, exemplarQueryable, engine
 }
 
 // NewSampleAndChunkQueryable creates a SampleAndChunkQueryable from a
@@ -211,6 +239,20 @@ type QueryableWithFilter interface {
 	UseQueryable(now time.Time, queryMinT, queryMaxT int64) bool
 }
 
+// contextQueryableWithFilter is implemented by the queryables whose filter depends on the query
+// context too, like the distributor queryable honoring a per-query QueryIngestersWithin.
+type contextQueryableWithFilter interface {
+	useQueryableWithContext(ctx context.Context, now time.Time, queryMinT, queryMaxT int64) bool
+}
+
+// useQueryable returns true if the queryable should be used to satisfy the query for given time range.
+func useQueryable(ctx context.Context, q QueryableWithFilter, now time.Time, queryMinT, queryMaxT int64) bool {
+	if cq, ok := q.(contextQueryableWithFilter); ok {
+		return cq.useQueryableWithContext(ctx, now, queryMinT, queryMaxT)
+	}
+	return q.UseQueryable(now, queryMinT, queryMaxT)
+}
+
 // NewQueryable creates a new Queryable for cortex.
 func NewQueryable(distributor QueryableWithFilter, stores []QueryableWithFilter, chunkIterFn chunkIteratorFunc, cfg Config, limits *validation.Overrides, tombstonesLoader purger.TombstonesLoader) storage.Queryable {
 	return storage.QueryableFunc(func(ctx context.Context, mint, maxt int64) (storage.Querier, error) {
@@ -248,7 +290,7 @@ func NewQueryable(distributor QueryableWithFilter, stores []QueryableWithFilter,
 
 		q.metadataQuerier = dqr
 
-		if distributor.UseQueryable(now, mint, maxt) {
+		if useQueryable(ctx, distributor, now, mint, maxt) {
 			q.queriers = append(q.queriers, dqr)
 		}
 
@@ -287,8 +329,9 @@ type querier struct {
 }
 
 // Select implements storage.Querier interface.
-// The bool passed is ignored because the series is always sorted.
-func (q querier) Select(_ bool, sp *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+// The sortSeries bool is honored only when querying a single querier, because merging the
+// series of several queriers requires them to be sorted.
+func (q querier) Select(sortSeries bool, sp *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
 	log, ctx := spanlogger.New(q.ctx, "querier.Select")
 	defer log.Span.Finish()
 
@@ -347,7 +390,7 @@ func (q querier) Select(_ bool, sp *storage.SelectHints, matchers ...*labels.Mat
 	}
 
 	if len(q.queriers) == 1 {
-		seriesSet := q.queriers[0].Select(true, sp, matchers...)
+		seriesSet := q.queriers[0].Select(sortSeries, sp, matchers...)
 
 		if tombstones.Len() != 0 {
 			seriesSet = series.NewDeletedSeriesSet(seriesSet, tombstones, model.Interval{Start: startTime, End: endTime})
@@ -483,6 +526,7 @@ func (q querier) mergeSeriesSets(sets []storage.SeriesSet) storage.SeriesSet {
 
 	otherSets := []storage.SeriesSet(nil)
 	chunks := []chunk.Chunk(nil)
+	warnings := storage.Warnings(nil)
 
 	for _, set := range sets {
 		nonChunkSeries := []storage.Series(nil)
@@ -503,8 +547,14 @@ func (q querier) mergeSeriesSets(sets []storage.SeriesSet) storage.SeriesSet {
 		} else if len(nonChunkSeries) > 0 {
 			otherSets = append(otherSets, &sliceSeriesSet{series: nonChunkSeries, ix: -1})
 		}
+
+		warnings = append(warnings, set.Warnings()...)
 	}
 
+	return withWarnings(q.mergeSets(otherSets, chunks), warnings)
+}
+
+func (q querier) mergeSets(otherSets []storage.SeriesSet, chunks []chunk.Chunk) storage.SeriesSet {
 	if len(chunks) == 0 {
 		return storage.NewMergeSeriesSet(otherSets, storage.ChainedSeriesMerge)
 	}
@@ -520,6 +570,15 @@ func (q querier) mergeSeriesSets(sets []storage.SeriesSet) storage.SeriesSet {
 	return storage.NewMergeSeriesSet(otherSets, storage.ChainedSeriesMerge)
 }
 
+// withWarnings preserves the warnings of the merged sets, which are dropped once the
+// sets have been consumed to build the merged one.
+func withWarnings(set storage.SeriesSet, warnings storage.Warnings) storage.SeriesSet {
+	if len(warnings) == 0 {
+		return set
+	}
+	return series.NewSeriesSetWithWarnings(set, warnings)
+}
+
 type sliceSeriesSet struct {
 	series []storage.Series
 	ix     int
@@ -34,6 +34,7 @@ import (
 	"github.com/cortexproject/cortex/pkg/prom1/storage/metric"
 	"github.com/cortexproject/cortex/pkg/querier/batch"
 	"github.com/cortexproject/cortex/pkg/querier/iterators"
+	"github.com/cortexproject/cortex/pkg/querier/series"
 	"github.com/cortexproject/cortex/pkg/util"
 	"github.com/cortexproject/cortex/pkg/util/chunkcompat"
 	"github.com/cortexproject/cortex/pkg/util/flagext"
@@ -780,6 +781,9 @@ func (m *errDistributor) LabelValuesForLabelName(context.Context, model.Time, mo
 func (m *errDistributor) LabelValuesForLabelNameStream(context.Context, model.Time, model.Time, model.LabelName, ...*labels.Matcher) ([]string, error) {
 	return nil, errDistributorError
 }
+func (m *errDistributor) LabelValuesForLabelNameIterator(context.Context, model.Time, model.Time, model.LabelName, func([]string) bool, ...*labels.Matcher) error {
+	return errDistributorError
+}
 func (m *errDistributor) LabelNames(context.Context, model.Time, model.Time) ([]string, error) {
 	return nil, errDistributorError
 }
@@ -792,8 +796,11 @@ func (m *errDistributor) MetricsForLabelMatchers(ctx context.Context, from, thro
 func (m *errDistributor) MetricsForLabelMatchersStream(ctx context.Context, from, through model.Time, matchers ...*labels.Matcher) ([]metric.Metric, error) {
 	return nil, errDistributorError
 }
+func (m *errDistributor) MetricsForLabelMatchersIterator(context.Context, model.Time, model.Time, func([]metric.Metric) bool, ...*labels.Matcher) error {
+	return errDistributorError
+}
 
-func (m *errDistributor) MetricsMetadata(ctx context.Context) ([]scrape.MetricMetadata, error) {
+func (m *errDistributor) MetricsMetadata(ctx context.Context, limit, limitPerMetric int) ([]scrape.MetricMetadata, error) {
 	return nil, errDistributorError
 }
 
@@ -837,6 +844,10 @@ func (d *emptyDistributor) LabelValuesForLabelNameStream(context.Context, model.
 	return nil, nil
 }
 
+func (d *emptyDistributor) LabelValuesForLabelNameIterator(context.Context, model.Time, model.Time, model.LabelName, func([]string) bool, ...*labels.Matcher) error {
+	return nil
+}
+
 func (d *emptyDistributor) LabelNames(context.Context, model.Time, model.Time) ([]string, error) {
 	return nil, nil
 }
@@ -853,7 +864,11 @@ func (d *emptyDistributor) MetricsForLabelMatchersStream(ctx context.Context, fr
 	return nil, nil
 }
 
-func (d *emptyDistributor) MetricsMetadata(ctx context.Context) ([]scrape.MetricMetadata, error) {
+func (d *emptyDistributor) MetricsForLabelMatchersIterator(context.Context, model.Time, model.Time, func([]metric.Metric) bool, ...*labels.Matcher) error {
+	return nil
+}
+
+func (d *emptyDistributor) MetricsMetadata(ctx context.Context, limit, limitPerMetric int) ([]scrape.MetricMetadata, error) {
 	return nil, nil
 }
 
@@ -925,6 +940,21 @@ func (q *mockStoreQuerier) Close() error {
 	return nil
 }
 
+func TestQuerier_MergeSeriesSetsPreservesWarnings(t *testing.T) {
+	q := querier{}
+	ingestersWarning := errors.New("ingesters warning")
+	storageWarning := errors.New("storage warning")
+
+	set := q.mergeSeriesSets([]storage.SeriesSet{
+		series.NewSeriesSetWithWarnings(storage.EmptySeriesSet(), storage.Warnings{ingestersWarning}),
+		series.NewSeriesSetWithWarnings(storage.EmptySeriesSet(), storage.Warnings{storageWarning}),
+	})
+
+	require.False(t, set.Next())
+	require.NoError(t, set.Err())
+	assert.Equal(t, storage.Warnings{ingestersWarning, storageWarning}, set.Warnings())
+}
+
 func TestShortTermQueryToLTS(t *testing.T) {
 	testCases := []struct {
 		name                 string
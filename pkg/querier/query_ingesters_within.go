@@ -0,0 +1,72 @@
+package querier
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/common/model"
+	"github.com/weaveworks/common/middleware"
+
+	util_log "github.com/cortexproject/cortex/pkg/util/log"
+)
+
+// QueryIngestersWithinHeader is the request header overriding -querier.query-ingesters-within
+// for a single query, like backfill or debug queries which need to hit ingesters for older
+// time ranges too. It's only honored if -querier.query-ingesters-within-header-enabled is set.
+const QueryIngestersWithinHeader = "X-Cortex-Query-Ingesters-Within"
+
+type queryIngestersWithinContextKey int
+
+const queryIngestersWithinKey queryIngestersWithinContextKey = 0
+
+// InjectQueryIngestersWithin returns a derived context overriding the max lookback beyond which
+// the query is not sent to ingesters. 0 means the query is always sent to ingesters.
+func InjectQueryIngestersWithin(ctx context.Context, queryIngestersWithin time.Duration) context.Context {
+	return context.WithValue(ctx, queryIngestersWithinKey, queryIngestersWithin)
+}
+
+// queryIngestersWithinFromContext returns the override carried by the context, or the
+// configured value if none.
+func queryIngestersWithinFromContext(ctx context.Context, configured time.Duration) time.Duration {
+	if queryIngestersWithin, ok := ctx.Value(queryIngestersWithinKey).(time.Duration); ok {
+		return queryIngestersWithin
+	}
+	return configured
+}
+
+// NewQueryIngestersWithinMiddleware returns a middleware overriding the max lookback beyond which
+// the query is not sent to ingesters with the value of the X-Cortex-Query-Ingesters-Within header,
+// if any. An invalid value, or one which is not greater than queryStoreAfter (the query could be
+// sent neither to ingesters nor to the store), is logged and ignored, so the query falls back to
+// the configured -querier.query-ingesters-within.
+func NewQueryIngestersWithinMiddleware(queryStoreAfter time.Duration, logger log.Logger) middleware.Interface {
+	return middleware.Func(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			value := r.Header.Get(QueryIngestersWithinHeader)
+			if value == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			parsed, err := model.ParseDuration(value)
+			if err != nil {
+				level.Warn(util_log.WithContext(r.Context(), logger)).Log("msg", "ignoring invalid "+QueryIngestersWithinHeader+" header", "value", value, "err", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			queryIngestersWithin := time.Duration(parsed)
+			if queryIngestersWithin != 0 && queryStoreAfter != 0 && queryIngestersWithin <= queryStoreAfter {
+				level.Warn(util_log.WithContext(r.Context(), logger)).Log("msg", "ignoring invalid "+QueryIngestersWithinHeader+" header", "value", value, "err", fmt.Sprintf("the value must be greater than query_store_after (%s)", queryStoreAfter))
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(InjectQueryIngestersWithin(r.Context(), queryIngestersWithin)))
+		})
+	})
+}
@@ -0,0 +1,79 @@
+package querier
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryIngestersWithinMiddleware(t *testing.T) {
+	const configured = 13 * time.Hour
+
+	tests := map[string]struct {
+		header          string
+		queryStoreAfter time.Duration
+		expected        time.Duration
+	}{
+		"no header": {
+			expected: configured,
+		},
+		"valid duration": {
+			header:   "48h",
+			expected: 48 * time.Hour,
+		},
+		"valid Prometheus duration": {
+			header:   "2d",
+			expected: 48 * time.Hour,
+		},
+		"zero duration": {
+			header:          "0s",
+			queryStoreAfter: time.Hour,
+			expected:        0,
+		},
+		"duration greater than query store after": {
+			header:          "2h",
+			queryStoreAfter: time.Hour,
+			expected:        2 * time.Hour,
+		},
+		"duration equal to query store after": {
+			header:          "1h",
+			queryStoreAfter: time.Hour,
+			expected:        configured,
+		},
+		"duration lower than query store after": {
+			header:          "30m",
+			queryStoreAfter: time.Hour,
+			expected:        configured,
+		},
+		"invalid duration": {
+			header:   "forever",
+			expected: configured,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			called := false
+			var actual time.Duration
+			handler := NewQueryIngestersWithinMiddleware(testData.queryStoreAfter, log.NewNopLogger()).Wrap(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				called = true
+				actual = queryIngestersWithinFromContext(r.Context(), configured)
+			}))
+
+			req := httptest.NewRequest("GET", "/api/v1/query", nil)
+			if testData.header != "" {
+				req.Header.Set(QueryIngestersWithinHeader, testData.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.True(t, called)
+			assert.Equal(t, testData.expected, actual)
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package querier
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+var errQueryPlanNotSupported = errors.New("query analysis is not supported by this querier")
+
+// QueryPlanner is implemented by queryables able to explain how a query would be
+// executed, without fetching any sample.
+type QueryPlanner interface {
+	QueryPlan(ctx context.Context, qs string, ts time.Time) (*QueryPlan, error)
+}
+
+// QueryPlan describes how an instant query would be executed.
+type QueryPlan struct {
+	Query     string         `json:"query"`
+	Time      time.Time      `json:"time"`
+	Selectors []SelectorPlan `json:"selectors"`
+}
+
+// SelectorPlan describes which time range is selected by a series selector of the
+// query and where the series would be fetched from.
+type SelectorPlan struct {
+	Matchers       string    `json:"matchers"`
+	Start          time.Time `json:"start"`
+	End            time.Time `json:"end"`
+	QueryIngesters bool      `json:"queryIngesters"`
+	QueryStore     bool      `json:"queryStore"`
+}
+
+type queryPlanner struct {
+	engine      *promql.Engine
+	distributor QueryableWithFilter
+	stores      []QueryableWithFilter
+}
+
+// QueryPlan runs the query against a queryable which records the series selections
+// done by the PromQL engine without returning any series, and then checks which
+// queryables would be used for each of them.
+func (p queryPlanner) QueryPlan(ctx context.Context, qs string, ts time.Time) (*QueryPlan, error) {
+	recorder := &selectRecorder{}
+
+	q, err := p.engine.NewInstantQuery(recorder, nil, qs, ts)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+
+	if res := q.Exec(ctx); res.Err != nil {
+		return nil, res.Err
+	}
+
+	now := time.Now()
+	plan := &QueryPlan{Query: qs, Time: ts, Selectors: []SelectorPlan{}}
+	for _, s := range recorder.selects {
+		selector := SelectorPlan{
+			Matchers:       util.LabelMatchersToString(s.matchers),
+			Start:          util.TimeFromMillis(s.start),
+			End:            util.TimeFromMillis(s.end),
+			QueryIngesters: useQueryable(ctx, p.distributor, now, s.start, s.end),
+		}
+		for _, store := range p.stores {
+			if store.UseQueryable(now, s.start, s.end) {
+				selector.QueryStore = true
+				break
+			}
+		}
+		plan.Selectors = append(plan.Selectors, selector)
+	}
+
+	return plan, nil
+}
+
+type recordedSelect struct {
+	start, end int64
+	matchers   []*labels.Matcher
+}
+
+// selectRecorder is a storage.Queryable recording the Select calls and returning no series.
+type selectRecorder struct {
+	mtx     sync.Mutex
+	selects []recordedSelect
+}
+
+func (r *selectRecorder) Querier(_ context.Context, mint, maxt int64) (storage.Querier, error) {
+	return &selectRecorderQuerier{recorder: r, mint: mint, maxt: maxt}, nil
+}
+
+type selectRecorderQuerier struct {
+	recorder   *selectRecorder
+	mint, maxt int64
+}
+
+func (q *selectRecorderQuerier) Select(_ bool, sp *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	s := recordedSelect{start: q.mint, end: q.maxt, matchers: matchers}
+	if sp != nil {
+		s.start, s.end = sp.Start, sp.End
+	}
+
+	q.recorder.mtx.Lock()
+	q.recorder.selects = append(q.recorder.selects, s)
+	q.recorder.mtx.Unlock()
+
+	return storage.EmptySeriesSet()
+}
+
+func (q *selectRecorderQuerier) LabelValues(string, ...*labels.Matcher) ([]string, storage.Warnings, error) {
+	return nil, nil, nil
+}
+
+func (q *selectRecorderQuerier) LabelNames(...*labels.Matcher) ([]string, storage.Warnings, error) {
+	return nil, nil, nil
+}
+
+func (q *selectRecorderQuerier) Close() error {
+	return nil
+}
+
+// plannedSampleAndChunkQueryable is a storage.SampleAndChunkQueryable which is also
+// able to explain how queries would be executed.
+type plannedSampleAndChunkQueryable struct {
+	storage.SampleAndChunkQueryable
+	QueryPlanner
+}
+
+type queryPlanResult struct {
+	Status string     `json:"status"`
+	Data   *QueryPlan `json:"data,omitempty"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// AnalyzeQueryHandler serves instant queries with the analyze=true parameter returning
+// the query execution plan instead of its result. Any other request is passed to next.
+func AnalyzeQueryHandler(queryable storage.SampleAndChunkQueryable, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("analyze") != "true" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		planner, ok := queryable.(QueryPlanner)
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			util.WriteJSONResponse(w, queryPlanResult{Status: statusError, Error: errQueryPlanNotSupported.Error()})
+			return
+		}
+
+		ts := time.Now()
+		if t := r.FormValue("time"); t != "" {
+			ms, err := util.ParseTime(t)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				util.WriteJSONResponse(w, queryPlanResult{Status: statusError, Error: err.Error()})
+				return
+			}
+			ts = util.TimeFromMillis(ms)
+		}
+
+		plan, err := planner.QueryPlan(r.Context(), r.FormValue("query"), ts)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			util.WriteJSONResponse(w, queryPlanResult{Status: statusError, Error: err.Error()})
+			return
+		}
+
+		util.WriteJSONResponse(w, queryPlanResult{Status: statusSuccess, Data: plan})
+	})
+}
@@ -0,0 +1,105 @@
+package querier
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/chunk/purger"
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/validation"
+)
+
+func TestAnalyzeQueryHandler(t *testing.T) {
+	var cfg Config
+	flagext.DefaultValues(&cfg)
+	cfg.QueryIngestersWithin = 2 * time.Hour
+	cfg.QueryStoreAfter = time.Hour
+
+	overrides, err := validation.NewOverrides(DefaultLimitsConfig(), nil)
+	require.NoError(t, err)
+
+	// The distributor and the store must never be queried while analyzing a query.
+	distributor := &MockDistributor{}
+	store := UseAlwaysQueryable(storage.QueryableFunc(func(_ context.Context, _, _ int64) (storage.Querier, error) {
+		t.Fatal("the store should not be queried")
+		return nil, nil
+	}))
+
+	queryable, _, _ := New(cfg, overrides, distributor, []QueryableWithFilter{store}, purger.NewNoopTombstonesLoader(), nil, log.NewNopLogger())
+
+	nextCalled := false
+	handler := AnalyzeQueryHandler(queryable, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nextCalled = true
+	}))
+
+	ts := time.Now().Truncate(time.Second)
+	params := url.Values{
+		"query":   []string{"rate(foo[5m]) + bar offset 3h"},
+		"time":    []string{strconv.FormatInt(ts.Unix(), 10)},
+		"analyze": []string{"true"},
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/query?"+params.Encode(), nil)
+	req = req.WithContext(user.InjectOrgID(req.Context(), "user-1"))
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	require.False(t, nextCalled)
+
+	var result struct {
+		Status string    `json:"status"`
+		Data   QueryPlan `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &result))
+	assert.Equal(t, statusSuccess, result.Status)
+	require.Len(t, result.Data.Selectors, 2)
+
+	// The recent selector is only fetched from ingesters.
+	foo := result.Data.Selectors[0]
+	assert.Equal(t, `{__name__="foo"}`, foo.Matchers)
+	assert.Equal(t, util.TimeToMillis(ts.Add(-5*time.Minute)), util.TimeToMillis(foo.Start))
+	assert.Equal(t, util.TimeToMillis(ts), util.TimeToMillis(foo.End))
+	assert.True(t, foo.QueryIngesters)
+	assert.False(t, foo.QueryStore)
+
+	// The selector with the offset is only fetched from the store.
+	bar := result.Data.Selectors[1]
+	assert.Equal(t, `{__name__="bar"}`, bar.Matchers)
+	assert.Equal(t, util.TimeToMillis(ts.Add(-3*time.Hour-cfg.LookbackDelta)), util.TimeToMillis(bar.Start))
+	assert.Equal(t, util.TimeToMillis(ts.Add(-3*time.Hour)), util.TimeToMillis(bar.End))
+	assert.False(t, bar.QueryIngesters)
+	assert.True(t, bar.QueryStore)
+
+	// Requests without the analyze parameter are passed through.
+	params.Del("analyze")
+	req = httptest.NewRequest("GET", "/api/v1/query?"+params.Encode(), nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	require.True(t, nextCalled)
+}
+
+func TestAnalyzeQueryHandler_NotSupported(t *testing.T) {
+	handler := AnalyzeQueryHandler(NewSampleAndChunkQueryable(storage.QueryableFunc(func(_ context.Context, _, _ int64) (storage.Querier, error) {
+		return storage.NoopQuerier(), nil
+	})), http.NotFoundHandler())
+
+	req := httptest.NewRequest("GET", "/api/v1/query?analyze=true&query=foo", nil)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Contains(t, resp.Body.String(), errQueryPlanNotSupported.Error())
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/weaveworks/common/httpgrpc"
 
 	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/querier"
 	"github.com/cortexproject/cortex/pkg/util"
 	"github.com/cortexproject/cortex/pkg/util/spanlogger"
 )
@@ -253,6 +254,11 @@ func (prometheusCodec) DecodeRequest(_ context.Context, r *http.Request, forward
 		}
 	}
 
+	// The results of a query overriding the ingesters lookback must not be shared with the other queries.
+	if r.Header.Get(querier.QueryIngestersWithinHeader) != "" {
+		result.CachingOptions.Disabled = true
+	}
+
 	return &result, nil
 }
 
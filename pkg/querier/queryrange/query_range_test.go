@@ -17,6 +17,7 @@ import (
 	"github.com/weaveworks/common/user"
 
 	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/querier"
 )
 
 func TestRequest(t *testing.T) {
@@ -82,6 +83,20 @@ func TestRequest(t *testing.T) {
 	}
 }
 
+func TestRequestShouldDisableCachingWhenOverridingQueryIngestersWithin(t *testing.T) {
+	for header, expectedDisabled := range map[string]bool{"": false, "48h": true} {
+		r, err := http.NewRequest("GET", query, nil)
+		require.NoError(t, err)
+		if header != "" {
+			r.Header.Set(querier.QueryIngestersWithinHeader, header)
+		}
+
+		req, err := PrometheusCodec.DecodeRequest(context.Background(), r, nil)
+		require.NoError(t, err)
+		assert.Equal(t, expectedDisabled, req.GetCachingOptions().Disabled)
+	}
+}
+
 func TestResponse(t *testing.T) {
 	r := *parsedResponse
 	r.Headers = respHeaders
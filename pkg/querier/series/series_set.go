@@ -38,6 +38,12 @@ type ConcreteSeriesSet struct {
 // Series will be sorted by labels.
 func NewConcreteSeriesSet(series []storage.Series) storage.SeriesSet {
 	sort.Sort(byLabels(series))
+	return NewUnsortedConcreteSeriesSet(series)
+}
+
+// NewUnsortedConcreteSeriesSet instantiates an in-memory series set from a series,
+// preserving their order.
+func NewUnsortedConcreteSeriesSet(series []storage.Series) storage.SeriesSet {
 	return &ConcreteSeriesSet{
 		cur:    -1,
 		series: series,
@@ -153,6 +159,16 @@ func (e errIterator) Err() error {
 // MatrixToSeriesSet creates a storage.SeriesSet from a model.Matrix
 // Series will be sorted by labels.
 func MatrixToSeriesSet(m model.Matrix) storage.SeriesSet {
+	return NewConcreteSeriesSet(matrixToSeries(m))
+}
+
+// MatrixToUnsortedSeriesSet creates a storage.SeriesSet from a model.Matrix, preserving
+// the order of the series.
+func MatrixToUnsortedSeriesSet(m model.Matrix) storage.SeriesSet {
+	return NewUnsortedConcreteSeriesSet(matrixToSeries(m))
+}
+
+func matrixToSeries(m model.Matrix) []storage.Series {
 	series := make([]storage.Series, 0, len(m))
 	for _, ss := range m {
 		series = append(series, &ConcreteSeries{
@@ -160,7 +176,7 @@ func MatrixToSeriesSet(m model.Matrix) storage.SeriesSet {
 			samples: ss.Values,
 		})
 	}
-	return NewConcreteSeriesSet(series)
+	return series
 }
 
 // MetricsToSeriesSet creates a storage.SeriesSet from a []metric.Metric
@@ -230,7 +246,7 @@ func (d DeletedSeriesSet) Err() error {
 }
 
 func (d DeletedSeriesSet) Warnings() storage.Warnings {
-	return nil
+	return d.seriesSet.Warnings()
 }
 
 type DeletedSeries struct {
@@ -0,0 +1,78 @@
+package querier
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// seriesResponse is the response of the series API.
+type seriesResponse struct {
+	Status    string              `json:"status"`
+	Data      []map[string]string `json:"data"`
+	ErrorType string              `json:"errorType,omitempty"`
+	Error     string              `json:"error,omitempty"`
+	Warnings  []string            `json:"warnings,omitempty"`
+}
+
+// seriesSortResponseWriter buffers the response body, so that the series can be sorted
+// before being written to the client.
+type seriesSortResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (w *seriesSortResponseWriter) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+}
+
+func (w *seriesSortResponseWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+// SeriesSortHandler sorts the series returned by the series API by the value of the label
+// in the sort_by parameter, if any, instead of by their labels. The series missing the label
+// are returned last, and the series with the same value are kept sorted by their labels.
+// The series are sorted once merged, so the order holds for multiple match[] selectors too.
+func SeriesSortHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.FormValue("sort_by")
+		if name == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rw := &seriesSortResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(rw, r)
+
+		body := rw.body.Bytes()
+		if rw.statusCode == http.StatusOK {
+			var resp seriesResponse
+			if err := json.Unmarshal(body, &resp); err == nil {
+				sortSeriesByLabel(resp.Data, name)
+				if sorted, err := json.Marshal(resp); err == nil {
+					body = sorted
+				}
+			}
+		}
+
+		if w.Header().Get("Content-Length") != "" {
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		}
+		w.WriteHeader(rw.statusCode)
+		_, _ = w.Write(body)
+	})
+}
+
+func sortSeriesByLabel(series []map[string]string, name string) {
+	sort.SliceStable(series, func(i, j int) bool {
+		vi, vj := series[i][name], series[j][name]
+		if vi == "" || vj == "" {
+			return vj == "" && vi != ""
+		}
+		return vi < vj
+	})
+}
@@ -0,0 +1,101 @@
+package querier
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/user"
+
+	"github.com/cortexproject/cortex/pkg/prom1/storage/metric"
+)
+
+func TestSeriesSortHandler(t *testing.T) {
+	var (
+		series1 = model.Metric{model.MetricNameLabel: "up", "job": "a", "pod": "pod-2"}
+		series2 = model.Metric{model.MetricNameLabel: "up", "job": "a"}
+		series3 = model.Metric{model.MetricNameLabel: "up", "job": "b", "pod": "pod-1"}
+		series4 = model.Metric{model.MetricNameLabel: "up", "job": "b", "pod": "pod-3"}
+	)
+
+	matcherName := func(name string) interface{} {
+		return mock.MatchedBy(func(matchers []*labels.Matcher) bool {
+			for _, m := range matchers {
+				if m.Name == name {
+					return true
+				}
+			}
+			return false
+		})
+	}
+
+	// The two selectors overlap on the first series.
+	d := &MockDistributor{}
+	d.On("MetricsForLabelMatchers", mock.Anything, mock.Anything, mock.Anything, matcherName("job")).Return([]metric.Metric{
+		{Metric: series1}, {Metric: series2},
+	}, nil)
+	d.On("MetricsForLabelMatchers", mock.Anything, mock.Anything, mock.Anything, matcherName("pod")).Return([]metric.Metric{
+		{Metric: series4}, {Metric: series3}, {Metric: series1},
+	}, nil)
+
+	tests := map[string]struct {
+		sortLabel    string
+		expectedBody string
+	}{
+		"default order": {
+			expectedBody: `{"status":"success","data":[
+				{"__name__":"up","job":"a"},
+				{"__name__":"up","job":"a","pod":"pod-2"},
+				{"__name__":"up","job":"b","pod":"pod-1"},
+				{"__name__":"up","job":"b","pod":"pod-3"}
+			]}`,
+		},
+		"sorted by label": {
+			sortLabel: "pod",
+			expectedBody: `{"status":"success","data":[
+				{"__name__":"up","job":"b","pod":"pod-1"},
+				{"__name__":"up","job":"a","pod":"pod-2"},
+				{"__name__":"up","job":"b","pod":"pod-3"},
+				{"__name__":"up","job":"a"}
+			]}`,
+		},
+		"sorted by a label missing from all the series": {
+			sortLabel: "namespace",
+			expectedBody: `{"status":"success","data":[
+				{"__name__":"up","job":"a"},
+				{"__name__":"up","job":"a","pod":"pod-2"},
+				{"__name__":"up","job":"b","pod":"pod-1"},
+				{"__name__":"up","job":"b","pod":"pod-3"}
+			]}`,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			ctx := user.InjectOrgID(context.Background(), "test")
+			querier, err := newDistributorQueryable(d, Config{}, nil, nil, nil).Querier(ctx, 0, 10000)
+			require.NoError(t, err)
+			handler := SeriesSortHandler(createPrometheusAPI(errorTestQueryable{q: querier}))
+
+			params := url.Values{"match[]": []string{`up{job="a"}`, `up{pod=~"pod-.+"}`}}
+			if testData.sortLabel != "" {
+				params.Set("sort_by", testData.sortLabel)
+			}
+
+			req := httptest.NewRequest("GET", "/api/v1/series?"+params.Encode(), nil)
+			req = req.WithContext(ctx)
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+
+			require.Equal(t, http.StatusOK, resp.Code)
+			assert.JSONEq(t, testData.expectedBody, resp.Body.String())
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package querier
+
+import (
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/storage"
+)
+
+// staleOnlySeriesFilter is a storage.SeriesSet skipping the series which only have stale
+// markers within [minT, maxT], like the series of a target which has gone away right
+// before the queried time range.
+type staleOnlySeriesFilter struct {
+	storage.SeriesSet
+	minT, maxT int64
+}
+
+func newStaleOnlySeriesFilter(set storage.SeriesSet, minT, maxT int64) storage.SeriesSet {
+	return &staleOnlySeriesFilter{SeriesSet: set, minT: minT, maxT: maxT}
+}
+
+func (f *staleOnlySeriesFilter) Next() bool {
+	for f.SeriesSet.Next() {
+		if !isStaleOnlySeries(f.SeriesSet.At(), f.minT, f.maxT) {
+			return true
+		}
+	}
+	return false
+}
+
+// isStaleOnlySeries returns whether all the samples of the series within [minT, maxT] are
+// stale markers. Series without samples in the range are kept as is.
+func isStaleOnlySeries(s storage.Series, minT, maxT int64) bool {
+	it := s.Iterator()
+	if !it.Seek(minT) {
+		return false
+	}
+
+	found := false
+	for ok := true; ok; ok = it.Next() {
+		t, v := it.At()
+		if t > maxT {
+			break
+		}
+		if !value.IsStaleNaN(v) {
+			return false
+		}
+		found = true
+	}
+	return found && it.Err() == nil
+}
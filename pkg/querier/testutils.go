@@ -38,6 +38,15 @@ func (m *MockDistributor) LabelValuesForLabelNameStream(ctx context.Context, fro
 	args := m.Called(ctx, from, to, lbl, matchers)
 	return args.Get(0).([]string), args.Error(1)
 }
+func (m *MockDistributor) LabelValuesForLabelNameIterator(ctx context.Context, from, to model.Time, lbl model.LabelName, f func(values []string) bool, matchers ...*labels.Matcher) error {
+	args := m.Called(ctx, from, to, lbl, matchers)
+	for _, values := range args.Get(0).([][]string) {
+		if !f(values) {
+			break
+		}
+	}
+	return args.Error(1)
+}
 func (m *MockDistributor) LabelNames(ctx context.Context, from, to model.Time) ([]string, error) {
 	args := m.Called(ctx, from, to)
 	return args.Get(0).([]string), args.Error(1)
@@ -54,9 +63,18 @@ func (m *MockDistributor) MetricsForLabelMatchersStream(ctx context.Context, fro
 	args := m.Called(ctx, from, to, matchers)
 	return args.Get(0).([]metric.Metric), args.Error(1)
 }
+func (m *MockDistributor) MetricsForLabelMatchersIterator(ctx context.Context, from, to model.Time, f func(metrics []metric.Metric) bool, matchers ...*labels.Matcher) error {
+	args := m.Called(ctx, from, to, matchers)
+	for _, metrics := range args.Get(0).([][]metric.Metric) {
+		if !f(metrics) {
+			break
+		}
+	}
+	return args.Error(1)
+}
 
-func (m *MockDistributor) MetricsMetadata(ctx context.Context) ([]scrape.MetricMetadata, error) {
-	args := m.Called(ctx)
+func (m *MockDistributor) MetricsMetadata(ctx context.Context, limit, limitPerMetric int) ([]scrape.MetricMetadata, error) {
+	args := m.Called(ctx, limit, limitPerMetric)
 	return args.Get(0).([]scrape.MetricMetadata), args.Error(1)
 }
 
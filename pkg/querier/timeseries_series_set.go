@@ -17,6 +17,11 @@ type timeSeriesSeriesSet struct {
 }
 
 func newTimeSeriesSeriesSet(series []cortexpb.TimeSeries) *timeSeriesSeriesSet {
+	// Canonicalize the labels of each series the same way as the chunk series, so that the
+	// same series received both as samples and chunks is merged into a single one.
+	for _, s := range series {
+		sort.Sort(cortexpb.FromLabelAdaptersToLabels(s.Labels))
+	}
 	sort.Sort(byTimeSeriesLabels(series))
 	return &timeSeriesSeriesSet{
 		ts: series,
@@ -61,7 +66,7 @@ func (b byTimeSeriesLabels) Less(i, j int) bool {
 }
 
 // Labels implements the storage.Series interface.
-// Conversion is safe because ingester sets these by calling client.FromLabelsToLabelAdapters which guarantees labels are sorted.
+// Conversion is safe because the labels are sorted by newTimeSeriesSeriesSet.
 func (t *timeseries) Labels() labels.Labels {
 	return cortexpb.FromLabelAdaptersToLabels(t.series.Labels)
 }
@@ -28,7 +28,7 @@ type Config struct {
 	TLS         cortextls.ClientConfig `yaml:",inline"`
 
 	UserName string `yaml:"username"`
-	Password string `yaml:"password"`
+	Password string `yaml:"password" secret:"true"`
 }
 
 // Clientv3Facade is a subset of all Etcd client operations that are required
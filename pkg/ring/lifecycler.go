@@ -295,6 +295,21 @@ func (i *Lifecycler) ChangeState(ctx context.Context, state InstanceState) error
 	return <-errCh
 }
 
+// Heartbeat forces an immediate update of the instance in the ring. If the instance
+// is missing from the ring (ie. it has been forgotten), it's re-registered with its
+// current tokens.
+func (i *Lifecycler) Heartbeat(ctx context.Context) error {
+	errCh := make(chan error)
+	fn := func() {
+		errCh <- i.updateConsul(ctx)
+	}
+
+	if err := i.sendToLifecyclerLoop(fn); err != nil {
+		return err
+	}
+	return <-errCh
+}
+
 func (i *Lifecycler) getTokens() Tokens {
 	i.stateMtx.RLock()
 	defer i.stateMtx.RUnlock()
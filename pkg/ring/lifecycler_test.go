@@ -254,6 +254,49 @@ func TestLifecycler_ShouldHandleInstanceAbruptlyRestarted(t *testing.T) {
 	})
 }
 
+func TestLifecycler_HeartbeatShouldReregisterForgottenInstance(t *testing.T) {
+	ringStore, closer := consul.NewInMemoryClient(GetCodec(), log.NewNopLogger(), nil)
+	t.Cleanup(func() { assert.NoError(t, closer.Close()) })
+
+	var ringConfig Config
+	flagext.DefaultValues(&ringConfig)
+	ringConfig.KVStore.Mock = ringStore
+
+	r, err := New(ringConfig, "ingester", ringKey, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), r))
+	defer services.StopAndAwaitTerminated(context.Background(), r) //nolint:errcheck
+
+	// Disable the periodic heartbeat, so that the instance is re-registered only when explicitly requested.
+	lifecyclerConfig := testLifecyclerConfig(ringConfig, "ing1")
+	lifecyclerConfig.HeartbeatPeriod = 0
+	l1, err := NewLifecycler(lifecyclerConfig, &nopFlushTransferer{}, "ingester", ringKey, true, log.NewNopLogger(), nil)
+	require.NoError(t, err)
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), l1))
+	defer services.StopAndAwaitTerminated(context.Background(), l1) //nolint:errcheck
+
+	test.Poll(t, 1000*time.Millisecond, true, func() interface{} {
+		d, err := r.KVClient.Get(context.Background(), ringKey)
+		require.NoError(t, err)
+		return checkNormalised(d, "ing1")
+	})
+
+	expectedTokens := l1.getTokens()
+
+	// Forget the instance and check it's gone from the ring.
+	require.NoError(t, r.forget(context.Background(), "ing1"))
+	d, err := r.KVClient.Get(context.Background(), ringKey)
+	require.NoError(t, err)
+	require.Empty(t, d.(*Desc).Ingesters)
+
+	// Heartbeat and check the instance has been re-registered with the same tokens.
+	require.NoError(t, l1.Heartbeat(context.Background()))
+	d, err = r.KVClient.Get(context.Background(), ringKey)
+	require.NoError(t, err)
+	require.True(t, checkNormalised(d, "ing1"))
+	assert.Equal(t, expectedTokens, Tokens(d.(*Desc).Ingesters["ing1"].Tokens))
+}
+
 type MockClient struct {
 	ListFunc        func(ctx context.Context, prefix string) ([]string, error)
 	GetFunc         func(ctx context.Context, key string) (interface{}, error)
@@ -13,7 +13,7 @@ type Config struct {
 	UserDomainName    string        `yaml:"user_domain_name"`
 	UserDomainID      string        `yaml:"user_domain_id"`
 	UserID            string        `yaml:"user_id"`
-	Password          string        `yaml:"password"`
+	Password          string        `yaml:"password" secret:"true"`
 	DomainID          string        `yaml:"domain_id"`
 	DomainName        string        `yaml:"domain_name"`
 	ProjectID         string        `yaml:"project_id"`
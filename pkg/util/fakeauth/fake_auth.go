@@ -12,8 +12,9 @@ import (
 	"google.golang.org/grpc"
 )
 
-// SetupAuthMiddleware for the given server config.
-func SetupAuthMiddleware(config *server.Config, enabled bool, noGRPCAuthOn []string) middleware.Interface {
+// SetupAuthMiddleware for the given server config. When enabled, the gRPC requests are authenticated
+// with the given interceptors, except the ones to the noGRPCAuthOn methods.
+func SetupAuthMiddleware(config *server.Config, enabled bool, grpcAuth grpc.UnaryServerInterceptor, grpcStreamAuth grpc.StreamServerInterceptor, noGRPCAuthOn []string) middleware.Interface {
 	if enabled {
 		ignoredMethods := map[string]bool{}
 		for _, m := range noGRPCAuthOn {
@@ -24,7 +25,7 @@ func SetupAuthMiddleware(config *server.Config, enabled bool, noGRPCAuthOn []str
 			if ignoredMethods[info.FullMethod] {
 				return handler(ctx, req)
 			}
-			return middleware.ServerUserHeaderInterceptor(ctx, req, info, handler)
+			return grpcAuth(ctx, req, info, handler)
 		})
 
 		config.GRPCStreamMiddleware = append(config.GRPCStreamMiddleware,
@@ -32,7 +33,7 @@ func SetupAuthMiddleware(config *server.Config, enabled bool, noGRPCAuthOn []str
 				if ignoredMethods[info.FullMethod] {
 					return handler(srv, ss)
 				}
-				return middleware.StreamServerUserHeaderInterceptor(srv, ss, info, handler)
+				return grpcStreamAuth(srv, ss, info, handler)
 			},
 		)
 
@@ -30,7 +30,7 @@ func IsRequestBodyTooLarge(err error) bool {
 // BasicAuth configures basic authentication for HTTP clients.
 type BasicAuth struct {
 	Username string `yaml:"basic_auth_username"`
-	Password string `yaml:"basic_auth_password"`
+	Password string `yaml:"basic_auth_password" secret:"true"`
 }
 
 func (b *BasicAuth) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
@@ -0,0 +1,457 @@
+package push
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log/level"
+	"github.com/gogo/protobuf/proto"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/weaveworks/common/middleware"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+const (
+	otlpProtobufContentType = "application/x-protobuf"
+
+	// OTLP aggregation temporality of sums and histograms.
+	otlpTemporalityDelta = 1
+
+	// OTLP data point flag marking a data point without recorded value.
+	otlpFlagNoRecordedValue = 1
+)
+
+// OTLPHandler is a http.Handler which accepts OTLP/HTTP metrics export requests, encoded
+// in protobuf and optionally gzip-compressed, and pushes them as WriteRequests.
+//
+// Gauges, sums, histograms and summaries are translated to series the same way Prometheus does:
+// the resource service.name and service.instance.id attributes become the job and instance
+// labels, while histograms and summaries are split into their _bucket, _sum and _count series.
+// Delta sums and histograms, as well as exponential histograms, are not supported and dropped.
+func OTLPHandler(maxRecvMsgSize int, sourceIPs *middleware.SourceIPExtractor, push Func) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, logger := contextWithSourceIPs(r, sourceIPs)
+
+		if contentType := r.Header.Get("Content-Type"); contentType != "" {
+			if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != otlpProtobufContentType {
+				http.Error(w, fmt.Sprintf("unsupported content type %q, supported: %q", contentType, otlpProtobufContentType), http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+
+		var (
+			body         io.Reader = r.Body
+			expectedSize           = int(r.ContentLength)
+		)
+
+		switch encoding := r.Header.Get("Content-Encoding"); encoding {
+		case "":
+		case "gzip":
+			gzipReader, err := gzip.NewReader(r.Body)
+			if err != nil {
+				level.Error(logger).Log("err", err.Error())
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer gzipReader.Close()
+
+			// The size of the decompressed body is unknown.
+			body, expectedSize = gzipReader, 0
+		default:
+			http.Error(w, fmt.Sprintf("unsupported content encoding %q", encoding), http.StatusUnsupportedMediaType)
+			return
+		}
+
+		var req otlpMetricsRequest
+		if err := util.ParseProtoReader(ctx, body, expectedSize, maxRecvMsgSize, &req, util.NoCompression); err != nil {
+			level.Error(logger).Log("err", err.Error())
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !doPush(ctx, logger, w, &cortexpb.WriteRequest{Timeseries: req.timeseries, Source: cortexpb.API}, push) {
+			return
+		}
+
+		// An empty message is a valid OTLP export response.
+		w.Header().Set("Content-Type", otlpProtobufContentType)
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// otlpMetricsRequest is an OTLP ExportMetricsServiceRequest, translated to series while
+// being unmarshalled.
+type otlpMetricsRequest struct {
+	timeseries []cortexpb.PreallocTimeseries
+}
+
+// Reset implements proto.Message.
+func (r *otlpMetricsRequest) Reset() {
+	r.timeseries = nil
+}
+
+// String implements proto.Message.
+func (r *otlpMetricsRequest) String() string {
+	return fmt.Sprintf("OTLP metrics request with %d series", len(r.timeseries))
+}
+
+// ProtoMessage implements proto.Message.
+func (r *otlpMetricsRequest) ProtoMessage() {}
+
+// Unmarshal implements proto.Unmarshaler.
+func (r *otlpMetricsRequest) Unmarshal(b []byte) error {
+	return decodeProtoMessage(b, func(f protoField) error {
+		if f.num == 1 && f.typ == proto.WireBytes {
+			return r.decodeResourceMetrics(f.bytes)
+		}
+		return nil
+	})
+}
+
+func (r *otlpMetricsRequest) decodeResourceMetrics(b []byte) error {
+	var resource, scopeMetrics [][]byte
+	err := decodeProtoMessage(b, func(f protoField) error {
+		if f.typ != proto.WireBytes {
+			return nil
+		}
+		switch f.num {
+		case 1:
+			resource = append(resource, f.bytes)
+		case 2:
+			scopeMetrics = append(scopeMetrics, f.bytes)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// The resource is decoded first, since its labels are attached to all the series.
+	attributes := map[string]string{}
+	for _, b := range resource {
+		if err := decodeOTLPAttributes(b, 1, attributes); err != nil {
+			return err
+		}
+	}
+
+	var resourceLabels labels.Labels
+	if job := attributes["service.name"]; job != "" {
+		if namespace := attributes["service.namespace"]; namespace != "" {
+			job = namespace + "/" + job
+		}
+		resourceLabels = append(resourceLabels, labels.Label{Name: "job", Value: job})
+	}
+	if instance := attributes["service.instance.id"]; instance != "" {
+		resourceLabels = append(resourceLabels, labels.Label{Name: "instance", Value: instance})
+	}
+
+	for _, b := range scopeMetrics {
+		err := decodeProtoMessage(b, func(f protoField) error {
+			if f.num == 2 && f.typ == proto.WireBytes {
+				return r.decodeMetric(f.bytes, resourceLabels)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *otlpMetricsRequest) decodeMetric(b []byte, resourceLabels labels.Labels) error {
+	var (
+		name       string
+		kind       int
+		data       []byte
+		dataPoints [][]byte
+		delta      bool
+	)
+
+	err := decodeProtoMessage(b, func(f protoField) error {
+		if f.typ != proto.WireBytes {
+			return nil
+		}
+		switch f.num {
+		case 1:
+			name = string(f.bytes)
+		case 5, 7, 9, 11: // gauge, sum, histogram, summary
+			kind, data = f.num, f.bytes
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = decodeProtoMessage(data, func(f protoField) error {
+		switch {
+		case f.num == 1 && f.typ == proto.WireBytes:
+			dataPoints = append(dataPoints, f.bytes)
+		case f.num == 2 && f.typ == proto.WireVarint && (kind == 7 || kind == 9):
+			delta = f.value == otlpTemporalityDelta
+		}
+		return nil
+	})
+	if err != nil || delta {
+		return err
+	}
+
+	name = sanitizeOTLPName(name, true)
+	for _, b := range dataPoints {
+		switch kind {
+		case 5, 7:
+			err = r.decodeNumberDataPoint(b, name, resourceLabels)
+		case 9:
+			err = r.decodeHistogramDataPoint(b, name, resourceLabels)
+		case 11:
+			err = r.decodeSummaryDataPoint(b, name, resourceLabels)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *otlpMetricsRequest) decodeNumberDataPoint(b []byte, name string, resourceLabels labels.Labels) error {
+	var (
+		attributes = map[string]string{}
+		timestamp  int64
+		val        float64
+		flags      uint64
+	)
+
+	err := decodeProtoMessage(b, func(f protoField) error {
+		switch {
+		case f.num == 3 && f.typ == proto.WireFixed64:
+			timestamp = otlpTimestamp(f.value)
+		case f.num == 4 && f.typ == proto.WireFixed64:
+			val = math.Float64frombits(f.value)
+		case f.num == 6 && f.typ == proto.WireFixed64:
+			val = float64(int64(f.value))
+		case f.num == 7 && f.typ == proto.WireBytes:
+			return decodeOTLPKeyValue(f.bytes, attributes)
+		case f.num == 8 && f.typ == proto.WireVarint:
+			flags = f.value
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	r.addSample(name, resourceLabels, attributes, nil, timestamp, otlpValue(val, flags))
+	return nil
+}
+
+func (r *otlpMetricsRequest) decodeHistogramDataPoint(b []byte, name string, resourceLabels labels.Labels) error {
+	var (
+		attributes = map[string]string{}
+		timestamp  int64
+		count, sum float64
+		flags      uint64
+		buckets    []uint64
+		bounds     []uint64
+	)
+
+	err := decodeProtoMessage(b, func(f protoField) error {
+		var err error
+		switch {
+		case f.num == 3 && f.typ == proto.WireFixed64:
+			timestamp = otlpTimestamp(f.value)
+		case f.num == 4 && f.typ == proto.WireFixed64:
+			count = float64(f.value)
+		case f.num == 5 && f.typ == proto.WireFixed64:
+			sum = math.Float64frombits(f.value)
+		case f.num == 6:
+			buckets, err = appendProtoFixed64(buckets, f)
+		case f.num == 7:
+			bounds, err = appendProtoFixed64(bounds, f)
+		case f.num == 9 && f.typ == proto.WireBytes:
+			err = decodeOTLPKeyValue(f.bytes, attributes)
+		case f.num == 10 && f.typ == proto.WireVarint:
+			flags = f.value
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	r.addSample(name+"_sum", resourceLabels, attributes, nil, timestamp, otlpValue(sum, flags))
+	r.addSample(name+"_count", resourceLabels, attributes, nil, timestamp, otlpValue(count, flags))
+
+	// Buckets are cumulative in Prometheus, while OTLP carries the count of each bucket.
+	// The last bucket, without upper bound, is the +Inf one matching the overall count.
+	var cumulative uint64
+	for i, bound := range bounds {
+		if i < len(buckets) {
+			cumulative += buckets[i]
+		}
+		le := strconv.FormatFloat(math.Float64frombits(bound), 'g', -1, 64)
+		r.addSample(name+"_bucket", resourceLabels, attributes, &labels.Label{Name: labels.BucketLabel, Value: le}, timestamp, otlpValue(float64(cumulative), flags))
+	}
+	r.addSample(name+"_bucket", resourceLabels, attributes, &labels.Label{Name: labels.BucketLabel, Value: "+Inf"}, timestamp, otlpValue(count, flags))
+	return nil
+}
+
+func (r *otlpMetricsRequest) decodeSummaryDataPoint(b []byte, name string, resourceLabels labels.Labels) error {
+	type quantile struct {
+		quantile, value float64
+	}
+
+	var (
+		attributes = map[string]string{}
+		timestamp  int64
+		count, sum float64
+		flags      uint64
+		quantiles  []quantile
+	)
+
+	err := decodeProtoMessage(b, func(f protoField) error {
+		switch {
+		case f.num == 3 && f.typ == proto.WireFixed64:
+			timestamp = otlpTimestamp(f.value)
+		case f.num == 4 && f.typ == proto.WireFixed64:
+			count = float64(f.value)
+		case f.num == 5 && f.typ == proto.WireFixed64:
+			sum = math.Float64frombits(f.value)
+		case f.num == 6 && f.typ == proto.WireBytes:
+			var q quantile
+			err := decodeProtoMessage(f.bytes, func(f protoField) error {
+				switch {
+				case f.num == 1 && f.typ == proto.WireFixed64:
+					q.quantile = math.Float64frombits(f.value)
+				case f.num == 2 && f.typ == proto.WireFixed64:
+					q.value = math.Float64frombits(f.value)
+				}
+				return nil
+			})
+			quantiles = append(quantiles, q)
+			return err
+		case f.num == 7 && f.typ == proto.WireBytes:
+			return decodeOTLPKeyValue(f.bytes, attributes)
+		case f.num == 8 && f.typ == proto.WireVarint:
+			flags = f.value
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	r.addSample(name+"_sum", resourceLabels, attributes, nil, timestamp, otlpValue(sum, flags))
+	r.addSample(name+"_count", resourceLabels, attributes, nil, timestamp, otlpValue(count, flags))
+	for _, q := range quantiles {
+		quantileLabel := &labels.Label{Name: "quantile", Value: strconv.FormatFloat(q.quantile, 'g', -1, 64)}
+		r.addSample(name, resourceLabels, attributes, quantileLabel, timestamp, otlpValue(q.value, flags))
+	}
+	return nil
+}
+
+// addSample adds a series with a single sample. The series labels are the resource ones,
+// the data point attributes and the optional extra label, in increasing order of precedence.
+func (r *otlpMetricsRequest) addSample(name string, resourceLabels labels.Labels, attributes map[string]string, extra *labels.Label, timestamp int64, val float64) {
+	b := labels.NewBuilder(resourceLabels)
+	for k, v := range attributes {
+		b.Set(sanitizeOTLPName(k, false), v)
+	}
+	if extra != nil {
+		b.Set(extra.Name, extra.Value)
+	}
+	b.Set(labels.MetricName, name)
+
+	r.timeseries = append(r.timeseries, cortexpb.PreallocTimeseries{
+		TimeSeries: &cortexpb.TimeSeries{
+			Labels:  cortexpb.FromLabelsToLabelAdapters(b.Labels()),
+			Samples: []cortexpb.Sample{{Value: val, TimestampMs: timestamp}},
+		},
+	})
+}
+
+// decodeOTLPAttributes decodes the KeyValue attributes stored in the given field of the
+// message into attributes.
+func decodeOTLPAttributes(b []byte, num int, attributes map[string]string) error {
+	return decodeProtoMessage(b, func(f protoField) error {
+		if f.num == num && f.typ == proto.WireBytes {
+			return decodeOTLPKeyValue(f.bytes, attributes)
+		}
+		return nil
+	})
+}
+
+// decodeOTLPKeyValue decodes a KeyValue into attributes. Only scalar values are supported,
+// while attributes with array, key-value list or bytes values are dropped.
+func decodeOTLPKeyValue(b []byte, attributes map[string]string) error {
+	var (
+		key, val string
+		ok       bool
+	)
+
+	err := decodeProtoMessage(b, func(f protoField) error {
+		switch {
+		case f.num == 1 && f.typ == proto.WireBytes:
+			key = string(f.bytes)
+		case f.num == 2 && f.typ == proto.WireBytes:
+			return decodeProtoMessage(f.bytes, func(f protoField) error {
+				switch {
+				case f.num == 1 && f.typ == proto.WireBytes:
+					val, ok = string(f.bytes), true
+				case f.num == 2 && f.typ == proto.WireVarint:
+					val, ok = strconv.FormatBool(f.value != 0), true
+				case f.num == 3 && f.typ == proto.WireVarint:
+					val, ok = strconv.FormatInt(int64(f.value), 10), true
+				case f.num == 4 && f.typ == proto.WireFixed64:
+					val, ok = strconv.FormatFloat(math.Float64frombits(f.value), 'g', -1, 64), true
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if ok && key != "" {
+		attributes[key] = val
+	}
+	return nil
+}
+
+func otlpTimestamp(unixNano uint64) int64 {
+	return int64(unixNano / 1e6)
+}
+
+func otlpValue(val float64, flags uint64) float64 {
+	if flags&otlpFlagNoRecordedValue != 0 {
+		return math.Float64frombits(value.StaleNaN)
+	}
+	return val
+}
+
+// sanitizeOTLPName replaces the characters not allowed in Prometheus metric or label
+// names with underscores.
+func sanitizeOTLPName(name string, allowColons bool) string {
+	sanitized := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' || (allowColons && r == ':') {
+			return r
+		}
+		return '_'
+	}, name)
+
+	if sanitized != "" && sanitized[0] >= '0' && sanitized[0] <= '9' {
+		sanitized = "_" + sanitized
+	}
+	return sanitized
+}
@@ -0,0 +1,185 @@
+package push
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+)
+
+func TestOTLPHandler(t *testing.T) {
+	const ts = uint64(1600000000000 * 1e6)
+
+	resource := protoMessage{}.
+		message(1, otlpStringAttribute("service.name", "api")).
+		message(1, otlpStringAttribute("service.namespace", "prod")).
+		message(1, otlpStringAttribute("service.instance.id", "host-1"))
+
+	gauge := protoMessage{}.
+		str(1, "process.memory").
+		message(5, protoMessage{}.message(1, protoMessage{}.
+			message(7, otlpStringAttribute("http.method", "GET")).
+			fixed64(3, ts).
+			double(4, 1.5)))
+
+	cumulativeSum := protoMessage{}.
+		str(1, "requests_total").
+		message(7, protoMessage{}.
+			message(1, protoMessage{}.fixed64(3, ts).fixed64(6, 10)).
+			varint(2, 2))
+
+	// Delta sums are dropped.
+	deltaSum := protoMessage{}.
+		str(1, "delta_total").
+		message(7, protoMessage{}.
+			message(1, protoMessage{}.fixed64(3, ts).fixed64(6, 10)).
+			varint(2, 1))
+
+	histogram := protoMessage{}.
+		str(1, "latency").
+		message(9, protoMessage{}.
+			message(1, protoMessage{}.
+				fixed64(3, ts).
+				fixed64(4, 6).
+				double(5, 12).
+				packedFixed64(6, 1, 2, 3).
+				packedDouble(7, 0.5, 1)).
+			varint(2, 2))
+
+	// The summary data point has no recorded value.
+	summary := protoMessage{}.
+		str(1, "duration").
+		message(11, protoMessage{}.
+			message(1, protoMessage{}.
+				fixed64(3, ts).
+				fixed64(4, 4).
+				double(5, 8).
+				message(6, protoMessage{}.double(1, 0.5).double(2, 2)).
+				varint(8, 1)))
+
+	scopeMetrics := protoMessage{}.
+		message(2, gauge).
+		message(2, cumulativeSum).
+		message(2, deltaSum).
+		message(2, histogram).
+		message(2, summary)
+
+	request := protoMessage{}.message(1, protoMessage{}.message(1, resource).message(2, scopeMetrics))
+
+	var received []cortexpb.PreallocTimeseries
+	pushFn := func(_ context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+		assert.Equal(t, cortexpb.API, req.Source)
+		received = req.Timeseries
+		return &cortexpb.WriteResponse{}, nil
+	}
+
+	for _, gzipped := range []bool{false, true} {
+		received = nil
+
+		body := []byte(request)
+		if gzipped {
+			var buf bytes.Buffer
+			gzipWriter := gzip.NewWriter(&buf)
+			_, err := gzipWriter.Write(body)
+			require.NoError(t, err)
+			require.NoError(t, gzipWriter.Close())
+			body = buf.Bytes()
+		}
+
+		req := httptest.NewRequest("POST", "/api/v1/otlp/v1/metrics", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		if gzipped {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		resp := httptest.NewRecorder()
+		OTLPHandler(100000, nil, pushFn).ServeHTTP(resp, req)
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		assert.Equal(t, "application/x-protobuf", resp.Header().Get("Content-Type"))
+
+		var actual []string
+		for _, series := range received {
+			require.Len(t, series.Samples, 1)
+			assert.Equal(t, int64(1600000000000), series.Samples[0].TimestampMs)
+
+			val := series.Samples[0].Value
+			if value.IsStaleNaN(val) {
+				actual = append(actual, cortexpb.FromLabelAdaptersToLabels(series.Labels).String()+" stale")
+				continue
+			}
+			actual = append(actual, cortexpb.FromLabelAdaptersToLabels(series.Labels).String()+" "+strconv.FormatFloat(val, 'g', -1, 64))
+		}
+
+		assert.Equal(t, []string{
+			`{__name__="process_memory", http_method="GET", instance="host-1", job="prod/api"} 1.5`,
+			`{__name__="requests_total", instance="host-1", job="prod/api"} 10`,
+			`{__name__="latency_sum", instance="host-1", job="prod/api"} 12`,
+			`{__name__="latency_count", instance="host-1", job="prod/api"} 6`,
+			`{__name__="latency_bucket", instance="host-1", job="prod/api", le="0.5"} 1`,
+			`{__name__="latency_bucket", instance="host-1", job="prod/api", le="1"} 3`,
+			`{__name__="latency_bucket", instance="host-1", job="prod/api", le="+Inf"} 6`,
+			`{__name__="duration_sum", instance="host-1", job="prod/api"} stale`,
+			`{__name__="duration_count", instance="host-1", job="prod/api"} stale`,
+			`{__name__="duration", instance="host-1", job="prod/api", quantile="0.5"} stale`,
+		}, actual)
+	}
+}
+
+func TestOTLPHandler_InvalidRequests(t *testing.T) {
+	tests := map[string]struct {
+		contentType     string
+		contentEncoding string
+		body            []byte
+		expectedStatus  int
+	}{
+		"JSON content type": {
+			contentType:    "application/json",
+			body:           []byte(`{}`),
+			expectedStatus: http.StatusUnsupportedMediaType,
+		},
+		"unsupported content encoding": {
+			contentType:     "application/x-protobuf",
+			contentEncoding: "snappy",
+			expectedStatus:  http.StatusUnsupportedMediaType,
+		},
+		"invalid gzip body": {
+			contentType:     "application/x-protobuf",
+			contentEncoding: "gzip",
+			body:            []byte("not gzip"),
+			expectedStatus:  http.StatusBadRequest,
+		},
+		"truncated protobuf": {
+			contentType:    "application/x-protobuf",
+			body:           protoMessage{}.message(1, protoMessage{}.str(1, "foo"))[:4],
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/v1/otlp/v1/metrics", bytes.NewReader(testData.body))
+			req.Header.Set("Content-Type", testData.contentType)
+			if testData.contentEncoding != "" {
+				req.Header.Set("Content-Encoding", testData.contentEncoding)
+			}
+			resp := httptest.NewRecorder()
+			OTLPHandler(100000, nil, func(context.Context, *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+				t.Fatal("unexpected push")
+				return nil, nil
+			}).ServeHTTP(resp, req)
+			assert.Equal(t, testData.expectedStatus, resp.Code)
+		})
+	}
+}
+
+func otlpStringAttribute(key, val string) protoMessage {
+	return protoMessage{}.str(1, key).message(2, protoMessage{}.str(1, val))
+}
@@ -0,0 +1,103 @@
+package push
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/gogo/protobuf/proto"
+)
+
+// The push formats whose protobuf definitions are not vendored, like OTLP, are decoded
+// field by field with the following helpers.
+
+// protoField is a field of a protobuf-encoded message.
+type protoField struct {
+	num int
+	typ int // One of the proto.Wire* wire types.
+
+	// bytes is set for length-delimited fields, value for the other ones.
+	bytes []byte
+	value uint64
+}
+
+var errProtoTruncated = errors.New("unexpected end of protobuf message")
+
+// decodeProtoMessage calls fn for each field of the protobuf-encoded message.
+func decodeProtoMessage(b []byte, fn func(f protoField) error) error {
+	for len(b) > 0 {
+		tag, n := proto.DecodeVarint(b)
+		if n == 0 {
+			return errProtoTruncated
+		}
+		b = b[n:]
+
+		f := protoField{num: int(tag >> 3), typ: int(tag & 7)}
+		switch f.typ {
+		case proto.WireVarint:
+			f.value, n = proto.DecodeVarint(b)
+			if n == 0 {
+				return errProtoTruncated
+			}
+		case proto.WireFixed64:
+			if n = 8; len(b) < n {
+				return errProtoTruncated
+			}
+			f.value = binary.LittleEndian.Uint64(b)
+		case proto.WireFixed32:
+			if n = 4; len(b) < n {
+				return errProtoTruncated
+			}
+			f.value = uint64(binary.LittleEndian.Uint32(b))
+		case proto.WireBytes:
+			size, sizeLen := proto.DecodeVarint(b)
+			if sizeLen == 0 || size > uint64(len(b)-sizeLen) {
+				return errProtoTruncated
+			}
+			n = sizeLen + int(size)
+			f.bytes = b[sizeLen:n]
+		default:
+			return fmt.Errorf("unsupported wire type %d in protobuf message", f.typ)
+		}
+		b = b[n:]
+
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendProtoFixed64 appends the values of a repeated fixed64 or double field, either packed or not.
+func appendProtoFixed64(values []uint64, f protoField) ([]uint64, error) {
+	switch f.typ {
+	case proto.WireFixed64:
+		return append(values, f.value), nil
+	case proto.WireBytes:
+		if len(f.bytes)%8 != 0 {
+			return nil, errProtoTruncated
+		}
+		for b := f.bytes; len(b) > 0; b = b[8:] {
+			values = append(values, binary.LittleEndian.Uint64(b))
+		}
+	}
+	return values, nil
+}
+
+// appendProtoVarint appends the values of a repeated varint field, either packed or not.
+func appendProtoVarint(values []uint64, f protoField) ([]uint64, error) {
+	switch f.typ {
+	case proto.WireVarint:
+		return append(values, f.value), nil
+	case proto.WireBytes:
+		for b := f.bytes; len(b) > 0; {
+			v, n := proto.DecodeVarint(b)
+			if n == 0 {
+				return nil, errProtoTruncated
+			}
+			values = append(values, v)
+			b = b[n:]
+		}
+	}
+	return values, nil
+}
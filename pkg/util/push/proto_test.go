@@ -0,0 +1,99 @@
+package push
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeProtoMessage(t *testing.T) {
+	message := protoMessage{}.
+		varint(1, 300).
+		fixed64(2, math.Float64bits(1.5)).
+		str(3, "foo").
+		packedFixed64(4, 1, 2)
+
+	var fields []protoField
+	require.NoError(t, decodeProtoMessage(message, func(f protoField) error {
+		fields = append(fields, f)
+		return nil
+	}))
+
+	require.Len(t, fields, 4)
+	assert.Equal(t, protoField{num: 1, typ: proto.WireVarint, value: 300}, fields[0])
+	assert.Equal(t, protoField{num: 2, typ: proto.WireFixed64, value: math.Float64bits(1.5)}, fields[1])
+	assert.Equal(t, protoField{num: 3, typ: proto.WireBytes, bytes: []byte("foo")}, fields[2])
+
+	values, err := appendProtoFixed64(nil, fields[3])
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1, 2}, values)
+
+	// Any truncated message is invalid.
+	for i := 1; i < len(message); i++ {
+		err := decodeProtoMessage(message[:i], func(protoField) error { return nil })
+		if i == 3 || i == 12 || i == 17 {
+			// The message is truncated on a field boundary.
+			assert.NoError(t, err, "truncated at %d", i)
+			continue
+		}
+		assert.Error(t, err, "truncated at %d", i)
+	}
+}
+
+// protoMessage builds protobuf-encoded messages in tests.
+type protoMessage []byte
+
+func (m protoMessage) tag(num, typ int) protoMessage {
+	return append(m, proto.EncodeVarint(uint64(num<<3|typ))...)
+}
+
+func (m protoMessage) message(num int, b protoMessage) protoMessage {
+	m = append(m.tag(num, proto.WireBytes), proto.EncodeVarint(uint64(len(b)))...)
+	return append(m, b...)
+}
+
+func (m protoMessage) str(num int, s string) protoMessage {
+	return m.message(num, protoMessage(s))
+}
+
+func (m protoMessage) varint(num int, v uint64) protoMessage {
+	return append(m.tag(num, proto.WireVarint), proto.EncodeVarint(v)...)
+}
+
+func (m protoMessage) packedVarint(num int, values ...uint64) protoMessage {
+	var packed protoMessage
+	for _, v := range values {
+		packed = append(packed, proto.EncodeVarint(v)...)
+	}
+	return m.message(num, packed)
+}
+
+func (m protoMessage) fixed64(num int, v uint64) protoMessage {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	return append(m.tag(num, proto.WireFixed64), b[:]...)
+}
+
+func (m protoMessage) double(num int, v float64) protoMessage {
+	return m.fixed64(num, math.Float64bits(v))
+}
+
+func (m protoMessage) packedFixed64(num int, values ...uint64) protoMessage {
+	packed := make(protoMessage, 8*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint64(packed[8*i:], v)
+	}
+	return m.message(num, packed)
+}
+
+func (m protoMessage) packedDouble(num int, values ...float64) protoMessage {
+	bits := make([]uint64, 0, len(values))
+	for _, v := range values {
+		bits = append(bits, math.Float64bits(v))
+	}
+	return m.packedFixed64(num, bits...)
+}
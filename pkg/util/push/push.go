@@ -4,32 +4,37 @@ import (
 	"context"
 	"net/http"
 
+	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/weaveworks/common/httpgrpc"
 	"github.com/weaveworks/common/middleware"
 
 	"github.com/cortexproject/cortex/pkg/cortexpb"
 	"github.com/cortexproject/cortex/pkg/util"
-	"github.com/cortexproject/cortex/pkg/util/log"
+	util_log "github.com/cortexproject/cortex/pkg/util/log"
 )
 
 // Func defines the type of the push. It is similar to http.HandlerFunc.
 type Func func(context.Context, *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error)
 
-// Handler is a http.Handler which accepts WriteRequests.
+// Handler is a http.Handler which accepts WriteRequests. Both Remote-Write 1.0 and 2.0
+// requests are supported, the latter being translated to WriteRequests.
 func Handler(maxRecvMsgSize int, sourceIPs *middleware.SourceIPExtractor, push Func) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
-		logger := log.WithContext(ctx, log.Logger)
-		if sourceIPs != nil {
-			source := sourceIPs.Get(r)
-			if source != "" {
-				ctx = util.AddSourceIPsToOutgoingContext(ctx, source)
-				logger = log.WithSourceIPs(source, logger)
-			}
+		ctx, logger := contextWithSourceIPs(r, sourceIPs)
+
+		remoteWrite2, err := isRemoteWrite2(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
 		}
+		if remoteWrite2 {
+			handleRemoteWrite2(ctx, logger, w, r, maxRecvMsgSize, push)
+			return
+		}
+
 		var req cortexpb.PreallocWriteRequest
-		err := util.ParseProtoReader(ctx, r.Body, int(r.ContentLength), maxRecvMsgSize, &req, util.RawSnappy)
+		err = util.ParseProtoReader(ctx, r.Body, int(r.ContentLength), maxRecvMsgSize, &req, util.RawSnappy)
 		if err != nil {
 			level.Error(logger).Log("err", err.Error())
 			http.Error(w, err.Error(), http.StatusBadRequest)
@@ -41,16 +46,38 @@ func Handler(maxRecvMsgSize int, sourceIPs *middleware.SourceIPExtractor, push F
 			req.Source = cortexpb.API
 		}
 
-		if _, err := push(ctx, &req.WriteRequest); err != nil {
-			resp, ok := httpgrpc.HTTPResponseFromError(err)
-			if !ok {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			if resp.GetCode() != 202 {
-				level.Error(logger).Log("msg", "push error", "err", err)
-			}
-			http.Error(w, string(resp.Body), int(resp.Code))
-		}
+		doPush(ctx, logger, w, &req.WriteRequest, push)
 	})
 }
+
+// contextWithSourceIPs returns the request context and logger, both carrying the
+// source IPs of the request if they can be extracted.
+func contextWithSourceIPs(r *http.Request, sourceIPs *middleware.SourceIPExtractor) (context.Context, log.Logger) {
+	ctx := r.Context()
+	logger := util_log.WithContext(ctx, util_log.Logger)
+	if sourceIPs != nil {
+		source := sourceIPs.Get(r)
+		if source != "" {
+			ctx = util.AddSourceIPsToOutgoingContext(ctx, source)
+			logger = util_log.WithSourceIPs(source, logger)
+		}
+	}
+	return ctx, logger
+}
+
+// doPush pushes the request and writes the push error, if any, to the response.
+func doPush(ctx context.Context, logger log.Logger, w http.ResponseWriter, req *cortexpb.WriteRequest, push Func) bool {
+	if _, err := push(ctx, req); err != nil {
+		resp, ok := httpgrpc.HTTPResponseFromError(err)
+		if !ok {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return false
+		}
+		if resp.GetCode() != 202 {
+			level.Error(logger).Log("msg", "push error", "err", err)
+		}
+		http.Error(w, string(resp.Body), int(resp.Code))
+		return false
+	}
+	return true
+}
@@ -46,6 +46,108 @@ func TestHandler_ignoresSkipLabelNameValidationIfSet(t *testing.T) {
 	}
 }
 
+func TestHandler_remoteWriteVersions(t *testing.T) {
+	ts := time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond)
+
+	// A Remote-Write 2.0 request, whose series reference the symbols table encoded after them.
+	// The series labels references are packed, while the exemplar ones are not.
+	series := protoMessage{}.
+		packedVarint(1, 1, 2, 3, 4).
+		message(2, protoMessage{}.double(1, 1).varint(2, uint64(ts))).
+		message(4, protoMessage{}.varint(1, 5).varint(1, 6).double(2, 2).varint(3, uint64(ts))).
+		message(5, protoMessage{}.varint(1, uint64(cortexpb.COUNTER)).varint(3, 7))
+	remoteWrite2 := protoMessage{}.message(5, series)
+	for _, symbol := range []string{"", "__name__", "foo", "job", "bar", "trace_id", "1234", "Help text."} {
+		remoteWrite2 = remoteWrite2.str(4, symbol)
+	}
+
+	tests := map[string]struct {
+		body           []byte
+		contentType    string
+		version        string
+		expectedStatus int
+		expectedReq    *cortexpb.WriteRequest
+	}{
+		"Remote-Write 1.0": {
+			body:           createPrometheusRemoteWriteProtobuf(t),
+			contentType:    "application/x-protobuf",
+			version:        "0.1.0",
+			expectedStatus: http.StatusOK,
+		},
+		"Remote-Write 1.0 with explicit proto message": {
+			body:           createPrometheusRemoteWriteProtobuf(t),
+			contentType:    "application/x-protobuf;proto=prometheus.WriteRequest",
+			version:        "0.1.0",
+			expectedStatus: http.StatusOK,
+		},
+		"Remote-Write 2.0": {
+			body:           remoteWrite2,
+			contentType:    "application/x-protobuf;proto=io.prometheus.write.v2.Request",
+			version:        "2.0.0",
+			expectedStatus: http.StatusNoContent,
+		},
+		"Remote-Write 2.0 detected from the version header": {
+			body:           remoteWrite2,
+			contentType:    "application/x-protobuf",
+			version:        "2.0.0",
+			expectedStatus: http.StatusNoContent,
+		},
+		"unsupported proto message": {
+			body:           remoteWrite2,
+			contentType:    "application/x-protobuf;proto=io.prometheus.write.v3.Request",
+			expectedStatus: http.StatusUnsupportedMediaType,
+		},
+		"Remote-Write 2.0 with invalid symbol reference": {
+			body:           protoMessage{}.message(5, protoMessage{}.packedVarint(1, 1, 2)).str(4, ""),
+			contentType:    "application/x-protobuf;proto=io.prometheus.write.v2.Request",
+			expectedStatus: http.StatusBadRequest,
+		},
+		"Remote-Write 2.0 with native histograms": {
+			body:           protoMessage{}.message(5, protoMessage{}.message(3, protoMessage{})),
+			contentType:    "application/x-protobuf;proto=io.prometheus.write.v2.Request",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			var received []*cortexpb.WriteRequest
+			handler := Handler(100000, nil, func(_ context.Context, req *cortexpb.WriteRequest) (*cortexpb.WriteResponse, error) {
+				received = append(received, req)
+				return &cortexpb.WriteResponse{}, nil
+			})
+
+			req := createRequest(t, testData.body)
+			req.Header.Set("Content-Type", testData.contentType)
+			req.Header.Set("X-Prometheus-Remote-Write-Version", testData.version)
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			require.Equal(t, testData.expectedStatus, resp.Code, resp.Body.String())
+
+			switch testData.expectedStatus {
+			case http.StatusOK:
+				require.Len(t, received, 1)
+				assert.Equal(t, []cortexpb.LabelAdapter{{Name: "__name__", Value: "foo"}}, received[0].Timeseries[0].Labels)
+				assert.Empty(t, resp.Header().Get("X-Prometheus-Remote-Write-Samples-Written"))
+			case http.StatusNoContent:
+				require.Len(t, received, 1)
+				assert.Equal(t, cortexpb.API, received[0].Source)
+				require.Len(t, received[0].Timeseries, 1)
+				assert.Equal(t, []cortexpb.LabelAdapter{{Name: "__name__", Value: "foo"}, {Name: "job", Value: "bar"}}, received[0].Timeseries[0].Labels)
+				assert.Equal(t, []cortexpb.Sample{{Value: 1, TimestampMs: ts}}, received[0].Timeseries[0].Samples)
+				assert.Equal(t, []cortexpb.Exemplar{{Labels: []cortexpb.LabelAdapter{{Name: "trace_id", Value: "1234"}}, Value: 2, TimestampMs: ts}}, received[0].Timeseries[0].Exemplars)
+				assert.Equal(t, []*cortexpb.MetricMetadata{{Type: cortexpb.COUNTER, MetricFamilyName: "foo", Help: "Help text."}}, received[0].Metadata)
+
+				assert.Equal(t, "1", resp.Header().Get("X-Prometheus-Remote-Write-Samples-Written"))
+				assert.Equal(t, "0", resp.Header().Get("X-Prometheus-Remote-Write-Histograms-Written"))
+				assert.Equal(t, "1", resp.Header().Get("X-Prometheus-Remote-Write-Exemplars-Written"))
+			default:
+				assert.Empty(t, received)
+			}
+		})
+	}
+}
+
 func verifyWriteRequestHandler(t *testing.T, expectSource cortexpb.WriteRequest_SourceEnum) func(ctx context.Context, request *cortexpb.WriteRequest) (response *cortexpb.WriteResponse, err error) {
 	t.Helper()
 	return func(ctx context.Context, request *cortexpb.WriteRequest) (response *cortexpb.WriteResponse, err error) {
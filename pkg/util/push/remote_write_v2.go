@@ -0,0 +1,262 @@
+package push
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/gogo/protobuf/proto"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/cortexproject/cortex/pkg/cortexpb"
+	"github.com/cortexproject/cortex/pkg/util"
+)
+
+const (
+	remoteWriteVersionHeader = "X-Prometheus-Remote-Write-Version"
+
+	remoteWrite1ProtoMessage = "prometheus.WriteRequest"
+	remoteWrite2ProtoMessage = "io.prometheus.write.v2.Request"
+
+	// Headers returned to Remote-Write 2.0 senders, with the number of items written.
+	remoteWrite2SamplesWrittenHeader    = "X-Prometheus-Remote-Write-Samples-Written"
+	remoteWrite2HistogramsWrittenHeader = "X-Prometheus-Remote-Write-Histograms-Written"
+	remoteWrite2ExemplarsWrittenHeader  = "X-Prometheus-Remote-Write-Exemplars-Written"
+)
+
+// isRemoteWrite2 returns whether the request is a Remote-Write 2.0 one. The protobuf message
+// is negotiated via the proto parameter of the Content-Type, falling back to the major version
+// of the X-Prometheus-Remote-Write-Version header if not set, and defaults to Remote-Write 1.0.
+func isRemoteWrite2(r *http.Request) (bool, error) {
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		_, params, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return false, err
+		}
+
+		switch protoMessage := params["proto"]; protoMessage {
+		case "":
+		case remoteWrite1ProtoMessage:
+			return false, nil
+		case remoteWrite2ProtoMessage:
+			return true, nil
+		default:
+			return false, fmt.Errorf("unsupported remote write protobuf message %q, supported: %q, %q", protoMessage, remoteWrite1ProtoMessage, remoteWrite2ProtoMessage)
+		}
+	}
+
+	return strings.HasPrefix(r.Header.Get(remoteWriteVersionHeader), "2."), nil
+}
+
+// handleRemoteWrite2 decodes a Remote-Write 2.0 request to a WriteRequest and pushes it.
+func handleRemoteWrite2(ctx context.Context, logger log.Logger, w http.ResponseWriter, r *http.Request, maxRecvMsgSize int, push Func) {
+	var req remoteWrite2Request
+	if err := util.ParseProtoReader(ctx, r.Body, int(r.ContentLength), maxRecvMsgSize, &req, util.RawSnappy); err != nil {
+		level.Error(logger).Log("err", err.Error())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Counted before pushing, since the distributor reuses the series once done.
+	samples, exemplars := 0, 0
+	for _, series := range req.timeseries {
+		samples += len(series.Samples)
+		exemplars += len(series.Exemplars)
+	}
+
+	writeReq := &cortexpb.WriteRequest{Timeseries: req.timeseries, Metadata: req.metadata, Source: cortexpb.API}
+	if !doPush(ctx, logger, w, writeReq, push) {
+		return
+	}
+
+	w.Header().Set(remoteWrite2SamplesWrittenHeader, strconv.Itoa(samples))
+	w.Header().Set(remoteWrite2HistogramsWrittenHeader, "0")
+	w.Header().Set(remoteWrite2ExemplarsWrittenHeader, strconv.Itoa(exemplars))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// remoteWrite2Request is a Remote-Write 2.0 io.prometheus.write.v2.Request, translated to
+// series and metadata while being unmarshalled. Native histograms are not supported.
+type remoteWrite2Request struct {
+	timeseries []cortexpb.PreallocTimeseries
+	metadata   []*cortexpb.MetricMetadata
+}
+
+// Reset implements proto.Message.
+func (r *remoteWrite2Request) Reset() {
+	r.timeseries = nil
+	r.metadata = nil
+}
+
+// String implements proto.Message.
+func (r *remoteWrite2Request) String() string {
+	return fmt.Sprintf("Remote-Write 2.0 request with %d series", len(r.timeseries))
+}
+
+// ProtoMessage implements proto.Message.
+func (r *remoteWrite2Request) ProtoMessage() {}
+
+// Unmarshal implements proto.Unmarshaler.
+func (r *remoteWrite2Request) Unmarshal(b []byte) error {
+	var (
+		symbols    []string
+		timeseries [][]byte
+	)
+
+	// The series reference the symbols table, which could be encoded after them.
+	err := decodeProtoMessage(b, func(f protoField) error {
+		if f.typ != proto.WireBytes {
+			return nil
+		}
+		switch f.num {
+		case 4:
+			symbols = append(symbols, string(f.bytes))
+		case 5:
+			timeseries = append(timeseries, f.bytes)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	metadataByName := map[string]struct{}{}
+	for _, b := range timeseries {
+		if err := r.decodeTimeSeries(b, symbols, metadataByName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *remoteWrite2Request) decodeTimeSeries(b []byte, symbols []string, metadataByName map[string]struct{}) error {
+	var (
+		labelRefs []uint64
+		samples   []cortexpb.Sample
+		exemplars []cortexpb.Exemplar
+		metadata  *cortexpb.MetricMetadata
+	)
+
+	err := decodeProtoMessage(b, func(f protoField) error {
+		var err error
+		switch {
+		case f.num == 1:
+			labelRefs, err = appendProtoVarint(labelRefs, f)
+		case f.num == 2 && f.typ == proto.WireBytes:
+			var s cortexpb.Sample
+			err = decodeProtoMessage(f.bytes, func(f protoField) error {
+				switch {
+				case f.num == 1 && f.typ == proto.WireFixed64:
+					s.Value = math.Float64frombits(f.value)
+				case f.num == 2 && f.typ == proto.WireVarint:
+					s.TimestampMs = int64(f.value)
+				}
+				return nil
+			})
+			samples = append(samples, s)
+		case f.num == 3:
+			err = fmt.Errorf("native histograms are not supported")
+		case f.num == 4 && f.typ == proto.WireBytes:
+			var (
+				e    cortexpb.Exemplar
+				refs []uint64
+			)
+			err = decodeProtoMessage(f.bytes, func(f protoField) error {
+				var err error
+				switch {
+				case f.num == 1:
+					refs, err = appendProtoVarint(refs, f)
+				case f.num == 2 && f.typ == proto.WireFixed64:
+					e.Value = math.Float64frombits(f.value)
+				case f.num == 3 && f.typ == proto.WireVarint:
+					e.TimestampMs = int64(f.value)
+				}
+				return err
+			})
+			if err == nil {
+				e.Labels, err = remoteWrite2Labels(refs, symbols)
+			}
+			exemplars = append(exemplars, e)
+		case f.num == 5 && f.typ == proto.WireBytes:
+			metadata = &cortexpb.MetricMetadata{}
+			err = decodeProtoMessage(f.bytes, func(f protoField) error {
+				if f.typ != proto.WireVarint {
+					return nil
+				}
+				var err error
+				switch f.num {
+				case 1:
+					metadata.Type = cortexpb.MetricMetadata_MetricType(f.value)
+				case 3:
+					metadata.Help, err = remoteWrite2Symbol(f.value, symbols)
+				case 4:
+					metadata.Unit, err = remoteWrite2Symbol(f.value, symbols)
+				}
+				return err
+			})
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	lbls, err := remoteWrite2Labels(labelRefs, symbols)
+	if err != nil {
+		return err
+	}
+
+	r.timeseries = append(r.timeseries, cortexpb.PreallocTimeseries{
+		TimeSeries: &cortexpb.TimeSeries{
+			Labels:    lbls,
+			Samples:   samples,
+			Exemplars: exemplars,
+		},
+	})
+
+	// Metadata is sent along with each series, while Cortex stores it per metric name.
+	if metadata != nil && (metadata.Type != cortexpb.UNKNOWN || metadata.Help != "" || metadata.Unit != "") {
+		name := cortexpb.FromLabelAdaptersToLabels(lbls).Get(labels.MetricName)
+		if _, ok := metadataByName[name]; !ok && name != "" {
+			metadataByName[name] = struct{}{}
+			metadata.MetricFamilyName = name
+			r.metadata = append(r.metadata, metadata)
+		}
+	}
+	return nil
+}
+
+// remoteWrite2Labels resolves the labels referenced by refs, which alternate the
+// name and value symbols of each label.
+func remoteWrite2Labels(refs []uint64, symbols []string) ([]cortexpb.LabelAdapter, error) {
+	if len(refs)%2 != 0 {
+		return nil, fmt.Errorf("odd number of label references: %d", len(refs))
+	}
+
+	lbls := make([]cortexpb.LabelAdapter, 0, len(refs)/2)
+	for i := 0; i < len(refs); i += 2 {
+		name, err := remoteWrite2Symbol(refs[i], symbols)
+		if err != nil {
+			return nil, err
+		}
+		value, err := remoteWrite2Symbol(refs[i+1], symbols)
+		if err != nil {
+			return nil, err
+		}
+		lbls = append(lbls, cortexpb.LabelAdapter{Name: name, Value: value})
+	}
+	return lbls, nil
+}
+
+func remoteWrite2Symbol(ref uint64, symbols []string) (string, error) {
+	if ref >= uint64(len(symbols)) {
+		return "", fmt.Errorf("symbol reference %d out of range, symbols table size: %d", ref, len(symbols))
+	}
+	return symbols[ref], nil
+}
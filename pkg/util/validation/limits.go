@@ -70,13 +70,17 @@ type Limits struct {
 
 	// Querier enforced limits.
 	MaxChunksPerQuery            int            `yaml:"max_fetched_chunks_per_query" json:"max_fetched_chunks_per_query"`
+	MaxChunksPerSeries           int            `yaml:"max_fetched_chunks_per_series" json:"max_fetched_chunks_per_series"`
 	MaxFetchedSeriesPerQuery     int            `yaml:"max_fetched_series_per_query" json:"max_fetched_series_per_query"`
 	MaxFetchedChunkBytesPerQuery int            `yaml:"max_fetched_chunk_bytes_per_query" json:"max_fetched_chunk_bytes_per_query"`
+	MaxSeriesPerLabelNamesQuery  int            `yaml:"max_series_per_label_names_query" json:"max_series_per_label_names_query"`
+	MaxLabelNamesPerQuery        int            `yaml:"max_label_names_per_query" json:"max_label_names_per_query"`
 	MaxQueryLookback             model.Duration `yaml:"max_query_lookback" json:"max_query_lookback"`
 	MaxQueryLength               model.Duration `yaml:"max_query_length" json:"max_query_length"`
 	MaxQueryParallelism          int            `yaml:"max_query_parallelism" json:"max_query_parallelism"`
 	MaxCacheFreshness            model.Duration `yaml:"max_cache_freshness" json:"max_cache_freshness"`
 	MaxQueriersPerTenant         int            `yaml:"max_queriers_per_tenant" json:"max_queriers_per_tenant"`
+	QueryMaintenanceMode         bool           `yaml:"query_maintenance_mode" json:"query_maintenance_mode"`
 
 	// Ruler defaults and limits.
 	RulerEvaluationDelay        model.Duration `yaml:"ruler_evaluation_delay_duration" json:"ruler_evaluation_delay_duration"`
@@ -97,6 +101,9 @@ type Limits struct {
 	S3SSEKMSKeyID             string `yaml:"s3_sse_kms_key_id" json:"s3_sse_kms_key_id" doc:"nocli|description=S3 server-side encryption KMS Key ID. Ignored if the SSE type override is not set."`
 	S3SSEKMSEncryptionContext string `yaml:"s3_sse_kms_encryption_context" json:"s3_sse_kms_encryption_context" doc:"nocli|description=S3 server-side encryption KMS encryption context. If unset and the key ID override is set, the encryption context will not be provided to S3. Ignored if the SSE type override is not set."`
 
+	// API. When not set, -api.response-compression-enabled applies.
+	ResponseCompressionEnabled *bool `yaml:"response_compression_enabled" json:"response_compression_enabled" doc:"nocli|description=Use GZIP compression for API responses of the tenant. If not set, the value of -api.response-compression-enabled is used."`
+
 	// Alertmanager.
 	AlertmanagerReceiversBlockCIDRNetworks     flagext.CIDRSliceCSV `yaml:"alertmanager_receivers_firewall_block_cidr_networks" json:"alertmanager_receivers_firewall_block_cidr_networks"`
 	AlertmanagerReceiversBlockPrivateAddresses bool                 `yaml:"alertmanager_receivers_firewall_block_private_addresses" json:"alertmanager_receivers_firewall_block_private_addresses"`
@@ -146,14 +153,18 @@ func (l *Limits) RegisterFlags(f *flag.FlagSet) {
 	f.IntVar(&l.MaxGlobalMetricsWithMetadataPerUser, "ingester.max-global-metadata-per-user", 0, "The maximum number of active metrics with metadata per user, across the cluster. 0 to disable. Supported only if -distributor.shard-by-all-labels is true.")
 	f.IntVar(&l.MaxGlobalMetadataPerMetric, "ingester.max-global-metadata-per-metric", 0, "The maximum number of metadata per metric, across the cluster. 0 to disable.")
 	f.IntVar(&l.MaxChunksPerQuery, "querier.max-fetched-chunks-per-query", 2000000, "Maximum number of chunks that can be fetched in a single query from ingesters and long-term storage. This limit is enforced in the querier, ruler and store-gateway. 0 to disable.")
+	f.IntVar(&l.MaxChunksPerSeries, "querier.max-fetched-chunks-per-series", 0, "Maximum number of chunks that can be fetched for a single series from ingesters. This limit is enforced in the querier when ingester streaming is enabled. 0 to disable.")
 	f.IntVar(&l.MaxFetchedSeriesPerQuery, "querier.max-fetched-series-per-query", 0, "The maximum number of unique series for which a query can fetch samples from each ingesters and blocks storage. This limit is enforced in the querier only when running Cortex with blocks storage. 0 to disable")
 	f.IntVar(&l.MaxFetchedChunkBytesPerQuery, "querier.max-fetched-chunk-bytes-per-query", 0, "The maximum size of all chunks in bytes that a query can fetch from each ingester and storage. This limit is enforced in the querier and ruler only when running Cortex with blocks storage. 0 to disable.")
+	f.IntVar(&l.MaxSeriesPerLabelNamesQuery, "querier.max-series-per-label-names-query", 0, "The maximum number of series examined by the querier to build the label names returned by a label names query with matchers. When the limit is hit, the response only includes the label names of the series examined so far, along with a warning. When set, the series are streamed from ingesters and the querier stops fetching them once the limit is hit. 0 to disable.")
+	f.IntVar(&l.MaxLabelNamesPerQuery, "querier.max-label-names-per-query", 0, "The maximum number of label names returned by a label names query with matchers. When the limit is hit, the response only includes the label names collected so far, along with a warning. 0 to disable.")
 	f.Var(&l.MaxQueryLength, "store.max-query-length", "Limit the query time range (end - start time). This limit is enforced in the query-frontend (on the received query) and in the querier (on the query possibly split by the query-frontend). 0 to disable.")
 	f.Var(&l.MaxQueryLookback, "querier.max-query-lookback", "Limit how long back data (series and metadata) can be queried, up until <lookback> duration ago. This limit is enforced in the query-frontend, querier and ruler. If the requested time range is outside the allowed range, the request will not fail but will be manipulated to only query data within the allowed time range. 0 to disable.")
 	f.IntVar(&l.MaxQueryParallelism, "querier.max-query-parallelism", 14, "Maximum number of split queries will be scheduled in parallel by the frontend.")
 	_ = l.MaxCacheFreshness.Set("1m")
 	f.Var(&l.MaxCacheFreshness, "frontend.max-cache-freshness", "Most recent allowed cacheable result per-tenant, to prevent caching very recent results that might still be in flux.")
 	f.IntVar(&l.MaxQueriersPerTenant, "frontend.max-queriers-per-tenant", 0, "Maximum number of queriers that can handle requests for a single tenant. If set to 0 or value higher than number of available queriers, *all* queriers will handle requests for the tenant. Each frontend (or query-scheduler, if used) will select the same set of queriers for the same tenant (given that all queriers are connected to all frontends / query-schedulers). This option only works with queriers connecting to the query-frontend / query-scheduler, not when using downstream URL.")
+	f.BoolVar(&l.QueryMaintenanceMode, "querier.maintenance-mode", false, "Put the tenant in maintenance, for example while its data is being migrated. Queries to ingesters fail with an error stating the tenant is in maintenance, rather than returning partial data. This limit is enforced in the querier and is meant to be set per-tenant via the runtime configuration.")
 
 	f.Var(&l.RulerEvaluationDelay, "ruler.evaluation-delay-duration", "Duration to delay the evaluation of rules to ensure the underlying metrics have been pushed to Cortex.")
 	f.IntVar(&l.RulerTenantShardSize, "ruler.tenant-shard-size", 0, "The default tenant's shard size when the shuffle-sharding strategy is used by ruler. When this setting is specified in the per-tenant overrides, a value of 0 disables shuffle sharding for the tenant.")
@@ -207,6 +218,7 @@ func (l *Limits) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		*l = *defaultLimits
 		// Make copy of default limits. Otherwise unmarshalling would modify map in default limits.
 		l.copyNotificationIntegrationLimits(defaultLimits.NotificationRateLimitPerIntegration)
+		l.copyResponseCompressionEnabled(defaultLimits.ResponseCompressionEnabled)
 	}
 	type plain Limits
 	return unmarshal((*plain)(l))
@@ -221,6 +233,7 @@ func (l *Limits) UnmarshalJSON(data []byte) error {
 		*l = *defaultLimits
 		// Make copy of default limits. Otherwise unmarshalling would modify map in default limits.
 		l.copyNotificationIntegrationLimits(defaultLimits.NotificationRateLimitPerIntegration)
+		l.copyResponseCompressionEnabled(defaultLimits.ResponseCompressionEnabled)
 	}
 
 	type plain Limits
@@ -237,6 +250,16 @@ func (l *Limits) copyNotificationIntegrationLimits(defaults NotificationRateLimi
 	}
 }
 
+// copyResponseCompressionEnabled makes a copy of the default value, given unmarshalling
+// would otherwise modify the value pointed by the default limits.
+func (l *Limits) copyResponseCompressionEnabled(defaults *bool) {
+	if defaults == nil {
+		return
+	}
+	enabled := *defaults
+	l.ResponseCompressionEnabled = &enabled
+}
+
 // When we load YAML from disk, we want the various per-customer limits
 // to default to any values specified on the command line, not default
 // command line values.  This global contains those values.  I (Tom) cannot
@@ -385,6 +408,12 @@ func (o *Overrides) MaxChunksPerQuery(userID string) int {
 	return o.getOverridesForUser(userID).MaxChunksPerQuery
 }
 
+// MaxChunksPerSeries returns the maximum number of chunks allowed for a single series
+// when fetching chunks from ingesters.
+func (o *Overrides) MaxChunksPerSeries(userID string) int {
+	return o.getOverridesForUser(userID).MaxChunksPerSeries
+}
+
 // MaxFetchedSeriesPerQuery returns the maximum number of series allowed per query when fetching
 // chunks from ingesters and blocks storage.
 func (o *Overrides) MaxFetchedSeriesPerQuery(userID string) int {
@@ -397,6 +426,23 @@ func (o *Overrides) MaxFetchedChunkBytesPerQuery(userID string) int {
 	return o.getOverridesForUser(userID).MaxFetchedChunkBytesPerQuery
 }
 
+// MaxSeriesPerLabelNamesQuery returns the maximum number of series examined to build the
+// label names of a label names query with matchers.
+func (o *Overrides) MaxSeriesPerLabelNamesQuery(userID string) int {
+	return o.getOverridesForUser(userID).MaxSeriesPerLabelNamesQuery
+}
+
+// MaxLabelNamesPerQuery returns the maximum number of label names returned by a
+// label names query with matchers.
+func (o *Overrides) MaxLabelNamesPerQuery(userID string) int {
+	return o.getOverridesForUser(userID).MaxLabelNamesPerQuery
+}
+
+// QueryMaintenanceMode returns whether the tenant is in maintenance, and queries should fail.
+func (o *Overrides) QueryMaintenanceMode(userID string) bool {
+	return o.getOverridesForUser(userID).QueryMaintenanceMode
+}
+
 // MaxQueryLookback returns the max lookback period of queries.
 func (o *Overrides) MaxQueryLookback(userID string) time.Duration {
 	return time.Duration(o.getOverridesForUser(userID).MaxQueryLookback)
@@ -519,6 +565,15 @@ func (o *Overrides) S3SSEKMSEncryptionContext(user string) string {
 	return o.getOverridesForUser(user).S3SSEKMSEncryptionContext
 }
 
+// ResponseCompressionEnabled returns whether API responses should be compressed for the given user.
+// The returned ok is false if it isn't set, in which case the global setting applies.
+func (o *Overrides) ResponseCompressionEnabled(user string) (enabled, ok bool) {
+	if v := o.getOverridesForUser(user).ResponseCompressionEnabled; v != nil {
+		return *v, true
+	}
+	return false, false
+}
+
 // AlertmanagerReceiversBlockCIDRNetworks returns the list of network CIDRs that should be blocked
 // in the Alertmanager receivers for the given user.
 func (o *Overrides) AlertmanagerReceiversBlockCIDRNetworks(user string) []flagext.CIDR {
@@ -528,3 +528,73 @@ testuser:
 		})
 	}
 }
+
+func TestResponseCompressionEnabledOverrides(t *testing.T) {
+	for name, tc := range map[string]struct {
+		baseYaml        string
+		overrides       string
+		expectedEnabled bool
+		expectedOk      bool
+	}{
+		"not set": {
+			expectedOk: false,
+		},
+		"set in the defaults": {
+			baseYaml:        `response_compression_enabled: true`,
+			expectedEnabled: true,
+			expectedOk:      true,
+		},
+		"set in the defaults, overridden for another tenant": {
+			baseYaml: `response_compression_enabled: true`,
+			overrides: `
+differentuser:
+  response_compression_enabled: false
+`,
+			expectedEnabled: true,
+			expectedOk:      true,
+		},
+		"set in the defaults, overridden for the tenant": {
+			baseYaml: `response_compression_enabled: true`,
+			overrides: `
+testuser:
+  response_compression_enabled: false
+`,
+			expectedEnabled: false,
+			expectedOk:      true,
+		},
+		"only set for the tenant": {
+			overrides: `
+testuser:
+  response_compression_enabled: true
+`,
+			expectedEnabled: true,
+			expectedOk:      true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			SetDefaultLimitsForYAMLUnmarshalling(Limits{})
+
+			limitsYAML := Limits{}
+			err := yaml.Unmarshal([]byte(tc.baseYaml), &limitsYAML)
+			require.NoError(t, err, "expected to be able to unmarshal from YAML")
+
+			SetDefaultLimitsForYAMLUnmarshalling(limitsYAML)
+
+			overrides := map[string]*Limits{}
+			err = yaml.Unmarshal([]byte(tc.overrides), &overrides)
+			require.NoError(t, err, "parsing overrides")
+
+			ov, err := NewOverrides(limitsYAML, newMockTenantLimits(overrides))
+			require.NoError(t, err)
+
+			enabled, ok := ov.ResponseCompressionEnabled("testuser")
+			require.Equal(t, tc.expectedOk, ok)
+			require.Equal(t, tc.expectedEnabled, enabled)
+
+			// The overrides of the other tenants shouldn't modify the defaults.
+			enabled, ok = ov.ResponseCompressionEnabled("anotheruser")
+			require.Equal(t, tc.baseYaml != "", ok)
+			require.Equal(t, tc.baseYaml != "", enabled)
+		})
+	}
+}
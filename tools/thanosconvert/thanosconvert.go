@@ -8,6 +8,7 @@ import (
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	"github.com/oklog/ulid"
 	"github.com/pkg/errors"
 	"github.com/thanos-io/thanos/pkg/block"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
@@ -163,25 +164,192 @@ func convertMetadata(meta metadata.Meta, expectedUser string) (metadata.Meta, []
 	return meta, changesRequired
 }
 
-func ConvertMetadata(meta metadata.Meta, expectedUser string) (metadata.Meta, []string) {
-	var changesRequired []string
+// ValidateMeta checks that the input block metadata has the fields required by the conversion.
+func ValidateMeta(meta metadata.Meta) error {
+	if meta.ULID == (ulid.ULID{}) {
+		return errors.New("empty ULID")
+	}
+	if meta.MaxTime < meta.MinTime {
+		return fmt.Errorf("block %s has maxTime %d before minTime %d", meta.ULID, meta.MaxTime, meta.MinTime)
+	}
+	if len(meta.Compaction.Sources) == 0 {
+		return fmt.Errorf("block %s has no compaction sources", meta.ULID)
+	}
+	return nil
+}
+
+// ConvertMetadata converts the input metadata of a block of the given user, returning the
+// changes required. The tenant label is merged into the existing external labels, which are
+// preserved. It fails if the input metadata is invalid or already has a different tenant label.
+func ConvertMetadata(meta metadata.Meta, expectedUser string) (metadata.Meta, []string, error) {
+	if err := ValidateMeta(meta); err != nil {
+		return meta, nil, errors.Wrap(err, "invalid block metadata")
+	}
+
+	org, ok := meta.Thanos.Labels[cortex_tsdb.TenantIDExternalLabel]
+	if ok && org != expectedUser {
+		return meta, nil, conflictingTenantError(meta, org, expectedUser)
+	}
+	if ok {
+		return meta, nil, nil
+	}
+
+	// Don't modify the labels of the input metadata.
+	labels := make(map[string]string, len(meta.Thanos.Labels)+1)
+	for k, v := range meta.Thanos.Labels {
+		labels[k] = v
+	}
+	labels[cortex_tsdb.TenantIDExternalLabel] = expectedUser
+	meta.Thanos.Labels = labels
+
+	return meta, []string{"add __org_id__ label"}, nil
+}
+
+func conflictingTenantError(meta metadata.Meta, org, expectedUser string) error {
+	return fmt.Errorf("block %s has conflicting %s label %s, expected %s", meta.ULID, cortex_tsdb.TenantIDExternalLabel, org, expectedUser)
+}
+
+// ConvertStatus is the outcome of the conversion of a single block metadata.
+type ConvertStatus string
+
+const (
+	ConvertStatusConverted ConvertStatus = "converted"
+	ConvertStatusSkipped   ConvertStatus = "skipped"
+	ConvertStatusErrored   ConvertStatus = "errored"
+)
+
+// ConvertResult is the result of the conversion of a single block metadata.
+type ConvertResult struct {
+	BlockID         string
+	Status          ConvertStatus
+	ChangesRequired []string
+	Err             error
+}
+
+// ConvertMetadataBatch converts the input metadata of the blocks of the given tenant, returning
+// the converted metadata of the blocks which didn't fail, along with a result for each processed
+// block. Each block is converted with ConvertMetadata, and fails if its metadata has an unsupported
+// version, is invalid or belongs to another tenant: the conversion stops at the first failed block,
+// unless continueOnError is true.
+func ConvertMetadataBatch(metas []metadata.Meta, tenant string, continueOnError bool) ([]metadata.Meta, []ConvertResult, error) {
+	if tenant == "" {
+		return nil, nil, errors.New("tenant is required")
+	}
+
+	converted := make([]metadata.Meta, 0, len(metas))
+	results := make([]ConvertResult, 0, len(metas))
+
+	for _, meta := range metas {
+		blockID := meta.ULID.String()
+
+		newMeta, changesRequired, err := convertMetadataWithVersion(meta, tenant)
+		if err != nil {
+			results = append(results, ConvertResult{BlockID: blockID, Status: ConvertStatusErrored, Err: err})
+			if !continueOnError {
+				return converted, results, errors.Wrap(err, fmt.Sprintf("error converting block %s", blockID))
+			}
+			continue
+		}
+
+		converted = append(converted, newMeta)
+
+		if len(changesRequired) > 0 {
+			results = append(results, ConvertResult{BlockID: blockID, Status: ConvertStatusConverted, ChangesRequired: changesRequired})
+		} else {
+			results = append(results, ConvertResult{BlockID: blockID, Status: ConvertStatusSkipped})
+		}
+	}
+
+	return converted, results, nil
+}
+
+func convertMetadataWithVersion(meta metadata.Meta, tenant string) (metadata.Meta, []string, error) {
+	if err := validateMetadataVersion(meta); err != nil {
+		return meta, nil, err
+	}
+	return ConvertMetadata(meta, tenant)
+}
+
+// validateMetadataVersion checks the metadata versions like metadata.Read does.
+func validateMetadataVersion(meta metadata.Meta) error {
+	if meta.Version != metadata.TSDBVersion1 {
+		return errors.Errorf("unexpected meta file version %d", meta.Version)
+	}
+
+	if meta.Thanos.Version != 0 && meta.Thanos.Version != metadata.ThanosVersion1 {
+		return errors.Errorf("unexpected meta file Thanos section version %d", meta.Thanos.Version)
+	}
+	return nil
+}
+
+// ConvertDiff describes the changes which the conversion would apply to the external labels
+// of a block metadata.
+type ConvertDiff struct {
+	// AddedLabels are the external labels which would be added.
+	AddedLabels map[string]string `json:"added_labels"`
+	// PreservedLabels are the existing external labels, which would be left unchanged.
+	PreservedLabels map[string]string `json:"preserved_labels"`
+	// TenantLabelInjected is true if the tenant label is missing and would be added.
+	TenantLabelInjected bool `json:"tenant_label_injected"`
+}
+
+// HasChanges returns whether the conversion would change the block metadata.
+func (d ConvertDiff) HasChanges() bool {
+	return len(d.AddedLabels) > 0
+}
+
+// ConvertMetadataDryRun returns the changes which ConvertMetadata would apply to the input
+// metadata of a block of the given tenant, without changing it. Like ConvertMetadata, it fails
+// if the input metadata is invalid or already has a different tenant label.
+func ConvertMetadataDryRun(meta metadata.Meta, tenant string) (ConvertDiff, error) {
+	if err := ValidateMeta(meta); err != nil {
+		return ConvertDiff{}, errors.Wrap(err, "invalid block metadata")
+	}
+	if tenant == "" {
+		return ConvertDiff{}, errors.New("tenant is required")
+	}
+
+	diff := ConvertDiff{
+		AddedLabels:     map[string]string{},
+		PreservedLabels: map[string]string{},
+	}
+
+	for name, value := range meta.Thanos.Labels {
+		diff.PreservedLabels[name] = value
+	}
 
 	org, ok := meta.Thanos.Labels[cortex_tsdb.TenantIDExternalLabel]
+	if ok && org != tenant {
+		return ConvertDiff{}, conflictingTenantError(meta, org, tenant)
+	}
 	if !ok {
-		changesRequired = append(changesRequired, "add __org_id__ label")
-	} else if org != expectedUser {
-		changesRequired = append(changesRequired, fmt.Sprintf("change __org_id__ from %s to %s", org, expectedUser))
+		diff.AddedLabels[cortex_tsdb.TenantIDExternalLabel] = tenant
+		diff.TenantLabelInjected = true
 	}
 
-	// remove __org_id__ so that we can see if there are any other labels
-	delete(meta.Thanos.Labels, cortex_tsdb.TenantIDExternalLabel)
-	if len(meta.Thanos.Labels) > 0 {
-		changesRequired = append(changesRequired, "remove extra Thanos labels")
+	return diff, nil
+}
+
+// RevertMetadata is the inverse of ConvertMetadata: it removes the tenant external label from the
+// input metadata of a block of the given tenant, so that the block can be read by Thanos. It fails
+// if the block doesn't belong to the tenant. The other external labels are preserved.
+func RevertMetadata(meta metadata.Meta, tenant string) (metadata.Meta, error) {
+	org, ok := meta.Thanos.Labels[cortex_tsdb.TenantIDExternalLabel]
+	if !ok {
+		return meta, fmt.Errorf("block %s has no %s label", meta.ULID, cortex_tsdb.TenantIDExternalLabel)
+	}
+	if org != tenant {
+		return meta, fmt.Errorf("block %s belongs to tenant %s instead of %s", meta.ULID, org, tenant)
 	}
 
-	meta.Thanos.Labels = map[string]string{
-		cortex_tsdb.TenantIDExternalLabel: expectedUser,
+	// Don't modify the labels of the input metadata.
+	labels := make(map[string]string, len(meta.Thanos.Labels)-1)
+	for k, v := range meta.Thanos.Labels {
+		if k != cortex_tsdb.TenantIDExternalLabel {
+			labels[k] = v
+		}
 	}
+	meta.Thanos.Labels = labels
 
-	return meta, changesRequired
+	return meta, nil
 }
@@ -393,3 +393,389 @@ func TestConvertMetadata(t *testing.T) {
 		})
 	}
 }
+
+func TestConvertMetadataBatch(t *testing.T) {
+	newMeta := func(id ulid.ULID, version int, labels map[string]string) metadata.Meta {
+		return metadata.Meta{
+			BlockMeta: tsdb.BlockMeta{
+				ULID:       id,
+				Version:    version,
+				Compaction: tsdb.BlockMetaCompaction{Sources: []ulid.ULID{id}},
+			},
+			Thanos: metadata.Thanos{Labels: labels},
+		}
+	}
+
+	var (
+		id1 = ulid.MustNew(1, nil)
+		id2 = ulid.MustNew(2, nil)
+		id3 = ulid.MustNew(3, nil)
+		id4 = ulid.MustNew(4, nil)
+		id5 = ulid.MustNew(5, nil)
+	)
+
+	// A mixed batch, where the 3rd block has an unsupported metadata version and the 5th block
+	// belongs to another tenant.
+	batch := func() []metadata.Meta {
+		return []metadata.Meta{
+			newMeta(id1, metadata.TSDBVersion1, map[string]string{cortex_tsdb.TenantIDExternalLabel: "user1"}),
+			newMeta(id2, metadata.TSDBVersion1, map[string]string{"cluster": "foo"}),
+			newMeta(id3, 2, map[string]string{cortex_tsdb.TenantIDExternalLabel: "user1"}),
+			newMeta(id4, metadata.TSDBVersion1, nil),
+			newMeta(id5, metadata.TSDBVersion1, map[string]string{cortex_tsdb.TenantIDExternalLabel: "user2"}),
+		}
+	}
+	expectedLabels := map[string]string{cortex_tsdb.TenantIDExternalLabel: "user1"}
+	expectedMergedLabels := map[string]string{cortex_tsdb.TenantIDExternalLabel: "user1", "cluster": "foo"}
+
+	tests := map[string]struct {
+		continueOnError bool
+		expectedMetas   []metadata.Meta
+		expectedResults []ConvertResult
+		expectedErr     bool
+	}{
+		"should stop at the first failed block": {
+			continueOnError: false,
+			expectedMetas: []metadata.Meta{
+				newMeta(id1, metadata.TSDBVersion1, expectedLabels),
+				newMeta(id2, metadata.TSDBVersion1, expectedMergedLabels),
+			},
+			expectedResults: []ConvertResult{
+				{BlockID: id1.String(), Status: ConvertStatusSkipped},
+				{BlockID: id2.String(), Status: ConvertStatusConverted, ChangesRequired: []string{"add __org_id__ label"}},
+				{BlockID: id3.String(), Status: ConvertStatusErrored},
+			},
+			expectedErr: true,
+		},
+		"should continue after a failed block if enabled": {
+			continueOnError: true,
+			expectedMetas: []metadata.Meta{
+				newMeta(id1, metadata.TSDBVersion1, expectedLabels),
+				newMeta(id2, metadata.TSDBVersion1, expectedMergedLabels),
+				newMeta(id4, metadata.TSDBVersion1, expectedLabels),
+			},
+			expectedResults: []ConvertResult{
+				{BlockID: id1.String(), Status: ConvertStatusSkipped},
+				{BlockID: id2.String(), Status: ConvertStatusConverted, ChangesRequired: []string{"add __org_id__ label"}},
+				{BlockID: id3.String(), Status: ConvertStatusErrored},
+				{BlockID: id4.String(), Status: ConvertStatusConverted, ChangesRequired: []string{"add __org_id__ label"}},
+				{BlockID: id5.String(), Status: ConvertStatusErrored},
+			},
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			in := batch()
+			metas, results, err := ConvertMetadataBatch(in, "user1", testData.continueOnError)
+			if testData.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, testData.expectedMetas, metas)
+
+			// The errors are checked apart, because they can't be compared.
+			assert.Len(t, results, len(testData.expectedResults))
+			for i := range results {
+				if testData.expectedResults[i].Status == ConvertStatusErrored {
+					assert.Error(t, results[i].Err)
+				}
+				results[i].Err = nil
+			}
+			assert.Equal(t, testData.expectedResults, results)
+
+			// The input metadata should be left untouched.
+			assert.Equal(t, batch(), in)
+		})
+	}
+
+	t.Run("should fail without a tenant", func(t *testing.T) {
+		_, _, err := ConvertMetadataBatch(batch(), "", true)
+		assert.Error(t, err)
+	})
+}
+
+func TestConvertMetadataDryRun(t *testing.T) {
+	tests := map[string]struct {
+		labels       map[string]string
+		expectedDiff ConvertDiff
+		expectedErr  bool
+	}{
+		"no changes required": {
+			labels: map[string]string{cortex_tsdb.TenantIDExternalLabel: "user1", "cluster": "foo"},
+			expectedDiff: ConvertDiff{
+				AddedLabels:     map[string]string{},
+				PreservedLabels: map[string]string{cortex_tsdb.TenantIDExternalLabel: "user1", "cluster": "foo"},
+			},
+		},
+		"nil labels map": {
+			labels: nil,
+			expectedDiff: ConvertDiff{
+				AddedLabels:         map[string]string{cortex_tsdb.TenantIDExternalLabel: "user1"},
+				PreservedLabels:     map[string]string{},
+				TenantLabelInjected: true,
+			},
+		},
+		"add __org_id__ label and preserve extra Thanos labels": {
+			labels: map[string]string{"cluster": "foo", "replica": "a"},
+			expectedDiff: ConvertDiff{
+				AddedLabels:         map[string]string{cortex_tsdb.TenantIDExternalLabel: "user1"},
+				PreservedLabels:     map[string]string{"cluster": "foo", "replica": "a"},
+				TenantLabelInjected: true,
+			},
+		},
+		"conflicting __org_id__": {
+			labels:      map[string]string{cortex_tsdb.TenantIDExternalLabel: "wrong_user"},
+			expectedErr: true,
+		},
+	}
+
+	copyLabels := func(in map[string]string) map[string]string {
+		if in == nil {
+			return nil
+		}
+		out := make(map[string]string, len(in))
+		for k, v := range in {
+			out[k] = v
+		}
+		return out
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			meta := metadata.Meta{
+				BlockMeta: tsdb.BlockMeta{
+					ULID:       ulid.MustNew(1, nil),
+					Compaction: tsdb.BlockMetaCompaction{Sources: []ulid.ULID{ulid.MustNew(1, nil)}},
+				},
+				Thanos: metadata.Thanos{Labels: copyLabels(testData.labels)},
+			}
+
+			diff, err := ConvertMetadataDryRun(meta, "user1")
+			if testData.expectedErr {
+				assert.Error(t, err)
+
+				// The real conversion should fail too.
+				_, _, err = ConvertMetadata(meta, "user1")
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testData.expectedDiff, diff)
+
+			// The input metadata should be left untouched.
+			assert.Equal(t, testData.labels, meta.Thanos.Labels)
+
+			// Applying the diff should give the same labels of the real conversion.
+			converted, changesRequired, err := ConvertMetadata(meta, "user1")
+			assert.NoError(t, err)
+			assert.Equal(t, len(changesRequired) > 0, diff.HasChanges())
+
+			applied := copyLabels(diff.PreservedLabels)
+			for name, value := range diff.AddedLabels {
+				applied[name] = value
+			}
+			assert.Equal(t, converted.Thanos.Labels, applied)
+		})
+	}
+
+	t.Run("should fail without a tenant", func(t *testing.T) {
+		meta := metadata.Meta{
+			BlockMeta: tsdb.BlockMeta{
+				ULID:       ulid.MustNew(1, nil),
+				Compaction: tsdb.BlockMetaCompaction{Sources: []ulid.ULID{ulid.MustNew(1, nil)}},
+			},
+		}
+
+		_, err := ConvertMetadataDryRun(meta, "")
+		assert.EqualError(t, err, "tenant is required")
+	})
+}
+
+func TestRevertMetadata(t *testing.T) {
+	newMeta := func() metadata.Meta {
+		return metadata.Meta{
+			BlockMeta: tsdb.BlockMeta{
+				ULID:       ulid.MustNew(1, nil),
+				MinTime:    1000,
+				MaxTime:    2000,
+				Version:    metadata.TSDBVersion1,
+				Compaction: tsdb.BlockMetaCompaction{Level: 1, Sources: []ulid.ULID{ulid.MustNew(1, nil)}},
+			},
+			Thanos: metadata.Thanos{
+				Version: metadata.ThanosVersion1,
+				Labels:  map[string]string{},
+				Source:  metadata.CompactorSource,
+			},
+		}
+	}
+
+	t.Run("should be the inverse of the conversion", func(t *testing.T) {
+		converted, _, err := ConvertMetadata(newMeta(), "user1")
+		assert.NoError(t, err)
+		reverted, err := RevertMetadata(converted, "user1")
+		assert.NoError(t, err)
+		assert.Equal(t, newMeta(), reverted)
+
+		// The input metadata should be left untouched.
+		assert.Equal(t, map[string]string{cortex_tsdb.TenantIDExternalLabel: "user1"}, converted.Thanos.Labels)
+	})
+
+	t.Run("should be the inverse of the conversion with extra external labels", func(t *testing.T) {
+		withLabels := func() metadata.Meta {
+			m := newMeta()
+			m.Thanos.Labels = map[string]string{"cluster": "foo", "replica": "a"}
+			return m
+		}
+
+		converted, _, err := ConvertMetadata(withLabels(), "user1")
+		assert.NoError(t, err)
+		reverted, err := RevertMetadata(converted, "user1")
+		assert.NoError(t, err)
+		assert.Equal(t, withLabels(), reverted)
+	})
+
+	t.Run("should fail if the block belongs to another tenant", func(t *testing.T) {
+		converted, _, err := ConvertMetadata(newMeta(), "user1")
+		assert.NoError(t, err)
+		_, err = RevertMetadata(converted, "user2")
+		assert.Error(t, err)
+	})
+
+	t.Run("should fail if the block has no tenant label", func(t *testing.T) {
+		_, err := RevertMetadata(newMeta(), "user1")
+		assert.Error(t, err)
+	})
+}
+
+func TestValidateMeta(t *testing.T) {
+	validMeta := func() metadata.Meta {
+		return metadata.Meta{
+			BlockMeta: tsdb.BlockMeta{
+				ULID:       ulid.MustNew(1, nil),
+				MinTime:    1000,
+				MaxTime:    2000,
+				Compaction: tsdb.BlockMetaCompaction{Level: 1, Sources: []ulid.ULID{ulid.MustNew(1, nil)}},
+			},
+		}
+	}
+
+	tests := map[string]struct {
+		meta        func() metadata.Meta
+		expectedErr string
+	}{
+		"valid metadata": {
+			meta: validMeta,
+		},
+		"empty ULID": {
+			meta: func() metadata.Meta {
+				m := validMeta()
+				m.ULID = ulid.ULID{}
+				return m
+			},
+			expectedErr: "empty ULID",
+		},
+		"maxTime before minTime": {
+			meta: func() metadata.Meta {
+				m := validMeta()
+				m.MinTime, m.MaxTime = 2000, 1000
+				return m
+			},
+			expectedErr: "maxTime 1000 before minTime 2000",
+		},
+		"no compaction sources": {
+			meta: func() metadata.Meta {
+				m := validMeta()
+				m.Compaction.Sources = nil
+				return m
+			},
+			expectedErr: "no compaction sources",
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			err := ValidateMeta(testData.meta())
+			if testData.expectedErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), testData.expectedErr)
+
+			// The conversion should reject the invalid metadata too.
+			_, _, err = ConvertMetadata(testData.meta(), "user1")
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), testData.expectedErr)
+
+			// And so should the dry run, like the real conversion.
+			_, err = ConvertMetadataDryRun(testData.meta(), "user1")
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), testData.expectedErr)
+		})
+	}
+}
+
+func TestConvertMetadataShouldMergeExternalLabels(t *testing.T) {
+	newMeta := func(labels map[string]string) metadata.Meta {
+		return metadata.Meta{
+			BlockMeta: tsdb.BlockMeta{
+				ULID:       ulid.MustNew(1, nil),
+				Compaction: tsdb.BlockMetaCompaction{Sources: []ulid.ULID{ulid.MustNew(1, nil)}},
+			},
+			Thanos: metadata.Thanos{Labels: labels},
+		}
+	}
+
+	tests := map[string]struct {
+		labels                  map[string]string
+		expectedLabels          map[string]string
+		expectedChangesRequired []string
+		expectedErr             bool
+	}{
+		"should inject the tenant label preserving the extra labels": {
+			labels:                  map[string]string{"cluster": "foo", "replica": "a"},
+			expectedLabels:          map[string]string{cortex_tsdb.TenantIDExternalLabel: "user1", "cluster": "foo", "replica": "a"},
+			expectedChangesRequired: []string{"add __org_id__ label"},
+		},
+		"should leave the labels unchanged if the tenant label is already set": {
+			labels:         map[string]string{cortex_tsdb.TenantIDExternalLabel: "user1", "cluster": "foo"},
+			expectedLabels: map[string]string{cortex_tsdb.TenantIDExternalLabel: "user1", "cluster": "foo"},
+		},
+		"should inject the tenant label if there are no labels": {
+			labels:                  nil,
+			expectedLabels:          map[string]string{cortex_tsdb.TenantIDExternalLabel: "user1"},
+			expectedChangesRequired: []string{"add __org_id__ label"},
+		},
+		"should fail if the tenant label has a different value": {
+			labels:      map[string]string{cortex_tsdb.TenantIDExternalLabel: "user2", "cluster": "foo"},
+			expectedErr: true,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			in := newMeta(testData.labels)
+			var inLabels map[string]string
+			if testData.labels != nil {
+				inLabels = make(map[string]string, len(testData.labels))
+				for k, v := range testData.labels {
+					inLabels[k] = v
+				}
+			}
+
+			out, changesRequired, err := ConvertMetadata(in, "user1")
+			if testData.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testData.expectedLabels, out.Thanos.Labels)
+			assert.Equal(t, testData.expectedChangesRequired, changesRequired)
+
+			// The input metadata should be left untouched.
+			assert.Equal(t, inLabels, in.Thanos.Labels)
+		})
+	}
+}